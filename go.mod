@@ -4,10 +4,14 @@ go 1.16
 
 require (
 	github.com/alecthomas/kong v0.4.1
-	github.com/go-openapi/strfmt v0.21.2 // indirect
 	github.com/guysports/go-betfair-api v0.0.0-20220110131836-9ca495b65385
 	github.com/hashicorp/go-cleanhttp v0.5.2 // indirect
-	github.com/hashicorp/go-retryablehttp v0.7.0 // indirect
-	github.com/jedib0t/go-pretty v4.3.0+incompatible
+	github.com/hashicorp/go-retryablehttp v0.7.0
+	github.com/jedib0t/go-pretty/v6 v6.2.2
+	github.com/kr/text v0.2.0 // indirect
+	github.com/niemeyer/pretty v0.0.0-20200227124842-a10e7caefd8e // indirect
 	github.com/stretchr/testify v1.7.1
+	golang.org/x/sys v0.7.0 // indirect
+	gopkg.in/check.v1 v1.0.0-20200227125254-8fa46927fb4f // indirect
+	gopkg.in/yaml.v3 v3.0.0-20210107192922-496545a6307b
 )
@@ -24,8 +24,12 @@ import (
 )
 
 var cli struct {
-	Track   cmd.Track   `cmd:"" help:"Track back and lay prices for a given league"`
-	Analyze cmd.Analyze `cmd:"" help:"Analyze price trends in fixtures"`
+	Track    cmd.Track    `cmd:"" help:"Track back and lay prices for a given league"`
+	Analyze  cmd.Analyze  `cmd:"" help:"Analyze price trends in fixtures"`
+	Backtest cmd.Backtest `cmd:"" help:"Replay the stored price history through a trading strategy"`
+	Settle   cmd.Settle   `cmd:"" help:"Record the results of tracked fixtures from their closed markets"`
+	Trade    cmd.Trade    `cmd:"" help:"Open and close positions on tracked fixtures"`
+	Store    cmd.Store    `cmd:"" help:"Maintain the store of price data"`
 }
 
 func main() {
@@ -35,7 +39,7 @@ func main() {
 		os.Exit(1)
 	}
 
-	ctx := kong.Parse(&cli)
+	ctx := kong.Parse(&cli, cmd.OptionalFloatMapper())
 	err := ctx.Run(&types.Globals{
 		AppKey: appkey,
 	})
@@ -0,0 +1,75 @@
+// Copyright 2022 Guy Barden
+// catalogue.go - market catalogues that keep the event each market belongs to
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package access
+
+import (
+	"encoding/json"
+
+	"github.com/guysports/go-betfair-api/pkg/betting"
+	"github.com/guysports/go-betfair-api/pkg/types"
+)
+
+type (
+	// EventCatalogueInterface lists market catalogues with the EVENT projection so markets can be mapped to their
+	// events by id, the event id of each market is keyed by market id
+	EventCatalogueInterface interface {
+		ListEventMarketCatalogue(filter *types.MarketFilter, maxResults int) ([]types.MarketCatalogueWrapper, map[string]string, error)
+	}
+
+	// EventQuery is a betting client whose market catalogues keep the event of each market, which the catalogues
+	// of the betting api drop
+	EventQuery struct {
+		*betting.API
+	}
+
+	eventMarketCatalogue struct {
+		types.MarketCatalogueWrapper
+		Event *types.Detail `json:"event"`
+	}
+)
+
+const (
+	rpcId = 1
+)
+
+// NewEventQuery wraps the betting client, the client's session is shared so a refreshed session is used
+func NewEventQuery(api *betting.API) *EventQuery {
+	return &EventQuery{API: api}
+}
+
+func (q *EventQuery) ListEventMarketCatalogue(filter *types.MarketFilter, maxResults int) ([]types.MarketCatalogueWrapper, map[string]string, error) {
+	params := types.MarketFilterParams{
+		MaxResults:       maxResults,
+		MarketProjection: []string{"EVENT", "RUNNER_METADATA"},
+	}
+	buf, err := q.Client.Do(rpcId, "listMarketCatalogue", filter, &params)
+	if err != nil {
+		return nil, nil, err
+	}
+	catalogue := []eventMarketCatalogue{}
+	if err := json.Unmarshal(buf, &catalogue); err != nil {
+		return nil, nil, err
+	}
+	markets := []types.MarketCatalogueWrapper{}
+	eventIds := map[string]string{}
+	for _, market := range catalogue {
+		markets = append(markets, market.MarketCatalogueWrapper)
+		if market.Event != nil {
+			eventIds[market.MarketId] = market.Event.ID
+		}
+	}
+	return markets, eventIds, nil
+}
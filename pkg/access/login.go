@@ -121,10 +121,7 @@ func (l *Login) BetfairAuthenticate(ctx context.Context, appKey string, client *
 	// Check for existing session key and use if it hasn't expired
 	sessionAuth := SessionData{}
 	sessionDirExists := false
-	sessionHome := os.Getenv("UNIT_TEST_HOME")
-	if sessionHome == "" {
-		sessionHome = fmt.Sprintf("%s/%s", os.Getenv("HOME"), ".betfair")
-	}
+	sessionHome := getSessionHome()
 	if _, err := os.Stat(sessionHome); !os.IsNotExist(err) {
 		sessionDirExists = true
 		// Check sessiondata
@@ -145,6 +142,13 @@ func (l *Login) BetfairAuthenticate(ctx context.Context, appKey string, client *
 	return l.BetfairAuthenticateImpl(client, sessionHome, sessionDirExists)
 }
 
+// RefreshSession forces a new login, replacing the cached session key, for long running clients
+func (l *Login) RefreshSession(client *betting.API) (*betting.API, error) {
+	sessionHome := getSessionHome()
+	_, err := os.Stat(sessionHome)
+	return l.BetfairAuthenticateImpl(client, sessionHome, !os.IsNotExist(err))
+}
+
 func (l *Login) BetfairAuthenticateImpl(client *betting.API, sessionHome string, sessionDirExists bool) (*betting.API, error) {
 	authData, err := client.Client.Authenticate()
 	if err != nil {
@@ -169,3 +173,11 @@ func (l *Login) BetfairAuthenticateImpl(client *betting.API, sessionHome string,
 
 	return client, nil
 }
+
+func getSessionHome() string {
+	sessionHome := os.Getenv("UNIT_TEST_HOME")
+	if sessionHome == "" {
+		sessionHome = fmt.Sprintf("%s/%s", os.Getenv("HOME"), ".betfair")
+	}
+	return sessionHome
+}
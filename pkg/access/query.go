@@ -17,6 +17,7 @@ package access
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 
 	"github.com/guysports/go-betfair-api/pkg/types"
@@ -30,6 +31,15 @@ type (
 		MaxDaysToFixtures int      `json:"maxdays"`
 		MinOdds           float32  `json:"minodds"`
 		MaxOdds           float32  `json:"maxodds"`
+		// KeepTracking continues sampling an admitted fixture even if its prices drift outside the odds range
+		KeepTracking bool `json:"keeptracking"`
+		// LadderDepth records this many levels of the back and lay ladders with traded volume, 0 records best prices only
+		LadderDepth int `json:"ladderdepth"`
+		// MarketTypes are the Betfair market type codes tracked for each admitted fixture, match odds is always
+		// tracked as it decides which fixtures are admitted
+		MarketTypes []string `json:"markettypes"`
+		// InPlay keeps sampling tracked fixtures after kickoff until their market closes
+		InPlay bool `json:"inplay"`
 	}
 
 	QueryInterface interface {
@@ -51,3 +61,43 @@ func NewQuery(queryPath string) (*MarketQuery, error) {
 
 	return &query, nil
 }
+
+const (
+	// MatchOddsMarket is the market type code of the match odds market
+	MatchOddsMarket = "MATCH_ODDS"
+)
+
+// OtherMarketTypes returns the market types to track besides match odds
+func (q *MarketQuery) OtherMarketTypes() []string {
+	others := []string{}
+	for _, marketType := range q.MarketTypes {
+		if marketType != MatchOddsMarket {
+			others = append(others, marketType)
+		}
+	}
+	return others
+}
+
+// HasOddsFilter reports whether a minimum or maximum odds has been set in the query
+func (q *MarketQuery) HasOddsFilter() bool {
+	return q.MinOdds > 0 || q.MaxOdds > 0
+}
+
+// OddsInRange reports whether the price is inside the query odds range, an unset bound is not applied
+func (q *MarketQuery) OddsInRange(price float32) bool {
+	if q.MinOdds > 0 && price < q.MinOdds {
+		return false
+	}
+	if q.MaxOdds > 0 && price > q.MaxOdds {
+		return false
+	}
+	return true
+}
+
+// OddsRange returns a readable description of the query odds range
+func (q *MarketQuery) OddsRange() string {
+	if q.MaxOdds > 0 {
+		return fmt.Sprintf("%.2f to %.2f", q.MinOdds, q.MaxOdds)
+	}
+	return fmt.Sprintf("%.2f and above", q.MinOdds)
+}
@@ -0,0 +1,151 @@
+// Copyright 2022 Guy Barden
+// ratelimit.go - shares a budget of Betfair request weight between concurrent market data requests
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package access
+
+import (
+	"sync"
+	"time"
+
+	"github.com/guysports/go-betfair-api/pkg/types"
+)
+
+type (
+	// RateLimiter is a token bucket of request weight points. Each request takes its weight from the bucket, which
+	// refills at a steady rate up to its capacity, and waits for the points it is short of. Requests reserve their
+	// points in the order they ask, so waiting requests are served in turn
+	RateLimiter struct {
+		// Capacity is the most points the bucket holds, enough for at least one request of the maximum weight
+		Capacity float64
+		// Rate is the number of points added each second
+		Rate float64
+		// Now and Sleep provide the clock, they are replaced in tests
+		Now   func() time.Time
+		Sleep func(time.Duration)
+
+		mu     sync.Mutex
+		tokens float64
+		last   time.Time
+	}
+
+	// LimitedQuery is a query client whose requests each wait on a shared limiter for their request weight
+	LimitedQuery struct {
+		QueryInterface
+		Limiter *RateLimiter
+	}
+)
+
+var (
+	// marketProjectionWeights are the Betfair weights of the market catalogue projections that carry a weight, per
+	// market returned
+	marketProjectionWeights = map[string]int{
+		"MARKET_DESCRIPTION": 1,
+		"RUNNER_METADATA":    1,
+	}
+)
+
+// NewRateLimiter returns a full bucket refilled at the rate of points per second, holding a second's worth of points.
+// Requests are not limited if the rate is not positive
+func NewRateLimiter(weightPerSecond float64) *RateLimiter {
+	if weightPerSecond <= 0 {
+		return nil
+	}
+	capacity := weightPerSecond
+	if capacity < MaxRequestWeight {
+		capacity = MaxRequestWeight
+	}
+	return &RateLimiter{
+		Capacity: capacity,
+		Rate:     weightPerSecond,
+		Now:      time.Now,
+		Sleep:    time.Sleep,
+		tokens:   capacity,
+	}
+}
+
+// Wait takes the weight from the bucket, sleeping until the bucket has refilled enough to cover it. Every request
+// takes at least one point and at most the capacity
+func (r *RateLimiter) Wait(weight int) {
+	if r == nil {
+		return
+	}
+	cost := float64(weight)
+	if cost < 1 {
+		cost = 1
+	}
+	if cost > r.Capacity {
+		cost = r.Capacity
+	}
+	r.mu.Lock()
+	now := r.Now()
+	if !r.last.IsZero() {
+		r.tokens += now.Sub(r.last).Seconds() * r.Rate
+		if r.tokens > r.Capacity {
+			r.tokens = r.Capacity
+		}
+	}
+	r.last = now
+	r.tokens -= cost
+	wait := time.Duration(0)
+	if r.tokens < 0 {
+		wait = time.Duration(-r.tokens / r.Rate * float64(time.Second))
+	}
+	r.mu.Unlock()
+	if wait > 0 {
+		r.Sleep(wait)
+	}
+}
+
+// marketCatalogueWeight is the weight of a listMarketCatalogue request returning up to maxResults markets
+func marketCatalogueWeight(marketProjection []string, maxResults int) int {
+	weight := 0
+	for _, projection := range marketProjection {
+		weight += marketProjectionWeights[projection]
+	}
+	return weight * maxResults
+}
+
+// NewLimitedQuery makes every request of the client wait on the limiter
+func NewLimitedQuery(client QueryInterface, limiter *RateLimiter) *LimitedQuery {
+	return &LimitedQuery{QueryInterface: client, Limiter: limiter}
+}
+
+// ListEvents is not weighted by Betfair, it takes the minimum from the bucket
+func (q *LimitedQuery) ListEvents(filter *types.MarketFilter) ([]types.EventWrapper, error) {
+	q.Limiter.Wait(0)
+	return q.QueryInterface.ListEvents(filter)
+}
+
+func (q *LimitedQuery) ListMarketCatalogue(filter *types.MarketFilter, numEvts int, marketType []string) ([]types.MarketCatalogueWrapper, error) {
+	q.Limiter.Wait(marketCatalogueWeight(marketType, numEvts))
+	return q.QueryInterface.ListMarketCatalogue(filter, numEvts, marketType)
+}
+
+func (q *LimitedQuery) ListMarketBook(marketIds []string, priceProjection *types.PriceProjection, orderProjection string, matchProjection string) ([]types.MarketBookWrapper, error) {
+	q.Limiter.Wait(PriceProjectionWeight(priceProjection) * len(marketIds))
+	return q.QueryInterface.ListMarketBook(marketIds, priceProjection, orderProjection, matchProjection)
+}
+
+// ListEventMarketCatalogue keeps the events of the markets when the wrapped client can, otherwise the markets are
+// listed without them
+func (q *LimitedQuery) ListEventMarketCatalogue(filter *types.MarketFilter, maxResults int) ([]types.MarketCatalogueWrapper, map[string]string, error) {
+	eventClient, ok := q.QueryInterface.(EventCatalogueInterface)
+	if !ok {
+		markets, err := q.ListMarketCatalogue(filter, maxResults, []string{"RUNNER_METADATA"})
+		return markets, map[string]string{}, err
+	}
+	q.Limiter.Wait(marketCatalogueWeight([]string{"EVENT", "RUNNER_METADATA"}, maxResults))
+	return eventClient.ListEventMarketCatalogue(filter, maxResults)
+}
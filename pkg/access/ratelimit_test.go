@@ -0,0 +1,105 @@
+// Copyright 2022 Guy Barden
+// ratelimit_test.go - tests for sharing the Betfair request weight between requests
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package access
+
+import (
+	"guysports/go-football-trader/pkg/fake"
+	"testing"
+	"time"
+
+	"github.com/guysports/go-betfair-api/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiter_Wait(t *testing.T) {
+	type step struct {
+		elapsed   time.Duration
+		weight    int
+		wantSleep time.Duration
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name:  "a full bucket serves at once",
+			steps: []step{{weight: 150}, {weight: 50}},
+		},
+		{
+			name:  "a request short of points waits for them",
+			steps: []step{{weight: 150}, {weight: 100, wantSleep: 500 * time.Millisecond}},
+		},
+		{
+			name: "waiting requests are served in turn",
+			steps: []step{
+				{weight: 200},
+				{weight: 100, wantSleep: time.Second},
+				{weight: 100, wantSleep: 2 * time.Second},
+			},
+		},
+		{
+			name:  "the bucket refills over time up to its capacity",
+			steps: []step{{weight: 200}, {elapsed: time.Minute, weight: 200}, {weight: 10, wantSleep: 100 * time.Millisecond}},
+		},
+		{
+			name:  "requests take at least one point and at most the capacity",
+			steps: []step{{weight: 0}, {weight: 500, wantSleep: 10 * time.Millisecond}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Date(2022, 4, 6, 16, 30, 0, 0, time.UTC)
+			limiter := NewRateLimiter(100)
+			limiter.Now = func() time.Time { return now }
+			for i, step := range tt.steps {
+				now = now.Add(step.elapsed)
+				slept := time.Duration(0)
+				limiter.Sleep = func(d time.Duration) { slept = d }
+				limiter.Wait(step.weight)
+				assert.Equal(t, step.wantSleep, slept, "step %d", i)
+			}
+		})
+	}
+}
+
+func TestNewRateLimiter(t *testing.T) {
+	assert.Nil(t, NewRateLimiter(0))
+	assert.Equal(t, float64(MaxRequestWeight), NewRateLimiter(50).Capacity)
+	assert.Equal(t, float64(1000), NewRateLimiter(1000).Capacity)
+	// Without a limiter requests are not held up
+	var limiter *RateLimiter
+	limiter.Wait(MaxRequestWeight)
+}
+
+func TestLimitedQuery(t *testing.T) {
+	limiter := NewRateLimiter(100)
+	now := time.Date(2022, 4, 6, 16, 30, 0, 0, time.UTC)
+	limiter.Now = func() time.Time { return now }
+	waits := []time.Duration{}
+	limiter.Sleep = func(d time.Duration) { waits = append(waits, d) }
+	q := NewLimitedQuery(&fake.FakeQuery{}, limiter)
+
+	// 3 markets with runner metadata and events weigh 3, then 40 market books with the best offers weigh 200
+	_, eventIds, err := q.ListEventMarketCatalogue(&types.MarketFilter{}, 3)
+	assert.Nil(t, err)
+	assert.NotEmpty(t, eventIds)
+	marketIds := make([]string, 40)
+	_, err = q.ListMarketBook(marketIds, &types.PriceProjection{PriceData: []string{"EX_BEST_OFFERS"}}, "EXECUTABLE", "ROLLED_UP_BY_AVG_PRICE")
+	assert.Nil(t, err)
+	_, err = q.ListEvents(&types.MarketFilter{})
+	assert.Nil(t, err)
+	assert.Equal(t, []time.Duration{30 * time.Millisecond, 40 * time.Millisecond}, waits)
+}
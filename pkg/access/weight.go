@@ -0,0 +1,67 @@
+// Copyright 2022 Guy Barden
+// weight.go - the Betfair request weight of market book requests
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package access
+
+import (
+	"github.com/guysports/go-betfair-api/pkg/types"
+)
+
+const (
+	// MaxRequestWeight is the most weight Betfair accepts in a single listMarketCatalogue or listMarketBook request,
+	// a heavier request fails with TOO_MUCH_DATA
+	MaxRequestWeight = 200
+)
+
+var (
+	// priceDataWeights are the Betfair weights of each price projection, per market requested
+	priceDataWeights = map[string]int{
+		"SP_AVAILABLE":   3,
+		"SP_TRADED":      7,
+		"EX_BEST_OFFERS": 5,
+		"EX_ALL_OFFERS":  17,
+		"EX_TRADED":      17,
+	}
+)
+
+// PriceProjectionWeight is the weight of each market in a listMarketBook request with the projection. Betfair
+// weighs the traded volume with the best or all offers less than the sum of the two
+func PriceProjectionWeight(priceProjection *types.PriceProjection) int {
+	if priceProjection == nil || len(priceProjection.PriceData) == 0 {
+		return 2
+	}
+	requested := map[string]bool{}
+	weight := 0
+	for _, priceData := range priceProjection.PriceData {
+		requested[priceData] = true
+		weight += priceDataWeights[priceData]
+	}
+	switch {
+	case requested["EX_ALL_OFFERS"] && requested["EX_TRADED"]:
+		weight -= 2
+	case requested["EX_BEST_OFFERS"] && requested["EX_TRADED"]:
+		weight -= 2
+	}
+	return weight
+}
+
+// MarketBookBatchSize is the most markets a listMarketBook request with the projection can ask for
+func MarketBookBatchSize(priceProjection *types.PriceProjection) int {
+	size := MaxRequestWeight / PriceProjectionWeight(priceProjection)
+	if size < 1 {
+		return 1
+	}
+	return size
+}
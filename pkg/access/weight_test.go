@@ -0,0 +1,44 @@
+// Copyright 2022 Guy Barden
+// weight_test.go - tests for the Betfair request weight of market book requests
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package access
+
+import (
+	"testing"
+
+	"github.com/guysports/go-betfair-api/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPriceProjectionWeight(t *testing.T) {
+	tests := []struct {
+		name          string
+		projection    *types.PriceProjection
+		wantWeight    int
+		wantBatchSize int
+	}{
+		{name: "no prices", wantWeight: 2, wantBatchSize: 100},
+		{name: "best offers", projection: &types.PriceProjection{PriceData: []string{"EX_BEST_OFFERS"}}, wantWeight: 5, wantBatchSize: 40},
+		{name: "best offers and traded", projection: &types.PriceProjection{PriceData: []string{"EX_BEST_OFFERS", "EX_TRADED"}}, wantWeight: 20, wantBatchSize: 10},
+		{name: "ladders and traded", projection: &types.PriceProjection{PriceData: []string{"EX_ALL_OFFERS", "EX_TRADED"}}, wantWeight: 32, wantBatchSize: 6},
+		{name: "starting prices", projection: &types.PriceProjection{PriceData: []string{"SP_AVAILABLE", "SP_TRADED"}}, wantWeight: 10, wantBatchSize: 20},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.wantWeight, PriceProjectionWeight(tt.projection))
+			assert.Equal(t, tt.wantBatchSize, MarketBookBatchSize(tt.projection))
+		})
+	}
+}
@@ -0,0 +1,231 @@
+// Copyright 2022 Guy Barden
+// profile.go - the odds ranges, stake and commission used to analyze price trends
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package analysis
+
+import (
+	"encoding/json"
+	"fmt"
+	"guysports/go-football-trader/pkg/helper"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+type (
+	OddsRange struct {
+		Low  float32 `json:"low" yaml:"low"`
+		High float32 `json:"high" yaml:"high"`
+	}
+
+	// Profile describes how to bucket and value the trends, unset fields take the defaults
+	Profile struct {
+		// Bucketing is fixed to use the ranges, quantile for ranges holding an equal number of runners or
+		// probability for ranges of equal implied probability
+		Bucketing string      `json:"bucketing" yaml:"bucketing"`
+		Ranges    []OddsRange `json:"ranges" yaml:"ranges"`
+		Buckets   int         `json:"buckets" yaml:"buckets"`
+		Stake     float32     `json:"stake" yaml:"stake"`
+		// Commission is the rate paid on net winnings, nil takes the default so that 0 can be set
+		Commission *float32 `json:"commission" yaml:"commission"`
+		// MinMatched leaves out runners whose market had less matched at its last pre-match sample, 0 keeps every
+		// runner including those stored before market history was recorded
+		MinMatched float32 `json:"min_matched" yaml:"min_matched"`
+	}
+)
+
+const (
+	FixedBucketing       = "fixed"
+	QuantileBucketing    = "quantile"
+	ProbabilityBucketing = "probability"
+
+	DefaultStake      = 100
+	DefaultCommission = 0.02
+	DefaultBuckets    = 6
+
+	// maximumOdds is the highest price on the Betfair exchange
+	maximumOdds = 1000
+)
+
+var (
+	// DefaultRanges are the odds ranges analyzed when none are given
+	DefaultRanges = []OddsRange{
+		{
+			Low:  1.2,
+			High: 1.99,
+		},
+		{
+			Low:  2.0,
+			High: 2.99,
+		},
+		{
+			Low:  3.0,
+			High: 4.99,
+		},
+		{
+			Low:  5.0,
+			High: 9.99,
+		},
+		{
+			Low:  10.0,
+			High: 19.99,
+		},
+		{
+			Low:  20.0,
+			High: 29.99,
+		},
+	}
+)
+
+// LoadProfile reads a profile from a YAML file if it has a .yaml or .yml extension, otherwise from JSON
+func LoadProfile(path string) (*Profile, error) {
+	profileBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	profile := Profile{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(profileBytes, &profile)
+	default:
+		err = json.Unmarshal(profileBytes, &profile)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read analysis profile %s: %s", path, err.Error())
+	}
+	return &profile, nil
+}
+
+// SetDefaults fills in any unset fields and checks the bucketing scheme is known
+func (p *Profile) SetDefaults() error {
+	if p.Bucketing == "" {
+		p.Bucketing = FixedBucketing
+	}
+	switch p.Bucketing {
+	case FixedBucketing, QuantileBucketing, ProbabilityBucketing:
+	default:
+		return fmt.Errorf("bucketing must be one of %s, %s or %s not %s", FixedBucketing, QuantileBucketing, ProbabilityBucketing, p.Bucketing)
+	}
+	if len(p.Ranges) == 0 {
+		p.Ranges = DefaultRanges
+	}
+	if p.Buckets <= 0 {
+		p.Buckets = DefaultBuckets
+	}
+	if p.Stake <= 0 {
+		p.Stake = DefaultStake
+	}
+	if p.Commission == nil {
+		commission := float32(DefaultCommission)
+		p.Commission = &commission
+	}
+	if *p.Commission < 0 {
+		return fmt.Errorf("commission must not be negative not %v", *p.Commission)
+	}
+	return nil
+}
+
+// CommissionRate is the commission set in the profile or the default if it is unset
+func (p *Profile) CommissionRate() float32 {
+	if p.Commission == nil {
+		return DefaultCommission
+	}
+	return *p.Commission
+}
+
+// ParseOddsRange reads a range written as low-high, such as 2.0-2.99
+func ParseOddsRange(text string) (OddsRange, error) {
+	bounds := strings.SplitN(text, "-", 2)
+	if len(bounds) != 2 {
+		return OddsRange{}, fmt.Errorf("odds range %s must be written as low-high", text)
+	}
+	low, err := strconv.ParseFloat(strings.TrimSpace(bounds[0]), 32)
+	if err != nil {
+		return OddsRange{}, fmt.Errorf("odds range %s has an invalid low price", text)
+	}
+	high, err := strconv.ParseFloat(strings.TrimSpace(bounds[1]), 32)
+	if err != nil {
+		return OddsRange{}, fmt.Errorf("odds range %s has an invalid high price", text)
+	}
+	if low > high {
+		return OddsRange{}, fmt.Errorf("odds range %s is the wrong way round", text)
+	}
+	return OddsRange{Low: float32(low), High: float32(high)}, nil
+}
+
+// Contains reports whether the price is inside the range, including both ends
+func (o OddsRange) Contains(price float32) bool {
+	return price >= o.Low && price <= o.High
+}
+
+// OddsRanges returns the ranges to analyze, quantile ranges are built from the start prices of the runners
+func (p *Profile) OddsRanges(prices []float32) []OddsRange {
+	switch p.Bucketing {
+	case QuantileBucketing:
+		return quantileRanges(prices, p.Buckets)
+	case ProbabilityBucketing:
+		return probabilityRanges(p.Buckets)
+	}
+	return p.Ranges
+}
+
+// quantileRanges splits the prices into ranges holding about the same number of prices. Equal prices are kept in
+// the same range, so there may be fewer ranges than asked for
+func quantileRanges(prices []float32, buckets int) []OddsRange {
+	sorted := []float32{}
+	for _, price := range prices {
+		if price > 0 {
+			sorted = append(sorted, price)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	ranges := []OddsRange{}
+	start := 0
+	for bucket := 1; bucket <= buckets && start < len(sorted); bucket++ {
+		end := len(sorted) * bucket / buckets
+		if end <= start {
+			continue
+		}
+		for end < len(sorted) && sorted[end] == sorted[end-1] {
+			end++
+		}
+		ranges = append(ranges, OddsRange{Low: sorted[start], High: sorted[end-1]})
+		start = end
+	}
+	return ranges
+}
+
+// probabilityRanges splits the implied probability of winning into equal bands, from the shortest odds to the
+// longest. The band boundaries are rounded to prices so neighbouring ranges do not overlap
+func probabilityRanges(buckets int) []OddsRange {
+	ranges := make([]OddsRange, buckets)
+	high := float32(maximumOdds)
+	for band := 0; band < buckets; band++ {
+		// Band 0 holds the longest odds, with an implied probability up to 1/buckets
+		low := float32(math.Ceil(float64(buckets)/float64(band+1)*100-1e-9) / 100)
+		if band == buckets-1 {
+			low = 1.01
+		}
+		ranges[buckets-1-band] = OddsRange{Low: low, High: high}
+		high = helper.ConvertTo2DP(low - 0.01)
+	}
+	return ranges
+}
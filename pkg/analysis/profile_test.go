@@ -0,0 +1,154 @@
+// Copyright 2022 Guy Barden
+// profile_test.go - tests for analysis profiles and odds range bucketing
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package analysis
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadProfile(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		want    *Profile
+		wantErr bool
+	}{
+		{
+			name:    "json profile",
+			file:    "profile.json",
+			content: `{"bucketing": "quantile", "buckets": 4, "stake": 10, "commission": 0.05, "ranges": [{"low": 2, "high": 2.99}]}`,
+			want:    &Profile{Bucketing: QuantileBucketing, Buckets: 4, Stake: 10, Commission: commissionRate(0.05), Ranges: []OddsRange{{Low: 2, High: 2.99}}},
+		},
+		{
+			name:    "zero commission",
+			file:    "profile.yaml",
+			content: "commission: 0\n",
+			want:    &Profile{Commission: commissionRate(0)},
+		},
+		{
+			name:    "yaml profile",
+			file:    "profile.yaml",
+			content: "stake: 50\nranges:\n  - low: 1.5\n    high: 1.99\n  - low: 2\n    high: 3\n",
+			want:    &Profile{Stake: 50, Ranges: []OddsRange{{Low: 1.5, High: 1.99}, {Low: 2, High: 3}}},
+		},
+		{
+			name:    "invalid profile",
+			file:    "profile.json",
+			content: "stake: 50",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.file)
+			assert.Nil(t, ioutil.WriteFile(path, []byte(tt.content), 0644))
+			got, err := LoadProfile(path)
+			if tt.wantErr {
+				assert.NotNil(t, err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestProfile_SetDefaults(t *testing.T) {
+	profile := Profile{Stake: 10}
+	assert.Nil(t, profile.SetDefaults())
+	assert.Equal(t, Profile{Bucketing: FixedBucketing, Ranges: DefaultRanges, Buckets: DefaultBuckets, Stake: 10, Commission: commissionRate(DefaultCommission)}, profile)
+
+	profile = Profile{Commission: commissionRate(0)}
+	assert.Nil(t, profile.SetDefaults())
+	assert.Equal(t, float32(0), profile.CommissionRate())
+
+	profile = Profile{Commission: commissionRate(-0.02)}
+	assert.NotNil(t, profile.SetDefaults())
+
+	profile = Profile{Bucketing: "deciles"}
+	assert.NotNil(t, profile.SetDefaults())
+}
+
+func commissionRate(rate float32) *float32 {
+	return &rate
+}
+
+func TestParseOddsRange(t *testing.T) {
+	tests := []struct {
+		text    string
+		want    OddsRange
+		wantErr bool
+	}{
+		{text: "2.0-2.99", want: OddsRange{Low: 2, High: 2.99}},
+		{text: " 10 - 20 ", want: OddsRange{Low: 10, High: 20}},
+		{text: "2.0", wantErr: true},
+		{text: "a-3", wantErr: true},
+		{text: "3-b", wantErr: true},
+		{text: "3-2", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			got, err := ParseOddsRange(tt.text)
+			assert.Equal(t, tt.wantErr, err != nil)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestProfile_OddsRanges(t *testing.T) {
+	prices := []float32{3.0, 1.5, 2.0, 0, 2.0, 2.0, 4.5, 6.0, 1.8}
+	tests := []struct {
+		name    string
+		profile Profile
+		want    []OddsRange
+	}{
+		{
+			name:    "fixed ranges",
+			profile: Profile{Bucketing: FixedBucketing, Ranges: []OddsRange{{Low: 1, High: 2}}},
+			want:    []OddsRange{{Low: 1, High: 2}},
+		},
+		{
+			name:    "quantiles keep equal prices together",
+			profile: Profile{Bucketing: QuantileBucketing, Buckets: 4},
+			want:    []OddsRange{{Low: 1.5, High: 1.8}, {Low: 2.0, High: 2.0}, {Low: 3.0, High: 3.0}, {Low: 4.5, High: 6.0}},
+		},
+		{
+			name:    "more quantiles than prices",
+			profile: Profile{Bucketing: QuantileBucketing, Buckets: 20},
+			want:    []OddsRange{{Low: 1.5, High: 1.5}, {Low: 1.8, High: 1.8}, {Low: 2.0, High: 2.0}, {Low: 3.0, High: 3.0}, {Low: 4.5, High: 4.5}, {Low: 6.0, High: 6.0}},
+		},
+		{
+			name:    "implied probability bands",
+			profile: Profile{Bucketing: ProbabilityBucketing, Buckets: 4},
+			want:    []OddsRange{{Low: 1.01, High: 1.33}, {Low: 1.34, High: 1.99}, {Low: 2, High: 3.99}, {Low: 4, High: 1000}},
+		},
+		{
+			name:    "implied probability bands with rounding",
+			profile: Profile{Bucketing: ProbabilityBucketing, Buckets: 6},
+			want:    []OddsRange{{Low: 1.01, High: 1.19}, {Low: 1.2, High: 1.49}, {Low: 1.5, High: 1.99}, {Low: 2, High: 2.99}, {Low: 3, High: 5.99}, {Low: 6, High: 1000}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.profile.OddsRanges(prices))
+		})
+	}
+}
@@ -0,0 +1,505 @@
+// Copyright 2022 Guy Barden
+// report.go - builds the analysis of price trends by odds range and writes it as text, JSON, CSV or tables
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package analysis
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"guysports/go-football-trader/pkg/hedge"
+	"guysports/go-football-trader/pkg/helper"
+	"guysports/go-football-trader/pkg/store"
+	"io"
+	"math"
+	"sort"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+)
+
+type (
+	// trendGroup is the trends analyzed together, the group of another market is named after its market type
+	trendGroup struct {
+		name   string
+		trends []store.Trend
+	}
+
+	// TrendRow values the price movement of one runner as a trade entered at the start price and exited at the last
+	TrendRow struct {
+		Group          string  `json:"group"`
+		Strategy       string  `json:"strategy"`
+		RangeLow       float32 `json:"range_low"`
+		RangeHigh      float32 `json:"range_high"`
+		StartTime      string  `json:"start_time"`
+		Fixture        string  `json:"fixture"`
+		Team           string  `json:"team"`
+		Samples        int     `json:"samples"`
+		EntryPrice     float32 `json:"entry_price"`
+		OppositePrice  float32 `json:"opposite_price"`
+		ExitPrice      float32 `json:"exit_price"`
+		Delta          float32 `json:"delta"`
+		DeltaPercent   float32 `json:"delta_percent"`
+		HedgeStake     float32 `json:"hedge_stake"`
+		QualifyingLoss float32 `json:"qualifying_loss"`
+		Profit         float32 `json:"profit"`
+	}
+
+	// RangeSummary totals the trend rows of a strategy in an odds range, split by whether the price shortened
+	RangeSummary struct {
+		Group            string  `json:"group"`
+		Strategy         string  `json:"strategy"`
+		RangeLow         float32 `json:"range_low"`
+		RangeHigh        float32 `json:"range_high"`
+		Runners          int     `json:"runners"`
+		CumulativeProfit float32 `json:"cumulative_profit"`
+		ProfitCount      int     `json:"profit_count"`
+		CumulativeLoss   float32 `json:"cumulative_loss"`
+		LossCount        int     `json:"loss_count"`
+	}
+
+	// DriftSummary compares how often runners whose price moved one way won against the chance implied at entry
+	DriftSummary struct {
+		Movement    string  `json:"movement"`
+		Runners     int     `json:"runners"`
+		Winners     int     `json:"winners"`
+		StrikeRate  float64 `json:"strike_rate"`
+		ImpliedRate float64 `json:"implied_rate"`
+	}
+
+	// ShockSummary totals the in-play suspensions put down to the same cause, the shift is the mean change in the
+	// implied probability of the team the event is put down to, or the larger change of the two teams if there is none
+	ShockSummary struct {
+		Event       string  `json:"event"`
+		Suspensions int     `json:"suspensions"`
+		MeanSeconds float64 `json:"mean_seconds"`
+		MeanShift   float64 `json:"mean_shift"`
+	}
+
+	// Report holds the analysis of the trends in the store
+	Report struct {
+		Trends         []TrendRow     `json:"trends"`
+		Ranges         []RangeSummary `json:"ranges"`
+		Drift          []DriftSummary `json:"drift"`
+		SettledRunners int            `json:"settled_runners"`
+		// Correlation is the Pearson correlation of the percentage price movement to winning
+		Correlation float64 `json:"correlation"`
+		// Shocks summarises the in-play suspensions, kept apart from the pre-match trends
+		Shocks []ShockSummary `json:"shocks,omitempty"`
+		// DelayedRunners counts the runners analyzed whose market was sampled from delayed data
+		DelayedRunners int `json:"delayed_runners,omitempty"`
+	}
+)
+
+const (
+	TeamGroup = "team"
+	DrawGroup = "draw"
+
+	// UnclassifiedShock is a suspension that moved the prices too little to be put down to a goal or red card
+	UnclassifiedShock = "unclassified"
+
+	BackFirst = "back_first"
+	LayFirst  = "lay_first"
+
+	TextOutput     = "text"
+	JSONOutput     = "json"
+	CSVOutput      = "csv"
+	MarkdownOutput = "markdown"
+	TableOutput    = "table"
+
+	lineBreak = "__________________________________________________________________________________________"
+)
+
+// NewReport analyzes the trends in the odds ranges of the profile. The draw is analyzed separately from the teams,
+// and the runners of each other market type separately again
+func NewReport(trends []store.Trend, profile *Profile) *Report {
+	report := Report{}
+	trends = liquidTrends(trends, profile.MinMatched)
+	for _, trend := range trends {
+		if trend.Delayed {
+			report.DelayedRunners++
+		}
+	}
+	for _, group := range groupTrends(trends) {
+		for _, odds := range profile.OddsRanges(startPrices(group.trends)) {
+			for _, strategy := range []string{BackFirst, LayFirst} {
+				report.addRange(group.name, strategy, odds, group.trends, profile)
+			}
+		}
+	}
+	report.addDrift(trends)
+	return &report
+}
+
+// liquidTrends returns the trends whose market had at least the minimum matched before kickoff
+func liquidTrends(trends []store.Trend, minMatched float32) []store.Trend {
+	if minMatched <= 0 {
+		return trends
+	}
+	liquid := []store.Trend{}
+	for _, trend := range trends {
+		if trend.MarketMatched >= minMatched {
+			liquid = append(liquid, trend)
+		}
+	}
+	return liquid
+}
+
+func (r *Report) addRange(group string, strategy string, odds OddsRange, trends []store.Trend, profile *Profile) {
+	summary := RangeSummary{Group: group, Strategy: strategy, RangeLow: odds.Low, RangeHigh: odds.High}
+	for _, trend := range trends {
+		if !odds.Contains(trend.StartPrice) {
+			continue
+		}
+		row := TrendRow{
+			Group:        group,
+			Strategy:     strategy,
+			RangeLow:     odds.Low,
+			RangeHigh:    odds.High,
+			StartTime:    trend.StartTime,
+			Fixture:      trend.Fixture,
+			Team:         trend.Team,
+			Samples:      trend.SampleNumber,
+			Delta:        trend.Delta,
+			DeltaPercent: trend.Delta * 100 / trend.StartPrice,
+		}
+		if strategy == BackFirst {
+			row.EntryPrice, row.OppositePrice, row.ExitPrice = trend.StartPrice, trend.StartLayPrice, trend.CurrentPrice
+		} else {
+			row.EntryPrice, row.OppositePrice, row.ExitPrice = trend.StartLayPrice, trend.StartPrice, trend.CurrentLayPrice
+		}
+		row.HedgeStake, row.Profit = hedgeProfit(row.EntryPrice, row.ExitPrice, profile.Stake, profile.CommissionRate())
+		row.QualifyingLoss = helper.ConvertTo2DP(profile.Stake - row.HedgeStake*(1-profile.CommissionRate()))
+		r.Trends = append(r.Trends, row)
+
+		summary.Runners++
+		if trend.Delta > 0 {
+			summary.CumulativeProfit += row.Profit
+			summary.ProfitCount++
+		} else {
+			summary.CumulativeLoss += row.Profit
+			summary.LossCount++
+		}
+	}
+	r.Ranges = append(r.Ranges, summary)
+}
+
+// hedgeProfit returns the stake that closes a position opened at the entry price at the exit price, and the
+// profit it leaves after commission
+func hedgeProfit(entry, exit, stake, commission float32) (float32, float32) {
+	hedgeStake := (stake * entry) / exit
+
+	// Profit = stake - laystake * (1-commission)
+	// Loss = stake - laystake (no commission payable)
+	profit := hedge.NetOfCommission(hedgeStake-stake, commission)
+	return helper.ConvertTo2DP(hedgeStake), helper.ConvertTo2DP(profit)
+}
+
+// addDrift compares the pre-match price movement against the results of settled fixtures
+func (r *Report) addDrift(trends []store.Trend) {
+	drift := []DriftSummary{{Movement: "Shortened"}, {Movement: "Drifted"}, {Movement: "Unchanged"}}
+	implied := make([]float64, len(drift))
+	var moves, outcomes []float64
+	for _, trend := range trends {
+		if !trend.Settled() || trend.StartPrice == 0 {
+			continue
+		}
+		group := 2
+		if trend.Delta > 0 {
+			group = 0
+		} else if trend.Delta < 0 {
+			group = 1
+		}
+		drift[group].Runners++
+		implied[group] += 1 / float64(trend.StartPrice)
+		won := 0.0
+		if trend.RunnerWon() {
+			drift[group].Winners++
+			won = 1
+		}
+		moves = append(moves, float64(trend.Delta*100/trend.StartPrice))
+		outcomes = append(outcomes, won)
+	}
+	for i := range drift {
+		if drift[i].Runners > 0 {
+			drift[i].StrikeRate = float64(drift[i].Winners) * 100 / float64(drift[i].Runners)
+			drift[i].ImpliedRate = implied[i] * 100 / float64(drift[i].Runners)
+		}
+	}
+	r.Drift = drift
+	r.SettledRunners = len(moves)
+	r.Correlation = correlation(moves, outcomes)
+}
+
+// AddShocks summarises the in-play suspensions that have ended by their inferred cause, goals then red cards then
+// those left unclassified
+func (r *Report) AddShocks(shocks []store.Shock) {
+	summaries := []ShockSummary{{Event: string(store.Goal)}, {Event: string(store.RedCard)}, {Event: UnclassifiedShock}}
+	seconds := make([]float64, len(summaries))
+	shifts := make([]float64, len(summaries))
+	for _, shock := range shocks {
+		if shock.End == "" {
+			continue
+		}
+		home := store.ImpliedShift(shock.HomeBefore, shock.HomeAfter)
+		away := store.ImpliedShift(shock.AwayBefore, shock.AwayAfter)
+		i, shift := 2, math.Max(math.Abs(home), math.Abs(away))
+		switch shock.Event {
+		case store.Goal:
+			i = 0
+		case store.RedCard:
+			i = 1
+		}
+		if i < 2 {
+			shift = away
+			if shock.Team == store.HomeSide {
+				shift = home
+			}
+		}
+		summaries[i].Suspensions++
+		seconds[i] += shock.Duration().Seconds()
+		shifts[i] += shift * 100
+	}
+	r.Shocks = []ShockSummary{}
+	for i, summary := range summaries {
+		if summary.Suspensions == 0 {
+			continue
+		}
+		summary.MeanSeconds = seconds[i] / float64(summary.Suspensions)
+		summary.MeanShift = shifts[i] / float64(summary.Suspensions)
+		r.Shocks = append(r.Shocks, summary)
+	}
+}
+
+// correlation returns the Pearson correlation coefficient of two equal length series, 0 if either does not vary
+func correlation(x, y []float64) float64 {
+	n := float64(len(x))
+	if n == 0 {
+		return 0
+	}
+	var sumX, sumY float64
+	for i := range x {
+		sumX += x[i]
+		sumY += y[i]
+	}
+	meanX, meanY := sumX/n, sumY/n
+	var cov, varX, varY float64
+	for i := range x {
+		cov += (x[i] - meanX) * (y[i] - meanY)
+		varX += (x[i] - meanX) * (x[i] - meanX)
+		varY += (y[i] - meanY) * (y[i] - meanY)
+	}
+	if varX == 0 || varY == 0 {
+		return 0
+	}
+	return cov / math.Sqrt(varX*varY)
+}
+
+// groupTrends splits the match odds trends into the teams and the draw, followed by a group for each other market
+// type in name order
+func groupTrends(trends []store.Trend) []trendGroup {
+	groups := []trendGroup{{name: TeamGroup}, {name: DrawGroup}}
+	markets := map[string][]store.Trend{}
+	for _, trend := range trends {
+		switch {
+		case trend.MarketType != "":
+			markets[trend.MarketType] = append(markets[trend.MarketType], trend)
+		case trend.Draw:
+			groups[1].trends = append(groups[1].trends, trend)
+		default:
+			groups[0].trends = append(groups[0].trends, trend)
+		}
+	}
+	marketTypes := []string{}
+	for marketType := range markets {
+		marketTypes = append(marketTypes, marketType)
+	}
+	sort.Strings(marketTypes)
+	for _, marketType := range marketTypes {
+		groups = append(groups, trendGroup{name: marketType, trends: markets[marketType]})
+	}
+	return groups
+}
+
+func startPrices(trends []store.Trend) []float32 {
+	prices := []float32{}
+	for _, trend := range trends {
+		prices = append(prices, trend.StartPrice)
+	}
+	return prices
+}
+
+// Write writes the report in the given output format
+func (r *Report) Write(w io.Writer, format string) error {
+	switch format {
+	case TextOutput, "":
+		return r.writeText(w)
+	case JSONOutput:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(r)
+	case CSVOutput:
+		return r.writeCSV(w)
+	case MarkdownOutput:
+		return r.writeMarkdown(w)
+	case TableOutput:
+		return r.writeTable(w)
+	}
+	return fmt.Errorf("output must be one of %s, %s, %s, %s or %s not %s", TextOutput, JSONOutput, CSVOutput, MarkdownOutput, TableOutput, format)
+}
+
+func (r *Report) writeText(w io.Writer) error {
+	if r.DelayedRunners > 0 {
+		fmt.Fprintf(w, "Warning: %d runners were sampled from delayed data, check the app key receives live prices\n", r.DelayedRunners)
+	}
+	for _, summary := range r.Ranges {
+		label := ""
+		switch summary.Group {
+		case TeamGroup:
+		case DrawGroup:
+			label = "Draw "
+		default:
+			label = summary.Group + " "
+		}
+		strategy := "Back"
+		if summary.Strategy == LayFirst {
+			strategy = "Lay"
+		}
+		fmt.Fprintln(w, lineBreak)
+		fmt.Fprintf(w, "%s%s First price analysis in the %.2f to %.2f range\n", label, strategy, summary.RangeLow, summary.RangeHigh)
+		for _, row := range r.rangeTrends(summary) {
+			fmt.Fprintf(w, "%s %s (%s) (%d) %.2f %.2f %.2f %.2f --- %.2f%% --- £%.2f £%.2f £%.2f\n", row.StartTime, row.Fixture, row.Team, row.Samples, row.EntryPrice, row.OppositePrice, row.ExitPrice, row.Delta, row.DeltaPercent, row.HedgeStake, row.QualifyingLoss, row.Profit)
+		}
+		fmt.Fprintln(w, lineBreak)
+		fmt.Fprintf(w, "Cumulative Profit %.2f (%d)\n", summary.CumulativeProfit, summary.ProfitCount)
+		fmt.Fprintf(w, "Cumulative Loss %.2f (%d)\n", summary.CumulativeLoss, summary.LossCount)
+		fmt.Fprintln(w, lineBreak)
+	}
+
+	fmt.Fprintln(w, lineBreak)
+	fmt.Fprintf(w, "Pre-match price movement against results (%d settled runners)\n", r.SettledRunners)
+	for _, drift := range r.Drift {
+		if drift.Runners == 0 {
+			fmt.Fprintf(w, "%s: no runners\n", drift.Movement)
+			continue
+		}
+		fmt.Fprintf(w, "%s: %d runners, %d winners, strike rate %.2f%% against %.2f%% implied at entry\n", drift.Movement, drift.Runners, drift.Winners, drift.StrikeRate, drift.ImpliedRate)
+	}
+	fmt.Fprintf(w, "Correlation of price movement %% to winning %.3f\n", r.Correlation)
+	fmt.Fprintln(w, lineBreak)
+
+	if len(r.Shocks) == 0 {
+		return nil
+	}
+	fmt.Fprintln(w, "In-play suspensions")
+	for _, shock := range r.Shocks {
+		fmt.Fprintf(w, "%s: %d suspensions, mean %.0fs, implied probability moved %.2f%%\n", shock.Event, shock.Suspensions, shock.MeanSeconds, shock.MeanShift)
+	}
+	fmt.Fprintln(w, lineBreak)
+	return nil
+}
+
+// rangeTrends returns the trend rows counted in a range summary
+func (r *Report) rangeTrends(summary RangeSummary) []TrendRow {
+	rows := []TrendRow{}
+	for _, row := range r.Trends {
+		if row.Group == summary.Group && row.Strategy == summary.Strategy && row.RangeLow == summary.RangeLow && row.RangeHigh == summary.RangeHigh {
+			rows = append(rows, row)
+		}
+	}
+	return rows
+}
+
+// tables returns the trends, ranges and drift as titled tables of formatted cells
+func (r *Report) tables() (titles []string, tables [][][]string) {
+	trends := [][]string{{"group", "strategy", "range_low", "range_high", "start_time", "fixture", "team", "samples", "entry_price", "opposite_price", "exit_price", "delta", "delta_percent", "hedge_stake", "qualifying_loss", "profit"}}
+	for _, row := range r.Trends {
+		trends = append(trends, []string{row.Group, row.Strategy, price(row.RangeLow), price(row.RangeHigh), row.StartTime, row.Fixture, row.Team, fmt.Sprint(row.Samples),
+			price(row.EntryPrice), price(row.OppositePrice), price(row.ExitPrice), price(row.Delta), price(row.DeltaPercent), price(row.HedgeStake), price(row.QualifyingLoss), price(row.Profit)})
+	}
+	ranges := [][]string{{"group", "strategy", "range_low", "range_high", "runners", "cumulative_profit", "profit_count", "cumulative_loss", "loss_count"}}
+	for _, summary := range r.Ranges {
+		ranges = append(ranges, []string{summary.Group, summary.Strategy, price(summary.RangeLow), price(summary.RangeHigh), fmt.Sprint(summary.Runners),
+			price(summary.CumulativeProfit), fmt.Sprint(summary.ProfitCount), price(summary.CumulativeLoss), fmt.Sprint(summary.LossCount)})
+	}
+	drift := [][]string{{"movement", "runners", "winners", "strike_rate", "implied_rate"}}
+	for _, summary := range r.Drift {
+		drift = append(drift, []string{summary.Movement, fmt.Sprint(summary.Runners), fmt.Sprint(summary.Winners), fmt.Sprintf("%.2f", summary.StrikeRate), fmt.Sprintf("%.2f", summary.ImpliedRate)})
+	}
+	return []string{"Trends", "Ranges", "Drift"}, [][][]string{trends, ranges, drift}
+}
+
+// writeCSV writes the trends, ranges and drift tables one after another, separated by an empty line
+func (r *Report) writeCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	_, tables := r.tables()
+	for i, table := range tables {
+		if i > 0 {
+			if err := writer.Write([]string{}); err != nil {
+				return err
+			}
+		}
+		if err := writer.WriteAll(table); err != nil {
+			return err
+		}
+	}
+	return writer.Error()
+}
+
+// writeMarkdown writes each table as a markdown table under a heading of its title
+func (r *Report) writeMarkdown(w io.Writer) error {
+	titles, tables := r.tables()
+	for i, cells := range tables {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintf(w, "## %s\n\n%s\n", titles[i], newTableWriter(cells).RenderMarkdown())
+	}
+	return nil
+}
+
+// writeTable writes each table as a bordered text table titled above its header
+func (r *Report) writeTable(w io.Writer) error {
+	titles, tables := r.tables()
+	for i, cells := range tables {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		writer := newTableWriter(cells)
+		writer.SetTitle(titles[i])
+		fmt.Fprintln(w, writer.Render())
+	}
+	return nil
+}
+
+// newTableWriter holds the cells with the first row as the header
+func newTableWriter(cells [][]string) table.Writer {
+	writer := table.NewWriter()
+	for i, cellRow := range cells {
+		row := make(table.Row, len(cellRow))
+		for j, cell := range cellRow {
+			row[j] = cell
+		}
+		if i == 0 {
+			writer.AppendHeader(row)
+			continue
+		}
+		writer.AppendRow(row)
+	}
+	return writer
+}
+
+func price(value float32) string {
+	return fmt.Sprintf("%.2f", value)
+}
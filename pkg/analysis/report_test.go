@@ -0,0 +1,236 @@
+// Copyright 2022 Guy Barden
+// report_test.go - tests for building and writing the analysis report
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package analysis
+
+import (
+	"bytes"
+	"encoding/json"
+	"guysports/go-football-trader/pkg/store"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testTrends() []store.Trend {
+	return []store.Trend{
+		{Fixture: "Mainz v Dortmund", Team: "Mainz", Home: true, StartTime: "2022-04-06T12:00:00Z", StartPrice: 2.5, StartLayPrice: 2.52, CurrentPrice: 2.0, CurrentLayPrice: 1.98, Delta: 0.5, SampleNumber: 3, OutCome: store.HomeWin},
+		{Fixture: "Mainz v Dortmund", Team: "Dortmund", StartTime: "2022-04-06T12:00:00Z", StartPrice: 4.0, StartLayPrice: 4.1, CurrentPrice: 5.0, CurrentLayPrice: 4.9, Delta: -1, SampleNumber: 3, OutCome: store.HomeWin},
+		{Fixture: "Mainz v Dortmund", Team: "The Draw", Draw: true, StartTime: "2022-04-06T12:00:00Z", StartPrice: 3.5, StartLayPrice: 3.55, CurrentPrice: 3.5, CurrentLayPrice: 3.45, SampleNumber: 3, OutCome: store.HomeWin},
+	}
+}
+
+func testProfile() *Profile {
+	return &Profile{Bucketing: FixedBucketing, Ranges: []OddsRange{{Low: 2, High: 2.99}}, Stake: 100, Commission: commissionRate(0.02)}
+}
+
+func TestNewReport(t *testing.T) {
+	report := NewReport(testTrends(), testProfile())
+
+	assert.Equal(t, []TrendRow{
+		{Group: TeamGroup, Strategy: BackFirst, RangeLow: 2, RangeHigh: 2.99, StartTime: "2022-04-06T12:00:00Z", Fixture: "Mainz v Dortmund", Team: "Mainz", Samples: 3,
+			EntryPrice: 2.5, OppositePrice: 2.52, ExitPrice: 2.0, Delta: 0.5, DeltaPercent: 20, HedgeStake: 125, QualifyingLoss: -22.5, Profit: 24.5},
+		{Group: TeamGroup, Strategy: LayFirst, RangeLow: 2, RangeHigh: 2.99, StartTime: "2022-04-06T12:00:00Z", Fixture: "Mainz v Dortmund", Team: "Mainz", Samples: 3,
+			EntryPrice: 2.52, OppositePrice: 2.5, ExitPrice: 1.98, Delta: 0.5, DeltaPercent: 20, HedgeStake: 127.27, QualifyingLoss: -24.72, Profit: 26.73},
+	}, report.Trends)
+	assert.Equal(t, []RangeSummary{
+		{Group: TeamGroup, Strategy: BackFirst, RangeLow: 2, RangeHigh: 2.99, Runners: 1, CumulativeProfit: 24.5, ProfitCount: 1},
+		{Group: TeamGroup, Strategy: LayFirst, RangeLow: 2, RangeHigh: 2.99, Runners: 1, CumulativeProfit: 26.73, ProfitCount: 1},
+		{Group: DrawGroup, Strategy: BackFirst, RangeLow: 2, RangeHigh: 2.99},
+		{Group: DrawGroup, Strategy: LayFirst, RangeLow: 2, RangeHigh: 2.99},
+	}, report.Ranges)
+	assert.Equal(t, []DriftSummary{
+		{Movement: "Shortened", Runners: 1, Winners: 1, StrikeRate: 100, ImpliedRate: 40},
+		{Movement: "Drifted", Runners: 1, StrikeRate: 0, ImpliedRate: 25},
+		{Movement: "Unchanged", Runners: 1, StrikeRate: 0, ImpliedRate: 28.57142857142857},
+	}, report.Drift)
+	assert.Equal(t, 3, report.SettledRunners)
+	assert.InDelta(t, 0.9, report.Correlation, 0.1)
+}
+
+func TestNewReport_Markets(t *testing.T) {
+	trends := append(testTrends(),
+		store.Trend{Fixture: "Mainz v Dortmund", Team: "Under 2.5 Goals", MarketType: "OVER_UNDER_25", StartTime: "2022-04-06T12:00:00Z", StartPrice: 2.2, StartLayPrice: 2.22, CurrentPrice: 2.1, CurrentLayPrice: 2.08, Delta: 0.1, SampleNumber: 3, RunnerStatus: store.RunnerWinner},
+		store.Trend{Fixture: "Mainz v Dortmund", Team: "Mainz -0.5", MarketType: "ASIAN_HANDICAP", StartTime: "2022-04-06T12:00:00Z", StartPrice: 2.5, StartLayPrice: 2.52, CurrentPrice: 2.6, CurrentLayPrice: 2.58, Delta: -0.1, SampleNumber: 3},
+	)
+	report := NewReport(trends, testProfile())
+
+	groups := []string{}
+	for _, summary := range report.Ranges {
+		groups = append(groups, summary.Group)
+	}
+	assert.Equal(t, []string{TeamGroup, TeamGroup, DrawGroup, DrawGroup, "ASIAN_HANDICAP", "ASIAN_HANDICAP", "OVER_UNDER_25", "OVER_UNDER_25"}, groups)
+	assert.Equal(t, 1, report.Ranges[4].Runners)
+	assert.Equal(t, 1, report.Ranges[6].Runners)
+	// The unsettled handicap runner is left out of the movement against results
+	assert.Equal(t, 4, report.SettledRunners)
+	assert.Equal(t, 2, report.Drift[0].Winners)
+
+	out := bytes.Buffer{}
+	assert.Nil(t, report.Write(&out, TextOutput))
+	assert.Contains(t, out.String(), "OVER_UNDER_25 Back First price analysis in the 2.00 to 2.99 range")
+}
+
+func TestNewReport_MinMatched(t *testing.T) {
+	trends := testTrends()
+	trends[0].MarketMatched, trends[0].Delayed = 50000, true
+	trends[1].MarketMatched = 500
+	profile := testProfile()
+	profile.MinMatched = 1000
+	report := NewReport(trends, profile)
+
+	// Only the home runner's market had enough matched
+	assert.Equal(t, 1, report.SettledRunners)
+	assert.Equal(t, 1, report.DelayedRunners)
+	out := bytes.Buffer{}
+	assert.Nil(t, report.Write(&out, TextOutput))
+	assert.Contains(t, out.String(), "Warning: 1 runners were sampled from delayed data")
+}
+
+func TestReport_AddShocks(t *testing.T) {
+	suspension := func(start, end string, event store.InPlayEvent, team store.Side, homeBefore, awayBefore, homeAfter, awayAfter float32) store.Shock {
+		return store.Shock{Fixture: "Mainz v Dortmund", Suspension: store.Suspension{Start: start, End: end, Event: event, Team: team,
+			HomeBefore: homeBefore, AwayBefore: awayBefore, HomeAfter: homeAfter, AwayAfter: awayAfter}}
+	}
+	report := NewReport(testTrends(), testProfile())
+	report.AddShocks([]store.Shock{
+		suspension("2022-04-06T16:40:00Z", "2022-04-06T16:41:00Z", store.Goal, store.HomeSide, 2.5, 3.2, 1.6, 6.4),
+		suspension("2022-04-06T16:50:00Z", "2022-04-06T16:52:00Z", store.Goal, store.AwaySide, 1.6, 6.4, 2.5, 3.2),
+		suspension("2022-04-06T17:00:00Z", "2022-04-06T17:00:30Z", "", "", 2.5, 3.2, 2.48, 3.25),
+		// Still suspended
+		suspension("2022-04-06T17:10:00Z", "", "", "", 2.5, 3.2, 0, 0),
+	})
+
+	assert.Equal(t, 2, len(report.Shocks))
+	assert.Equal(t, string(store.Goal), report.Shocks[0].Event)
+	assert.Equal(t, 2, report.Shocks[0].Suspensions)
+	assert.Equal(t, float64(90), report.Shocks[0].MeanSeconds)
+	assert.InDelta(t, 19.06, report.Shocks[0].MeanShift, 0.01)
+	assert.Equal(t, UnclassifiedShock, report.Shocks[1].Event)
+	assert.Equal(t, float64(30), report.Shocks[1].MeanSeconds)
+
+	out := bytes.Buffer{}
+	assert.Nil(t, report.Write(&out, TextOutput))
+	assert.Contains(t, out.String(), "goal: 2 suspensions, mean 90s")
+}
+
+func TestReport_Write(t *testing.T) {
+	report := &Report{
+		Trends: []TrendRow{{Group: TeamGroup, Strategy: BackFirst, RangeLow: 2, RangeHigh: 2.99, StartTime: "2022-04-06T12:00:00Z", Fixture: "Mainz v Dortmund", Team: "Mainz", Samples: 3,
+			EntryPrice: 2.5, OppositePrice: 2.52, ExitPrice: 2.0, Delta: 0.5, DeltaPercent: 20, HedgeStake: 125, QualifyingLoss: -22.5, Profit: 24.5}},
+		Ranges: []RangeSummary{{Group: TeamGroup, Strategy: BackFirst, RangeLow: 2, RangeHigh: 2.99, Runners: 1, CumulativeProfit: 24.5, ProfitCount: 1}},
+		Drift:  []DriftSummary{{Movement: "Shortened", Runners: 1, Winners: 1, StrikeRate: 100, ImpliedRate: 40}},
+	}
+	tests := []struct {
+		format  string
+		want    string
+		wantErr bool
+	}{
+		{
+			format: CSVOutput,
+			want: `group,strategy,range_low,range_high,start_time,fixture,team,samples,entry_price,opposite_price,exit_price,delta,delta_percent,hedge_stake,qualifying_loss,profit
+team,back_first,2.00,2.99,2022-04-06T12:00:00Z,Mainz v Dortmund,Mainz,3,2.50,2.52,2.00,0.50,20.00,125.00,-22.50,24.50
+
+group,strategy,range_low,range_high,runners,cumulative_profit,profit_count,cumulative_loss,loss_count
+team,back_first,2.00,2.99,1,24.50,1,0.00,0
+
+movement,runners,winners,strike_rate,implied_rate
+Shortened,1,1,100.00,40.00
+`,
+		},
+		{
+			format: MarkdownOutput,
+			want: `## Trends
+
+| group | strategy | range_low | range_high | start_time | fixture | team | samples | entry_price | opposite_price | exit_price | delta | delta_percent | hedge_stake | qualifying_loss | profit |
+| --- | --- | --- | --- | --- | --- | --- | --- | --- | --- | --- | --- | --- | --- | --- | --- |
+| team | back_first | 2.00 | 2.99 | 2022-04-06T12:00:00Z | Mainz v Dortmund | Mainz | 3 | 2.50 | 2.52 | 2.00 | 0.50 | 20.00 | 125.00 | -22.50 | 24.50 |
+
+## Ranges
+
+| group | strategy | range_low | range_high | runners | cumulative_profit | profit_count | cumulative_loss | loss_count |
+| --- | --- | --- | --- | --- | --- | --- | --- | --- |
+| team | back_first | 2.00 | 2.99 | 1 | 24.50 | 1 | 0.00 | 0 |
+
+## Drift
+
+| movement | runners | winners | strike_rate | implied_rate |
+| --- | --- | --- | --- | --- |
+| Shortened | 1 | 1 | 100.00 | 40.00 |
+`,
+		},
+		{
+			format: TableOutput,
+			want: `+----------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------+
+| Trends                                                                                                                                                                                                               |
++-------+------------+-----------+------------+----------------------+------------------+-------+---------+-------------+----------------+------------+-------+---------------+-------------+-----------------+--------+
+| GROUP | STRATEGY   | RANGE_LOW | RANGE_HIGH | START_TIME           | FIXTURE          | TEAM  | SAMPLES | ENTRY_PRICE | OPPOSITE_PRICE | EXIT_PRICE | DELTA | DELTA_PERCENT | HEDGE_STAKE | QUALIFYING_LOSS | PROFIT |
++-------+------------+-----------+------------+----------------------+------------------+-------+---------+-------------+----------------+------------+-------+---------------+-------------+-----------------+--------+
+| team  | back_first | 2.00      | 2.99       | 2022-04-06T12:00:00Z | Mainz v Dortmund | Mainz | 3       | 2.50        | 2.52           | 2.00       | 0.50  | 20.00         | 125.00      | -22.50          | 24.50  |
++-------+------------+-----------+------------+----------------------+------------------+-------+---------+-------------+----------------+------------+-------+---------------+-------------+-----------------+--------+
+
++-------------------------------------------------------------------------------------------------------------------------+
+| Ranges                                                                                                                  |
++-------+------------+-----------+------------+---------+-------------------+--------------+-----------------+------------+
+| GROUP | STRATEGY   | RANGE_LOW | RANGE_HIGH | RUNNERS | CUMULATIVE_PROFIT | PROFIT_COUNT | CUMULATIVE_LOSS | LOSS_COUNT |
++-------+------------+-----------+------------+---------+-------------------+--------------+-----------------+------------+
+| team  | back_first | 2.00      | 2.99       | 1       | 24.50             | 1            | 0.00            | 0          |
++-------+------------+-----------+------------+---------+-------------------+--------------+-----------------+------------+
+
++------------------------------------------------------------+
+| Drift                                                      |
++-----------+---------+---------+-------------+--------------+
+| MOVEMENT  | RUNNERS | WINNERS | STRIKE_RATE | IMPLIED_RATE |
++-----------+---------+---------+-------------+--------------+
+| Shortened | 1       | 1       | 100.00      | 40.00        |
++-----------+---------+---------+-------------+--------------+
+`,
+		},
+		{
+			format: TextOutput,
+			want: `__________________________________________________________________________________________
+Back First price analysis in the 2.00 to 2.99 range
+2022-04-06T12:00:00Z Mainz v Dortmund (Mainz) (3) 2.50 2.52 2.00 0.50 --- 20.00% --- £125.00 £-22.50 £24.50
+__________________________________________________________________________________________
+Cumulative Profit 24.50 (1)
+Cumulative Loss 0.00 (0)
+__________________________________________________________________________________________
+__________________________________________________________________________________________
+Pre-match price movement against results (0 settled runners)
+Shortened: 1 runners, 1 winners, strike rate 100.00% against 40.00% implied at entry
+Correlation of price movement % to winning 0.000
+__________________________________________________________________________________________
+`,
+		},
+		{
+			format:  "xml",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			out := bytes.Buffer{}
+			err := report.Write(&out, tt.format)
+			assert.Equal(t, tt.wantErr, err != nil)
+			assert.Equal(t, tt.want, out.String())
+		})
+	}
+
+	out := bytes.Buffer{}
+	assert.Nil(t, report.Write(&out, JSONOutput))
+	decoded := &Report{}
+	assert.Nil(t, json.Unmarshal(out.Bytes(), decoded))
+	assert.Equal(t, report, decoded)
+}
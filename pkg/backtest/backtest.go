@@ -0,0 +1,419 @@
+// Copyright 2022 Guy Barden
+// backtest.go - replays the price history in the store through a strategy and reports how its trades performed
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backtest
+
+import (
+	"fmt"
+	"guysports/go-football-trader/pkg/hedge"
+	"guysports/go-football-trader/pkg/helper"
+	"guysports/go-football-trader/pkg/order"
+	"guysports/go-football-trader/pkg/store"
+	"math"
+	"sort"
+	"time"
+)
+
+type (
+	// Backtest holds the strategy to replay and how its positions are staked and charged
+	Backtest struct {
+		Strategy   Strategy
+		Staking    Staking
+		Bank       float32
+		Commission float32
+	}
+
+	// TradeBet is a simulated bet matched during the replay
+	TradeBet struct {
+		Timestamp string
+		Side      order.Side
+		Price     float32
+		Stake     float32
+	}
+
+	// Trade is the position taken on one runner of a fixture
+	Trade struct {
+		LeagueId    string
+		EventId     string
+		Fixture     string
+		Kickoff     string
+		Runner      string
+		SelectionId int
+		Bets        []TradeBet
+		// Stake is the amount risked by the opening bet, the stake of a back or the liability of a lay
+		Stake      float32
+		Closed     bool
+		Settled    bool
+		Won        bool
+		ProfitLoss float32
+	}
+
+	// Stats summarises the settled trades of a backtest
+	Stats struct {
+		Trades      int
+		Settled     int
+		Winners     int
+		Staked      float32
+		ProfitLoss  float32
+		ROI         float32
+		StrikeRate  float32
+		MaxDrawdown float32
+		// Sharpe is the mean return per unit staked of the trades over its standard deviation
+		Sharpe    float32
+		FinalBank float32
+	}
+
+	// Report holds the trades of a backtest in the order they were opened and their stats
+	Report struct {
+		Trades []Trade
+		Stats  Stats
+	}
+
+	// replayEvent is a price sample or a kickoff to pass to the strategy
+	replayEvent struct {
+		at          time.Time
+		leagueId    string
+		eventId     string
+		selectionId int
+		index       int
+		kickoff     bool
+	}
+
+	replay struct {
+		backtest *Backtest
+		bank     float32
+		// fixtures holds the state of each fixture as the strategy sees it, keyed by event id
+		fixtures  map[string]*store.FixturePrices
+		trades    map[string]map[int]*Trade
+		opened    []*Trade
+		timestamp string
+	}
+
+	// fixtureTrader trades the fixture whose sample or kickoff is being replayed
+	fixtureTrader struct {
+		replay   *replay
+		leagueId string
+		eventId  string
+	}
+)
+
+// Run replays every fixture in the store in timestamp order and settles the positions at kickoff against the
+// recorded results. Samples recorded after kickoff are not replayed
+func (b *Backtest) Run(globalPriceStore map[string]map[string]store.FixturePrices) Report {
+	r := replay{
+		backtest: b,
+		bank:     b.Bank,
+		fixtures: map[string]*store.FixturePrices{},
+		trades:   map[string]map[int]*Trade{},
+	}
+
+	for _, event := range replayEvents(globalPriceStore) {
+		fixture := globalPriceStore[event.leagueId][event.eventId]
+		state, ok := r.fixtures[event.eventId]
+		if !ok {
+			// The result is withheld until the fixture is settled
+			state = &store.FixturePrices{
+				Fixture:      fixture.Fixture,
+				Date:         fixture.Date,
+				MatchStatus:  store.Scheduled,
+				EventID:      fixture.EventID,
+				MarketID:     fixture.MarketID,
+				HomeRunnerId: fixture.HomeRunnerId,
+				AwayRunnerId: fixture.AwayRunnerId,
+				DrawRunnerId: fixture.DrawRunnerId,
+				PriceHistory: map[int][]store.Price{},
+			}
+			r.fixtures[event.eventId] = state
+		}
+		trader := &fixtureTrader{replay: &r, leagueId: event.leagueId, eventId: event.eventId}
+
+		if event.kickoff {
+			r.timestamp = fixture.Date
+			b.Strategy.OnKickoff(*state, trader)
+			r.settle(event.eventId, fixture)
+			continue
+		}
+		price := fixture.PriceHistory[event.selectionId][event.index]
+		r.timestamp = price.Timestamp
+		state.PriceHistory[event.selectionId] = append(state.PriceHistory[event.selectionId], price)
+		b.Strategy.OnSample(*state, event.selectionId, trader)
+	}
+
+	report := Report{}
+	for _, trade := range r.opened {
+		report.Trades = append(report.Trades, *trade)
+	}
+	report.Stats = newStats(b.Bank, report.Trades)
+	return report
+}
+
+// replayEvents orders the pre-kickoff samples and kickoffs of all fixtures by time. A kickoff follows samples
+// taken at the same time, and ties are broken by league, event, runner and sample so replays are repeatable
+func replayEvents(globalPriceStore map[string]map[string]store.FixturePrices) []replayEvent {
+	events := []replayEvent{}
+	for leagueId, league := range globalPriceStore {
+		for eventId, fixture := range league {
+			kickoff, err := time.Parse(time.RFC3339, fixture.Date)
+			hasKickoff := err == nil
+			if hasKickoff {
+				events = append(events, replayEvent{at: kickoff, leagueId: leagueId, eventId: eventId, kickoff: true})
+			}
+			for selectionId, history := range fixture.PriceHistory {
+				for idx, price := range history {
+					sampled, err := time.Parse(time.RFC3339, price.Timestamp)
+					if err != nil || (hasKickoff && !sampled.Before(kickoff)) {
+						continue
+					}
+					events = append(events, replayEvent{at: sampled, leagueId: leagueId, eventId: eventId, selectionId: selectionId, index: idx})
+				}
+			}
+		}
+	}
+	sort.Slice(events, func(i, j int) bool {
+		a, b := events[i], events[j]
+		switch {
+		case !a.at.Equal(b.at):
+			return a.at.Before(b.at)
+		case a.kickoff != b.kickoff:
+			return b.kickoff
+		case a.leagueId != b.leagueId:
+			return a.leagueId < b.leagueId
+		case a.eventId != b.eventId:
+			return a.eventId < b.eventId
+		case a.selectionId != b.selectionId:
+			return a.selectionId < b.selectionId
+		}
+		return a.index < b.index
+	})
+	return events
+}
+
+// settle records the profit or loss of the positions on a fixture, and adds it to the bank. Positions settle against
+// the result, a closed position on a fixture without a result settles at its green up profit if the runner wins,
+// which rounding leaves within a penny of the profit if it loses
+func (r *replay) settle(eventId string, fixture store.FixturePrices) {
+	selectionIds := []int{}
+	for selectionId := range r.trades[eventId] {
+		selectionIds = append(selectionIds, selectionId)
+	}
+	sort.Ints(selectionIds)
+	for _, selectionId := range selectionIds {
+		trade := r.trades[eventId][selectionId]
+		won, settled := fixture.RunnerResult(selectionId)
+		if !settled && !trade.Closed {
+			continue
+		}
+		position := trade.position()
+		profitLoss := position.IfLose
+		if won || !settled {
+			profitLoss = position.IfWin
+		}
+		trade.Settled = true
+		trade.Won = won
+		trade.ProfitLoss = helper.ConvertTo2DP(hedge.NetOfCommission(profitLoss, r.backtest.Commission))
+		r.bank += trade.ProfitLoss
+	}
+}
+
+func (t *Trade) position() hedge.Position {
+	bets := []hedge.Bet{}
+	for _, bet := range t.Bets {
+		bets = append(bets, hedge.Bet{Side: bet.Side, Price: bet.Price, Stake: bet.Stake})
+	}
+	return hedge.NewPosition(bets)
+}
+
+func (t *Trade) kickoff() time.Time {
+	kickoff, _ := time.Parse(time.RFC3339, t.Kickoff)
+	return kickoff
+}
+
+func (f *fixtureTrader) Open(selectionId int, side order.Side) error {
+	price, err := f.latestPrice(selectionId)
+	if err != nil {
+		return err
+	}
+	trade := f.replay.trades[f.eventId][selectionId]
+	if trade != nil && trade.Closed {
+		return fmt.Errorf("position on %d in %s has been closed", selectionId, trade.Fixture)
+	}
+
+	stake := helper.ConvertTo2DP(f.replay.backtest.Staking.Stake(f.replay.bank))
+	if stake <= 0 {
+		return fmt.Errorf("no stake available from a bank of %.2f", f.replay.bank)
+	}
+	bet := TradeBet{Timestamp: f.replay.timestamp, Side: side, Price: price.BackPrice, Stake: stake}
+	risk := stake
+	if side == order.Lay {
+		bet.Price = price.LayPrice
+		risk = helper.ConvertTo2DP(stake * (price.LayPrice - 1))
+	}
+	if bet.Price <= 0 {
+		return fmt.Errorf("no %s price available for %d", side, selectionId)
+	}
+
+	if trade == nil {
+		fixture := f.replay.fixtures[f.eventId]
+		trade = &Trade{
+			LeagueId:    f.leagueId,
+			EventId:     f.eventId,
+			Fixture:     fixture.Fixture,
+			Kickoff:     fixture.Date,
+			Runner:      runnerName(fixture, selectionId),
+			SelectionId: selectionId,
+		}
+		if f.replay.trades[f.eventId] == nil {
+			f.replay.trades[f.eventId] = map[int]*Trade{}
+		}
+		f.replay.trades[f.eventId][selectionId] = trade
+		f.replay.opened = append(f.replay.opened, trade)
+	}
+	trade.Bets = append(trade.Bets, bet)
+	trade.Stake += risk
+	return nil
+}
+
+func (f *fixtureTrader) Close(selectionId int) error {
+	trade := f.replay.trades[f.eventId][selectionId]
+	if trade == nil || trade.Closed {
+		return fmt.Errorf("no open position on %d", selectionId)
+	}
+	price, err := f.latestPrice(selectionId)
+	if err != nil {
+		return err
+	}
+	// Without a price to hedge at the position stays open, so the strategy can close it on a later sample
+	position := trade.position()
+	closing := hedge.GreenUp(position, price.BackPrice, price.LayPrice, f.replay.backtest.Commission)
+	if closing.Stake <= 0 && closing.Price <= 0 && helper.ConvertTo2DP(position.IfWin-position.IfLose) != 0 {
+		return fmt.Errorf("no %s price available to close the position on %d", closing.Side, selectionId)
+	}
+	if closing.Stake > 0 {
+		trade.Bets = append(trade.Bets, TradeBet{Timestamp: f.replay.timestamp, Side: closing.Side, Price: closing.Price, Stake: closing.Stake})
+	}
+	trade.Closed = true
+	return nil
+}
+
+func (f *fixtureTrader) Position(selectionId int) (hedge.Position, bool) {
+	trade := f.replay.trades[f.eventId][selectionId]
+	if trade == nil {
+		return hedge.Position{}, false
+	}
+	return trade.position(), !trade.Closed
+}
+
+func (f *fixtureTrader) Traded(selectionId int) bool {
+	return f.replay.trades[f.eventId][selectionId] != nil
+}
+
+func (f *fixtureTrader) GreenUp(selectionId int) hedge.Hedge {
+	position, _ := f.Position(selectionId)
+	price, err := f.latestPrice(selectionId)
+	if err != nil {
+		return hedge.Hedge{Position: position}
+	}
+	return hedge.GreenUp(position, price.BackPrice, price.LayPrice, f.replay.backtest.Commission)
+}
+
+func (f *fixtureTrader) latestPrice(selectionId int) (store.Price, error) {
+	history := f.replay.fixtures[f.eventId].PriceHistory[selectionId]
+	if len(history) == 0 {
+		return store.Price{}, fmt.Errorf("no prices recorded yet for %d", selectionId)
+	}
+	return history[len(history)-1], nil
+}
+
+func runnerName(fixture *store.FixturePrices, selectionId int) string {
+	switch selectionId {
+	case fixture.HomeRunnerId:
+		return "home"
+	case fixture.AwayRunnerId:
+		return "away"
+	case fixture.DrawRunnerId:
+		return "draw"
+	}
+	return fmt.Sprintf("%d", selectionId)
+}
+
+// newStats summarises the settled trades, the drawdown is measured over the bank as each trade settles
+func newStats(bank float32, trades []Trade) Stats {
+	stats := Stats{Trades: len(trades), FinalBank: bank}
+	settledTrades := []Trade{}
+	for _, trade := range trades {
+		if trade.Settled {
+			settledTrades = append(settledTrades, trade)
+		}
+	}
+	// Trades settle at kickoff, which can be in a different order to when they were opened
+	sort.SliceStable(settledTrades, func(i, j int) bool {
+		return settledTrades[i].kickoff().Before(settledTrades[j].kickoff())
+	})
+
+	peak := bank
+	returns := []float64{}
+	for _, trade := range settledTrades {
+		stats.Settled++
+		if trade.ProfitLoss > 0 {
+			stats.Winners++
+		}
+		stats.Staked += trade.Stake
+		stats.ProfitLoss += trade.ProfitLoss
+		stats.FinalBank += trade.ProfitLoss
+		if stats.FinalBank > peak {
+			peak = stats.FinalBank
+		}
+		if peak-stats.FinalBank > stats.MaxDrawdown {
+			stats.MaxDrawdown = peak - stats.FinalBank
+		}
+		if trade.Stake > 0 {
+			returns = append(returns, float64(trade.ProfitLoss/trade.Stake))
+		}
+	}
+	if stats.Settled == 0 {
+		return stats
+	}
+	stats.StrikeRate = helper.ConvertTo2DP(float32(stats.Winners) * 100 / float32(stats.Settled))
+	if stats.Staked > 0 {
+		stats.ROI = helper.ConvertTo2DP(stats.ProfitLoss * 100 / stats.Staked)
+	}
+	stats.Staked = helper.ConvertTo2DP(stats.Staked)
+	stats.ProfitLoss = helper.ConvertTo2DP(stats.ProfitLoss)
+	stats.FinalBank = helper.ConvertTo2DP(stats.FinalBank)
+	stats.MaxDrawdown = helper.ConvertTo2DP(stats.MaxDrawdown)
+	stats.Sharpe = sharpe(returns)
+	return stats
+}
+
+// sharpe returns the mean of the returns over their standard deviation, 0 if there are too few to vary
+func sharpe(returns []float64) float32 {
+	if len(returns) < 2 {
+		return 0
+	}
+	var sum float64
+	for _, r := range returns {
+		sum += r
+	}
+	mean := sum / float64(len(returns))
+	var variance float64
+	for _, r := range returns {
+		variance += (r - mean) * (r - mean)
+	}
+	deviation := math.Sqrt(variance / float64(len(returns)-1))
+	if deviation == 0 {
+		return 0
+	}
+	return float32(math.Round(mean/deviation*1000) / 1000)
+}
@@ -0,0 +1,255 @@
+// Copyright 2022 Guy Barden
+// backtest_test.go - tests for replaying the store through a strategy
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backtest
+
+import (
+	"fmt"
+	"guysports/go-football-trader/pkg/order"
+	"guysports/go-football-trader/pkg/store"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingStrategy struct {
+	calls []string
+}
+
+func (r *recordingStrategy) OnSample(fixture store.FixturePrices, selectionId int, trader Trader) {
+	history := fixture.PriceHistory[selectionId]
+	r.calls = append(r.calls, fmt.Sprintf("%s %d %s %s", history[len(history)-1].Timestamp, selectionId, fixture.MatchStatus, fixture.OutCome))
+}
+
+func (r *recordingStrategy) OnKickoff(fixture store.FixturePrices, trader Trader) {
+	r.calls = append(r.calls, fmt.Sprintf("%s kickoff %s", fixture.Date, fixture.Fixture))
+}
+
+func testPriceStore() map[string]map[string]store.FixturePrices {
+	return map[string]map[string]store.FixturePrices{
+		"league1": {
+			"fixture1": {
+				Fixture:      "Mainz v Dortmund",
+				Date:         "2022-04-06T12:00:00Z",
+				MatchStatus:  store.Played,
+				OutCome:      store.HomeWin,
+				HomeRunnerId: 1,
+				AwayRunnerId: 2,
+				PriceHistory: map[int][]store.Price{
+					1: {
+						{Timestamp: "2022-04-06T10:00:00Z", BackPrice: 3.0, LayPrice: 3.05},
+						{Timestamp: "2022-04-06T11:00:00Z", BackPrice: 2.5, LayPrice: 2.52},
+						{Timestamp: "2022-04-06T12:30:00Z", BackPrice: 1.5, LayPrice: 1.52},
+					},
+				},
+			},
+		},
+		"league2": {
+			"fixture2": {
+				Fixture:      "Roma v Lazio",
+				Date:         "2022-04-06T13:00:00Z",
+				MatchStatus:  store.Played,
+				OutCome:      store.AwayWin,
+				HomeRunnerId: 3,
+				AwayRunnerId: 4,
+				PriceHistory: map[int][]store.Price{
+					3: {
+						{Timestamp: "2022-04-06T10:30:00Z", BackPrice: 2.0, LayPrice: 2.02},
+						{Timestamp: "2022-04-06T12:30:00Z", BackPrice: 2.5, LayPrice: 2.52},
+					},
+				},
+			},
+			"fixture3": {
+				Fixture:      "Inter v Milan",
+				Date:         "2022-04-07T13:00:00Z",
+				MatchStatus:  store.Scheduled,
+				HomeRunnerId: 5,
+				AwayRunnerId: 6,
+				PriceHistory: map[int][]store.Price{
+					5: {
+						{Timestamp: "2022-04-06T11:00:00Z", BackPrice: 2.0, LayPrice: 2.02},
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestBacktest_RunOrder(t *testing.T) {
+	strategy := &recordingStrategy{}
+	backtest := Backtest{Strategy: strategy, Staking: FixedStake{Amount: 10}, Bank: 1000}
+	backtest.Run(testPriceStore())
+
+	// The result is withheld and samples after kickoff are not replayed
+	assert.Equal(t, []string{
+		"2022-04-06T10:00:00Z 1 scheduled ",
+		"2022-04-06T10:30:00Z 3 scheduled ",
+		"2022-04-06T11:00:00Z 1 scheduled ",
+		"2022-04-06T11:00:00Z 5 scheduled ",
+		"2022-04-06T12:00:00Z kickoff Mainz v Dortmund",
+		"2022-04-06T12:30:00Z 3 scheduled ",
+		"2022-04-06T13:00:00Z kickoff Roma v Lazio",
+		"2022-04-07T13:00:00Z kickoff Inter v Milan",
+	}, strategy.calls)
+}
+
+func TestBacktest_RunTradeOut(t *testing.T) {
+	tests := []struct {
+		name       string
+		strategy   *TradeOut
+		wantTrades []Trade
+		wantStats  Stats
+	}{
+		{
+			name:     "back the home team and trade out at kickoff",
+			strategy: &TradeOut{Side: order.Back, Runners: []string{"home"}},
+			wantTrades: []Trade{
+				{
+					LeagueId: "league1", EventId: "fixture1", Fixture: "Mainz v Dortmund", Kickoff: "2022-04-06T12:00:00Z", Runner: "home", SelectionId: 1,
+					Bets: []TradeBet{
+						{Timestamp: "2022-04-06T10:00:00Z", Side: order.Back, Price: 3.0, Stake: 10},
+						{Timestamp: "2022-04-06T12:00:00Z", Side: order.Lay, Price: 2.52, Stake: 11.9},
+					},
+					Stake: 10, Closed: true, Settled: true, Won: true, ProfitLoss: 1.87,
+				},
+				{
+					LeagueId: "league2", EventId: "fixture2", Fixture: "Roma v Lazio", Kickoff: "2022-04-06T13:00:00Z", Runner: "home", SelectionId: 3,
+					Bets: []TradeBet{
+						{Timestamp: "2022-04-06T10:30:00Z", Side: order.Back, Price: 2.0, Stake: 10},
+						{Timestamp: "2022-04-06T13:00:00Z", Side: order.Lay, Price: 2.52, Stake: 7.94},
+					},
+					Stake: 10, Closed: true, Settled: true, Won: false, ProfitLoss: -2.06,
+				},
+				{
+					LeagueId: "league2", EventId: "fixture3", Fixture: "Inter v Milan", Kickoff: "2022-04-07T13:00:00Z", Runner: "home", SelectionId: 5,
+					Bets: []TradeBet{
+						{Timestamp: "2022-04-06T11:00:00Z", Side: order.Back, Price: 2.0, Stake: 10},
+						{Timestamp: "2022-04-07T13:00:00Z", Side: order.Lay, Price: 2.02, Stake: 9.9},
+					},
+					// The fixture has no result, the position was traded out so it settles anyway
+					Stake: 10, Closed: true, Settled: true, ProfitLoss: -0.1,
+				},
+			},
+			wantStats: Stats{Trades: 3, Settled: 3, Winners: 1, Staked: 30, ProfitLoss: -0.29, ROI: -0.97, StrikeRate: 33.33, MaxDrawdown: 2.16, Sharpe: -0.049, FinalBank: 999.71},
+		},
+		{
+			name:     "take profit before kickoff",
+			strategy: &TradeOut{Side: order.Back, Runners: []string{"home"}, MinOdds: 2.9, TakeProfit: 0.1},
+			wantTrades: []Trade{
+				{
+					LeagueId: "league1", EventId: "fixture1", Fixture: "Mainz v Dortmund", Kickoff: "2022-04-06T12:00:00Z", Runner: "home", SelectionId: 1,
+					Bets: []TradeBet{
+						{Timestamp: "2022-04-06T10:00:00Z", Side: order.Back, Price: 3.0, Stake: 10},
+						{Timestamp: "2022-04-06T11:00:00Z", Side: order.Lay, Price: 2.52, Stake: 11.9},
+					},
+					Stake: 10, Closed: true, Settled: true, Won: true, ProfitLoss: 1.87,
+				},
+			},
+			wantStats: Stats{Trades: 1, Settled: 1, Winners: 1, Staked: 10, ProfitLoss: 1.87, ROI: 18.7, StrikeRate: 100, FinalBank: 1001.87},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backtest := Backtest{Strategy: tt.strategy, Staking: FixedStake{Amount: 10}, Bank: 1000, Commission: 0.02}
+			report := backtest.Run(testPriceStore())
+			assert.Equal(t, tt.wantTrades, report.Trades)
+			assert.Equal(t, tt.wantStats, report.Stats)
+		})
+	}
+}
+
+func TestBacktest_RunWithoutResults(t *testing.T) {
+	// Positions traded out before kickoff return the same whether or not the results have been recorded
+	backtest := Backtest{Strategy: &TradeOut{Side: order.Back, Runners: []string{"home"}}, Staking: FixedStake{Amount: 10}, Bank: 1000, Commission: 0.02}
+	settled := backtest.Run(testPriceStore())
+
+	unsettled := testPriceStore()
+	for _, league := range unsettled {
+		for eventId, fixture := range league {
+			fixture.MatchStatus = store.Scheduled
+			fixture.OutCome = ""
+			league[eventId] = fixture
+		}
+	}
+	report := backtest.Run(unsettled)
+	assert.Equal(t, settled.Stats.Settled, report.Stats.Settled)
+	for i, trade := range report.Trades {
+		assert.True(t, trade.Settled, trade.Fixture)
+		// Rounding the green up stake leaves the outcomes up to a penny apart
+		assert.InDelta(t, settled.Trades[i].ProfitLoss, trade.ProfitLoss, 0.011, trade.Fixture)
+	}
+
+	// A position still open at kickoff waits for the result
+	backtest.Strategy = &openStrategy{}
+	report = backtest.Run(unsettled)
+	assert.Equal(t, 3, len(report.Trades))
+	assert.Equal(t, 0, report.Stats.Settled)
+}
+
+func TestBacktest_CloseWithoutPrice(t *testing.T) {
+	prices := map[string]map[string]store.FixturePrices{
+		"league1": {
+			"fixture1": {
+				Fixture:      "Mainz v Dortmund",
+				Date:         "2022-04-06T12:00:00Z",
+				MatchStatus:  store.Played,
+				OutCome:      store.AwayWin,
+				HomeRunnerId: 1,
+				AwayRunnerId: 2,
+				PriceHistory: map[int][]store.Price{
+					1: {
+						{Timestamp: "2022-04-06T10:00:00Z", BackPrice: 2.0, LayPrice: 2.02},
+						{Timestamp: "2022-04-06T11:00:00Z", BackPrice: 2.5},
+					},
+				},
+			},
+		},
+	}
+	backtest := Backtest{Strategy: &TradeOut{Side: order.Back, Runners: []string{"home"}}, Staking: FixedStake{Amount: 10}, Bank: 1000, Commission: 0.02}
+	report := backtest.Run(prices)
+
+	// There was no lay price to close at kickoff, so the back stays open and loses with the runner
+	assert.Equal(t, []Trade{
+		{
+			LeagueId: "league1", EventId: "fixture1", Fixture: "Mainz v Dortmund", Kickoff: "2022-04-06T12:00:00Z", Runner: "home", SelectionId: 1,
+			Bets:  []TradeBet{{Timestamp: "2022-04-06T10:00:00Z", Side: order.Back, Price: 2.0, Stake: 10}},
+			Stake: 10, Settled: true, ProfitLoss: -10,
+		},
+	}, report.Trades)
+	assert.Equal(t, float32(990), report.Stats.FinalBank)
+
+	// Without a result the open position is not settled
+	fixture := prices["league1"]["fixture1"]
+	fixture.MatchStatus, fixture.OutCome = store.Scheduled, ""
+	prices["league1"]["fixture1"] = fixture
+	report = backtest.Run(prices)
+	assert.False(t, report.Trades[0].Settled)
+	assert.Equal(t, float32(1000), report.Stats.FinalBank)
+}
+
+// openStrategy backs every runner on its first sample and never closes
+type openStrategy struct{}
+
+func (o *openStrategy) OnSample(fixture store.FixturePrices, selectionId int, trader Trader) {
+	if !trader.Traded(selectionId) {
+		_ = trader.Open(selectionId, order.Back)
+	}
+}
+
+func (o *openStrategy) OnKickoff(fixture store.FixturePrices, trader Trader) {}
+
+func TestPercentStake(t *testing.T) {
+	assert.Equal(t, float32(25), PercentStake{Percent: 2.5}.Stake(1000))
+}
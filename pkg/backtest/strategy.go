@@ -0,0 +1,152 @@
+// Copyright 2022 Guy Barden
+// strategy.go - the hooks a backtested strategy implements and the strategies built in to the trader
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backtest
+
+import (
+	"guysports/go-football-trader/pkg/hedge"
+	"guysports/go-football-trader/pkg/order"
+	"guysports/go-football-trader/pkg/store"
+)
+
+type (
+	// Strategy decides when to enter and exit positions as the store is replayed. The fixture passed to each hook
+	// holds the prices recorded up to that point and no result, so a strategy cannot see the future
+	Strategy interface {
+		// OnSample is called for each price sample of a runner, in timestamp order across all fixtures
+		OnSample(fixture store.FixturePrices, selectionId int, trader Trader)
+		// OnKickoff is called when the fixture kicks off, open positions are settled against the result afterwards
+		OnKickoff(fixture store.FixturePrices, trader Trader)
+	}
+
+	// Trader places simulated bets on the fixture being replayed, matched in full at the runner's latest prices
+	Trader interface {
+		// Open backs at the best back price or lays at the best lay price, sized by the backtest's staking
+		Open(selectionId int, side order.Side) error
+		// Close greens up the position on the runner at the latest prices, leaving it open if there is no price to
+		// hedge at
+		Close(selectionId int) error
+		// Position returns the position on the runner and whether it is still open
+		Position(selectionId int) (hedge.Position, bool)
+		// Traded reports whether a position has been opened on the runner in this fixture
+		Traded(selectionId int) bool
+		// GreenUp returns the bet that would close the position on the runner, without placing it
+		GreenUp(selectionId int) hedge.Hedge
+	}
+
+	// Staking sizes the stake of a new position from the current bank
+	Staking interface {
+		Stake(bank float32) float32
+	}
+
+	// FixedStake stakes the same amount on every position
+	FixedStake struct {
+		Amount float32
+	}
+
+	// PercentStake stakes a percentage of the current bank on every position
+	PercentStake struct {
+		Percent float32
+	}
+
+	// TradeOut enters once on each chosen runner when the spread is tight and the price is in range, and trades out
+	// when the green up profit reaches the take profit or stop loss fraction of the stake, or at kickoff
+	TradeOut struct {
+		Side       order.Side
+		Runners    []string
+		MinOdds    float32
+		MaxOdds    float32
+		TakeProfit float32
+		StopLoss   float32
+	}
+)
+
+func (f FixedStake) Stake(bank float32) float32 {
+	return f.Amount
+}
+
+func (p PercentStake) Stake(bank float32) float32 {
+	return bank * p.Percent / 100
+}
+
+// OnSample opens a position on the first suitable sample and closes it once a profit or loss limit is reached
+func (t *TradeOut) OnSample(fixture store.FixturePrices, selectionId int, trader Trader) {
+	if !t.tradesRunner(&fixture, selectionId) {
+		return
+	}
+	if !trader.Traded(selectionId) {
+		history := fixture.PriceHistory[selectionId]
+		price := history[len(history)-1]
+		if !price.TightSpread() || !t.oddsInRange(price.BackPrice) {
+			return
+		}
+		_ = trader.Open(selectionId, t.Side)
+		return
+	}
+	position, open := trader.Position(selectionId)
+	if !open {
+		return
+	}
+
+	// The green up leaves the same amount whichever way the runner finishes
+	closing := trader.GreenUp(selectionId)
+	if closing.Stake <= 0 {
+		return
+	}
+	stake := entryStake(position)
+	if (t.TakeProfit > 0 && closing.Position.IfWin >= stake*t.TakeProfit) || (t.StopLoss > 0 && closing.Position.IfWin <= -stake*t.StopLoss) {
+		_ = trader.Close(selectionId)
+	}
+}
+
+// OnKickoff trades out of any open positions before the fixture is settled
+func (t *TradeOut) OnKickoff(fixture store.FixturePrices, trader Trader) {
+	for _, runner := range t.Runners {
+		selectionId, err := fixture.RunnerId(runner)
+		if err != nil {
+			continue
+		}
+		if _, open := trader.Position(selectionId); open {
+			_ = trader.Close(selectionId)
+		}
+	}
+}
+
+func (t *TradeOut) tradesRunner(fixture *store.FixturePrices, selectionId int) bool {
+	for _, runner := range t.Runners {
+		if id, err := fixture.RunnerId(runner); err == nil && id == selectionId {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *TradeOut) oddsInRange(price float32) bool {
+	if t.MinOdds > 0 && price < t.MinOdds {
+		return false
+	}
+	if t.MaxOdds > 0 && price > t.MaxOdds {
+		return false
+	}
+	return true
+}
+
+// entryStake recovers the risk of a single opening bet from its position, the loss if the bet loses
+func entryStake(position hedge.Position) float32 {
+	if position.IfLose < 0 {
+		return -position.IfLose
+	}
+	return -position.IfWin
+}
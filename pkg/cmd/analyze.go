@@ -16,60 +16,48 @@ package cmd
 
 import (
 	"fmt"
-	"guysports/go-football-trader/pkg/helper"
+	"guysports/go-football-trader/pkg/analysis"
 	"guysports/go-football-trader/pkg/store"
+	"os"
+	"reflect"
+	"strconv"
+	"time"
 
+	"github.com/alecthomas/kong"
 	"github.com/guysports/go-betfair-api/pkg/types"
 )
 
 type (
 	Analyze struct {
-		StoreFile string `help:"Path to the where the history of price data for fixtures stored in json format"`
-	}
-
-	OddsRange struct {
-		Low  float32
-		High float32
-	}
-)
-
-const (
-	BetfairCommission = 0.02
-	DefaultStake      = 100
-)
-
-var (
-	// Odds ranges to analyze
-	oddsRangeToTest = []OddsRange{
-		{
-			Low:  1.2,
-			High: 1.99,
-		},
-		{
-			Low:  2.0,
-			High: 2.99,
-		},
-		{
-			Low:  3.0,
-			High: 4.99,
-		},
-		{
-			Low:  5.0,
-			High: 9.99,
-		},
-		{
-			Low:  10.0,
-			High: 19.99,
-		},
-		{
-			Low:  20.0,
-			High: 29.99,
-		},
+		StoreFile  string   `help:"Path to the where the history of price data for fixtures stored in json format, or the log directory"`
+		Backend    string   `enum:"json,log" default:"json" help:"Read the price data from a single json file or an append-only segmented log (json or log)"`
+		Leagues    []string `help:"Only analyze fixtures in these league ids"`
+		From       string   `help:"Only analyze fixtures kicking off on or after this date (YYYY-MM-DD)"`
+		To         string   `help:"Only analyze fixtures kicking off before this date (YYYY-MM-DD)"`
+		Profile    string   `help:"Path to a JSON or YAML analysis profile of odds ranges, bucketing, stake and commission"`
+		Ranges     []string `help:"Odds ranges to analyze written as low-high, such as 2.0-2.99"`
+		Bucketing  string   `help:"Bucket runners by the fixed odds ranges, into equal sized quantiles or into equal implied probability bands (fixed, quantile or probability)"`
+		Buckets    int      `help:"Number of quantile or probability buckets (default 6)"`
+		Stake      float32  `help:"Stake to value each trend with (default 100)"`
+		Commission *float32 `help:"Exchange commission rate on net winnings (default 0.02)"`
+		MinMatched float32  `help:"Leave out runners whose market had less than this matched at its last pre-match sample"`
+		Output     string   `enum:"text,json,csv,markdown,table" default:"text" help:"Output format (text, json, csv, markdown or table)"`
 	}
 )
 
 func (a *Analyze) Run(globals *types.Globals) error {
-	s := store.NewStore(a.StoreFile, nil)
+	query, err := parseFixtureQuery(a.Leagues, a.From, a.To)
+	if err != nil {
+		return err
+	}
+	backend, err := store.OpenBackend(a.Backend, a.StoreFile)
+	if err != nil {
+		return err
+	}
+	s, err := store.LoadStore(backend, nil, query)
+	if err != nil {
+		return err
+	}
 
 	// For each fixture in the store a picture of price trending is established, initially look at back prices
 	// data to mine, start price, number of price changes in trend direction and against trend direction,
@@ -77,112 +65,94 @@ func (a *Analyze) Run(globals *types.Globals) error {
 	trends := s.ExtractTrendsFromFixtures()
 
 	// Show delta breakdown by odds range
-	oddsRangeToTest := []OddsRange{
-		{
-			Low:  1.2,
-			High: 1.99,
-		},
-		{
-			Low:  2.0,
-			High: 2.99,
-		},
-		{
-			Low:  3.0,
-			High: 4.99,
-		},
-		{
-			Low:  5.0,
-			High: 9.99,
-		},
-		{
-			Low:  10.0,
-			High: 19.99,
-		},
-		{
-			Low:  20.0,
-			High: 29.99,
-		},
+	profile, err := a.analysisProfile()
+	if err != nil {
+		return err
 	}
-
-	for _, odds := range oddsRangeToTest {
-		printTrendForOddsRangeInformation(trends, odds)
-	}
-
-	return nil
+	// In-play suspensions are reported apart from the pre-match trends
+	report := analysis.NewReport(trends, profile)
+	report.AddShocks(s.ExtractShocks())
+	return report.Write(os.Stdout, a.Output)
 }
 
-func printTrendForOddsRangeInformation(trends []store.Trend, odds OddsRange) {
-	lineBreak()
-	fmt.Printf("Back First price analysis in the %.2f to %.2f range\n", odds.Low, odds.High)
-	var cumulativeProfit, cumulativeLoss float32
-	var positive, negative int
-	for _, trend := range trends {
-		if trend.StartPrice < odds.Low || trend.StartPrice > odds.High {
-			continue
+// parseFixtureQuery selects fixtures in the leagues kicking off from the start of the from date to the end of the day
+// before the to date, dates are written YYYY-MM-DD and empty values are not applied
+func parseFixtureQuery(leagues []string, from string, to string) (*store.FixtureQuery, error) {
+	query := &store.FixtureQuery{LeagueIds: leagues}
+	var err error
+	if from != "" {
+		if query.From, err = time.Parse(dateLayout, from); err != nil {
+			return nil, fmt.Errorf("from date %s must be written as YYYY-MM-DD", from)
 		}
-		percent := trend.Delta * 100 / trend.StartPrice
-		laystake, profit := calculateBackProfit(trend.StartPrice, trend.CurrentPrice)
-		ql := DefaultStake - laystake*(1-BetfairCommission)
-		fmt.Printf("%s %s (%s) (%d) %.2f %.2f %.2f %.2f --- %.2f%% --- £%.2f £%.2f £%.2f\n", trend.StartTime, trend.Fixture, trend.Team, trend.SampleNumber, trend.StartPrice, trend.StartLayPrice, trend.CurrentPrice, trend.Delta, percent, laystake, ql, profit)
-		if trend.Delta > 0 {
-			cumulativeProfit += profit
-			positive++
-		} else {
-			cumulativeLoss += profit
-			negative++
+	}
+	if to != "" {
+		if query.To, err = time.Parse(dateLayout, to); err != nil {
+			return nil, fmt.Errorf("to date %s must be written as YYYY-MM-DD", to)
 		}
+		// The query includes its end, so stop just before the next day starts
+		query.To = query.To.Add(-time.Nanosecond)
 	}
-	lineBreak()
-	fmt.Printf("Cumulative Profit %.2f (%d)\n", cumulativeProfit, positive)
-	fmt.Printf("Cumulative Loss %.2f (%d)\n", cumulativeLoss, negative)
-	lineBreak()
+	return query, nil
+}
 
-	lineBreak()
-	fmt.Printf("Lay First price analysis in the %.2f to %.2f range\n", odds.Low, odds.High)
-	for _, trend := range trends {
-		if trend.StartPrice < odds.Low || trend.StartPrice > odds.High {
-			continue
+// analysisProfile reads the profile if one is given, with any flags that are set taking precedence
+func (a *Analyze) analysisProfile() (*analysis.Profile, error) {
+	profile := &analysis.Profile{}
+	if a.Profile != "" {
+		var err error
+		profile, err = analysis.LoadProfile(a.Profile)
+		if err != nil {
+			return nil, err
 		}
-		percent := trend.Delta * 100 / trend.StartPrice
-		laystake, profit := calculateLayProfit(trend.StartLayPrice, trend.CurrentLayPrice)
-		ql := DefaultStake - laystake*(1-BetfairCommission)
-		fmt.Printf("%s %s (%s) (%d) %.2f %.2f %.2f %.2f --- %.2f%% --- £%.2f £%.2f £%.2f\n", trend.StartTime, trend.Fixture, trend.Team, trend.SampleNumber, trend.StartLayPrice, trend.StartPrice, trend.CurrentLayPrice, trend.Delta, percent, laystake, ql, profit)
-		if trend.Delta > 0 {
-			cumulativeProfit += profit
-			positive++
-		} else {
-			cumulativeLoss += profit
-			negative++
+	}
+	if len(a.Ranges) > 0 {
+		profile.Ranges = []analysis.OddsRange{}
+		for _, text := range a.Ranges {
+			odds, err := analysis.ParseOddsRange(text)
+			if err != nil {
+				return nil, err
+			}
+			profile.Ranges = append(profile.Ranges, odds)
 		}
 	}
-	lineBreak()
-	fmt.Printf("Cumulative Profit %.2f (%d)\n", cumulativeProfit, positive)
-	fmt.Printf("Cumulative Loss %.2f (%d)\n", cumulativeLoss, negative)
-	lineBreak()
-}
-
-func calculateBackProfit(backodds, layodds float32) (float32, float32) {
-	laystake := (DefaultStake * backodds) / layodds
-
-	// Profit = stake - laystake * (1-commission)
-	// Loss = stake - laystake (no commission payable)
-	profit := (laystake - DefaultStake)
-	if laystake > DefaultStake {
-		profit = profit * (1 - BetfairCommission)
+	if a.Bucketing != "" {
+		profile.Bucketing = a.Bucketing
+	}
+	if a.Buckets > 0 {
+		profile.Buckets = a.Buckets
+	}
+	if a.Stake > 0 {
+		profile.Stake = a.Stake
 	}
-	return helper.ConvertTo2DP(laystake), helper.ConvertTo2DP(profit)
+	if a.Commission != nil {
+		profile.Commission = a.Commission
+	}
+	if a.MinMatched > 0 {
+		profile.MinMatched = a.MinMatched
+	}
+	return profile, profile.SetDefaults()
 }
 
-func calculateLayProfit(layodds, backodds float32) (float32, float32) {
-	backstake := (DefaultStake * layodds) / backodds
+const (
+	dateLayout = "2006-01-02"
+)
 
-	// Profit = stake - laystake * (1-commission)
-	// Loss = stake - laystake (no commission payable)
-	profit := (backstake - DefaultStake)
-	if backstake > DefaultStake {
-		profit = profit * (1 - BetfairCommission)
-	}
-	return helper.ConvertTo2DP(backstake), helper.ConvertTo2DP(profit)
+// OptionalFloatMapper reads *float32 flags, which stay nil when the flag is not given so that 0 can be told apart
+// from unset
+func OptionalFloatMapper() kong.Option {
+	return kong.TypeMapper(reflect.TypeOf((*float32)(nil)), kong.MapperFunc(func(ctx *kong.DecodeContext, target reflect.Value) error {
+		token, err := ctx.Scan.PopValue("float")
+		if err != nil {
+			return err
+		}
+		value, err := strconv.ParseFloat(fmt.Sprint(token.Value), 32)
+		if err != nil {
+			return fmt.Errorf("expected a float but got %q", token.Value)
+		}
+		rate := float32(value)
+		target.Set(reflect.ValueOf(&rate))
+		return nil
+	}))
 }
 
 func lineBreak() {
@@ -0,0 +1,98 @@
+// Copyright 2022 Guy Barden
+// backtest.go - top level command that replays the stored price history through a trading strategy
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"guysports/go-football-trader/pkg/backtest"
+	"guysports/go-football-trader/pkg/order"
+	"guysports/go-football-trader/pkg/store"
+
+	"github.com/guysports/go-betfair-api/pkg/types"
+)
+
+type (
+	Backtest struct {
+		StoreFile    string   `help:"Path to the where the history of price data for fixtures stored in json format, or the log directory"`
+		Backend      string   `enum:"json,log" default:"json" help:"Read the price data from a single json file or an append-only segmented log (json or log)"`
+		Side         string   `enum:"back,lay" default:"back" help:"Open each position with a back or a lay"`
+		Runners      []string `default:"home,away" help:"Runners to trade (home, away, draw)"`
+		MinOdds      float32  `help:"Lowest back price to open a position at"`
+		MaxOdds      float32  `help:"Highest back price to open a position at"`
+		TakeProfit   float32  `help:"Trade out once the green up profit reaches this fraction of the stake"`
+		StopLoss     float32  `help:"Trade out once the green up loss reaches this fraction of the stake"`
+		Stake        float32  `default:"100" help:"Fixed stake of each position"`
+		StakePercent float32  `help:"Stake this percentage of the bank on each position instead of a fixed stake"`
+		Bank         float32  `default:"1000" help:"Starting bank"`
+		Commission   float32  `default:"0.02" help:"Exchange commission rate on net winnings"`
+	}
+)
+
+func (b *Backtest) Run(globals *types.Globals) error {
+	for _, runner := range b.Runners {
+		if runner != "home" && runner != "away" && runner != "draw" {
+			return fmt.Errorf("runner must be one of home, away or draw not %s", runner)
+		}
+	}
+	backend, err := store.OpenBackend(b.Backend, b.StoreFile)
+	if err != nil {
+		return err
+	}
+	s, err := store.LoadStore(backend, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	var staking backtest.Staking = backtest.FixedStake{Amount: b.Stake}
+	if b.StakePercent > 0 {
+		staking = backtest.PercentStake{Percent: b.StakePercent}
+	}
+	test := backtest.Backtest{
+		Strategy: &backtest.TradeOut{
+			Side:       order.Side(strings.ToUpper(b.Side)),
+			Runners:    b.Runners,
+			MinOdds:    b.MinOdds,
+			MaxOdds:    b.MaxOdds,
+			TakeProfit: b.TakeProfit,
+			StopLoss:   b.StopLoss,
+		},
+		Staking:    staking,
+		Bank:       b.Bank,
+		Commission: b.Commission,
+	}
+	report := test.Run(s.GlobalPriceStore)
+
+	lineBreak()
+	for _, trade := range report.Trades {
+		bets := []string{}
+		for _, bet := range trade.Bets {
+			bets = append(bets, fmt.Sprintf("%s %s %.2f at %.2f", bet.Timestamp, bet.Side, bet.Stake, bet.Price))
+		}
+		result := "unsettled"
+		if trade.Settled {
+			result = fmt.Sprintf("£%.2f", trade.ProfitLoss)
+		}
+		fmt.Printf("%s %s (%s) %s --- %s\n", trade.Kickoff, trade.Fixture, trade.Runner, strings.Join(bets, ", "), result)
+	}
+	lineBreak()
+	stats := report.Stats
+	fmt.Printf("Trades %d, settled %d, winners %d, strike rate %.2f%%\n", stats.Trades, stats.Settled, stats.Winners, stats.StrikeRate)
+	fmt.Printf("Staked £%.2f, profit £%.2f, ROI %.2f%%\n", stats.Staked, stats.ProfitLoss, stats.ROI)
+	fmt.Printf("Bank £%.2f to £%.2f, max drawdown £%.2f, Sharpe ratio %.3f\n", b.Bank, stats.FinalBank, stats.MaxDrawdown, stats.Sharpe)
+	lineBreak()
+	return nil
+}
@@ -0,0 +1,69 @@
+// Copyright 2022 Guy Barden
+// settle.go - top level command that records the results of tracked fixtures from the Betfair Exchange
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"guysports/go-football-trader/pkg/access"
+
+	"github.com/guysports/go-betfair-api/pkg/types"
+)
+
+type (
+	Settle struct {
+		JsonLoginPath string `help:"Path to the json file containing the api login information to Betfair"`
+		StorePath     string `help:"Path to the where the history of price data for fixtures is stored"`
+		Backend       string `enum:"json,log" default:"json" help:"Backend the store is kept in (json or log)"`
+	}
+)
+
+func (s *Settle) Run(globals *types.Globals) error {
+	apiClient, err := access.NewLogin(s.JsonLoginPath)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), types.DefaultTimeout)
+	defer cancel()
+
+	// Login to Betfair
+	bettingClient, err := apiClient.BetfairAuthenticate(ctx, globals.AppKey, nil)
+	if err != nil {
+		return err
+	}
+
+	lock, err := lockStore(s.StorePath)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+	storeClient, err := openStore(s.Backend, s.StorePath, bettingClient, nil)
+	if err != nil {
+		return err
+	}
+	var settled int
+	err = withSession(apiClient, bettingClient, func() error {
+		settled, err = storeClient.SettleFixtures(time.Now())
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Settled %d fixtures\n", settled)
+
+	return storeClient.SaveStoreToFile()
+}
@@ -0,0 +1,283 @@
+// Copyright 2022 Guy Barden
+// store.go - top level command that maintains the store of price information
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"guysports/go-football-trader/pkg/export"
+	"guysports/go-football-trader/pkg/historic"
+	"guysports/go-football-trader/pkg/store"
+	"os"
+	"time"
+
+	"github.com/guysports/go-betfair-api/pkg/types"
+)
+
+type (
+	Store struct {
+		Prune   StorePrune   `cmd:"" help:"Remove or compress the archived snapshots of the store"`
+		Verify  StoreVerify  `cmd:"" help:"Check the structure of the stored price histories"`
+		Repair  StoreRepair  `cmd:"" help:"Salvage a damaged store and remove the faults verify finds"`
+		Migrate StoreMigrate `cmd:"" help:"Rewrite the store in the newest schema version, keeping a backup"`
+		Export  StoreExport  `cmd:"" help:"Export the price history as a row per runner sample in CSV or parquet"`
+		Import  StoreImport  `cmd:"" help:"Import match odds markets from Betfair historical data files"`
+	}
+
+	StorePrune struct {
+		StorePath  string `help:"Path to the where the history of price data for fixtures is stored"`
+		KeepLast   int    `help:"Keep the newest archives"`
+		KeepDaily  int    `help:"Keep the newest archive of each of the most recent days"`
+		KeepWeekly int    `help:"Keep the newest archive of each of the most recent weeks"`
+		MaxSize    int64  `help:"Remove the oldest archives until the rest fit in this many megabytes"`
+		Gzip       bool   `help:"Compress the kept archives with gzip"`
+		DryRun     bool   `help:"Show what would be removed or compressed without changing anything"`
+	}
+
+	StoreVerify struct {
+		StorePath string `help:"Path to the where the history of price data for fixtures is stored"`
+		Backend   string `enum:"json,log" default:"json" help:"Backend the store is kept in (json or log)"`
+	}
+
+	StoreRepair struct {
+		StorePath string `help:"Path to the where the history of price data for fixtures is stored"`
+		Backend   string `enum:"json,log" default:"json" help:"Backend the store is kept in (json or log)"`
+		DryRun    bool   `help:"Show what would be removed without saving the store"`
+	}
+
+	StoreMigrate struct {
+		StorePath string `help:"Path to the where the history of price data for fixtures is stored"`
+		Archives  bool   `help:"Migrate the archived snapshots of the store as well"`
+		DryRun    bool   `help:"Show the files that would be migrated without changing them"`
+	}
+
+	StoreExport struct {
+		StorePath string   `help:"Path to the where the history of price data for fixtures is stored"`
+		Backend   string   `enum:"json,log" default:"json" help:"Backend the store is kept in (json or log)"`
+		Format    string   `enum:"csv,parquet" default:"csv" help:"Write CSV or a parquet file of columns (csv or parquet)"`
+		Output    string   `help:"File to write the export to, standard output if not set"`
+		Leagues   []string `help:"Only export fixtures in these league ids"`
+		From      string   `help:"Only export fixtures kicking off on or after this date (YYYY-MM-DD)"`
+		To        string   `help:"Only export fixtures kicking off before this date (YYYY-MM-DD)"`
+		Fixtures  []string `help:"Only export these fixtures, given as event ids or part of the fixture name"`
+	}
+
+	StoreImport struct {
+		StorePath string        `help:"Path to the where the history of price data for fixtures is stored"`
+		Backend   string        `enum:"json,log" default:"json" help:"Backend the store is kept in (json or log)"`
+		LeagueId  string        `required:"" help:"League id to store the imported fixtures under"`
+		Interval  time.Duration `default:"1m" help:"How often to sample the prices of each market before kickoff"`
+		Replace   bool          `help:"Replace fixtures already in the store instead of skipping them"`
+		Files     []string      `arg:"" type:"path" help:"Historical data files or directories of them, plain, bz2, gz or tar"`
+	}
+)
+
+func (s *StorePrune) Run(globals *types.Globals) error {
+	policy := store.RetentionPolicy{
+		KeepLast:   s.KeepLast,
+		KeepDaily:  s.KeepDaily,
+		KeepWeekly: s.KeepWeekly,
+		MaxBytes:   s.MaxSize << 20,
+		Compress:   s.Gzip,
+	}
+	result, err := store.PruneArchives(fmt.Sprintf("%s/store.json", s.StorePath), policy, s.DryRun)
+	if err != nil {
+		return err
+	}
+
+	action := "Removed"
+	if s.DryRun {
+		action = "Would remove"
+	}
+	for _, archive := range result.Removed {
+		fmt.Printf("%s %s\n", action, archive.Path)
+	}
+	action = "Compressed"
+	if s.DryRun {
+		action = "Would compress"
+	}
+	for _, archive := range result.Compressed {
+		fmt.Printf("%s %s\n", action, archive.Path)
+	}
+	size := int64(0)
+	for _, archive := range result.Kept {
+		size += archive.Size
+	}
+	fmt.Printf("Kept %d archives using %.1fMB, removed %d\n", len(result.Kept), float64(size)/(1<<20), len(result.Removed))
+	return nil
+}
+
+func (s *StoreVerify) Run(globals *types.Globals) error {
+	storeClient, err := openStore(s.Backend, s.StorePath, nil, nil)
+	if err != nil {
+		return err
+	}
+	problems := storeClient.Verify()
+	for _, problem := range problems {
+		fmt.Println(problem)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("found %d problems, run store repair to remove them", len(problems))
+	}
+	fmt.Println("No problems found")
+	return nil
+}
+
+func (s *StoreRepair) Run(globals *types.Globals) error {
+	lock, err := lockStore(s.StorePath)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
+	storeClient, err := openStore(s.Backend, s.StorePath, nil, nil)
+	if err != nil && s.Backend == store.JSONBackendName {
+		// Keep what can be read, the damaged file is archived when the repaired store is saved
+		fmt.Printf("Salvaging store: %s\n", err.Error())
+		storeClient, err = store.SalvageStore(fmt.Sprintf("%s/store.json", s.StorePath), nil)
+		if err != nil {
+			fmt.Printf("Lost data: %s\n", err.Error())
+		}
+	} else if err != nil {
+		return err
+	}
+
+	problems := storeClient.Repair()
+	action := "Removed"
+	if s.DryRun {
+		action = "Would remove"
+	}
+	for _, problem := range problems {
+		fmt.Printf("%s %s\n", action, problem)
+	}
+	fmt.Printf("%s %d problems\n", action, len(problems))
+	if s.DryRun {
+		return nil
+	}
+	return storeClient.SaveStoreToFile()
+}
+
+func (s *StoreMigrate) Run(globals *types.Globals) error {
+	lock, err := lockStore(s.StorePath)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
+	storeFile := fmt.Sprintf("%s/store.json", s.StorePath)
+	paths := []string{storeFile}
+	if s.Archives {
+		archives, err := store.ListArchives(storeFile)
+		if err != nil {
+			return err
+		}
+		for _, archive := range archives {
+			paths = append(paths, archive.Path)
+		}
+	}
+
+	action := "Migrated"
+	if s.DryRun {
+		action = "Would migrate"
+	}
+	for _, path := range paths {
+		version, backup, err := store.MigrateStoreFile(path, s.DryRun)
+		if err != nil {
+			return err
+		}
+		if backup == "" {
+			fmt.Printf("%s is already schema version %d\n", path, version)
+			continue
+		}
+		fmt.Printf("%s %s from schema version %d to %d, backup %s\n", action, path, version, store.CurrentSchemaVersion, backup)
+	}
+	return nil
+}
+
+func (s *StoreExport) Run(globals *types.Globals) error {
+	query, err := parseFixtureQuery(s.Leagues, s.From, s.To)
+	if err != nil {
+		return err
+	}
+	storeClient, err := openStore(s.Backend, s.StorePath, nil, query)
+	if err != nil {
+		return err
+	}
+	rows, skipped := export.Rows(storeClient.GlobalPriceStore, &export.Filter{Query: *query, Fixtures: s.Fixtures})
+
+	output := os.Stdout
+	if s.Output != "" {
+		output, err = os.Create(s.Output)
+		if err != nil {
+			return err
+		}
+		defer output.Close()
+	}
+	writer := bufio.NewWriter(output)
+	if err := export.Write(writer, rows, s.Format); err != nil {
+		return err
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	// Progress goes to standard error so it never mixes with an export written to standard output
+	fmt.Fprintf(os.Stderr, "Exported %d samples", len(rows))
+	if skipped > 0 {
+		fmt.Fprintf(os.Stderr, ", skipped %d without a valid timestamp", skipped)
+	}
+	fmt.Fprintln(os.Stderr)
+	return nil
+}
+
+func (s *StoreImport) Run(globals *types.Globals) error {
+	importer := historic.NewImporter(s.Interval)
+	for _, file := range s.Files {
+		if err := importer.ImportPath(file); err != nil {
+			return err
+		}
+	}
+
+	// Imports often start a new store
+	if err := os.MkdirAll(s.StorePath, 0755); err != nil {
+		return err
+	}
+	lock, err := lockStore(s.StorePath)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+	storeClient, err := openStore(s.Backend, s.StorePath, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	imported, skipped, settled := 0, 0, 0
+	for eventId, fixture := range importer.Fixtures() {
+		if _, ok := storeClient.GlobalPriceStore[s.LeagueId][eventId]; ok && !s.Replace {
+			skipped++
+			continue
+		}
+		storeClient.ImportFixture(s.LeagueId, eventId, fixture)
+		imported++
+		if fixture.MatchStatus == store.Played {
+			settled++
+		}
+	}
+	fmt.Printf("Imported %d fixtures (%d settled), skipped %d already in the store\n", imported, settled, skipped)
+	if imported == 0 {
+		return nil
+	}
+	return storeClient.SaveStoreToFile()
+}
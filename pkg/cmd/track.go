@@ -18,19 +18,32 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 
 	"guysports/go-football-trader/pkg/access"
 	"guysports/go-football-trader/pkg/store"
 
+	"github.com/guysports/go-betfair-api/pkg/betting"
 	"github.com/guysports/go-betfair-api/pkg/types"
 )
 
 type (
 	Track struct {
-		JsonLoginPath string `help:"Path to the json file containing the api login information to Betfair"`
-		JsonQuery     string `help:"Path to the markets to be queried for match odds"`
-		StorePath     string `help:"Path to the where the history of price data for fixtures should be stored"`
+		JsonLoginPath      string        `help:"Path to the json file containing the api login information to Betfair"`
+		JsonQuery          string        `help:"Path to the markets to be queried for match odds"`
+		StorePath          string        `help:"Path to the where the history of price data for fixtures should be stored"`
+		Backend            string        `enum:"json,log" default:"json" help:"Store the price data in a single json file or an append-only segmented log (json or log)"`
+		Daemon             bool          `help:"Keep running, polling fixtures more frequently as kickoff approaches"`
+		PollTick           time.Duration `default:"30s" help:"How often the daemon checks for fixtures due a price sample"`
+		DiscoveryInterval  time.Duration `default:"1h" help:"How often the daemon queries the leagues for new fixtures"`
+		CheckpointInterval time.Duration `default:"15m" help:"How often the daemon saves the store to file"`
+		SessionRefresh     time.Duration `default:"3h" help:"How often the daemon renews the Betfair session"`
+		InPlayInterval     time.Duration `default:"30s" help:"How often the daemon samples fixtures after kickoff when the query tracks in-play"`
+		Workers            int           `default:"4" help:"How many leagues are sampled at once"`
+		WeightPerSecond    float64       `default:"1000" help:"Betfair request weight shared by the workers each second"`
 	}
 )
 
@@ -43,7 +56,14 @@ func (t *Track) Run(globals *types.Globals) error {
 	if err != nil {
 		return err
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), types.DefaultTimeout)
+	// The context is held by the betting client for every request, so a daemon cannot use a timeout
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if t.Daemon {
+		ctx, cancel = signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	} else {
+		ctx, cancel = context.WithTimeout(context.Background(), types.DefaultTimeout)
+	}
 	defer cancel()
 
 	// Login to Betfair
@@ -56,17 +76,35 @@ func (t *Track) Run(globals *types.Globals) error {
 		return err
 	}
 
-	storeClient := store.NewStore(fmt.Sprintf("%s/store.json", t.StorePath), bettingClient)
-	err = storeClient.AddLeaguePricesToStore(queryParameters)
+	// The lock is held until the store is saved for the last time, so a second tracker cannot overwrite it
+	lock, err := lockStore(t.StorePath)
 	if err != nil {
-		if strings.Contains(err.Error(), SessionExpired) {
-			// session expired force token refresh
-			bettingClient, err = apiClient.BetfairAuthenticateImpl(bettingClient, os.Getenv("HOME"), true)
-			err = storeClient.AddLeaguePricesToStore(queryParameters)
-			if err != nil {
-				return err
-			}
-		} else {
+		return err
+	}
+	defer lock.Release()
+	// Every worker's requests draw on the one limiter, so together they stay inside the Betfair request weight limit
+	queryClient := access.NewLimitedQuery(access.NewEventQuery(bettingClient), access.NewRateLimiter(t.WeightPerSecond))
+	storeClient, err := openStore(t.Backend, t.StorePath, queryClient, nil)
+	if err != nil {
+		return err
+	}
+	storeClient.Workers = t.Workers
+	if t.Daemon {
+		return t.runDaemon(ctx, apiClient, bettingClient, storeClient, queryParameters)
+	}
+
+	err = withSession(apiClient, bettingClient, func() error {
+		return storeClient.AddLeaguePricesToStore(queryParameters)
+	})
+	if err != nil {
+		return err
+	}
+	// Fixtures that have kicked off are not found by league, so those being tracked in play are sampled by market
+	if queryParameters.InPlay {
+		err = withSession(apiClient, bettingClient, func() error {
+			return storeClient.AddDuePricesToStore(queryParameters, storeClient.DueInPlayFixtures(0, time.Now()))
+		})
+		if err != nil {
 			return err
 		}
 	}
@@ -77,3 +115,105 @@ func (t *Track) Run(globals *types.Globals) error {
 	}
 	return nil
 }
+
+// runDaemon polls fixtures on the kickoff aware schedule until the context is cancelled by a signal
+func (t *Track) runDaemon(ctx context.Context, apiClient *access.Login, bettingClient *betting.API, storeClient *store.Store, queryParameters *access.MarketQuery) error {
+	poll := time.NewTicker(t.PollTick)
+	defer poll.Stop()
+	discover := time.NewTicker(t.DiscoveryInterval)
+	defer discover.Stop()
+	checkpoint := time.NewTicker(t.CheckpointInterval)
+	defer checkpoint.Stop()
+	refresh := time.NewTicker(t.SessionRefresh)
+	defer refresh.Stop()
+
+	discoverFixtures := func() {
+		err := withSession(apiClient, bettingClient, func() error {
+			return storeClient.AddLeaguePricesToStore(queryParameters)
+		})
+		if err != nil {
+			fmt.Printf("Error discovering fixtures: %s\n", err.Error())
+		}
+	}
+	discoverFixtures()
+
+	for {
+		select {
+		case <-ctx.Done():
+			fmt.Println("Shutting down, saving store")
+			return storeClient.SaveStoreToFile()
+		case <-discover.C:
+			discoverFixtures()
+		case <-poll.C:
+			err := withSession(apiClient, bettingClient, func() error {
+				return storeClient.AddDuePricesToStore(queryParameters, t.dueFixtures(storeClient, queryParameters, time.Now()))
+			})
+			if err != nil {
+				fmt.Printf("Error polling fixtures: %s\n", err.Error())
+			}
+		case <-checkpoint.C:
+			if err := storeClient.SaveStoreToFile(); err != nil {
+				fmt.Printf("Error saving store: %s\n", err.Error())
+			}
+		case <-refresh.C:
+			if _, err := apiClient.RefreshSession(bettingClient); err != nil {
+				fmt.Printf("Error refreshing session: %s\n", err.Error())
+			}
+		}
+	}
+}
+
+// dueFixtures returns the market ids, keyed by league, of fixtures due a sample before kickoff and, if the query
+// tracks in-play, after kickoff
+func (t *Track) dueFixtures(storeClient *store.Store, queryParameters *access.MarketQuery, now time.Time) map[string][]string {
+	due := storeClient.DueFixtures(store.DefaultSchedule, now)
+	if !queryParameters.InPlay {
+		return due
+	}
+	for leagueId, marketIds := range storeClient.DueInPlayFixtures(t.InPlayInterval, now) {
+		due[leagueId] = append(due[leagueId], marketIds...)
+	}
+	return due
+}
+
+// lockStore acquires the lock on the store in the store directory, whichever backend keeps it
+func lockStore(storePath string) (*store.Lock, error) {
+	return store.AcquireLock(store.LockPath(fmt.Sprintf("%s/store.json", storePath)))
+}
+
+// openStore loads the store kept by the named backend in the store directory, the json backend keeps the
+// store.json file and the log backend keeps its segments in the log directory. Only the fixtures selected by the query
+// are loaded if one is given. Prices captured but not saved by an earlier run are replayed from the journal
+func openStore(backendName string, storePath string, qc access.QueryInterface, query *store.FixtureQuery) (*store.Store, error) {
+	path := fmt.Sprintf("%s/store.json", storePath)
+	if backendName == store.LogBackendName {
+		path = fmt.Sprintf("%s/log", storePath)
+	}
+	backend, err := store.OpenBackend(backendName, path)
+	if err != nil {
+		return nil, err
+	}
+	storeClient, err := store.LoadStore(backend, qc, query)
+	if err != nil {
+		return nil, err
+	}
+	storeClient.StorePath = path
+	if err := storeClient.AttachJournal(store.NewJournal(store.JournalPath(path))); err != nil {
+		return nil, err
+	}
+	return storeClient, nil
+}
+
+// withSession runs the query, logging in again and retrying once if the session has expired
+func withSession(apiClient *access.Login, bettingClient *betting.API, query func() error) error {
+	err := query()
+	if err != nil && strings.Contains(err.Error(), SessionExpired) {
+		// session expired force token refresh
+		_, err = apiClient.RefreshSession(bettingClient)
+		if err != nil {
+			return err
+		}
+		err = query()
+	}
+	return err
+}
@@ -0,0 +1,335 @@
+// Copyright 2022 Guy Barden
+// trade.go - top level command that opens and closes positions on tracked fixtures in the Betfair Exchange
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"guysports/go-football-trader/pkg/access"
+	"guysports/go-football-trader/pkg/hedge"
+	"guysports/go-football-trader/pkg/ladder"
+	"guysports/go-football-trader/pkg/order"
+	"guysports/go-football-trader/pkg/paper"
+	"guysports/go-football-trader/pkg/store"
+
+	"github.com/guysports/go-betfair-api/pkg/betting"
+	"github.com/guysports/go-betfair-api/pkg/types"
+)
+
+type (
+	Trade struct {
+		Open   TradeOpen   `cmd:"" help:"Open a position with a back or lay on a tracked fixture's runner"`
+		Close  TradeClose  `cmd:"" help:"Close or reduce the position on a tracked fixture's runner"`
+		Ledger TradeLedger `cmd:"" help:"Show the paper trading orders and positions"`
+	}
+
+	TradeOpen struct {
+		JsonLoginPath string  `help:"Path to the json file containing the api login information to Betfair"`
+		StorePath     string  `help:"Path to the where the history of price data for fixtures is stored"`
+		Backend       string  `enum:"json,log" default:"json" help:"Backend the store is kept in (json or log)"`
+		EventId       string  `required:"" help:"Event id of the tracked fixture"`
+		Runner        string  `enum:"home,away,draw" default:"home" help:"Runner to bet on (home, away or draw)"`
+		Side          string  `enum:"back,lay" default:"back" help:"Back or lay the runner"`
+		Price         float32 `required:"" help:"Price to request"`
+		Stake         float32 `required:"" help:"Stake, or backer's stake for a lay"`
+		Paper         bool    `help:"Simulate the bet against the recorded prices instead of placing it on Betfair"`
+	}
+
+	TradeClose struct {
+		JsonLoginPath string  `help:"Path to the json file containing the api login information to Betfair"`
+		StorePath     string  `help:"Path to the where the history of price data for fixtures is stored"`
+		Backend       string  `enum:"json,log" default:"json" help:"Backend the store is kept in (json or log)"`
+		EventId       string  `required:"" help:"Event id of the tracked fixture"`
+		Runner        string  `enum:"home,away,draw" default:"home" help:"Runner to close the position on (home, away or draw)"`
+		Mode          string  `enum:"green,partial,stoploss" default:"green" help:"Green up, partially hedge or limit the loss of the position"`
+		Fraction      float32 `default:"0.5" help:"Fraction of the green up stake to place in partial mode"`
+		MaxLoss       float32 `help:"Largest loss to accept in stoploss mode"`
+		Commission    float32 `default:"0.02" help:"Exchange commission rate on net winnings"`
+		DryRun        bool    `help:"Show the closing bet without placing it"`
+		Paper         bool    `help:"Close the paper trading position instead of the position on Betfair"`
+	}
+
+	TradeLedger struct {
+		StorePath  string  `help:"Path to the where the history of price data for fixtures is stored"`
+		Backend    string  `enum:"json,log" default:"json" help:"Backend the store is kept in (json or log)"`
+		Commission float32 `default:"0.02" help:"Exchange commission rate on net winnings"`
+	}
+)
+
+const (
+	// paperLedgerFile is kept next to the store so simulated bets never mix with real ones
+	paperLedgerFile = "paper_ledger.json"
+)
+
+func (t *TradeOpen) Run(globals *types.Globals) error {
+	if t.Price < ladder.MinPrice || t.Price > ladder.MaxPrice {
+		return fmt.Errorf("price %.2f must be between %.2f and %.2f", t.Price, ladder.MinPrice, float32(ladder.MaxPrice))
+	}
+	if !ladder.IsValid(t.Price) {
+		return fmt.Errorf("price %.2f is not on the Betfair ladder, the nearest prices are %.2f and %.2f", t.Price, ladder.RoundToValid(t.Price, ladder.Down), ladder.RoundToValid(t.Price, ladder.Up))
+	}
+	if t.Stake <= 0 {
+		return fmt.Errorf("stake must be greater than zero")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), types.DefaultTimeout)
+	defer cancel()
+	session, err := newTradeSession(ctx, globals, t.JsonLoginPath, t.Backend, t.StorePath, t.EventId, t.Runner, t.Paper)
+	if err != nil {
+		return err
+	}
+	defer session.close()
+
+	instruction := order.NewLimitInstruction(session.selectionId, order.Side(strings.ToUpper(t.Side)), t.Price, t.Stake)
+	var report *order.PlaceExecutionReport
+	err = session.run(func() error {
+		report, err = session.orders.PlaceOrders(session.fixture.MarketID, []order.PlaceInstruction{instruction}, "")
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return session.recordPlacedBets(t.EventId, report)
+}
+
+func (t *TradeClose) Run(globals *types.Globals) error {
+	ctx, cancel := context.WithTimeout(context.Background(), types.DefaultTimeout)
+	defer cancel()
+	session, err := newTradeSession(ctx, globals, t.JsonLoginPath, t.Backend, t.StorePath, t.EventId, t.Runner, t.Paper)
+	if err != nil {
+		return err
+	}
+	defer session.close()
+	fixture, selectionId := session.fixture, session.selectionId
+
+	// The exchange's view of the matched bets is used rather than the store, as bets may have matched since placing
+	var current []order.CurrentOrder
+	var price *store.Price
+	err = session.run(func() error {
+		current, err = session.orders.ListCurrentOrders([]string{fixture.MarketID})
+		if err != nil {
+			return err
+		}
+		price, err = session.currentPrice()
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	bets := []hedge.Bet{}
+	for _, currentOrder := range current {
+		if currentOrder.SelectionId != selectionId || currentOrder.SizeMatched == 0 {
+			continue
+		}
+		bets = append(bets, hedge.Bet{
+			Side:  currentOrder.Side,
+			Price: currentOrder.AveragePriceMatched,
+			Stake: currentOrder.SizeMatched,
+		})
+	}
+	if len(bets) == 0 {
+		return fmt.Errorf("no matched bets on %s in %s to close", t.Runner, fixture.Fixture)
+	}
+
+	position := hedge.NewPosition(bets)
+	var closing hedge.Hedge
+	switch t.Mode {
+	case "partial":
+		closing = hedge.PartialHedge(position, price.BackPrice, price.LayPrice, t.Fraction, t.Commission)
+	case "stoploss":
+		closing = hedge.StopLoss(position, price.BackPrice, price.LayPrice, t.MaxLoss, t.Commission)
+	default:
+		closing = hedge.GreenUp(position, price.BackPrice, price.LayPrice, t.Commission)
+	}
+	fmt.Printf("Position if %s wins %.2f, loses %.2f\n", t.Runner, position.IfWin, position.IfLose)
+	fmt.Printf("Closing %s %.2f at %.2f leaves %.2f if %s wins, %.2f if it loses (net of commission)\n", closing.Side, closing.Stake, closing.Price, closing.NetIfWin, t.Runner, closing.NetIfLose)
+	if closing.Stake <= 0 || t.DryRun {
+		return nil
+	}
+
+	instruction := order.NewLimitInstruction(selectionId, closing.Side, closing.Price, closing.Stake)
+	var report *order.PlaceExecutionReport
+	err = session.run(func() error {
+		report, err = session.orders.PlaceOrders(fixture.MarketID, []order.PlaceInstruction{instruction}, "")
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return session.recordPlacedBets(t.EventId, report)
+}
+
+func (t *TradeLedger) Run(globals *types.Globals) error {
+	storeClient, err := openStore(t.Backend, t.StorePath, nil, nil)
+	if err != nil {
+		return err
+	}
+	exchange, err := paper.NewExchange(fmt.Sprintf("%s/%s", t.StorePath, paperLedgerFile), storeClient)
+	if err != nil {
+		return err
+	}
+
+	// Matching against the samples recorded since the last run brings the fills up to date
+	exchange.Match()
+	for _, paperOrder := range exchange.Ledger.Orders {
+		fmt.Printf("%s %s %d at %.2f for %.2f placed %s: matched %.2f at %.2f, cancelled %.2f, %s\n",
+			paperOrder.BetId, paperOrder.Side, paperOrder.SelectionId, paperOrder.Price, paperOrder.Size, paperOrder.PlacedDate,
+			paperOrder.SizeMatched(), paperOrder.AveragePriceMatched(), paperOrder.SizeCancelled, paperOrder.Status())
+	}
+
+	total := float32(0)
+	for _, position := range exchange.Positions(t.Commission) {
+		if !position.Settled {
+			fmt.Printf("%s %d (%d orders): if wins %.2f, if loses %.2f\n", position.Fixture, position.SelectionId, position.Orders, position.Position.IfWin, position.Position.IfLose)
+			continue
+		}
+		total += position.ProfitLoss
+		fmt.Printf("%s %d (%d orders): settled %.2f\n", position.Fixture, position.SelectionId, position.Orders, position.ProfitLoss)
+	}
+	fmt.Printf("Settled profit/loss %.2f\n", total)
+	return exchange.Save()
+}
+
+type tradeSession struct {
+	apiClient     *access.Login
+	bettingClient *betting.API
+	storeClient   *store.Store
+	orders        order.Orders
+	paper         *paper.Exchange
+	fixture       store.FixturePrices
+	selectionId   int
+	lock          *store.Lock
+}
+
+// newTradeSession logs in to Betfair, or opens the paper trading ledger, and finds the runner being traded in the store
+func newTradeSession(ctx context.Context, globals *types.Globals, jsonLoginPath string, backendName string, storePath string, eventId string, runner string, paperTrading bool) (*tradeSession, error) {
+	session := tradeSession{}
+	if paperTrading {
+		storeClient, err := openStore(backendName, storePath, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		session.storeClient = storeClient
+		exchange, err := paper.NewExchange(fmt.Sprintf("%s/%s", storePath, paperLedgerFile), session.storeClient)
+		if err != nil {
+			return nil, err
+		}
+		session.paper = exchange
+		session.orders = exchange
+	} else {
+		apiClient, err := access.NewLogin(jsonLoginPath)
+		if err != nil {
+			return nil, err
+		}
+
+		// Login to Betfair
+		bettingClient, err := apiClient.BetfairAuthenticate(ctx, globals.AppKey, nil)
+		if err != nil {
+			return nil, err
+		}
+		// Placed bets are recorded in the store, paper trading only writes to its ledger so needs no lock
+		lock, err := lockStore(storePath)
+		if err != nil {
+			return nil, err
+		}
+		session.lock = lock
+		storeClient, err := openStore(backendName, storePath, bettingClient, nil)
+		if err != nil {
+			session.close()
+			return nil, err
+		}
+		session.apiClient = apiClient
+		session.bettingClient = bettingClient
+		session.storeClient = storeClient
+		session.orders = order.NewBettingOrders(bettingClient)
+	}
+
+	_, fixture, err := session.storeClient.FindFixture(eventId)
+	if err != nil {
+		session.close()
+		return nil, err
+	}
+	selectionId, err := fixture.RunnerId(runner)
+	if err != nil {
+		session.close()
+		return nil, err
+	}
+	session.fixture = fixture
+	session.selectionId = selectionId
+	return &session, nil
+}
+
+// close releases the lock on the store
+func (t *tradeSession) close() {
+	_ = t.lock.Release()
+}
+
+// run calls Betfair through fn, logging in again if the session has expired. Paper trading has no session
+func (t *tradeSession) run(fn func() error) error {
+	if t.paper != nil {
+		return fn()
+	}
+	return withSession(t.apiClient, t.bettingClient, fn)
+}
+
+// currentPrice returns the runner's live prices, or its last recorded prices when paper trading
+func (t *tradeSession) currentPrice() (*store.Price, error) {
+	if t.paper != nil {
+		return t.paper.CurrentPrice(t.fixture.MarketID, t.selectionId)
+	}
+	return t.storeClient.CurrentRunnerPrice(t.fixture.MarketID, t.selectionId)
+}
+
+// recordPlacedBets saves the placed orders, paper orders are kept in the ledger rather than the store
+func (t *tradeSession) recordPlacedBets(eventId string, report *order.PlaceExecutionReport) error {
+	if t.paper != nil {
+		for _, placed := range report.InstructionReports {
+			fmt.Printf("Placed paper %s bet %s on %d at %.2f for %.2f, matched %.2f\n", placed.Instruction.Side, placed.BetId, placed.Instruction.SelectionId, placed.Instruction.LimitOrder.Price, placed.Instruction.LimitOrder.Size, placed.SizeMatched)
+		}
+		return t.paper.Save()
+	}
+	recordPlacedBets(t.storeClient, eventId, report)
+	return t.storeClient.SaveStoreToFile()
+}
+
+// recordPlacedBets stores the bet ids of the placed orders against the fixture
+func recordPlacedBets(storeClient *store.Store, eventId string, report *order.PlaceExecutionReport) {
+	for _, placed := range report.InstructionReports {
+		if placed.BetId == "" {
+			continue
+		}
+		bet := store.Bet{
+			BetID:               placed.BetId,
+			SelectionID:         placed.Instruction.SelectionId,
+			Side:                string(placed.Instruction.Side),
+			PlacedDate:          placed.PlacedDate,
+			Status:              placed.OrderStatus,
+			SizeMatched:         placed.SizeMatched,
+			AveragePriceMatched: placed.AveragePriceMatched,
+		}
+		if placed.Instruction.LimitOrder != nil {
+			bet.Price = placed.Instruction.LimitOrder.Price
+			bet.Stake = placed.Instruction.LimitOrder.Size
+		}
+		if err := storeClient.RecordBet(eventId, bet); err != nil {
+			fmt.Printf("Unable to record bet %s: %s\n", placed.BetId, err.Error())
+			continue
+		}
+		fmt.Printf("Placed %s bet %s on %d at %.2f for %.2f, matched %.2f\n", bet.Side, bet.BetID, bet.SelectionID, bet.Price, bet.Stake, bet.SizeMatched)
+	}
+}
@@ -0,0 +1,198 @@
+// Copyright 2022 Guy Barden
+// export.go - flattens the stored price history into rows for analysis outside the tool
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"encoding/csv"
+	"fmt"
+	"guysports/go-football-trader/pkg/access"
+	"guysports/go-football-trader/pkg/store"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type (
+	// Row is one price sample of a runner
+	Row struct {
+		LeagueId    string
+		EventId     string
+		Fixture     string
+		MarketId    string
+		MarketType  string
+		SelectionId int
+		Runner      string
+		Timestamp   time.Time
+		BackPrice   float32
+		BackAmount  float32
+		LayPrice    float32
+		LayAmount   float32
+	}
+
+	// Filter selects the fixtures to export, Fixtures matches event ids or is found in fixture names
+	Filter struct {
+		Query    store.FixtureQuery
+		Fixtures []string
+	}
+)
+
+const (
+	CSVFormat     = "csv"
+	ParquetFormat = "parquet"
+)
+
+var (
+	// Columns are the names of the exported fields in the order they are written
+	Columns = []string{"league_id", "event_id", "fixture", "market_id", "market_type", "selection_id", "runner", "timestamp", "back_price", "back_amount", "lay_price", "lay_amount"}
+)
+
+// Rows flattens the fixtures selected by the filter into a row per sample, ordered by league, event, market type,
+// runner and time. Match odds runners are named home, away or draw and the runners of other markets by their
+// Betfair name and handicap. Samples without a valid timestamp are left out and counted
+func Rows(leagues map[string]map[string]store.FixturePrices, filter *Filter) (rows []Row, skipped int) {
+	rows = []Row{}
+	for leagueId, league := range leagues {
+		for eventId, fixture := range league {
+			if !filter.Query.Matches(leagueId, &fixture) || !filter.matchesFixture(eventId, &fixture) {
+				continue
+			}
+			at := Row{LeagueId: leagueId, EventId: eventId, Fixture: fixture.Fixture, MarketId: fixture.MarketID, MarketType: access.MatchOddsMarket}
+			for selectionId, history := range fixture.PriceHistory {
+				at.SelectionId, at.Runner = selectionId, runnerName(&fixture, selectionId)
+				rows, skipped = appendRows(rows, skipped, at, history)
+			}
+			for marketType, market := range fixture.Markets {
+				at.MarketId, at.MarketType = market.MarketID, marketType
+				for _, runner := range market.Runners {
+					at.SelectionId, at.Runner = runner.SelectionId, runner.Label()
+					rows, skipped = appendRows(rows, skipped, at, runner.History)
+				}
+			}
+		}
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		a, b := &rows[i], &rows[j]
+		switch {
+		case a.LeagueId != b.LeagueId:
+			return a.LeagueId < b.LeagueId
+		case a.EventId != b.EventId:
+			return a.EventId < b.EventId
+		case a.MarketType != b.MarketType:
+			return a.MarketType < b.MarketType
+		case a.SelectionId != b.SelectionId:
+			return a.SelectionId < b.SelectionId
+		case a.Runner != b.Runner:
+			return a.Runner < b.Runner
+		}
+		return a.Timestamp.Before(b.Timestamp)
+	})
+	return rows, skipped
+}
+
+// appendRows adds a row like at for each sample in the history
+func appendRows(rows []Row, skipped int, at Row, history []store.Price) ([]Row, int) {
+	for _, sample := range history {
+		taken, err := time.Parse(time.RFC3339, sample.Timestamp)
+		if err != nil {
+			skipped++
+			continue
+		}
+		row := at
+		row.Timestamp = taken.UTC()
+		row.BackPrice, row.BackAmount = sample.BackPrice, sample.BackAmount
+		row.LayPrice, row.LayAmount = sample.LayPrice, sample.LayAmount
+		rows = append(rows, row)
+	}
+	return rows, skipped
+}
+
+func (f *Filter) matchesFixture(eventId string, fixture *store.FixturePrices) bool {
+	if len(f.Fixtures) == 0 {
+		return true
+	}
+	for _, name := range f.Fixtures {
+		if name == eventId || strings.Contains(strings.ToLower(fixture.Fixture), strings.ToLower(name)) {
+			return true
+		}
+	}
+	return false
+}
+
+// runnerName returns home, away or draw for the fixture's runners
+func runnerName(fixture *store.FixturePrices, selectionId int) string {
+	switch selectionId {
+	case fixture.HomeRunnerId:
+		return "home"
+	case fixture.AwayRunnerId:
+		return "away"
+	case fixture.DrawRunnerId:
+		return "draw"
+	}
+	return ""
+}
+
+// Write writes the rows in the format, csv or parquet
+func Write(w io.Writer, rows []Row, format string) error {
+	switch format {
+	case CSVFormat:
+		return WriteCSV(w, rows)
+	case ParquetFormat:
+		return WriteParquet(w, rows)
+	}
+	return fmt.Errorf("export format must be one of %s or %s not %s", CSVFormat, ParquetFormat, format)
+}
+
+// WriteCSV writes the rows with a header of the column names, timestamps are RFC3339 in UTC
+func WriteCSV(w io.Writer, rows []Row) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(Columns); err != nil {
+		return err
+	}
+	for _, row := range rows {
+		record := []string{
+			row.LeagueId,
+			row.EventId,
+			row.Fixture,
+			row.MarketId,
+			row.MarketType,
+			strconv.Itoa(row.SelectionId),
+			row.Runner,
+			row.Timestamp.Format(time.RFC3339),
+			formatPrice(row.BackPrice),
+			formatPrice(row.BackAmount),
+			formatPrice(row.LayPrice),
+			formatPrice(row.LayAmount),
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// formatPrice writes the shortest decimal that reads back as the same float32
+func formatPrice(value float32) string {
+	return strconv.FormatFloat(float64(value), 'f', -1, 32)
+}
+
+// widen converts a float32 to the float64 with the same shortest decimal, so 3.7 is exported as 3.7
+func widen(value float32) float64 {
+	wide, _ := strconv.ParseFloat(formatPrice(value), 64)
+	return wide
+}
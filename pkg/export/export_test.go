@@ -0,0 +1,391 @@
+// Copyright 2022 Guy Barden
+// export_test.go - tests for exporting the price history
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"guysports/go-football-trader/pkg/store"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testLeagues = map[string]map[string]store.FixturePrices{
+	"59": {
+		"fixture1": {Fixture: "Mainz v Dortmund", Date: "2022-04-09T13:30:00Z", MarketID: "1.1", HomeRunnerId: 1, AwayRunnerId: 2, DrawRunnerId: 3, PriceHistory: map[int][]store.Price{
+			1: {{Timestamp: "2022-04-06T12:10:00Z", BackPrice: 3.65, LayPrice: 3.7}, {Timestamp: "2022-04-06T12:00:00Z", BackPrice: 3.7, BackAmount: 10.5, LayPrice: 3.75, LayAmount: 20}},
+			3: {{Timestamp: "not a time"}},
+		}},
+	},
+	"81": {
+		"fixture2": {Fixture: "Roma v Lazio", Date: "2022-05-09T18:45:00Z", MarketID: "1.2", HomeRunnerId: 4, AwayRunnerId: 5, PriceHistory: map[int][]store.Price{
+			5: {{Timestamp: "2022-05-06T12:00:00Z", BackPrice: 2.02, LayPrice: 2.04}},
+		}, Markets: map[string]store.MarketPrices{
+			"ASIAN_HANDICAP": {MarketID: "1.3", MarketName: "Asian Handicap", Runners: []store.MarketRunner{
+				{SelectionId: 4, Name: "Roma", Handicap: -0.5, History: []store.Price{{Timestamp: "2022-05-06T12:00:00Z", BackPrice: 1.98, LayPrice: 2}}},
+				{SelectionId: 5, Name: "Lazio", Handicap: 0.5},
+			}},
+		}},
+	},
+}
+
+func TestRows(t *testing.T) {
+	tests := []struct {
+		name        string
+		filter      Filter
+		wantEvents  []string
+		wantSkipped int
+	}{
+		{
+			name:        "every fixture",
+			wantEvents:  []string{"fixture1", "fixture1", "fixture2", "fixture2"},
+			wantSkipped: 1,
+		},
+		{
+			name:       "by league",
+			filter:     Filter{Query: store.FixtureQuery{LeagueIds: []string{"81"}}},
+			wantEvents: []string{"fixture2", "fixture2"},
+		},
+		{
+			name:        "by date",
+			filter:      Filter{Query: store.FixtureQuery{To: time.Date(2022, 4, 30, 0, 0, 0, 0, time.UTC)}},
+			wantEvents:  []string{"fixture1", "fixture1"},
+			wantSkipped: 1,
+		},
+		{
+			name:       "by fixture name",
+			filter:     Filter{Fixtures: []string{"lazio"}},
+			wantEvents: []string{"fixture2", "fixture2"},
+		},
+		{
+			name:        "by event id",
+			filter:      Filter{Fixtures: []string{"fixture1"}},
+			wantEvents:  []string{"fixture1", "fixture1"},
+			wantSkipped: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rows, skipped := Rows(testLeagues, &tt.filter)
+			events := []string{}
+			for _, row := range rows {
+				events = append(events, row.EventId)
+			}
+			assert.Equal(t, tt.wantEvents, events)
+			assert.Equal(t, tt.wantSkipped, skipped)
+		})
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	rows, _ := Rows(testLeagues, &Filter{})
+	out := bytes.Buffer{}
+	assert.Nil(t, Write(&out, rows, CSVFormat))
+	assert.Equal(t, `league_id,event_id,fixture,market_id,market_type,selection_id,runner,timestamp,back_price,back_amount,lay_price,lay_amount
+59,fixture1,Mainz v Dortmund,1.1,MATCH_ODDS,1,home,2022-04-06T12:00:00Z,3.7,10.5,3.75,20
+59,fixture1,Mainz v Dortmund,1.1,MATCH_ODDS,1,home,2022-04-06T12:10:00Z,3.65,0,3.7,0
+81,fixture2,Roma v Lazio,1.3,ASIAN_HANDICAP,4,Roma -0.5,2022-05-06T12:00:00Z,1.98,0,2,0
+81,fixture2,Roma v Lazio,1.2,MATCH_ODDS,5,away,2022-05-06T12:00:00Z,2.02,0,2.04,0
+`, out.String())
+	assert.NotNil(t, Write(&out, rows, "xml"))
+}
+
+func TestWriteParquet(t *testing.T) {
+	rows, _ := Rows(testLeagues, &Filter{})
+	out := bytes.Buffer{}
+	assert.Nil(t, Write(&out, rows, ParquetFormat))
+	file := out.Bytes()
+	assert.Equal(t, []byte("PAR1"), file[:4])
+	assert.Equal(t, []byte("PAR1"), file[len(file)-4:])
+	footerLength := int(binary.LittleEndian.Uint32(file[len(file)-8:]))
+	footerStart := len(file) - 8 - footerLength
+
+	// The footer is read field by field with the ids of parquet.thrift rather than the writer's own encoding
+	reader := &compactReader{data: file[footerStart : len(file)-8]}
+	metadata, err := reader.readStruct()
+	assert.Nil(t, err)
+	assert.Equal(t, footerLength, reader.pos, "footer length")
+
+	// FileMetaData: 1 version, 2 schema, 3 num_rows, 4 row_groups
+	assert.Equal(t, int64(1), metadata[1])
+	assert.Equal(t, int64(4), metadata[3])
+
+	// SchemaElement: 1 type, 3 repetition_type, 4 name, 5 num_children, 6 converted_type
+	wantSchema := []struct {
+		name      string
+		physical  int64
+		converted interface{}
+	}{
+		{"league_id", 6, int64(0)},
+		{"event_id", 6, int64(0)},
+		{"fixture", 6, int64(0)},
+		{"market_id", 6, int64(0)},
+		{"market_type", 6, int64(0)},
+		{"selection_id", 2, nil},
+		{"runner", 6, int64(0)},
+		{"timestamp", 2, int64(9)},
+		{"back_price", 5, nil},
+		{"back_amount", 5, nil},
+		{"lay_price", 5, nil},
+		{"lay_amount", 5, nil},
+	}
+	schema := metadata[2].([]interface{})
+	assert.Len(t, schema, len(wantSchema)+1)
+	root := schema[0].(thriftStruct)
+	assert.Equal(t, "schema", root[4])
+	assert.Equal(t, int64(len(wantSchema)), root[5])
+	assert.Nil(t, root[1], "the root has no type")
+	for i, want := range wantSchema {
+		element := schema[i+1].(thriftStruct)
+		assert.Equal(t, want.name, element[4])
+		assert.Equal(t, want.physical, element[1], want.name)
+		assert.Equal(t, int64(0), element[3], "%s is required", want.name)
+		assert.Equal(t, want.converted, element[6], want.name)
+	}
+
+	// RowGroup: 1 columns, 2 total_byte_size, 3 num_rows
+	rowGroups := metadata[4].([]interface{})
+	assert.Len(t, rowGroups, 1)
+	rowGroup := rowGroups[0].(thriftStruct)
+	assert.Equal(t, int64(4), rowGroup[3])
+	chunks := rowGroup[1].([]interface{})
+	assert.Len(t, chunks, len(wantSchema))
+
+	values := map[string][]interface{}{}
+	totalSize := int64(0)
+	nextOffset := int64(4)
+	for i, want := range wantSchema {
+		// ColumnChunk: 2 file_offset, 3 meta_data
+		chunk := chunks[i].(thriftStruct)
+		// ColumnMetaData: 1 type, 2 encodings, 3 path_in_schema, 4 codec, 5 num_values, 6 total_uncompressed_size,
+		// 7 total_compressed_size, 9 data_page_offset
+		column := chunk[3].(thriftStruct)
+		assert.Equal(t, want.physical, column[1], want.name)
+		assert.Contains(t, column[2], int64(0), "%s is plain encoded", want.name)
+		assert.Equal(t, []interface{}{want.name}, column[3])
+		assert.Equal(t, int64(0), column[4], "%s is uncompressed", want.name)
+		assert.Equal(t, int64(4), column[5], want.name)
+		assert.Equal(t, column[6], column[7], want.name)
+		assert.Equal(t, nextOffset, column[9], "%s follows the previous column", want.name)
+		assert.Equal(t, column[9], chunk[2], want.name)
+		size := column[7].(int64)
+		totalSize += size
+		nextOffset += size
+
+		// PageHeader: 1 type, 2 uncompressed_page_size, 3 compressed_page_size, 5 data_page_header
+		page := &compactReader{data: file[column[9].(int64) : column[9].(int64)+size]}
+		header, err := page.readStruct()
+		assert.Nil(t, err)
+		assert.Equal(t, int64(0), header[1], "%s is a data page", want.name)
+		assert.Equal(t, header[2], header[3], want.name)
+		assert.Equal(t, int64(page.pos)+header[3].(int64), size, "%s page fills its chunk", want.name)
+		// DataPageHeader: 1 num_values, 2 encoding, 3 definition_level_encoding, 4 repetition_level_encoding
+		dataPage := header[5].(thriftStruct)
+		assert.Equal(t, int64(4), dataPage[1], want.name)
+		assert.Equal(t, int64(0), dataPage[2], want.name)
+		assert.NotNil(t, dataPage[3], want.name)
+		assert.NotNil(t, dataPage[4], want.name)
+
+		// Required columns have no levels, so the page holds only the plain encoded values
+		values[want.name] = readPlainValues(t, page.data[page.pos:], want.physical, 4)
+	}
+	assert.Equal(t, int64(footerStart), nextOffset, "the footer follows the last column")
+	assert.Equal(t, totalSize, rowGroup[2])
+
+	assert.Equal(t, []interface{}{"59", "59", "81", "81"}, values["league_id"])
+	assert.Equal(t, []interface{}{"fixture1", "fixture1", "fixture2", "fixture2"}, values["event_id"])
+	assert.Equal(t, []interface{}{"Mainz v Dortmund", "Mainz v Dortmund", "Roma v Lazio", "Roma v Lazio"}, values["fixture"])
+	assert.Equal(t, []interface{}{"1.1", "1.1", "1.3", "1.2"}, values["market_id"])
+	assert.Equal(t, []interface{}{"MATCH_ODDS", "MATCH_ODDS", "ASIAN_HANDICAP", "MATCH_ODDS"}, values["market_type"])
+	assert.Equal(t, []interface{}{int64(1), int64(1), int64(4), int64(5)}, values["selection_id"])
+	assert.Equal(t, []interface{}{"home", "home", "Roma -0.5", "away"}, values["runner"])
+	assert.Equal(t, []interface{}{
+		time.Date(2022, 4, 6, 12, 0, 0, 0, time.UTC).UnixNano() / 1e6,
+		time.Date(2022, 4, 6, 12, 10, 0, 0, time.UTC).UnixNano() / 1e6,
+		time.Date(2022, 5, 6, 12, 0, 0, 0, time.UTC).UnixNano() / 1e6,
+		time.Date(2022, 5, 6, 12, 0, 0, 0, time.UTC).UnixNano() / 1e6,
+	}, values["timestamp"])
+	// Prices are written as the double with the same decimal
+	assert.Equal(t, []interface{}{3.7, 3.65, 1.98, 2.02}, values["back_price"])
+	assert.Equal(t, []interface{}{10.5, 0.0, 0.0, 0.0}, values["back_amount"])
+	assert.Equal(t, []interface{}{3.75, 3.7, 2.0, 2.04}, values["lay_price"])
+	assert.Equal(t, []interface{}{20.0, 0.0, 0.0, 0.0}, values["lay_amount"])
+}
+
+type (
+	// thriftStruct is a decoded thrift struct keyed by field id
+	thriftStruct map[int]interface{}
+
+	// compactReader decodes the thrift compact protocol as set out in the thrift specification, integers are read as
+	// int64, binaries as strings, lists as slices and structs as thriftStruct
+	compactReader struct {
+		data []byte
+		pos  int
+	}
+)
+
+func (r *compactReader) readStruct() (thriftStruct, error) {
+	fields := thriftStruct{}
+	last := 0
+	for {
+		header, err := r.readByte()
+		if err != nil {
+			return nil, err
+		}
+		if header == 0 {
+			return fields, nil
+		}
+		id := last + int(header>>4)
+		if header>>4 == 0 {
+			zigzag, err := r.readVarint()
+			if err != nil {
+				return nil, err
+			}
+			id = int(unzigzag(zigzag))
+		}
+		value, err := r.readValue(header & 0x0f)
+		if err != nil {
+			return nil, err
+		}
+		fields[id] = value
+		last = id
+	}
+}
+
+func (r *compactReader) readValue(valueType byte) (interface{}, error) {
+	switch valueType {
+	case 1:
+		return true, nil
+	case 2:
+		return false, nil
+	case 3:
+		value, err := r.readByte()
+		return int64(int8(value)), err
+	case 4, 5, 6:
+		value, err := r.readVarint()
+		return unzigzag(value), err
+	case 7:
+		if r.pos+8 > len(r.data) {
+			return nil, fmt.Errorf("double at %d overruns the data", r.pos)
+		}
+		r.pos += 8
+		return math.Float64frombits(binary.LittleEndian.Uint64(r.data[r.pos-8:])), nil
+	case 8:
+		length, err := r.readVarint()
+		if err != nil {
+			return nil, err
+		}
+		if r.pos+int(length) > len(r.data) {
+			return nil, fmt.Errorf("binary at %d overruns the data", r.pos)
+		}
+		r.pos += int(length)
+		return string(r.data[r.pos-int(length) : r.pos]), nil
+	case 9, 10:
+		header, err := r.readByte()
+		if err != nil {
+			return nil, err
+		}
+		size := uint64(header >> 4)
+		if size == 15 {
+			if size, err = r.readVarint(); err != nil {
+				return nil, err
+			}
+		}
+		elements := []interface{}{}
+		for i := uint64(0); i < size; i++ {
+			element, err := r.readElement(header & 0x0f)
+			if err != nil {
+				return nil, err
+			}
+			elements = append(elements, element)
+		}
+		return elements, nil
+	case 12:
+		return r.readStruct()
+	}
+	return nil, fmt.Errorf("unknown compact type %d at %d", valueType, r.pos)
+}
+
+// readElement reads a list element, where booleans are written as a byte rather than in the type
+func (r *compactReader) readElement(elementType byte) (interface{}, error) {
+	if elementType == 1 || elementType == 2 {
+		value, err := r.readByte()
+		return value == 1, err
+	}
+	return r.readValue(elementType)
+}
+
+func (r *compactReader) readByte() (byte, error) {
+	if r.pos >= len(r.data) {
+		return 0, fmt.Errorf("read past the end of %d bytes", len(r.data))
+	}
+	r.pos++
+	return r.data[r.pos-1], nil
+}
+
+func (r *compactReader) readVarint() (uint64, error) {
+	value, n := binary.Uvarint(r.data[r.pos:])
+	if n <= 0 {
+		return 0, fmt.Errorf("invalid varint at %d", r.pos)
+	}
+	r.pos += n
+	return value, nil
+}
+
+func unzigzag(value uint64) int64 {
+	return int64(value>>1) ^ -int64(value&1)
+}
+
+// readPlainValues decodes the parquet plain encoding of the physical type, INT64 (2), DOUBLE (5) or BYTE_ARRAY (6)
+func readPlainValues(t *testing.T, data []byte, physical int64, count int) []interface{} {
+	values := []interface{}{}
+	pos := 0
+	for i := 0; i < count; i++ {
+		switch physical {
+		case 2:
+			values = append(values, int64(binary.LittleEndian.Uint64(data[pos:])))
+			pos += 8
+		case 5:
+			values = append(values, math.Float64frombits(binary.LittleEndian.Uint64(data[pos:])))
+			pos += 8
+		case 6:
+			length := int(binary.LittleEndian.Uint32(data[pos:]))
+			values = append(values, string(data[pos+4:pos+4+length]))
+			pos += 4 + length
+		default:
+			t.Fatalf("unexpected physical type %d", physical)
+		}
+	}
+	assert.Equal(t, len(data), pos, "the page holds only its values")
+	return values
+}
+
+func TestCompactWriter(t *testing.T) {
+	c := &compactWriter{}
+	c.structBegin()
+	c.i32Field(1, -1)
+	c.i64Field(20, 150)
+	c.stringField(21, "ab")
+	c.listField(22, compactI32, 2)
+	c.writeZigzag(1)
+	c.writeZigzag(2)
+	c.structEnd()
+	assert.Equal(t, []byte{0x15, 0x01, 0x06, 0x28, 0xac, 0x02, 0x18, 0x02, 'a', 'b', 0x19, 0x25, 0x02, 0x04, 0x00}, c.Bytes())
+}
@@ -0,0 +1,306 @@
+// Copyright 2022 Guy Barden
+// parquet.go - writes the exported rows as an uncompressed parquet file
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+)
+
+type (
+	// parquetColumn is a required column with its parquet physical and converted types
+	parquetColumn struct {
+		name      string
+		physical  int32
+		converted int32
+		encode    func(row *Row, buffer *bytes.Buffer)
+	}
+
+	// columnChunk records where a column of a row group was written
+	columnChunk struct {
+		offset int64
+		size   int64
+		values int64
+	}
+
+	// compactWriter encodes thrift structs with the compact protocol used by the parquet metadata
+	compactWriter struct {
+		bytes.Buffer
+		lastField []int
+	}
+
+	countingWriter struct {
+		w       io.Writer
+		written int64
+	}
+)
+
+const (
+	// RowGroupSize is the most rows written in each parquet row group
+	RowGroupSize = 100000
+
+	parquetMagic = "PAR1"
+
+	// Parquet physical types
+	typeInt64     = 2
+	typeDouble    = 5
+	typeByteArray = 6
+
+	// Parquet converted types, noConversion leaves the column as its physical type
+	noConversion    = -1
+	convertedUTF8   = 0
+	convertedMillis = 9
+
+	repetitionRequired = 0
+	encodingPlain      = 0
+	encodingRLE        = 3
+	codecUncompressed  = 0
+	pageTypeData       = 0
+
+	// Thrift compact protocol types
+	compactI32    = 5
+	compactI64    = 6
+	compactBinary = 8
+	compactList   = 9
+	compactStruct = 12
+)
+
+var (
+	parquetColumns = []parquetColumn{
+		{name: "league_id", physical: typeByteArray, converted: convertedUTF8, encode: func(row *Row, b *bytes.Buffer) { writeByteArray(b, row.LeagueId) }},
+		{name: "event_id", physical: typeByteArray, converted: convertedUTF8, encode: func(row *Row, b *bytes.Buffer) { writeByteArray(b, row.EventId) }},
+		{name: "fixture", physical: typeByteArray, converted: convertedUTF8, encode: func(row *Row, b *bytes.Buffer) { writeByteArray(b, row.Fixture) }},
+		{name: "market_id", physical: typeByteArray, converted: convertedUTF8, encode: func(row *Row, b *bytes.Buffer) { writeByteArray(b, row.MarketId) }},
+		{name: "market_type", physical: typeByteArray, converted: convertedUTF8, encode: func(row *Row, b *bytes.Buffer) { writeByteArray(b, row.MarketType) }},
+		{name: "selection_id", physical: typeInt64, converted: noConversion, encode: func(row *Row, b *bytes.Buffer) { writeInt64(b, int64(row.SelectionId)) }},
+		{name: "runner", physical: typeByteArray, converted: convertedUTF8, encode: func(row *Row, b *bytes.Buffer) { writeByteArray(b, row.Runner) }},
+		{name: "timestamp", physical: typeInt64, converted: convertedMillis, encode: func(row *Row, b *bytes.Buffer) { writeInt64(b, row.Timestamp.UnixNano()/1e6) }},
+		{name: "back_price", physical: typeDouble, converted: noConversion, encode: func(row *Row, b *bytes.Buffer) { writeDouble(b, row.BackPrice) }},
+		{name: "back_amount", physical: typeDouble, converted: noConversion, encode: func(row *Row, b *bytes.Buffer) { writeDouble(b, row.BackAmount) }},
+		{name: "lay_price", physical: typeDouble, converted: noConversion, encode: func(row *Row, b *bytes.Buffer) { writeDouble(b, row.LayPrice) }},
+		{name: "lay_amount", physical: typeDouble, converted: noConversion, encode: func(row *Row, b *bytes.Buffer) { writeDouble(b, row.LayAmount) }},
+	}
+)
+
+// WriteParquet writes the rows as a parquet file of required, plain encoded and uncompressed columns, with the
+// timestamp in milliseconds since the epoch
+func WriteParquet(w io.Writer, rows []Row) error {
+	out := &countingWriter{w: w}
+	if _, err := out.Write([]byte(parquetMagic)); err != nil {
+		return err
+	}
+	rowGroups := [][]columnChunk{}
+	for start := 0; start < len(rows); start += RowGroupSize {
+		end := start + RowGroupSize
+		if end > len(rows) {
+			end = len(rows)
+		}
+		chunks := []columnChunk{}
+		for _, column := range parquetColumns {
+			values := bytes.Buffer{}
+			for i := start; i < end; i++ {
+				column.encode(&rows[i], &values)
+			}
+			header := pageHeader(values.Len(), end-start)
+			chunk := columnChunk{offset: out.written, size: int64(header.Len() + values.Len()), values: int64(end - start)}
+			if _, err := out.Write(header.Bytes()); err != nil {
+				return err
+			}
+			if _, err := out.Write(values.Bytes()); err != nil {
+				return err
+			}
+			chunks = append(chunks, chunk)
+		}
+		rowGroups = append(rowGroups, chunks)
+	}
+
+	footer := fileMetaData(int64(len(rows)), rowGroups)
+	length := make([]byte, 4)
+	binary.LittleEndian.PutUint32(length, uint32(footer.Len()))
+	for _, part := range [][]byte{footer.Bytes(), length, []byte(parquetMagic)} {
+		if _, err := out.Write(part); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pageHeader describes a data page of values, required columns have no repetition or definition levels
+func pageHeader(size int, values int) *compactWriter {
+	c := &compactWriter{}
+	c.structBegin()
+	c.i32Field(1, pageTypeData)
+	c.i32Field(2, int32(size))
+	c.i32Field(3, int32(size))
+	c.structField(5)
+	c.i32Field(1, int32(values))
+	c.i32Field(2, encodingPlain)
+	c.i32Field(3, encodingRLE)
+	c.i32Field(4, encodingRLE)
+	c.structEnd()
+	c.structEnd()
+	return c
+}
+
+// fileMetaData describes the schema and where each row group's columns are
+func fileMetaData(rows int64, rowGroups [][]columnChunk) *compactWriter {
+	c := &compactWriter{}
+	c.structBegin()
+	c.i32Field(1, 1)
+
+	c.listField(2, compactStruct, len(parquetColumns)+1)
+	c.structBegin()
+	c.stringField(4, "schema")
+	c.i32Field(5, int32(len(parquetColumns)))
+	c.structEnd()
+	for _, column := range parquetColumns {
+		c.structBegin()
+		c.i32Field(1, column.physical)
+		c.i32Field(3, repetitionRequired)
+		c.stringField(4, column.name)
+		if column.converted != noConversion {
+			c.i32Field(6, column.converted)
+		}
+		c.structEnd()
+	}
+
+	c.i64Field(3, rows)
+	c.listField(4, compactStruct, len(rowGroups))
+	for _, chunks := range rowGroups {
+		c.structBegin()
+		total := int64(0)
+		c.listField(1, compactStruct, len(chunks))
+		for i, chunk := range chunks {
+			total += chunk.size
+			c.structBegin()
+			c.i64Field(2, chunk.offset)
+			c.structField(3)
+			c.i32Field(1, parquetColumns[i].physical)
+			c.listField(2, compactI32, 2)
+			c.writeZigzag(encodingPlain)
+			c.writeZigzag(encodingRLE)
+			c.listField(3, compactBinary, 1)
+			c.writeString(parquetColumns[i].name)
+			c.i32Field(4, codecUncompressed)
+			c.i64Field(5, chunk.values)
+			c.i64Field(6, chunk.size)
+			c.i64Field(7, chunk.size)
+			c.i64Field(9, chunk.offset)
+			c.structEnd()
+			c.structEnd()
+		}
+		c.i64Field(2, total)
+		c.i64Field(3, chunks[0].values)
+		c.structEnd()
+	}
+	c.stringField(6, "go-football-trader")
+	c.structEnd()
+	return c
+}
+
+func writeByteArray(b *bytes.Buffer, value string) {
+	length := make([]byte, 4)
+	binary.LittleEndian.PutUint32(length, uint32(len(value)))
+	b.Write(length)
+	b.WriteString(value)
+}
+
+func writeInt64(b *bytes.Buffer, value int64) {
+	encoded := make([]byte, 8)
+	binary.LittleEndian.PutUint64(encoded, uint64(value))
+	b.Write(encoded)
+}
+
+func writeDouble(b *bytes.Buffer, value float32) {
+	encoded := make([]byte, 8)
+	binary.LittleEndian.PutUint64(encoded, math.Float64bits(widen(value)))
+	b.Write(encoded)
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.written += int64(n)
+	return n, err
+}
+
+func (c *compactWriter) structBegin() {
+	c.lastField = append(c.lastField, 0)
+}
+
+func (c *compactWriter) structEnd() {
+	c.WriteByte(0)
+	c.lastField = c.lastField[:len(c.lastField)-1]
+}
+
+// fieldHeader writes the field id as a delta from the previous field of the struct where it fits in four bits
+func (c *compactWriter) fieldHeader(id int, fieldType byte) {
+	last := &c.lastField[len(c.lastField)-1]
+	if delta := id - *last; delta > 0 && delta <= 15 {
+		c.WriteByte(byte(delta<<4) | fieldType)
+	} else {
+		c.WriteByte(fieldType)
+		c.writeZigzag(int64(id))
+	}
+	*last = id
+}
+
+func (c *compactWriter) i32Field(id int, value int32) {
+	c.fieldHeader(id, compactI32)
+	c.writeZigzag(int64(value))
+}
+
+func (c *compactWriter) i64Field(id int, value int64) {
+	c.fieldHeader(id, compactI64)
+	c.writeZigzag(value)
+}
+
+func (c *compactWriter) stringField(id int, value string) {
+	c.fieldHeader(id, compactBinary)
+	c.writeString(value)
+}
+
+// listField starts a list, its elements are written after it
+func (c *compactWriter) listField(id int, elementType byte, size int) {
+	c.fieldHeader(id, compactList)
+	if size < 15 {
+		c.WriteByte(byte(size<<4) | elementType)
+		return
+	}
+	c.WriteByte(0xf0 | elementType)
+	c.writeVarint(uint64(size))
+}
+
+// structField starts a struct field, its fields are written after it and closed by structEnd
+func (c *compactWriter) structField(id int) {
+	c.fieldHeader(id, compactStruct)
+	c.structBegin()
+}
+
+func (c *compactWriter) writeString(value string) {
+	c.writeVarint(uint64(len(value)))
+	c.WriteString(value)
+}
+
+func (c *compactWriter) writeZigzag(value int64) {
+	c.writeVarint(uint64((value << 1) ^ (value >> 63)))
+}
+
+func (c *compactWriter) writeVarint(value uint64) {
+	encoded := make([]byte, binary.MaxVarintLen64)
+	c.Write(encoded[:binary.PutUvarint(encoded, value)])
+}
@@ -0,0 +1,97 @@
+// Copyright 2022 Guy Barden
+// orders.go - fake order client that accepts orders without placing them on the exchange
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package fake
+
+import (
+	"fmt"
+	"guysports/go-football-trader/pkg/order"
+)
+
+type (
+	FakeOrders struct {
+		InjectPlaceOrdersError   bool
+		InjectCancelOrdersError  bool
+		InjectReplaceOrdersError bool
+		InjectListOrdersError    bool
+		// Placed holds the instructions received, orders are fully matched at the requested price
+		Placed []order.PlaceInstruction
+		// Current is returned when listing current orders
+		Current []order.CurrentOrder
+	}
+)
+
+func (f *FakeOrders) PlaceOrders(marketId string, instructions []order.PlaceInstruction, customerRef string) (*order.PlaceExecutionReport, error) {
+	if f.InjectPlaceOrdersError {
+		return nil, fmt.Errorf("error placing orders")
+	}
+	report := order.PlaceExecutionReport{
+		CustomerRef: customerRef,
+		Status:      order.ReportSuccess,
+		MarketId:    marketId,
+	}
+	for _, instruction := range instructions {
+		f.Placed = append(f.Placed, instruction)
+		report.InstructionReports = append(report.InstructionReports, order.PlaceInstructionReport{
+			Status:              order.ReportSuccess,
+			OrderStatus:         "EXECUTION_COMPLETE",
+			Instruction:         instruction,
+			BetId:               fmt.Sprintf("bet%d", len(f.Placed)),
+			PlacedDate:          "2022-04-06T12:00:00.000Z",
+			AveragePriceMatched: instruction.LimitOrder.Price,
+			SizeMatched:         instruction.LimitOrder.Size,
+		})
+	}
+	return &report, nil
+}
+
+func (f *FakeOrders) CancelOrders(marketId string, instructions []order.CancelInstruction) (*order.CancelExecutionReport, error) {
+	if f.InjectCancelOrdersError {
+		return nil, fmt.Errorf("error cancelling orders")
+	}
+	report := order.CancelExecutionReport{
+		Status:   order.ReportSuccess,
+		MarketId: marketId,
+	}
+	for _, instruction := range instructions {
+		report.InstructionReports = append(report.InstructionReports, order.CancelInstructionReport{
+			Status:      order.ReportSuccess,
+			Instruction: instruction,
+		})
+	}
+	return &report, nil
+}
+
+func (f *FakeOrders) ReplaceOrders(marketId string, instructions []order.ReplaceInstruction) (*order.ReplaceExecutionReport, error) {
+	if f.InjectReplaceOrdersError {
+		return nil, fmt.Errorf("error replacing orders")
+	}
+	report := order.ReplaceExecutionReport{
+		Status:   order.ReportSuccess,
+		MarketId: marketId,
+	}
+	for range instructions {
+		report.InstructionReports = append(report.InstructionReports, order.ReplaceInstructionReport{
+			Status: order.ReportSuccess,
+		})
+	}
+	return &report, nil
+}
+
+func (f *FakeOrders) ListCurrentOrders(marketIds []string) ([]order.CurrentOrder, error) {
+	if f.InjectListOrdersError {
+		return nil, fmt.Errorf("error listing current orders")
+	}
+	return f.Current, nil
+}
@@ -26,9 +26,28 @@ type (
 		InjectListMarketCatalogueError bool
 		InjectListMarketBookError      bool
 		AppendPrices                   bool
+		// SettledWinner closes the market with this selection as the winner
+		SettledWinner int
+		// OmitCatalogueEvents leaves out the event of each market catalogue as the betting api does
+		OmitCatalogueEvents bool
+		// InPlay turns the match odds market in play, Suspended suspends it with no prices available and HomeGoal
+		// moves its prices as if the home team had scored
+		InPlay    bool
+		Suspended bool
+		HomeGoal  bool
 	}
 )
 
+const (
+	// Market types and ids of the other markets of the fixture
+	OverUnderMarketType     = "OVER_UNDER_25"
+	OverUnderMarketId       = "1.195693930"
+	AsianHandicapMarketType = "ASIAN_HANDICAP"
+	AsianHandicapMarketId   = "1.195693940"
+
+	matchOddsMarketType = "MATCH_ODDS"
+)
+
 func (f *FakeQuery) ListEvents(filter *types.MarketFilter) ([]types.EventWrapper, error) {
 	if f.InjectListEventsError {
 		return nil, fmt.Errorf("error listing events")
@@ -92,44 +111,104 @@ func (f *FakeQuery) ListMarketCatalogue(filter *types.MarketFilter, numEvts int,
 	// 		}
 	// 	}]
 	// }
-	catalog := []types.MarketCatalogueWrapper{
-		{
-			MarketId:     "1.195693926",
-			MarketName:   "Match Odds",
-			TotalMatched: 1000.00,
+	catalog := []types.MarketCatalogueWrapper{}
+	if requested(filter, matchOddsMarketType) {
+		catalog = append(catalog, matchOddsCatalogue())
+	}
+	if requested(filter, OverUnderMarketType) {
+		catalog = append(catalog, types.MarketCatalogueWrapper{
+			MarketId:     OverUnderMarketId,
+			MarketName:   "Over/Under 2.5 Goals",
+			TotalMatched: 500.00,
 			Selections: []types.Selection{
-				{
-					SelectionId: 64374,
-					Name:        "Mainz",
-					Handicap:    0.0,
-					Ranking:     1,
-					Metadata: map[string]string{
-						"runnerId": "64374",
-					},
+				{SelectionId: 47972, Name: "Under 2.5 Goals", Ranking: 1},
+				{SelectionId: 47973, Name: "Over 2.5 Goals", Ranking: 2},
+			},
+		})
+	}
+	if requested(filter, AsianHandicapMarketType) {
+		catalog = append(catalog, types.MarketCatalogueWrapper{
+			MarketId:     AsianHandicapMarketId,
+			MarketName:   "Asian Handicap",
+			TotalMatched: 250.00,
+			Selections: []types.Selection{
+				{SelectionId: 64374, Name: "Mainz", Handicap: -0.5, Ranking: 1},
+				{SelectionId: 44785, Name: "Dortmund", Handicap: 0.5, Ranking: 2},
+				{SelectionId: 64374, Name: "Mainz", Handicap: 0.5, Ranking: 3},
+				{SelectionId: 44785, Name: "Dortmund", Handicap: -0.5, Ranking: 4},
+			},
+		})
+	}
+	return catalog, nil
+}
+
+// requested reports whether the filter asks for markets of the market type, match odds is returned when the filter
+// names no market types
+func requested(filter *types.MarketFilter, marketType string) bool {
+	if filter == nil || len(filter.MarketTypeCodes) == 0 {
+		return marketType == matchOddsMarketType
+	}
+	for _, code := range filter.MarketTypeCodes {
+		if code == marketType {
+			return true
+		}
+	}
+	return false
+}
+
+func matchOddsCatalogue() types.MarketCatalogueWrapper {
+	return types.MarketCatalogueWrapper{
+		MarketId:     "1.195693926",
+		MarketName:   "Match Odds",
+		TotalMatched: 1000.00,
+		Selections: []types.Selection{
+			{
+				SelectionId: 64374,
+				Name:        "Mainz",
+				Handicap:    0.0,
+				Ranking:     1,
+				Metadata: map[string]string{
+					"runnerId": "64374",
 				},
-				{
-					SelectionId: 44785,
-					Name:        "Dortmund",
-					Handicap:    0.0,
-					Ranking:     2,
-					Metadata: map[string]string{
-						"runnerId": "64374",
-					},
+			},
+			{
+				SelectionId: 44785,
+				Name:        "Dortmund",
+				Handicap:    0.0,
+				Ranking:     2,
+				Metadata: map[string]string{
+					"runnerId": "64374",
 				},
-				{
-					SelectionId: 58805,
-					Name:        "The Draw",
-					Handicap:    0.0,
-					Ranking:     3,
-					Metadata: map[string]string{
-						"runnerId": "58805",
-					},
+			},
+			{
+				SelectionId: 58805,
+				Name:        "The Draw",
+				Handicap:    0.0,
+				Ranking:     3,
+				Metadata: map[string]string{
+					"runnerId": "58805",
 				},
 			},
 		},
 	}
-	return catalog, nil
 }
+
+// ListEventMarketCatalogue returns the market catalogue with each market in the event listed by ListEvents
+func (f *FakeQuery) ListEventMarketCatalogue(filter *types.MarketFilter, maxResults int) ([]types.MarketCatalogueWrapper, map[string]string, error) {
+	markets, err := f.ListMarketCatalogue(filter, maxResults, []string{"EVENT", "RUNNER_METADATA"})
+	if err != nil {
+		return nil, nil, err
+	}
+	eventIds := map[string]string{}
+	if !f.OmitCatalogueEvents {
+		events, _ := f.ListEvents(filter)
+		for _, market := range markets {
+			eventIds[market.MarketId] = events[0].Event.ID
+		}
+	}
+	return markets, eventIds, nil
+}
+
 func (f *FakeQuery) ListMarketBook(marketIds []string, priceProjection *types.PriceProjection, orderProjection string, matchProjection string) ([]types.MarketBookWrapper, error) {
 	if f.InjectListMarketBookError {
 		return nil, fmt.Errorf("error listing marketbook")
@@ -251,6 +330,18 @@ func (f *FakeQuery) ListMarketBook(marketIds []string, priceProjection *types.Pr
 	// 		}
 	// 	}]
 	// }]
+	// Traded volume is only returned when requested in the price projection
+	var homeTraded, awayTraded, drawTraded []types.Odds
+	if priceProjection != nil {
+		for _, priceData := range priceProjection.PriceData {
+			if priceData == "EX_TRADED" {
+				homeTraded = []types.Odds{{Price: 3.7, Size: 1250.12}, {Price: 3.75, Size: 842.6}}
+				awayTraded = []types.Odds{{Price: 2.88, Size: 2101.5}, {Price: 2.9, Size: 975.33}}
+				drawTraded = []types.Odds{{Price: 2.6, Size: 640.0}}
+			}
+		}
+	}
+
 	hbOdds := firstHomeBackOdds
 	hlOdds := firstHomeLayOdds
 	abOdds := firstAwayBackOdds
@@ -280,34 +371,120 @@ func (f *FakeQuery) ListMarketBook(marketIds []string, priceProjection *types.Pr
 			Version:             4417661231,
 			Runners: []types.Runner{
 				{
-					SelectionID: 64374,
-					Handicap:    0.0,
-					Status:      "ACTIVE",
+					SelectionID:     64374,
+					Handicap:        0.0,
+					Status:          "ACTIVE",
+					LastPriceTraded: 3.75,
+					TotalMatched:    2092.72,
 					Exchange: types.ExchangePrices{
 						AvailableToBack: hbOdds,
 						AvailableToLay:  hlOdds,
+						TradedVolume:    homeTraded,
 					},
 				},
 				{
-					SelectionID: 44785,
-					Handicap:    0.0,
-					Status:      "ACTIVE",
+					SelectionID:     44785,
+					Handicap:        0.0,
+					Status:          "ACTIVE",
+					LastPriceTraded: 2.9,
+					TotalMatched:    3076.83,
 					Exchange: types.ExchangePrices{
 						AvailableToBack: abOdds,
 						AvailableToLay:  alOdds,
+						TradedVolume:    awayTraded,
 					},
 				},
 				{
-					SelectionID: 58805,
-					Handicap:    0.0,
-					Status:      "ACTIVE",
+					SelectionID:     58805,
+					Handicap:        0.0,
+					Status:          "ACTIVE",
+					LastPriceTraded: 2.6,
+					TotalMatched:    640.0,
 					Exchange: types.ExchangePrices{
 						AvailableToBack: drawBackOdds,
 						AvailableToLay:  drawLayOdds,
+						TradedVolume:    drawTraded,
 					},
 				},
 			},
 		},
 	}
-	return marketbook, nil
+	marketbook[0].Inplay = f.InPlay
+	if f.HomeGoal {
+		marketbook[0].Runners[0].Exchange.AvailableToBack = []types.Odds{{Price: 1.8, Size: 1250.5}}
+		marketbook[0].Runners[0].Exchange.AvailableToLay = []types.Odds{{Price: 1.81, Size: 980.25}}
+		marketbook[0].Runners[1].Exchange.AvailableToBack = []types.Odds{{Price: 6, Size: 310.8}}
+		marketbook[0].Runners[1].Exchange.AvailableToLay = []types.Odds{{Price: 6.2, Size: 145.6}}
+	}
+	if f.Suspended {
+		marketbook[0].Status = "SUSPENDED"
+		for i := range marketbook[0].Runners {
+			marketbook[0].Runners[i].Exchange.AvailableToBack = nil
+			marketbook[0].Runners[i].Exchange.AvailableToLay = nil
+		}
+	}
+	if f.SettledWinner != 0 {
+		marketbook[0].Status = "CLOSED"
+		for i := range marketbook[0].Runners {
+			marketbook[0].Runners[i].Status = "LOSER"
+			if marketbook[0].Runners[i].SelectionID == f.SettledWinner {
+				marketbook[0].Runners[i].Status = "WINNER"
+			}
+		}
+	}
+
+	// The match odds book is returned unless only the other markets are asked for
+	otherBooks := []types.MarketBookWrapper{}
+	for _, marketId := range marketIds {
+		switch marketId {
+		case OverUnderMarketId:
+			otherBooks = append(otherBooks, f.otherMarketBook(OverUnderMarketId, []types.Runner{
+				bookRunner(47972, 0, 2.1, 2.12),
+				bookRunner(47973, 0, 1.9, 1.92),
+			}))
+		case AsianHandicapMarketId:
+			otherBooks = append(otherBooks, f.otherMarketBook(AsianHandicapMarketId, []types.Runner{
+				bookRunner(64374, -0.5, 3.7, 3.75),
+				bookRunner(44785, 0.5, 1.36, 1.37),
+				bookRunner(64374, 0.5, 1.57, 1.58),
+				bookRunner(44785, -0.5, 2.72, 2.74),
+			}))
+		}
+	}
+	if len(otherBooks) == len(marketIds) && len(marketIds) > 0 {
+		return otherBooks, nil
+	}
+	return append(marketbook, otherBooks...), nil
+}
+
+// otherMarketBook returns the book of another market of the fixture, closed with its first runner the winner when
+// the fake is settled
+func (f *FakeQuery) otherMarketBook(marketId string, runners []types.Runner) types.MarketBookWrapper {
+	book := types.MarketBookWrapper{
+		MarketId:        marketId,
+		Status:          "OPEN",
+		NumberOfWinners: 1,
+		NumberOfRunners: len(runners),
+		Runners:         runners,
+	}
+	if f.SettledWinner != 0 {
+		book.Status = "CLOSED"
+		for i := range book.Runners {
+			book.Runners[i].Status = "LOSER"
+		}
+		book.Runners[0].Status = "WINNER"
+	}
+	return book
+}
+
+func bookRunner(selectionId int, handicap float32, back float32, lay float32) types.Runner {
+	return types.Runner{
+		SelectionID: selectionId,
+		Handicap:    handicap,
+		Status:      "ACTIVE",
+		Exchange: types.ExchangePrices{
+			AvailableToBack: []types.Odds{{Price: back, Size: 100}},
+			AvailableToLay:  []types.Odds{{Price: lay, Size: 100}},
+		},
+	}
 }
@@ -0,0 +1,140 @@
+// Copyright 2022 Guy Barden
+// hedge.go - calculates the stakes to close or reduce a position on a runner
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hedge
+
+import (
+	"guysports/go-football-trader/pkg/helper"
+	"guysports/go-football-trader/pkg/order"
+)
+
+type (
+	// Bet is a matched back or lay on the runner
+	Bet struct {
+		Side  order.Side
+		Price float32
+		Stake float32
+	}
+
+	// Position holds the profit or loss on a runner's market if the runner wins or loses
+	Position struct {
+		IfWin  float32
+		IfLose float32
+	}
+
+	// Hedge is the bet to place to change a position and the position it leaves. A zero stake means no bet is needed
+	Hedge struct {
+		Side      order.Side
+		Price     float32
+		Stake     float32
+		Position  Position
+		NetIfWin  float32
+		NetIfLose float32
+	}
+)
+
+// NewPosition sums the matched bets on a runner into the profit or loss for each outcome
+func NewPosition(bets []Bet) Position {
+	position := Position{}
+	for _, bet := range bets {
+		position = position.add(bet)
+	}
+	return position
+}
+
+func (p Position) add(bet Bet) Position {
+	switch bet.Side {
+	case order.Back:
+		p.IfWin += bet.Stake * (bet.Price - 1)
+		p.IfLose -= bet.Stake
+	case order.Lay:
+		p.IfWin -= bet.Stake * (bet.Price - 1)
+		p.IfLose += bet.Stake
+	}
+	return p
+}
+
+// GreenUp returns the bet that leaves an equal profit or loss whichever way the runner finishes. A position that
+// gains if the runner wins is closed with a lay at the lay price, otherwise with a back at the back price
+func GreenUp(position Position, backPrice float32, layPrice float32, commission float32) Hedge {
+	return PartialHedge(position, backPrice, layPrice, 1, commission)
+}
+
+// PartialHedge returns a bet of the given fraction of the green up stake, 1 closes the position completely
+func PartialHedge(position Position, backPrice float32, layPrice float32, fraction float32, commission float32) Hedge {
+	side, price, stake := greenStake(position, backPrice, layPrice)
+	return newHedge(position, side, price, stake*fraction, commission)
+}
+
+// StopLoss returns the smallest bet that limits the worst outcome of the position to a loss of maxLoss. If the
+// loss cannot be limited that far the position is greened up, and no bet is needed if it is already within the limit
+func StopLoss(position Position, backPrice float32, layPrice float32, maxLoss float32, commission float32) Hedge {
+	side, price, greenUp := greenStake(position, backPrice, layPrice)
+	stake := float32(0)
+	switch side {
+	case order.Lay:
+		// Laying moves money from the winning outcome to the losing outcome one for one
+		if position.IfLose < -maxLoss {
+			stake = -maxLoss - position.IfLose
+		}
+	case order.Back:
+		// Backing moves money from the losing outcome to the winning outcome one for one
+		if position.IfWin < -maxLoss {
+			stake = (-maxLoss - position.IfWin) / (price - 1)
+		}
+	}
+	if stake > greenUp {
+		stake = greenUp
+	}
+	return newHedge(position, side, price, stake, commission)
+}
+
+// NetOfCommission returns the profit after exchange commission, which is only paid on winnings
+func NetOfCommission(profit float32, commission float32) float32 {
+	if profit > 0 {
+		return profit * (1 - commission)
+	}
+	return profit
+}
+
+// greenStake returns the side, price and stake that equalises the position
+func greenStake(position Position, backPrice float32, layPrice float32) (order.Side, float32, float32) {
+	if position.IfWin >= position.IfLose {
+		if layPrice <= 0 {
+			return order.Lay, layPrice, 0
+		}
+		return order.Lay, layPrice, (position.IfWin - position.IfLose) / layPrice
+	}
+	if backPrice <= 0 {
+		return order.Back, backPrice, 0
+	}
+	return order.Back, backPrice, (position.IfLose - position.IfWin) / backPrice
+}
+
+func newHedge(position Position, side order.Side, price float32, stake float32, commission float32) Hedge {
+	hedge := Hedge{
+		Side:  side,
+		Price: price,
+		Stake: helper.ConvertTo2DP(stake),
+	}
+	hedged := position.add(Bet{Side: side, Price: price, Stake: hedge.Stake})
+	hedge.Position = Position{
+		IfWin:  helper.ConvertTo2DP(hedged.IfWin),
+		IfLose: helper.ConvertTo2DP(hedged.IfLose),
+	}
+	hedge.NetIfWin = helper.ConvertTo2DP(NetOfCommission(hedged.IfWin, commission))
+	hedge.NetIfLose = helper.ConvertTo2DP(NetOfCommission(hedged.IfLose, commission))
+	return hedge
+}
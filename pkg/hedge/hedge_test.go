@@ -0,0 +1,166 @@
+// Copyright 2022 Guy Barden
+// hedge_test.go - tests for the hedging calculations
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hedge
+
+import (
+	"guysports/go-football-trader/pkg/order"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewPosition(t *testing.T) {
+	position := NewPosition([]Bet{
+		{Side: order.Back, Price: 3.0, Stake: 100},
+		{Side: order.Lay, Price: 2.5, Stake: 50},
+	})
+	assert.Equal(t, Position{IfWin: 125, IfLose: -50}, position)
+}
+
+func TestGreenUp(t *testing.T) {
+	tests := []struct {
+		name      string
+		bets      []Bet
+		backPrice float32
+		layPrice  float32
+		want      Hedge
+	}{
+		{
+			name:      "back then lay after the price shortens",
+			bets:      []Bet{{Side: order.Back, Price: 3.0, Stake: 100}},
+			backPrice: 2.48,
+			layPrice:  2.5,
+			want: Hedge{
+				Side:      order.Lay,
+				Price:     2.5,
+				Stake:     120,
+				Position:  Position{IfWin: 20, IfLose: 20},
+				NetIfWin:  19.6,
+				NetIfLose: 19.6,
+			},
+		},
+		{
+			name:      "lay then back after the price drifts",
+			bets:      []Bet{{Side: order.Lay, Price: 2.0, Stake: 100}},
+			backPrice: 2.5,
+			layPrice:  2.52,
+			want: Hedge{
+				Side:      order.Back,
+				Price:     2.5,
+				Stake:     80,
+				Position:  Position{IfWin: 20, IfLose: 20},
+				NetIfWin:  19.6,
+				NetIfLose: 19.6,
+			},
+		},
+		{
+			name:      "back then lay after the price drifts locks in a loss",
+			bets:      []Bet{{Side: order.Back, Price: 2.0, Stake: 100}},
+			backPrice: 3.95,
+			layPrice:  4.0,
+			want: Hedge{
+				Side:      order.Lay,
+				Price:     4.0,
+				Stake:     50,
+				Position:  Position{IfWin: -50, IfLose: -50},
+				NetIfWin:  -50,
+				NetIfLose: -50,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := GreenUp(NewPosition(tt.bets), tt.backPrice, tt.layPrice, 0.02)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestPartialHedge(t *testing.T) {
+	position := NewPosition([]Bet{{Side: order.Back, Price: 3.0, Stake: 100}})
+	got := PartialHedge(position, 2.48, 2.5, 0.5, 0.02)
+	assert.Equal(t, order.Lay, got.Side)
+	assert.Equal(t, float32(60), got.Stake)
+	assert.Equal(t, Position{IfWin: 110, IfLose: -40}, got.Position)
+	assert.Equal(t, float32(107.8), got.NetIfWin)
+}
+
+func TestStopLoss(t *testing.T) {
+	tests := []struct {
+		name         string
+		bets         []Bet
+		backPrice    float32
+		layPrice     float32
+		maxLoss      float32
+		wantSide     order.Side
+		wantStake    float32
+		wantPosition Position
+	}{
+		{
+			name:         "lay enough to limit the loss",
+			bets:         []Bet{{Side: order.Back, Price: 2.0, Stake: 100}},
+			backPrice:    3.95,
+			layPrice:     4.0,
+			maxLoss:      80,
+			wantSide:     order.Lay,
+			wantStake:    20,
+			wantPosition: Position{IfWin: 40, IfLose: -80},
+		},
+		{
+			name:         "green up when the loss cannot be limited further",
+			bets:         []Bet{{Side: order.Back, Price: 2.0, Stake: 100}},
+			backPrice:    3.95,
+			layPrice:     4.0,
+			maxLoss:      20,
+			wantSide:     order.Lay,
+			wantStake:    50,
+			wantPosition: Position{IfWin: -50, IfLose: -50},
+		},
+		{
+			name:         "no bet when already within the limit",
+			bets:         []Bet{{Side: order.Back, Price: 2.0, Stake: 100}},
+			backPrice:    3.95,
+			layPrice:     4.0,
+			maxLoss:      150,
+			wantSide:     order.Lay,
+			wantStake:    0,
+			wantPosition: Position{IfWin: 100, IfLose: -100},
+		},
+		{
+			name:         "back enough to limit the loss on a lay",
+			bets:         []Bet{{Side: order.Lay, Price: 2.0, Stake: 100}},
+			backPrice:    1.5,
+			layPrice:     1.52,
+			maxLoss:      50,
+			wantSide:     order.Back,
+			wantStake:    100,
+			wantPosition: Position{IfWin: -50, IfLose: 0},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := StopLoss(NewPosition(tt.bets), tt.backPrice, tt.layPrice, tt.maxLoss, 0.02)
+			assert.Equal(t, tt.wantSide, got.Side)
+			assert.Equal(t, tt.wantStake, got.Stake)
+			assert.Equal(t, tt.wantPosition, got.Position)
+		})
+	}
+}
+
+func TestNetOfCommission(t *testing.T) {
+	assert.Equal(t, float32(98), NetOfCommission(100, 0.02))
+	assert.Equal(t, float32(-100), NetOfCommission(-100, 0.02))
+}
@@ -0,0 +1,355 @@
+// Copyright 2022 Guy Barden
+// importer.go - builds stored fixtures from the Betfair historical data files
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package historic
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/bzip2"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"guysports/go-football-trader/pkg/store"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+type (
+	// Importer replays the market change messages of match odds markets, sampling the best prices of each runner
+	// at the interval until the market goes in play and recording the result when it closes
+	Importer struct {
+		Interval time.Duration
+		markets  map[string]*marketState
+	}
+
+	marketState struct {
+		definition *MarketDefinition
+		runners    map[int]*runnerState
+		samples    map[int][]store.Price
+		nextSample time.Time
+		source     string
+	}
+
+	// runnerState holds a runner's ladders keyed by price, or by level for the best available ladders
+	runnerState struct {
+		back            map[float32]float32
+		lay             map[float32]float32
+		bestBack        map[float32][2]float32
+		bestLay         map[float32][2]float32
+		lastPriceTraded float32
+		totalMatched    float32
+	}
+)
+
+const (
+	// DefaultInterval samples the prices once a minute
+	DefaultInterval = time.Minute
+)
+
+// NewImporter creates an importer sampling at the interval, an interval of zero samples every message
+func NewImporter(interval time.Duration) *Importer {
+	return &Importer{
+		Interval: interval,
+		markets:  map[string]*marketState{},
+	}
+}
+
+// ImportPath reads a historical data file, or every file in a directory. Files may be bz2 or gzip compressed, and
+// tar archives are read entry by entry
+func (i *Importer) ImportPath(path string) error {
+	return filepath.Walk(path, func(file string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if strings.HasSuffix(file, ".tar") {
+			return i.importTar(f, file)
+		}
+		return i.Import(decompress(f, file), file)
+	})
+}
+
+func (i *Importer) importTar(r io.Reader, path string) error {
+	archive := tar.NewReader(r)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		name := fmt.Sprintf("%s:%s", path, header.Name)
+		if err := i.Import(decompress(archive, header.Name), name); err != nil {
+			return err
+		}
+	}
+}
+
+// decompress reads the file through bzip2 or gzip by its extension
+func decompress(r io.Reader, name string) io.Reader {
+	switch {
+	case strings.HasSuffix(name, ".bz2"):
+		return bzip2.NewReader(r)
+	case strings.HasSuffix(name, ".gz"):
+		reader, err := gzip.NewReader(r)
+		if err != nil {
+			return errorReader{err}
+		}
+		return reader
+	}
+	return r
+}
+
+type errorReader struct {
+	err error
+}
+
+func (e errorReader) Read([]byte) (int, error) {
+	return 0, e.err
+}
+
+// Import reads the newline delimited market change messages, source names them in errors
+func (i *Importer) Import(r io.Reader, source string) error {
+	scanner := bufio.NewScanner(r)
+	// Market images of busy markets run to megabytes
+	scanner.Buffer(make([]byte, 1<<20), 64<<20)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		message := MarketChangeMessage{}
+		if err := json.Unmarshal(scanner.Bytes(), &message); err != nil {
+			return fmt.Errorf("unable to read line %d of %s: %s", line, source, err.Error())
+		}
+		if message.Op == MarketChangeOp {
+			i.apply(&message, source)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("unable to read %s: %s", source, err.Error())
+	}
+	return nil
+}
+
+func (i *Importer) apply(message *MarketChangeMessage, source string) {
+	published := time.Unix(0, message.PublishTime*int64(time.Millisecond)).UTC()
+	for c := range message.MarketChanges {
+		change := &message.MarketChanges[c]
+		market, ok := i.markets[change.Id]
+		if !ok {
+			market = &marketState{runners: map[int]*runnerState{}, samples: map[int][]store.Price{}, source: source}
+			i.markets[change.Id] = market
+		}
+		if change.MarketDefinition != nil {
+			market.definition = change.MarketDefinition
+		}
+		if market.definition != nil && market.definition.MarketType != MatchOddsMarket {
+			// Other markets are dropped as soon as they are known not to be match odds
+			market.runners = map[int]*runnerState{}
+			continue
+		}
+		if change.Image {
+			market.runners = map[int]*runnerState{}
+		}
+		for r := range change.RunnerChanges {
+			market.runner(change.RunnerChanges[r].Id).apply(&change.RunnerChanges[r])
+		}
+		if market.definition == nil || market.definition.InPlay || market.definition.Status != marketOpen {
+			continue
+		}
+		if !published.Before(market.nextSample) {
+			market.sample(published)
+			market.nextSample = published.Add(i.Interval)
+			if i.Interval > 0 {
+				market.nextSample = published.Truncate(i.Interval).Add(i.Interval)
+			}
+		}
+	}
+}
+
+func (m *marketState) runner(selectionId int) *runnerState {
+	runner, ok := m.runners[selectionId]
+	if !ok {
+		runner = &runnerState{
+			back:     map[float32]float32{},
+			lay:      map[float32]float32{},
+			bestBack: map[float32][2]float32{},
+			bestLay:  map[float32][2]float32{},
+		}
+		m.runners[selectionId] = runner
+	}
+	return runner
+}
+
+// sample records the best prices of every runner in the definition
+func (m *marketState) sample(published time.Time) {
+	for _, definition := range m.definition.Runners {
+		runner, ok := m.runners[definition.Id]
+		if !ok {
+			continue
+		}
+		price := runner.price()
+		price.Timestamp = published.Format(time.RFC3339)
+		m.samples[definition.Id] = append(m.samples[definition.Id], price)
+	}
+}
+
+func (r *runnerState) apply(change *RunnerChange) {
+	for _, level := range change.AvailableToBack {
+		updateLadder(r.back, level)
+	}
+	for _, level := range change.AvailableToLay {
+		updateLadder(r.lay, level)
+	}
+	for _, level := range change.BestAvailableToBack {
+		updateLevels(r.bestBack, level)
+	}
+	for _, level := range change.BestAvailableToLay {
+		updateLevels(r.bestLay, level)
+	}
+	if change.LastTradedPrice > 0 {
+		r.lastPriceTraded = change.LastTradedPrice
+	}
+	if change.TradedVolume > 0 {
+		r.totalMatched = change.TradedVolume
+	}
+}
+
+func updateLadder(ladder map[float32]float32, level []float32) {
+	if len(level) < 2 {
+		return
+	}
+	if level[1] == 0 {
+		delete(ladder, level[0])
+		return
+	}
+	ladder[level[0]] = level[1]
+}
+
+func updateLevels(levels map[float32][2]float32, level []float32) {
+	if len(level) < 3 {
+		return
+	}
+	if level[2] == 0 {
+		delete(levels, level[0])
+		return
+	}
+	levels[level[0]] = [2]float32{level[1], level[2]}
+}
+
+// price returns the best back and lay from the full ladders, or the best available ladders if there are none
+func (r *runnerState) price() store.Price {
+	price := store.Price{LastPriceTraded: r.lastPriceTraded, TotalMatched: r.totalMatched}
+	back, lay := r.back, r.lay
+	if len(back) == 0 && len(lay) == 0 {
+		back, lay = bestLadder(r.bestBack), bestLadder(r.bestLay)
+	}
+	for odds, size := range back {
+		if odds > price.BackPrice {
+			price.BackPrice, price.BackAmount = odds, size
+		}
+	}
+	for odds, size := range lay {
+		if price.LayPrice == 0 || odds < price.LayPrice {
+			price.LayPrice, price.LayAmount = odds, size
+		}
+	}
+	return price
+}
+
+func bestLadder(levels map[float32][2]float32) map[float32]float32 {
+	ladder := map[float32]float32{}
+	for _, level := range levels {
+		ladder[level[0]] = level[1]
+	}
+	return ladder
+}
+
+// Fixtures returns the imported match odds markets as fixtures keyed by event id. The first two runners by sort
+// priority are the home and away teams
+func (i *Importer) Fixtures() map[string]store.FixturePrices {
+	fixtures := map[string]store.FixturePrices{}
+	for marketId, market := range i.markets {
+		if market.definition == nil || market.definition.MarketType != MatchOddsMarket {
+			continue
+		}
+		definition := market.definition
+		runners := append([]RunnerDefinition{}, definition.Runners...)
+		sort.SliceStable(runners, func(a, b int) bool { return runners[a].SortPriority < runners[b].SortPriority })
+		fixture := store.FixturePrices{
+			Fixture:      definition.EventName,
+			Date:         normaliseTime(definition.MarketTime),
+			MatchStatus:  store.Scheduled,
+			EventID:      definition.EventId,
+			MarketID:     marketId,
+			PriceHistory: market.samples,
+			Admission:    &store.Admission{Status: store.Admitted, Reason: fmt.Sprintf("imported from %s", market.source), Timestamp: time.Now().Format(time.RFC3339)},
+		}
+		teams := []int{}
+		for _, runner := range runners {
+			if runner.Name == store.DrawRunnerName {
+				fixture.DrawRunnerId = runner.Id
+			} else {
+				teams = append(teams, runner.Id)
+			}
+		}
+		if len(teams) != 2 {
+			continue
+		}
+		fixture.HomeRunnerId, fixture.AwayRunnerId = teams[0], teams[1]
+		if definition.Status == marketClosed {
+			for _, runner := range runners {
+				if runner.Status != runnerWinner {
+					continue
+				}
+				fixture.MatchStatus = store.Played
+				switch runner.Id {
+				case fixture.HomeRunnerId:
+					fixture.OutCome = store.HomeWin
+				case fixture.AwayRunnerId:
+					fixture.OutCome = store.AwayWin
+				default:
+					fixture.OutCome = store.Draw
+				}
+			}
+		}
+		fixtures[definition.EventId] = fixture
+	}
+	return fixtures
+}
+
+// normaliseTime writes the stream's millisecond times as RFC3339 like the times recorded by the tracker
+func normaliseTime(value string) string {
+	parsed, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return value
+	}
+	return parsed.UTC().Format(time.RFC3339)
+}
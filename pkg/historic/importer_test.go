@@ -0,0 +1,99 @@
+// Copyright 2022 Guy Barden
+// importer_test.go - tests for importing Betfair historical data files
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package historic
+
+import (
+	"guysports/go-football-trader/pkg/store"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImport(t *testing.T) {
+	tests := []struct {
+		name        string
+		interval    time.Duration
+		wantHistory map[int][]store.Price
+	}{
+		{
+			name:     "sampled each minute until in play",
+			interval: time.Minute,
+			wantHistory: map[int][]store.Price{
+				1: {
+					{Timestamp: "2022-04-09T12:00:00Z", BackPrice: 3.65, BackAmount: 20, LayPrice: 3.7, LayAmount: 15, LastPriceTraded: 3.65, TotalMatched: 100},
+					{Timestamp: "2022-04-09T12:01:00Z", BackPrice: 3.6, BackAmount: 10, LayPrice: 3.7, LayAmount: 15, LastPriceTraded: 3.65, TotalMatched: 100},
+				},
+				2: {
+					{Timestamp: "2022-04-09T12:00:00Z", BackPrice: 2.1, BackAmount: 50, LayPrice: 2.12, LayAmount: 40},
+					{Timestamp: "2022-04-09T12:01:00Z", BackPrice: 2.1, BackAmount: 50, LayPrice: 2.12, LayAmount: 40},
+				},
+				58805: {
+					{Timestamp: "2022-04-09T12:00:00Z", BackPrice: 3.9, BackAmount: 5, LayPrice: 4, LayAmount: 6},
+					{Timestamp: "2022-04-09T12:01:00Z", BackPrice: 3.9, BackAmount: 5, LayPrice: 4, LayAmount: 6},
+				},
+			},
+		},
+		{
+			name:     "sampled on every message",
+			interval: 0,
+			wantHistory: map[int][]store.Price{
+				1: {
+					{Timestamp: "2022-04-09T12:00:00Z", BackPrice: 3.65, BackAmount: 20, LayPrice: 3.7, LayAmount: 15, LastPriceTraded: 3.65, TotalMatched: 100},
+					{Timestamp: "2022-04-09T12:00:30Z", BackPrice: 3.6, BackAmount: 10, LayPrice: 3.7, LayAmount: 15, LastPriceTraded: 3.65, TotalMatched: 100},
+					{Timestamp: "2022-04-09T12:01:00Z", BackPrice: 3.6, BackAmount: 10, LayPrice: 3.7, LayAmount: 15, LastPriceTraded: 3.65, TotalMatched: 100},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file, err := os.Open("testdata/match_odds.json")
+			assert.NoError(t, err)
+			defer file.Close()
+
+			importer := NewImporter(tt.interval)
+			assert.NoError(t, importer.Import(file, "match_odds.json"))
+			fixtures := importer.Fixtures()
+			assert.Len(t, fixtures, 1)
+
+			fixture := fixtures["31317592"]
+			assert.Equal(t, "Mainz v Dortmund", fixture.Fixture)
+			assert.Equal(t, "2022-04-09T13:30:00Z", fixture.Date)
+			assert.Equal(t, "1.200", fixture.MarketID)
+			assert.Equal(t, []int{1, 2, 58805}, []int{fixture.HomeRunnerId, fixture.AwayRunnerId, fixture.DrawRunnerId})
+			assert.Equal(t, store.Played, fixture.MatchStatus)
+			assert.Equal(t, store.AwayWin, fixture.OutCome)
+			assert.Equal(t, store.Admitted, fixture.Admission.Status)
+			for selectionId, history := range tt.wantHistory {
+				assert.Equal(t, history, fixture.PriceHistory[selectionId], "runner %d", selectionId)
+			}
+		})
+	}
+}
+
+func TestImportPath(t *testing.T) {
+	plain := NewImporter(DefaultInterval)
+	assert.NoError(t, plain.ImportPath("testdata/match_odds.json"))
+	compressed := NewImporter(DefaultInterval)
+	assert.NoError(t, compressed.ImportPath("testdata/match_odds.json.bz2"))
+	assert.Equal(t, plain.Fixtures()["31317592"].PriceHistory, compressed.Fixtures()["31317592"].PriceHistory)
+
+	err := NewImporter(DefaultInterval).Import(strings.NewReader("{\"op\":\"mcm\"}\nnot json\n"), "bad.json")
+	assert.EqualError(t, err, "unable to read line 2 of bad.json: invalid character 'o' in literal null (expecting 'u')")
+}
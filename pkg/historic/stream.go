@@ -0,0 +1,74 @@
+// Copyright 2022 Guy Barden
+// stream.go - the market change messages of the Betfair historical data files
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package historic
+
+type (
+	// MarketChangeMessage is one line of a historical data file, pt is the publish time in epoch milliseconds
+	MarketChangeMessage struct {
+		Op            string         `json:"op"`
+		PublishTime   int64          `json:"pt"`
+		MarketChanges []MarketChange `json:"mc"`
+	}
+
+	// MarketChange updates one market, a full image replaces everything known about its runners
+	MarketChange struct {
+		Id               string            `json:"id"`
+		Image            bool              `json:"img"`
+		MarketDefinition *MarketDefinition `json:"marketDefinition"`
+		RunnerChanges    []RunnerChange    `json:"rc"`
+	}
+
+	MarketDefinition struct {
+		EventId    string             `json:"eventId"`
+		EventName  string             `json:"eventName"`
+		MarketType string             `json:"marketType"`
+		MarketTime string             `json:"marketTime"`
+		Status     string             `json:"status"`
+		InPlay     bool               `json:"inPlay"`
+		Runners    []RunnerDefinition `json:"runners"`
+	}
+
+	RunnerDefinition struct {
+		Id           int    `json:"id"`
+		Name         string `json:"name"`
+		Status       string `json:"status"`
+		SortPriority int    `json:"sortPriority"`
+	}
+
+	// RunnerChange updates a runner's ladders. Full depth ladders are [price, size] pairs and best available ladders
+	// are [level, price, size] triples, a size of zero removes the price or level
+	RunnerChange struct {
+		Id                  int         `json:"id"`
+		LastTradedPrice     float32     `json:"ltp"`
+		TradedVolume        float32     `json:"tv"`
+		AvailableToBack     [][]float32 `json:"atb"`
+		AvailableToLay      [][]float32 `json:"atl"`
+		BestAvailableToBack [][]float32 `json:"batb"`
+		BestAvailableToLay  [][]float32 `json:"batl"`
+		Traded              [][]float32 `json:"trd"`
+	}
+)
+
+const (
+	// MarketChangeOp is the op of messages carrying market changes
+	MarketChangeOp = "mcm"
+	// MatchOddsMarket is the market type imported
+	MatchOddsMarket = "MATCH_ODDS"
+
+	marketOpen   = "OPEN"
+	marketClosed = "CLOSED"
+	runnerWinner = "WINNER"
+)
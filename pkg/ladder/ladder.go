@@ -0,0 +1,175 @@
+// Copyright 2022 Guy Barden
+// ladder.go - the Betfair price ladder of the 350 valid prices from 1.01 to 1000
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ladder
+
+import (
+	"math"
+	"sort"
+)
+
+type (
+	// Direction chooses which valid price a price off the ladder is rounded to
+	Direction string
+
+	// band is a range of the ladder with the same tick size, in hundredths. Each band starts above the end of the
+	// previous band
+	band struct {
+		low  int
+		high int
+		tick int
+	}
+)
+
+const (
+	Up      = Direction("up")
+	Down    = Direction("down")
+	Nearest = Direction("nearest")
+
+	MinPrice = 1.01
+	MaxPrice = 1000
+)
+
+var (
+	bands = []band{
+		{low: 100, high: 200, tick: 1},
+		{low: 200, high: 300, tick: 2},
+		{low: 300, high: 400, tick: 5},
+		{low: 400, high: 600, tick: 10},
+		{low: 600, high: 1000, tick: 20},
+		{low: 1000, high: 2000, tick: 50},
+		{low: 2000, high: 3000, tick: 100},
+		{low: 3000, high: 5000, tick: 200},
+		{low: 5000, high: 10000, tick: 500},
+		{low: 10000, high: 100000, tick: 1000},
+	}
+
+	// ticks holds every price on the ladder in hundredths, in ascending order
+	ticks = buildTicks()
+)
+
+func buildTicks() []int {
+	prices := []int{}
+	for _, b := range bands {
+		for price := b.low + b.tick; price <= b.high; price += b.tick {
+			prices = append(prices, price)
+		}
+	}
+	return prices
+}
+
+// hundredths converts a price to whole hundredths to avoid float32 rounding noise
+func hundredths(price float32) int {
+	return int(math.Round(float64(price) * 100))
+}
+
+func price(hundredths int) float32 {
+	return float32(hundredths) / 100
+}
+
+// Prices returns every price on the ladder in ascending order
+func Prices() []float32 {
+	prices := make([]float32, len(ticks))
+	for i, tick := range ticks {
+		prices[i] = price(tick)
+	}
+	return prices
+}
+
+// index returns the position of the price on the ladder and whether the price is on it. A price off the ladder
+// returns the position of the next valid price above it
+func index(p float32) (int, bool) {
+	h := hundredths(p)
+	i := sort.SearchInts(ticks, h)
+	return i, i < len(ticks) && ticks[i] == h
+}
+
+// IsValid reports whether the price is one of the prices on the ladder
+func IsValid(p float32) bool {
+	_, ok := index(p)
+	return ok
+}
+
+// RoundToValid returns the valid price nearest the price in the direction, prices beyond the ends of the ladder
+// are returned as the end price. A price halfway between two valid prices rounds up when the direction is Nearest
+func RoundToValid(p float32, direction Direction) float32 {
+	i, ok := index(p)
+	switch {
+	case ok:
+		return price(ticks[i])
+	case i == 0:
+		return MinPrice
+	case i == len(ticks):
+		return MaxPrice
+	}
+	switch direction {
+	case Up:
+		return price(ticks[i])
+	case Down:
+		return price(ticks[i-1])
+	}
+	if ticks[i]-hundredths(p) <= hundredths(p)-ticks[i-1] {
+		return price(ticks[i])
+	}
+	return price(ticks[i-1])
+}
+
+// TickUp returns the price n ticks above the price, stopping at the top of the ladder. A price off the ladder is
+// first rounded down, so one tick up is the next valid price above it
+func TickUp(p float32, n int) float32 {
+	i, _ := index(RoundToValid(p, Down))
+	i += n
+	if i >= len(ticks) {
+		i = len(ticks) - 1
+	}
+	if i < 0 {
+		i = 0
+	}
+	return price(ticks[i])
+}
+
+// TickDown returns the price n ticks below the price, stopping at the bottom of the ladder. A price off the ladder
+// is first rounded up, so one tick down is the next valid price below it
+func TickDown(p float32, n int) float32 {
+	i, _ := index(RoundToValid(p, Up))
+	i -= n
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(ticks) {
+		i = len(ticks) - 1
+	}
+	return price(ticks[i])
+}
+
+// TicksBetween returns the number of ticks from a up to b, negative if b is below a. Prices off the ladder are
+// rounded to the nearest valid price first
+func TicksBetween(a float32, b float32) int {
+	from, _ := index(RoundToValid(a, Nearest))
+	to, _ := index(RoundToValid(b, Nearest))
+	return to - from
+}
+
+// TickSize returns the increment from the price to the next price above it, or the largest increment at the top
+// of the ladder. Prices below the ladder have the smallest increment
+func TickSize(p float32) float32 {
+	h := hundredths(p)
+	for _, b := range bands {
+		if h < b.high {
+			return price(b.tick)
+		}
+	}
+	return price(bands[len(bands)-1].tick)
+}
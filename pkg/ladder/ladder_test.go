@@ -0,0 +1,217 @@
+// Copyright 2022 Guy Barden
+// ladder_test.go - tests for the Betfair price ladder
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ladder
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTickSize(t *testing.T) {
+	type args struct {
+		price float32
+	}
+	tests := []struct {
+		name     string
+		args     args
+		wantTick float32
+	}{
+		{
+			name: "price is between 1 and 2",
+			args: args{
+				price: 1.3,
+			},
+			wantTick: 0.01,
+		},
+		{
+			name: "price is between 2 and 3",
+			args: args{
+				price: 2.99,
+			},
+			wantTick: 0.02,
+		},
+		{
+			name: "price is between 3 and 4",
+			args: args{
+				price: 3.01,
+			},
+			wantTick: 0.05,
+		},
+		{
+			name: "price is between 4 and 6",
+			args: args{
+				price: 5.51,
+			},
+			wantTick: 0.1,
+		},
+		{
+			name: "price is between 6 and 10",
+			args: args{
+				price: 9.99,
+			},
+			wantTick: 0.2,
+		},
+		{
+			name: "price is between 10 and 20",
+			args: args{
+				price: 11,
+			},
+			wantTick: 0.5,
+		},
+		{
+			name: "price is between 20 and 30",
+			args: args{
+				price: 29.99,
+			},
+			wantTick: 1,
+		},
+		{
+			name: "price is between 30 and 50",
+			args: args{
+				price: 30.01,
+			},
+			wantTick: 2,
+		},
+		{
+			name: "price is greater than 50",
+			args: args{
+				price: 100,
+			},
+			wantTick: 10,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotTick := TickSize(tt.args.price)
+			assert.Equal(t, tt.wantTick, gotTick)
+		})
+	}
+}
+
+func TestIsValid(t *testing.T) {
+	tests := []struct {
+		price float32
+		want  bool
+	}{
+		{price: 1.01, want: true},
+		{price: 1, want: false},
+		{price: 1.99, want: true},
+		{price: 2.02, want: true},
+		{price: 2.03, want: false},
+		{price: 3.7, want: true},
+		{price: 3.72, want: false},
+		{price: 5.1, want: true},
+		{price: 9.8, want: true},
+		{price: 9.9, want: false},
+		{price: 15.5, want: true},
+		{price: 32, want: true},
+		{price: 33, want: false},
+		{price: 55, want: true},
+		{price: 110, want: true},
+		{price: 1000, want: true},
+		{price: 1010, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%.2f", tt.price), func(t *testing.T) {
+			assert.Equal(t, tt.want, IsValid(tt.price))
+		})
+	}
+}
+
+func TestPrices(t *testing.T) {
+	prices := Prices()
+	assert.Len(t, prices, 350)
+	assert.Equal(t, float32(MinPrice), prices[0])
+	assert.Equal(t, float32(MaxPrice), prices[len(prices)-1])
+	for i, price := range prices {
+		assert.True(t, IsValid(price), "%.2f", price)
+		if i > 0 {
+			assert.Equal(t, 1, TicksBetween(prices[i-1], price))
+		}
+	}
+}
+
+func TestRoundToValid(t *testing.T) {
+	tests := []struct {
+		price     float32
+		direction Direction
+		want      float32
+	}{
+		{price: 2.02, direction: Nearest, want: 2.02},
+		{price: 2.03, direction: Up, want: 2.04},
+		{price: 2.03, direction: Down, want: 2.02},
+		{price: 2.03, direction: Nearest, want: 2.04},
+		{price: 3.72, direction: Nearest, want: 3.7},
+		{price: 3.73, direction: Nearest, want: 3.75},
+		{price: 4.05, direction: Down, want: 4},
+		{price: 120, direction: Down, want: 120},
+		{price: 125, direction: Up, want: 130},
+		{price: 1, direction: Down, want: 1.01},
+		{price: 0, direction: Nearest, want: 1.01},
+		{price: 1500, direction: Up, want: 1000},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%.2f %s", tt.price, tt.direction), func(t *testing.T) {
+			assert.Equal(t, tt.want, RoundToValid(tt.price, tt.direction))
+		})
+	}
+}
+
+func TestTickUpAndDown(t *testing.T) {
+	tests := []struct {
+		name     string
+		price    float32
+		n        int
+		wantUp   float32
+		wantDown float32
+	}{
+		{name: "inside a band", price: 1.5, n: 2, wantUp: 1.52, wantDown: 1.48},
+		{name: "across a band", price: 2, n: 2, wantUp: 2.04, wantDown: 1.98},
+		{name: "across several bands", price: 3.95, n: 3, wantUp: 4.2, wantDown: 3.8},
+		{name: "off the ladder", price: 2.03, n: 1, wantUp: 2.04, wantDown: 2.02},
+		{name: "hundreds", price: 100, n: 1, wantUp: 110, wantDown: 95},
+		{name: "zero ticks", price: 5.1, n: 0, wantUp: 5.1, wantDown: 5.1},
+		{name: "top of the ladder", price: 990, n: 5, wantUp: 1000, wantDown: 940},
+		{name: "bottom of the ladder", price: 1.02, n: 5, wantUp: 1.07, wantDown: 1.01},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.wantUp, TickUp(tt.price, tt.n))
+			assert.Equal(t, tt.wantDown, TickDown(tt.price, tt.n))
+		})
+	}
+}
+
+func TestTicksBetween(t *testing.T) {
+	tests := []struct {
+		a    float32
+		b    float32
+		want int
+	}{
+		{a: 2, b: 2, want: 0},
+		{a: 1.98, b: 2.02, want: 3},
+		{a: 2.02, b: 1.98, want: -3},
+		{a: 1.01, b: 1000, want: 349},
+		{a: 3.7, b: 3.75, want: 1},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%.2f to %.2f", tt.a, tt.b), func(t *testing.T) {
+			assert.Equal(t, tt.want, TicksBetween(tt.a, tt.b))
+		})
+	}
+}
@@ -0,0 +1,321 @@
+// Copyright 2022 Guy Barden
+// order.go - places, cancels, replaces and lists orders on the Betfair Exchange
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package order
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/guysports/go-betfair-api/pkg/betting"
+	"github.com/guysports/go-betfair-api/pkg/transport"
+	"github.com/guysports/go-betfair-api/pkg/types"
+)
+
+type (
+	Side string
+
+	// Orders places and manages bets on the exchange
+	Orders interface {
+		PlaceOrders(marketId string, instructions []PlaceInstruction, customerRef string) (*PlaceExecutionReport, error)
+		CancelOrders(marketId string, instructions []CancelInstruction) (*CancelExecutionReport, error)
+		ReplaceOrders(marketId string, instructions []ReplaceInstruction) (*ReplaceExecutionReport, error)
+		ListCurrentOrders(marketIds []string) ([]CurrentOrder, error)
+	}
+
+	// BettingOrders sends orders through the transport of an authenticated betting client
+	BettingOrders struct {
+		API *betting.API
+	}
+
+	LimitOrder struct {
+		Size            float32 `json:"size"`
+		Price           float32 `json:"price"`
+		PersistenceType string  `json:"persistenceType"`
+	}
+
+	PlaceInstruction struct {
+		OrderType        string      `json:"orderType"`
+		SelectionId      int         `json:"selectionId"`
+		Handicap         float32     `json:"handicap"`
+		Side             Side        `json:"side"`
+		LimitOrder       *LimitOrder `json:"limitOrder,omitempty"`
+		CustomerOrderRef string      `json:"customerOrderRef,omitempty"`
+	}
+
+	PlaceInstructionReport struct {
+		Status              string           `json:"status"`
+		ErrorCode           string           `json:"errorCode,omitempty"`
+		OrderStatus         string           `json:"orderStatus,omitempty"`
+		Instruction         PlaceInstruction `json:"instruction"`
+		BetId               string           `json:"betId,omitempty"`
+		PlacedDate          string           `json:"placedDate,omitempty"`
+		AveragePriceMatched float32          `json:"averagePriceMatched,omitempty"`
+		SizeMatched         float32          `json:"sizeMatched,omitempty"`
+	}
+
+	PlaceExecutionReport struct {
+		CustomerRef        string                   `json:"customerRef,omitempty"`
+		Status             string                   `json:"status"`
+		ErrorCode          string                   `json:"errorCode,omitempty"`
+		MarketId           string                   `json:"marketId"`
+		InstructionReports []PlaceInstructionReport `json:"instructionReports"`
+	}
+
+	// CancelInstruction cancels the bet, or reduces it by the size reduction when set
+	CancelInstruction struct {
+		BetId         string  `json:"betId"`
+		SizeReduction float32 `json:"sizeReduction,omitempty"`
+	}
+
+	CancelInstructionReport struct {
+		Status        string            `json:"status"`
+		ErrorCode     string            `json:"errorCode,omitempty"`
+		Instruction   CancelInstruction `json:"instruction"`
+		SizeCancelled float32           `json:"sizeCancelled"`
+		CancelledDate string            `json:"cancelledDate,omitempty"`
+	}
+
+	CancelExecutionReport struct {
+		CustomerRef        string                    `json:"customerRef,omitempty"`
+		Status             string                    `json:"status"`
+		ErrorCode          string                    `json:"errorCode,omitempty"`
+		MarketId           string                    `json:"marketId"`
+		InstructionReports []CancelInstructionReport `json:"instructionReports"`
+	}
+
+	// ReplaceInstruction cancels the unmatched part of a bet and places it again at the new price
+	ReplaceInstruction struct {
+		BetId    string  `json:"betId"`
+		NewPrice float32 `json:"newPrice"`
+	}
+
+	ReplaceInstructionReport struct {
+		Status                  string                   `json:"status"`
+		ErrorCode               string                   `json:"errorCode,omitempty"`
+		CancelInstructionReport *CancelInstructionReport `json:"cancelInstructionReport,omitempty"`
+		PlaceInstructionReport  *PlaceInstructionReport  `json:"placeInstructionReport,omitempty"`
+	}
+
+	ReplaceExecutionReport struct {
+		CustomerRef        string                     `json:"customerRef,omitempty"`
+		Status             string                     `json:"status"`
+		ErrorCode          string                     `json:"errorCode,omitempty"`
+		MarketId           string                     `json:"marketId"`
+		InstructionReports []ReplaceInstructionReport `json:"instructionReports"`
+	}
+
+	PriceSize struct {
+		Price float32 `json:"price"`
+		Size  float32 `json:"size"`
+	}
+
+	CurrentOrder struct {
+		BetId               string    `json:"betId"`
+		MarketId            string    `json:"marketId"`
+		SelectionId         int       `json:"selectionId"`
+		Handicap            float32   `json:"handicap"`
+		PriceSize           PriceSize `json:"priceSize"`
+		Side                Side      `json:"side"`
+		Status              string    `json:"status"`
+		PersistenceType     string    `json:"persistenceType"`
+		OrderType           string    `json:"orderType"`
+		PlacedDate          string    `json:"placedDate"`
+		MatchedDate         string    `json:"matchedDate,omitempty"`
+		AveragePriceMatched float32   `json:"averagePriceMatched"`
+		SizeMatched         float32   `json:"sizeMatched"`
+		SizeRemaining       float32   `json:"sizeRemaining"`
+		SizeLapsed          float32   `json:"sizeLapsed"`
+		SizeCancelled       float32   `json:"sizeCancelled"`
+		SizeVoided          float32   `json:"sizeVoided"`
+		CustomerOrderRef    string    `json:"customerOrderRef,omitempty"`
+	}
+
+	currentOrderSummaryReport struct {
+		CurrentOrders []CurrentOrder `json:"currentOrders"`
+		MoreAvailable bool           `json:"moreAvailable"`
+	}
+
+	rpcRequest struct {
+		JsonRPC string      `json:"jsonrpc"`
+		Method  string      `json:"method"`
+		Params  interface{} `json:"params"`
+		ID      int         `json:"id"`
+	}
+
+	rpcResponse struct {
+		Result json.RawMessage  `json:"result"`
+		Error  *types.JsonError `json:"error,omitempty"`
+	}
+)
+
+const (
+	Back = Side("BACK")
+	Lay  = Side("LAY")
+
+	// Betfair order and report values used by the trader
+	LimitOrderType   = "LIMIT"
+	PersistenceLapse = "LAPSE"
+	ReportSuccess    = "SUCCESS"
+)
+
+var (
+	// bettingURL is the Betfair JSON-RPC endpoint, a variable so tests can point at a local server
+	bettingURL = "https://api.betfair.com/exchange/betting/json-rpc/v1"
+)
+
+// NewBettingOrders creates an order client from an authenticated betting client
+func NewBettingOrders(api *betting.API) *BettingOrders {
+	return &BettingOrders{
+		API: api,
+	}
+}
+
+// NewLimitInstruction builds an instruction to back or lay a runner at a price, lapsing any unmatched part in play
+func NewLimitInstruction(selectionId int, side Side, price float32, stake float32) PlaceInstruction {
+	return PlaceInstruction{
+		OrderType:   LimitOrderType,
+		SelectionId: selectionId,
+		Side:        side,
+		LimitOrder: &LimitOrder{
+			Size:            stake,
+			Price:           price,
+			PersistenceType: PersistenceLapse,
+		},
+	}
+}
+
+// PlaceOrders places new orders on a market
+func (b *BettingOrders) PlaceOrders(marketId string, instructions []PlaceInstruction, customerRef string) (*PlaceExecutionReport, error) {
+	params := map[string]interface{}{
+		"marketId":     marketId,
+		"instructions": instructions,
+	}
+	if customerRef != "" {
+		params["customerRef"] = customerRef
+	}
+	report := PlaceExecutionReport{}
+	if err := b.call("placeOrders", params, &report); err != nil {
+		return nil, err
+	}
+	return &report, reportError(report.Status, report.ErrorCode)
+}
+
+// CancelOrders cancels or reduces unmatched orders on a market
+func (b *BettingOrders) CancelOrders(marketId string, instructions []CancelInstruction) (*CancelExecutionReport, error) {
+	params := map[string]interface{}{
+		"marketId":     marketId,
+		"instructions": instructions,
+	}
+	report := CancelExecutionReport{}
+	if err := b.call("cancelOrders", params, &report); err != nil {
+		return nil, err
+	}
+	return &report, reportError(report.Status, report.ErrorCode)
+}
+
+// ReplaceOrders moves unmatched orders on a market to new prices
+func (b *BettingOrders) ReplaceOrders(marketId string, instructions []ReplaceInstruction) (*ReplaceExecutionReport, error) {
+	params := map[string]interface{}{
+		"marketId":     marketId,
+		"instructions": instructions,
+	}
+	report := ReplaceExecutionReport{}
+	if err := b.call("replaceOrders", params, &report); err != nil {
+		return nil, err
+	}
+	return &report, reportError(report.Status, report.ErrorCode)
+}
+
+// ListCurrentOrders lists the orders that have not been settled on the given markets, or all markets if none are given
+func (b *BettingOrders) ListCurrentOrders(marketIds []string) ([]CurrentOrder, error) {
+	orders := []CurrentOrder{}
+	for fromRecord := 0; ; {
+		params := map[string]interface{}{
+			"fromRecord": fromRecord,
+		}
+		if len(marketIds) > 0 {
+			params["marketIds"] = marketIds
+		}
+		report := currentOrderSummaryReport{}
+		if err := b.call("listCurrentOrders", params, &report); err != nil {
+			return nil, err
+		}
+		orders = append(orders, report.CurrentOrders...)
+		if !report.MoreAvailable || len(report.CurrentOrders) == 0 {
+			return orders, nil
+		}
+		fromRecord += len(report.CurrentOrders)
+	}
+}
+
+// call sends a JSON-RPC request using the session and TLS configuration of the betting client's transport.
+// The betting client cannot pass bet ids, so the request is built here. Requests are not retried, a
+// retried placement could open a position twice
+func (b *BettingOrders) call(method string, params interface{}, result interface{}) error {
+	client, ok := b.API.Client.(*transport.JsonRPCClient)
+	if !ok {
+		return fmt.Errorf("betting client transport does not support %s", method)
+	}
+	body, err := json.Marshal(&rpcRequest{
+		JsonRPC: "2.0",
+		Method:  fmt.Sprintf("SportsAPING/v1.0/%s", method),
+		Params:  params,
+		ID:      1,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(client.Ctx, http.MethodPost, bettingURL, bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Application", client.Config.AppKey)
+	req.Header.Set("X-Authentication", client.AuthData.SessionToken)
+	req.Header.Set("Content-type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Client.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unable to call %s with error %s [%d]", method, resp.Status, resp.StatusCode)
+	}
+	buf, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	rpcresp := rpcResponse{}
+	if err := json.Unmarshal(buf, &rpcresp); err != nil {
+		return err
+	}
+	if rpcresp.Error != nil {
+		return fmt.Errorf("Error returned from API %d [%s]", rpcresp.Error.Code, rpcresp.Error.Message)
+	}
+	return json.Unmarshal(rpcresp.Result, result)
+}
+
+func reportError(status string, errorCode string) error {
+	if status != ReportSuccess {
+		return fmt.Errorf("order failed with status %s [%s]", status, errorCode)
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+// Copyright 2022 Guy Barden
+// order_test.go - tests for the exchange order client
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package order
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/guysports/go-betfair-api/pkg/betting"
+	"github.com/guysports/go-betfair-api/pkg/transport"
+	"github.com/guysports/go-betfair-api/pkg/types"
+	"github.com/hashicorp/go-retryablehttp"
+	"github.com/stretchr/testify/assert"
+)
+
+// setupTestServer answers each JSON-RPC request with the next of the given responses
+func setupTestServer(tb testing.TB, responses ...string) (*BettingOrders, *[]rpcRequest, func(tb testing.TB)) {
+	requests := []rpcRequest{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		request := rpcRequest{}
+		_ = json.Unmarshal(body, &request)
+		requests = append(requests, request)
+		assert.Equal(tb, "appkey", r.Header.Get("X-Application"))
+		assert.Equal(tb, "sessionkey", r.Header.Get("X-Authentication"))
+		_, _ = w.Write([]byte(responses[len(requests)-1]))
+	}))
+	previousURL := bettingURL
+	bettingURL = server.URL
+
+	orders := NewBettingOrders(&betting.API{
+		Client: &transport.JsonRPCClient{
+			AuthData: types.Authenticate{SessionToken: "sessionkey"},
+			Client:   retryablehttp.NewClient(),
+			Config:   &types.Config{AppKey: "appkey"},
+			Ctx:      context.TODO(),
+		},
+	})
+	return orders, &requests, func(tb testing.TB) {
+		bettingURL = previousURL
+		server.Close()
+	}
+}
+
+func TestBettingOrders_PlaceOrders(t *testing.T) {
+	tests := []struct {
+		name      string
+		response  string
+		wantBetId string
+		wantErr   bool
+	}{
+		{
+			name:      "order placed",
+			response:  `{"jsonrpc":"2.0","result":{"status":"SUCCESS","marketId":"1.195693926","instructionReports":[{"status":"SUCCESS","betId":"31242604945","sizeMatched":10,"averagePriceMatched":3.7,"orderStatus":"EXECUTION_COMPLETE"}]},"id":1}`,
+			wantBetId: "31242604945",
+		},
+		{
+			name:     "order rejected",
+			response: `{"jsonrpc":"2.0","result":{"status":"FAILURE","errorCode":"BET_ACTION_ERROR","marketId":"1.195693926","instructionReports":[{"status":"FAILURE","errorCode":"INVALID_ODDS"}]},"id":1}`,
+			wantErr:  true,
+		},
+		{
+			name:     "session expired",
+			response: `{"jsonrpc":"2.0","error":{"code":-32099,"message":"ANGX-0003"},"id":1}`,
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			orders, requests, tearDownTest := setupTestServer(t, tt.response)
+			defer tearDownTest(t)
+
+			report, err := orders.PlaceOrders("1.195693926", []PlaceInstruction{NewLimitInstruction(64374, Back, 3.7, 10)}, "")
+			if tt.wantErr {
+				assert.NotNil(t, err)
+			} else {
+				assert.Nil(t, err)
+				assert.Equal(t, tt.wantBetId, report.InstructionReports[0].BetId)
+			}
+			assert.Equal(t, "SportsAPING/v1.0/placeOrders", (*requests)[0].Method)
+			params := (*requests)[0].Params.(map[string]interface{})
+			assert.Equal(t, "1.195693926", params["marketId"])
+			instruction := params["instructions"].([]interface{})[0].(map[string]interface{})
+			assert.Equal(t, "BACK", instruction["side"])
+			assert.Equal(t, "LIMIT", instruction["orderType"])
+		})
+	}
+}
+
+func TestBettingOrders_CancelOrders(t *testing.T) {
+	orders, requests, tearDownTest := setupTestServer(t, `{"jsonrpc":"2.0","result":{"status":"SUCCESS","marketId":"1.195693926","instructionReports":[{"status":"SUCCESS","instruction":{"betId":"31242604945"},"sizeCancelled":10}]},"id":1}`)
+	defer tearDownTest(t)
+
+	report, err := orders.CancelOrders("1.195693926", []CancelInstruction{{BetId: "31242604945"}})
+	assert.Nil(t, err)
+	assert.Equal(t, float32(10), report.InstructionReports[0].SizeCancelled)
+	assert.Equal(t, "SportsAPING/v1.0/cancelOrders", (*requests)[0].Method)
+}
+
+func TestBettingOrders_ReplaceOrders(t *testing.T) {
+	orders, requests, tearDownTest := setupTestServer(t, `{"jsonrpc":"2.0","result":{"status":"SUCCESS","marketId":"1.195693926","instructionReports":[{"status":"SUCCESS","placeInstructionReport":{"status":"SUCCESS","betId":"31242604946"}}]},"id":1}`)
+	defer tearDownTest(t)
+
+	report, err := orders.ReplaceOrders("1.195693926", []ReplaceInstruction{{BetId: "31242604945", NewPrice: 3.75}})
+	assert.Nil(t, err)
+	assert.Equal(t, "31242604946", report.InstructionReports[0].PlaceInstructionReport.BetId)
+	assert.Equal(t, "SportsAPING/v1.0/replaceOrders", (*requests)[0].Method)
+}
+
+func TestBettingOrders_ListCurrentOrders(t *testing.T) {
+	orders, requests, tearDownTest := setupTestServer(t,
+		`{"jsonrpc":"2.0","result":{"currentOrders":[{"betId":"1","marketId":"1.195693926","selectionId":64374,"side":"BACK","priceSize":{"price":3.7,"size":10}}],"moreAvailable":true},"id":1}`,
+		`{"jsonrpc":"2.0","result":{"currentOrders":[{"betId":"2","marketId":"1.195693926","selectionId":64374,"side":"LAY","priceSize":{"price":3.5,"size":10.57}}],"moreAvailable":false},"id":1}`,
+	)
+	defer tearDownTest(t)
+
+	current, err := orders.ListCurrentOrders([]string{"1.195693926"})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(current))
+	assert.Equal(t, Lay, current[1].Side)
+	assert.Equal(t, float64(1), (*requests)[1].Params.(map[string]interface{})["fromRecord"])
+}
+
+func TestBettingOrders_UnsupportedTransport(t *testing.T) {
+	orders := NewBettingOrders(&betting.API{})
+	_, err := orders.ListCurrentOrders(nil)
+	assert.NotNil(t, err)
+}
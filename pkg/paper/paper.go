@@ -0,0 +1,432 @@
+// Copyright 2022 Guy Barden
+// paper.go - simulated exchange that matches orders against the prices recorded in the store
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package paper
+
+import (
+	"encoding/json"
+	"fmt"
+	"guysports/go-football-trader/pkg/hedge"
+	"guysports/go-football-trader/pkg/helper"
+	"guysports/go-football-trader/pkg/order"
+	"guysports/go-football-trader/pkg/store"
+	"io/ioutil"
+	"os"
+	"sort"
+	"time"
+)
+
+type (
+	// Fill is part of an order matched against a recorded price sample
+	Fill struct {
+		Timestamp string  `json:"time_stamp"`
+		Price     float32 `json:"price"`
+		Size      float32 `json:"size"`
+	}
+
+	// Order is a simulated order and its fills
+	Order struct {
+		BetId         string     `json:"bet_id"`
+		MarketId      string     `json:"market_id"`
+		SelectionId   int        `json:"selection_id"`
+		Side          order.Side `json:"side"`
+		Price         float32    `json:"price"`
+		Size          float32    `json:"size"`
+		PlacedDate    string     `json:"placed_date"`
+		SizeCancelled float32    `json:"size_cancelled"`
+		Fills         []Fill     `json:"fills"`
+		// LastSample is the timestamp of the last price sample the order was matched against
+		LastSample string `json:"last_sample"`
+	}
+
+	// Ledger holds the simulated orders, kept apart from the price store
+	Ledger struct {
+		NextBetId int      `json:"next_bet_id"`
+		Orders    []*Order `json:"orders"`
+	}
+
+	// Exchange accepts the same orders as the Betfair exchange and matches them against the price history in the store
+	Exchange struct {
+		Store  *store.Store
+		Ledger *Ledger
+		Path   string
+		// Now provides the placement time of orders
+		Now func() time.Time
+	}
+
+	// Position is the matched position on a runner and its profit or loss once the fixture is settled
+	Position struct {
+		MarketId    string
+		SelectionId int
+		Fixture     string
+		Orders      int
+		Position    hedge.Position
+		Settled     bool
+		ProfitLoss  float32
+	}
+)
+
+const (
+	StatusExecutable        = "EXECUTABLE"
+	StatusExecutionComplete = "EXECUTION_COMPLETE"
+)
+
+// NewExchange loads the ledger at path, or starts a new one if it doesn't exist
+func NewExchange(path string, s *store.Store) (*Exchange, error) {
+	exchange := Exchange{
+		Store:  s,
+		Ledger: &Ledger{NextBetId: 1},
+		Path:   path,
+		Now:    time.Now,
+	}
+	ledgerBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &exchange, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(ledgerBytes, exchange.Ledger); err != nil {
+		return nil, err
+	}
+	return &exchange, nil
+}
+
+// Save writes the ledger to its file
+func (e *Exchange) Save() error {
+	ledgerBytes, err := json.Marshal(e.Ledger)
+	if err != nil {
+		return err
+	}
+	return store.WriteFileAtomic(e.Path, ledgerBytes, 0644)
+}
+
+// PlaceOrders records limit orders in the ledger, they are matched against samples recorded after they were placed
+func (e *Exchange) PlaceOrders(marketId string, instructions []order.PlaceInstruction, customerRef string) (*order.PlaceExecutionReport, error) {
+	if _, _, _, err := e.Store.FindFixtureByMarket(marketId); err != nil {
+		return nil, err
+	}
+	report := order.PlaceExecutionReport{
+		CustomerRef: customerRef,
+		Status:      order.ReportSuccess,
+		MarketId:    marketId,
+	}
+	placed := []*Order{}
+	for _, instruction := range instructions {
+		if instruction.LimitOrder == nil {
+			return nil, fmt.Errorf("paper trading only supports limit orders")
+		}
+		paperOrder := &Order{
+			BetId:       fmt.Sprintf("paper-%d", e.Ledger.NextBetId),
+			MarketId:    marketId,
+			SelectionId: instruction.SelectionId,
+			Side:        instruction.Side,
+			Price:       instruction.LimitOrder.Price,
+			Size:        instruction.LimitOrder.Size,
+			PlacedDate:  e.Now().Format(time.RFC3339),
+		}
+		e.Ledger.NextBetId++
+		e.Ledger.Orders = append(e.Ledger.Orders, paperOrder)
+		placed = append(placed, paperOrder)
+	}
+
+	e.Match()
+	for i, paperOrder := range placed {
+		report.InstructionReports = append(report.InstructionReports, order.PlaceInstructionReport{
+			Status:              order.ReportSuccess,
+			OrderStatus:         paperOrder.Status(),
+			Instruction:         instructions[i],
+			BetId:               paperOrder.BetId,
+			PlacedDate:          paperOrder.PlacedDate,
+			AveragePriceMatched: paperOrder.AveragePriceMatched(),
+			SizeMatched:         paperOrder.SizeMatched(),
+		})
+	}
+	return &report, nil
+}
+
+// CancelOrders cancels or reduces the unmatched part of orders
+func (e *Exchange) CancelOrders(marketId string, instructions []order.CancelInstruction) (*order.CancelExecutionReport, error) {
+	e.Match()
+	report := order.CancelExecutionReport{
+		Status:   order.ReportSuccess,
+		MarketId: marketId,
+	}
+	for _, instruction := range instructions {
+		paperOrder := e.findOrder(marketId, instruction.BetId)
+		if paperOrder == nil {
+			report.Status = "FAILURE"
+			report.InstructionReports = append(report.InstructionReports, order.CancelInstructionReport{
+				Status:      "FAILURE",
+				ErrorCode:   "BET_TAKEN_OR_LAPSED",
+				Instruction: instruction,
+			})
+			continue
+		}
+		cancelled := paperOrder.SizeRemaining()
+		if instruction.SizeReduction > 0 && instruction.SizeReduction < cancelled {
+			cancelled = instruction.SizeReduction
+		}
+		paperOrder.SizeCancelled += cancelled
+		report.InstructionReports = append(report.InstructionReports, order.CancelInstructionReport{
+			Status:        order.ReportSuccess,
+			Instruction:   instruction,
+			SizeCancelled: cancelled,
+			CancelledDate: e.Now().Format(time.RFC3339),
+		})
+	}
+	return &report, nil
+}
+
+// ReplaceOrders cancels the unmatched part of orders and places it again at the new price
+func (e *Exchange) ReplaceOrders(marketId string, instructions []order.ReplaceInstruction) (*order.ReplaceExecutionReport, error) {
+	report := order.ReplaceExecutionReport{
+		Status:   order.ReportSuccess,
+		MarketId: marketId,
+	}
+	for _, instruction := range instructions {
+		paperOrder := e.findOrder(marketId, instruction.BetId)
+		if paperOrder == nil {
+			report.Status = "FAILURE"
+			report.InstructionReports = append(report.InstructionReports, order.ReplaceInstructionReport{
+				Status:    "FAILURE",
+				ErrorCode: "BET_TAKEN_OR_LAPSED",
+			})
+			continue
+		}
+		cancelReport, _ := e.CancelOrders(marketId, []order.CancelInstruction{{BetId: instruction.BetId}})
+		cancelled := cancelReport.InstructionReports[0]
+		if cancelled.SizeCancelled <= 0 {
+			report.Status = "FAILURE"
+			report.InstructionReports = append(report.InstructionReports, order.ReplaceInstructionReport{
+				Status:                  "FAILURE",
+				ErrorCode:               "BET_TAKEN_OR_LAPSED",
+				CancelInstructionReport: &cancelled,
+			})
+			continue
+		}
+		replacement := order.NewLimitInstruction(paperOrder.SelectionId, paperOrder.Side, instruction.NewPrice, cancelled.SizeCancelled)
+		placeReport, err := e.PlaceOrders(marketId, []order.PlaceInstruction{replacement}, "")
+		if err != nil {
+			return nil, err
+		}
+		report.InstructionReports = append(report.InstructionReports, order.ReplaceInstructionReport{
+			Status:                  order.ReportSuccess,
+			CancelInstructionReport: &cancelled,
+			PlaceInstructionReport:  &placeReport.InstructionReports[0],
+		})
+	}
+	return &report, nil
+}
+
+// ListCurrentOrders lists the simulated orders on the given markets, or all markets if none are given
+func (e *Exchange) ListCurrentOrders(marketIds []string) ([]order.CurrentOrder, error) {
+	e.Match()
+	wanted := map[string]bool{}
+	for _, marketId := range marketIds {
+		wanted[marketId] = true
+	}
+	current := []order.CurrentOrder{}
+	for _, paperOrder := range e.Ledger.Orders {
+		if len(wanted) > 0 && !wanted[paperOrder.MarketId] {
+			continue
+		}
+		current = append(current, order.CurrentOrder{
+			BetId:               paperOrder.BetId,
+			MarketId:            paperOrder.MarketId,
+			SelectionId:         paperOrder.SelectionId,
+			PriceSize:           order.PriceSize{Price: paperOrder.Price, Size: paperOrder.Size},
+			Side:                paperOrder.Side,
+			Status:              paperOrder.Status(),
+			PersistenceType:     order.PersistenceLapse,
+			OrderType:           order.LimitOrderType,
+			PlacedDate:          paperOrder.PlacedDate,
+			AveragePriceMatched: paperOrder.AveragePriceMatched(),
+			SizeMatched:         paperOrder.SizeMatched(),
+			SizeRemaining:       paperOrder.SizeRemaining(),
+			SizeCancelled:       paperOrder.SizeCancelled,
+		})
+	}
+	return current, nil
+}
+
+// CurrentPrice returns the last price sample recorded for a runner, which stands in for the live price
+func (e *Exchange) CurrentPrice(marketId string, selectionId int) (*store.Price, error) {
+	_, _, fixture, err := e.Store.FindFixtureByMarket(marketId)
+	if err != nil {
+		return nil, err
+	}
+	history := fixture.PriceHistory[selectionId]
+	if len(history) == 0 {
+		return nil, fmt.Errorf("no prices recorded for runner %d in market %s", selectionId, marketId)
+	}
+	return &history[len(history)-1], nil
+}
+
+// Match fills open orders from the price samples recorded after they were placed. A back order is matched when the
+// best price available to back reaches the order price, filling up to the amount available, and a lay order when the
+// best price available to lay falls to the order price. Liquidity is not shared between orders on the same runner
+func (e *Exchange) Match() {
+	for _, paperOrder := range e.Ledger.Orders {
+		if paperOrder.SizeRemaining() <= 0 {
+			continue
+		}
+		_, _, fixture, err := e.Store.FindFixtureByMarket(paperOrder.MarketId)
+		if err != nil {
+			continue
+		}
+		after := paperOrder.PlacedDate
+		if paperOrder.LastSample != "" {
+			after = paperOrder.LastSample
+		}
+		for _, sample := range fixture.PriceHistory[paperOrder.SelectionId] {
+			if !sampleAfter(sample.Timestamp, after) {
+				continue
+			}
+			paperOrder.LastSample = sample.Timestamp
+			available := float32(0)
+			switch paperOrder.Side {
+			case order.Back:
+				if sample.BackPrice >= paperOrder.Price {
+					available = sample.BackAmount
+				}
+			case order.Lay:
+				if sample.LayPrice > 0 && sample.LayPrice <= paperOrder.Price {
+					available = sample.LayAmount
+				}
+			}
+			if available <= 0 {
+				continue
+			}
+			size := paperOrder.SizeRemaining()
+			if available < size {
+				size = available
+			}
+			paperOrder.Fills = append(paperOrder.Fills, Fill{
+				Timestamp: sample.Timestamp,
+				Price:     paperOrder.Price,
+				Size:      helper.ConvertTo2DP(size),
+			})
+			if paperOrder.SizeRemaining() <= 0 {
+				break
+			}
+		}
+	}
+}
+
+// Positions returns the matched position on each runner traded, with the profit or loss after commission
+// for fixtures that have been settled
+func (e *Exchange) Positions(commission float32) []Position {
+	bets := map[string][]hedge.Bet{}
+	positions := map[string]*Position{}
+	for _, paperOrder := range e.Ledger.Orders {
+		key := fmt.Sprintf("%s/%d", paperOrder.MarketId, paperOrder.SelectionId)
+		if _, ok := positions[key]; !ok {
+			positions[key] = &Position{
+				MarketId:    paperOrder.MarketId,
+				SelectionId: paperOrder.SelectionId,
+			}
+		}
+		positions[key].Orders++
+		if paperOrder.SizeMatched() > 0 {
+			bets[key] = append(bets[key], hedge.Bet{
+				Side:  paperOrder.Side,
+				Price: paperOrder.AveragePriceMatched(),
+				Stake: paperOrder.SizeMatched(),
+			})
+		}
+	}
+
+	result := []Position{}
+	for key, position := range positions {
+		position.Position = hedge.NewPosition(bets[key])
+		if _, _, fixture, err := e.Store.FindFixtureByMarket(position.MarketId); err == nil {
+			position.Fixture = fixture.Fixture
+			if won, settled := fixture.RunnerResult(position.SelectionId); settled {
+				position.Settled = true
+				position.ProfitLoss = position.Position.IfLose
+				if won {
+					position.ProfitLoss = position.Position.IfWin
+				}
+				position.ProfitLoss = helper.ConvertTo2DP(hedge.NetOfCommission(position.ProfitLoss, commission))
+			}
+		}
+		result = append(result, *position)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].MarketId != result[j].MarketId {
+			return result[i].MarketId < result[j].MarketId
+		}
+		return result[i].SelectionId < result[j].SelectionId
+	})
+	return result
+}
+
+// SizeMatched returns the total size of the order's fills
+func (o *Order) SizeMatched() float32 {
+	matched := float32(0)
+	for _, fill := range o.Fills {
+		matched += fill.Size
+	}
+	return helper.ConvertTo2DP(matched)
+}
+
+// SizeRemaining returns the size of the order still waiting to be matched
+func (o *Order) SizeRemaining() float32 {
+	return helper.ConvertTo2DP(o.Size - o.SizeMatched() - o.SizeCancelled)
+}
+
+// AveragePriceMatched returns the fill size weighted price of the order's fills
+func (o *Order) AveragePriceMatched() float32 {
+	matched := o.SizeMatched()
+	if matched == 0 {
+		return 0
+	}
+	weighted := float32(0)
+	for _, fill := range o.Fills {
+		weighted += fill.Price * fill.Size
+	}
+	return helper.ConvertTo2DP(weighted / matched)
+}
+
+// Status returns the Betfair order status of the order
+func (o *Order) Status() string {
+	if o.SizeRemaining() > 0 {
+		return StatusExecutable
+	}
+	return StatusExecutionComplete
+}
+
+func (e *Exchange) findOrder(marketId string, betId string) *Order {
+	for _, paperOrder := range e.Ledger.Orders {
+		if paperOrder.MarketId == marketId && paperOrder.BetId == betId {
+			return paperOrder
+		}
+	}
+	return nil
+}
+
+// sampleAfter reports whether a sample timestamp is later than the given time
+func sampleAfter(timestamp string, after string) bool {
+	sampled, err := time.Parse(time.RFC3339, timestamp)
+	if err != nil {
+		return false
+	}
+	since, err := time.Parse(time.RFC3339, after)
+	if err != nil {
+		return true
+	}
+	return sampled.After(since)
+}
@@ -0,0 +1,177 @@
+// Copyright 2022 Guy Barden
+// paper_test.go - tests for the simulated exchange
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package paper
+
+import (
+	"guysports/go-football-trader/pkg/hedge"
+	"guysports/go-football-trader/pkg/order"
+	"guysports/go-football-trader/pkg/store"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func setupTestExchange(tb testing.TB) *Exchange {
+	s := &store.Store{
+		GlobalPriceStore: map[string]map[string]store.FixturePrices{
+			"league1": {
+				"fixture1": {
+					Fixture:      "Mainz v Dortmund",
+					MatchStatus:  store.Scheduled,
+					MarketID:     "1.195693926",
+					HomeRunnerId: 64374,
+					AwayRunnerId: 44785,
+					PriceHistory: map[int][]store.Price{
+						64374: {
+							{Timestamp: "2022-04-06T12:00:00Z", BackPrice: 3.7, LayPrice: 3.75, BackAmount: 100, LayAmount: 50},
+							{Timestamp: "2022-04-06T12:10:00Z", BackPrice: 3.75, LayPrice: 3.8, BackAmount: 6, LayAmount: 50},
+							{Timestamp: "2022-04-06T12:20:00Z", BackPrice: 3.8, LayPrice: 3.85, BackAmount: 20, LayAmount: 50},
+							{Timestamp: "2022-04-06T12:30:00Z", BackPrice: 3.45, LayPrice: 3.5, BackAmount: 20, LayAmount: 30},
+						},
+					},
+				},
+			},
+		},
+	}
+	exchange, err := NewExchange(filepath.Join(tb.TempDir(), "paper.json"), s)
+	assert.Nil(tb, err)
+	exchange.Now = func() time.Time {
+		return time.Date(2022, 4, 6, 12, 5, 0, 0, time.UTC)
+	}
+	return exchange
+}
+
+func TestExchange_PlaceOrders(t *testing.T) {
+	tests := []struct {
+		name            string
+		instruction     order.PlaceInstruction
+		wantFills       []Fill
+		wantStatus      string
+		wantSizeMatched float32
+	}{
+		{
+			name:        "back order partially filled by later samples",
+			instruction: order.NewLimitInstruction(64374, order.Back, 3.75, 10),
+			wantFills: []Fill{
+				{Timestamp: "2022-04-06T12:10:00Z", Price: 3.75, Size: 6},
+				{Timestamp: "2022-04-06T12:20:00Z", Price: 3.75, Size: 4},
+			},
+			wantStatus:      StatusExecutionComplete,
+			wantSizeMatched: 10,
+		},
+		{
+			name:        "lay order filled when the lay price comes down",
+			instruction: order.NewLimitInstruction(64374, order.Lay, 3.5, 40),
+			wantFills: []Fill{
+				{Timestamp: "2022-04-06T12:30:00Z", Price: 3.5, Size: 30},
+			},
+			wantStatus:      StatusExecutable,
+			wantSizeMatched: 30,
+		},
+		{
+			name:            "samples before placement are not matched",
+			instruction:     order.NewLimitInstruction(64374, order.Back, 3.9, 10),
+			wantStatus:      StatusExecutable,
+			wantSizeMatched: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exchange := setupTestExchange(t)
+			report, err := exchange.PlaceOrders("1.195693926", []order.PlaceInstruction{tt.instruction}, "")
+			assert.Nil(t, err)
+			assert.Equal(t, "paper-1", report.InstructionReports[0].BetId)
+			assert.Equal(t, tt.wantStatus, report.InstructionReports[0].OrderStatus)
+			assert.Equal(t, tt.wantSizeMatched, report.InstructionReports[0].SizeMatched)
+			assert.Equal(t, tt.wantFills, exchange.Ledger.Orders[0].Fills)
+		})
+	}
+}
+
+func TestExchange_PlaceOrdersUnknownMarket(t *testing.T) {
+	exchange := setupTestExchange(t)
+	_, err := exchange.PlaceOrders("1.1", []order.PlaceInstruction{order.NewLimitInstruction(64374, order.Back, 3.75, 10)}, "")
+	assert.NotNil(t, err)
+}
+
+func TestExchange_CancelAndReplaceOrders(t *testing.T) {
+	exchange := setupTestExchange(t)
+	_, err := exchange.PlaceOrders("1.195693926", []order.PlaceInstruction{order.NewLimitInstruction(64374, order.Lay, 3.5, 40)}, "")
+	assert.Nil(t, err)
+
+	replaced, err := exchange.ReplaceOrders("1.195693926", []order.ReplaceInstruction{{BetId: "paper-1", NewPrice: 3.4}})
+	assert.Nil(t, err)
+	assert.Equal(t, float32(10), replaced.InstructionReports[0].CancelInstructionReport.SizeCancelled)
+	assert.Equal(t, "paper-2", replaced.InstructionReports[0].PlaceInstructionReport.BetId)
+
+	cancelled, err := exchange.CancelOrders("1.195693926", []order.CancelInstruction{{BetId: "paper-2", SizeReduction: 4}})
+	assert.Nil(t, err)
+	assert.Equal(t, float32(4), cancelled.InstructionReports[0].SizeCancelled)
+
+	current, err := exchange.ListCurrentOrders([]string{"1.195693926"})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(current))
+	assert.Equal(t, float32(30), current[0].SizeMatched)
+	assert.Equal(t, float32(0), current[0].SizeRemaining)
+	assert.Equal(t, float32(6), current[1].SizeRemaining)
+
+	_, err = exchange.CancelOrders("1.195693926", []order.CancelInstruction{{BetId: "nobet"}})
+	assert.Nil(t, err)
+}
+
+func TestExchange_PositionsAndLedger(t *testing.T) {
+	exchange := setupTestExchange(t)
+	_, err := exchange.PlaceOrders("1.195693926", []order.PlaceInstruction{order.NewLimitInstruction(64374, order.Back, 3.75, 10)}, "")
+	assert.Nil(t, err)
+
+	positions := exchange.Positions(0.02)
+	assert.Equal(t, []Position{{
+		MarketId:    "1.195693926",
+		SelectionId: 64374,
+		Fixture:     "Mainz v Dortmund",
+		Orders:      1,
+		Position:    hedge.Position{IfWin: 27.5, IfLose: -10},
+	}}, positions)
+
+	// Settle the fixture with a home win
+	fixture := exchange.Store.GlobalPriceStore["league1"]["fixture1"]
+	fixture.MatchStatus = store.Played
+	fixture.OutCome = store.HomeWin
+	exchange.Store.GlobalPriceStore["league1"]["fixture1"] = fixture
+	positions = exchange.Positions(0.02)
+	assert.True(t, positions[0].Settled)
+	assert.Equal(t, float32(26.95), positions[0].ProfitLoss)
+
+	// The ledger is persisted separately from the store
+	assert.Nil(t, exchange.Save())
+	_, err = os.Stat(exchange.Path)
+	assert.Nil(t, err)
+	reloaded, err := NewExchange(exchange.Path, exchange.Store)
+	assert.Nil(t, err)
+	assert.Equal(t, exchange.Ledger, reloaded.Ledger)
+}
+
+func TestExchange_CurrentPrice(t *testing.T) {
+	exchange := setupTestExchange(t)
+	price, err := exchange.CurrentPrice("1.195693926", 64374)
+	assert.Nil(t, err)
+	assert.Equal(t, float32(3.45), price.BackPrice)
+	_, err = exchange.CurrentPrice("1.195693926", 44785)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,278 @@
+// Copyright 2022 Guy Barden
+// backend.go - the storage interface behind the store and the single json file implementation
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+type (
+	// Backend persists the fixtures of the store. Writes may be buffered until Flush is called
+	Backend interface {
+		// ListFixtures returns every stored fixture
+		ListFixtures() ([]FixtureRef, error)
+		// QueryFixtures returns the stored fixtures in the query's leagues that kick off inside its dates
+		QueryFixtures(query FixtureQuery) ([]FixtureRef, error)
+		// LoadFixture returns a fixture with its price history
+		LoadFixture(leagueId string, eventId string) (FixturePrices, error)
+		// SaveFixture writes the details of a fixture, any price history it holds is ignored
+		SaveFixture(leagueId string, eventId string, fixture FixturePrices) error
+		// AppendSamples adds price samples to the end of a runner's history in a saved fixture
+		AppendSamples(leagueId string, eventId string, selectionId int, samples []Price) error
+		// AppendMarketSamples adds price samples to the end of the history of the runner at a position in another
+		// market of a saved fixture
+		AppendMarketSamples(leagueId string, eventId string, marketType string, runner int, samples []Price) error
+		// AppendMarketHistory adds market samples to the end of the history of a market of a saved fixture, the match
+		// odds market when the market type is empty
+		AppendMarketHistory(leagueId string, eventId string, marketType string, samples []MarketSample) error
+		// DeleteFixture removes a fixture and its price history
+		DeleteFixture(leagueId string, eventId string) error
+		// Flush makes the writes since the last flush durable
+		Flush() error
+	}
+
+	// FixtureRef identifies a stored fixture
+	FixtureRef struct {
+		LeagueId string
+		EventId  string
+		Date     string
+	}
+
+	// FixtureQuery selects fixtures by league and kickoff, empty leagues or zero times are not applied
+	FixtureQuery struct {
+		LeagueIds []string
+		From      time.Time
+		To        time.Time
+	}
+
+	// JSONBackend keeps the whole store in a single json file that is rewritten on each flush, the previous
+	// file is kept with the time it was replaced added to its name. A file ending .gz is read and written gzipped
+	JSONBackend struct {
+		Path     string
+		fixtures map[string]map[string]FixturePrices
+	}
+)
+
+const (
+	JSONBackendName = "json"
+	LogBackendName  = "log"
+)
+
+// OpenBackend opens the named backend, path is the json file or the directory of the log segments
+func OpenBackend(name string, path string) (Backend, error) {
+	switch name {
+	case JSONBackendName, "":
+		return NewJSONBackend(path), nil
+	case LogBackendName:
+		return NewSegmentedLog(path, DefaultSegmentSize)
+	}
+	return nil, fmt.Errorf("backend must be one of %s or %s not %s", JSONBackendName, LogBackendName, name)
+}
+
+// Matches reports whether the fixture is selected by the query
+func (q *FixtureQuery) Matches(leagueId string, fixture *FixturePrices) bool {
+	if len(q.LeagueIds) > 0 {
+		found := false
+		for _, id := range q.LeagueIds {
+			found = found || id == leagueId
+		}
+		if !found {
+			return false
+		}
+	}
+	if q.From.IsZero() && q.To.IsZero() {
+		return true
+	}
+	kickoff, err := time.Parse(time.RFC3339, fixture.Date)
+	if err != nil {
+		return false
+	}
+	if !q.From.IsZero() && kickoff.Before(q.From) {
+		return false
+	}
+	if !q.To.IsZero() && kickoff.After(q.To) {
+		return false
+	}
+	return true
+}
+
+// NewJSONBackend creates a backend for the json file at path, the file is read when first used
+func NewJSONBackend(path string) *JSONBackend {
+	return &JSONBackend{
+		Path: path,
+	}
+}
+
+// load reads the file, a missing file starts a new store. A file that cannot be read or decoded is an error so it
+// is never replaced by an empty store
+func (j *JSONBackend) load() (map[string]map[string]FixturePrices, error) {
+	if j.fixtures != nil {
+		return j.fixtures, nil
+	}
+	marshaledStore, err := readStoreFile(j.Path)
+	if os.IsNotExist(err) {
+		j.fixtures = map[string]map[string]FixturePrices{}
+		return j.fixtures, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read store %s: %s", j.Path, err.Error())
+	}
+	fixtures, version, err := DecodeStore(marshaledStore)
+	if version > CurrentSchemaVersion {
+		return nil, fmt.Errorf("unable to read store %s: %s", j.Path, err.Error())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode store %s, run store repair to salvage it: %s", j.Path, err.Error())
+	}
+	j.fixtures = fixtures
+	return j.fixtures, nil
+}
+
+func (j *JSONBackend) ListFixtures() ([]FixtureRef, error) {
+	fixtures, err := j.load()
+	if err != nil {
+		return nil, err
+	}
+	return fixtureRefs(fixtures, nil), nil
+}
+
+func (j *JSONBackend) QueryFixtures(query FixtureQuery) ([]FixtureRef, error) {
+	fixtures, err := j.load()
+	if err != nil {
+		return nil, err
+	}
+	return fixtureRefs(fixtures, &query), nil
+}
+
+func (j *JSONBackend) LoadFixture(leagueId string, eventId string) (FixturePrices, error) {
+	fixtures, err := j.load()
+	if err != nil {
+		return FixturePrices{}, err
+	}
+	fixture, ok := fixtures[leagueId][eventId]
+	if !ok {
+		return FixturePrices{}, fmt.Errorf("unable to find fixture %s in league %s", eventId, leagueId)
+	}
+	// Copy the histories so samples added to the store are not already in the backend when they are appended
+	return copyHistories(fixture), nil
+}
+
+func (j *JSONBackend) SaveFixture(leagueId string, eventId string, fixture FixturePrices) error {
+	fixtures, err := j.load()
+	if err != nil {
+		return err
+	}
+	if fixtures[leagueId] == nil {
+		fixtures[leagueId] = map[string]FixturePrices{}
+	}
+	stored := fixtures[leagueId][eventId]
+	fixtures[leagueId][eventId] = keepHistories(fixture, &stored)
+	return nil
+}
+
+func (j *JSONBackend) AppendSamples(leagueId string, eventId string, selectionId int, samples []Price) error {
+	fixtures, err := j.load()
+	if err != nil {
+		return err
+	}
+	fixture, ok := fixtures[leagueId][eventId]
+	if !ok {
+		return fmt.Errorf("unable to find fixture %s in league %s", eventId, leagueId)
+	}
+	// Copy the samples so the backend never shares a history with the store
+	history := make([]Price, 0, len(fixture.PriceHistory[selectionId])+len(samples))
+	history = append(history, fixture.PriceHistory[selectionId]...)
+	fixture.PriceHistory[selectionId] = append(history, samples...)
+	return nil
+}
+
+func (j *JSONBackend) AppendMarketSamples(leagueId string, eventId string, marketType string, runner int, samples []Price) error {
+	fixtures, err := j.load()
+	if err != nil {
+		return err
+	}
+	market, ok := fixtures[leagueId][eventId].Markets[marketType]
+	if !ok || runner >= len(market.Runners) {
+		return fmt.Errorf("unable to find runner %d of market %s in fixture %s in league %s", runner, marketType, eventId, leagueId)
+	}
+	history := make([]Price, 0, len(market.Runners[runner].History)+len(samples))
+	history = append(history, market.Runners[runner].History...)
+	market.Runners[runner].History = append(history, samples...)
+	return nil
+}
+
+func (j *JSONBackend) AppendMarketHistory(leagueId string, eventId string, marketType string, samples []MarketSample) error {
+	fixtures, err := j.load()
+	if err != nil {
+		return err
+	}
+	fixture, ok := fixtures[leagueId][eventId]
+	history, found := fixture.marketHistory(marketType)
+	if !ok || !found {
+		return fmt.Errorf("unable to find market %s in fixture %s in league %s", marketType, eventId, leagueId)
+	}
+	fixture.setMarketHistory(marketType, append(copyMarketHistory(history), samples...))
+	fixtures[leagueId][eventId] = fixture
+	return nil
+}
+
+func (j *JSONBackend) DeleteFixture(leagueId string, eventId string) error {
+	fixtures, err := j.load()
+	if err != nil {
+		return err
+	}
+	delete(fixtures[leagueId], eventId)
+	return nil
+}
+
+func (j *JSONBackend) Flush() error {
+	fixtures, err := j.load()
+	if err != nil {
+		return err
+	}
+	storebytes, err := EncodeStore(j.Path, fixtures)
+	if err != nil {
+		return err
+	}
+
+	if err := archiveFile(j.Path, ArchivePath(j.Path, time.Now())); err != nil {
+		return err
+	}
+	return WriteFileAtomic(j.Path, storebytes, 0644)
+}
+
+// fixtureRefs lists the fixtures matching the query, or all fixtures if there is no query, in league and event order
+func fixtureRefs(fixtures map[string]map[string]FixturePrices, query *FixtureQuery) []FixtureRef {
+	refs := []FixtureRef{}
+	for leagueId, league := range fixtures {
+		for eventId, fixture := range league {
+			if query != nil && !query.Matches(leagueId, &fixture) {
+				continue
+			}
+			refs = append(refs, FixtureRef{LeagueId: leagueId, EventId: eventId, Date: fixture.Date})
+		}
+	}
+	sort.Slice(refs, func(i, j int) bool {
+		if refs[i].LeagueId != refs[j].LeagueId {
+			return refs[i].LeagueId < refs[j].LeagueId
+		}
+		return refs[i].EventId < refs[j].EventId
+	})
+	return refs
+}
@@ -0,0 +1,220 @@
+// Copyright 2022 Guy Barden
+// backend_test.go - tests for the store backends
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testBackends(t *testing.T) map[string]func() Backend {
+	dir := t.TempDir()
+	return map[string]func() Backend{
+		JSONBackendName: func() Backend {
+			backend, err := OpenBackend(JSONBackendName, filepath.Join(dir, "store.json"))
+			assert.Nil(t, err)
+			return backend
+		},
+		LogBackendName: func() Backend {
+			backend, err := OpenBackend(LogBackendName, filepath.Join(dir, "log"))
+			assert.Nil(t, err)
+			return backend
+		},
+	}
+}
+
+func TestBackend_Fixtures(t *testing.T) {
+	for name, open := range testBackends(t) {
+		t.Run(name, func(t *testing.T) {
+			backend := open()
+			assert.Nil(t, backend.SaveFixture("59", "fixture1", FixturePrices{Fixture: "Mainz v Dortmund", Date: "2022-04-09T13:30:00Z", HomeRunnerId: 1, PriceHistory: map[int][]Price{1: {{Timestamp: "ignored"}}}}))
+			assert.Nil(t, backend.SaveFixture("59", "fixture2", FixturePrices{Fixture: "Bochum v Koln", Date: "2022-04-16T13:30:00Z"}))
+			assert.Nil(t, backend.SaveFixture("81", "fixture3", FixturePrices{Fixture: "Roma v Lazio", Date: "2022-04-10T18:45:00Z"}))
+			assert.Nil(t, backend.AppendSamples("59", "fixture1", 1, []Price{{Timestamp: "2022-04-06T12:00:00Z", BackPrice: 3.7}}))
+			assert.Nil(t, backend.AppendSamples("59", "fixture1", 1, []Price{{Timestamp: "2022-04-06T12:10:00Z", BackPrice: 3.75}}))
+			assert.NotNil(t, backend.AppendSamples("59", "nofixture", 1, []Price{{Timestamp: "2022-04-06T12:10:00Z"}}))
+			assert.Nil(t, backend.DeleteFixture("81", "fixture3"))
+			assert.Nil(t, backend.Flush())
+
+			// Reopen to read back what was flushed
+			backend = open()
+			refs, err := backend.ListFixtures()
+			assert.Nil(t, err)
+			assert.Equal(t, []FixtureRef{
+				{LeagueId: "59", EventId: "fixture1", Date: "2022-04-09T13:30:00Z"},
+				{LeagueId: "59", EventId: "fixture2", Date: "2022-04-16T13:30:00Z"},
+			}, refs)
+
+			refs, err = backend.QueryFixtures(FixtureQuery{LeagueIds: []string{"59"}, From: time.Date(2022, 4, 15, 0, 0, 0, 0, time.UTC)})
+			assert.Nil(t, err)
+			assert.Equal(t, []FixtureRef{{LeagueId: "59", EventId: "fixture2", Date: "2022-04-16T13:30:00Z"}}, refs)
+			refs, err = backend.QueryFixtures(FixtureQuery{LeagueIds: []string{"81"}})
+			assert.Nil(t, err)
+			assert.Equal(t, []FixtureRef{}, refs)
+
+			fixture, err := backend.LoadFixture("59", "fixture1")
+			assert.Nil(t, err)
+			assert.Equal(t, FixturePrices{Fixture: "Mainz v Dortmund", Date: "2022-04-09T13:30:00Z", HomeRunnerId: 1, PriceHistory: map[int][]Price{
+				1: {{Timestamp: "2022-04-06T12:00:00Z", BackPrice: 3.7}, {Timestamp: "2022-04-06T12:10:00Z", BackPrice: 3.75}},
+			}}, fixture)
+			_, err = backend.LoadFixture("81", "fixture3")
+			assert.NotNil(t, err)
+		})
+	}
+}
+
+func TestStore_SaveStoreToBackend(t *testing.T) {
+	for name, open := range testBackends(t) {
+		t.Run(name, func(t *testing.T) {
+			s, err := LoadStore(open(), nil, nil)
+			assert.Nil(t, err)
+			s.GlobalPriceStore["59"] = map[string]FixturePrices{
+				"fixture1": {Fixture: "Mainz v Dortmund", Date: "2022-04-09T13:30:00Z", HomeRunnerId: 1, PriceHistory: map[int][]Price{1: {{Timestamp: "2022-04-06T12:00:00Z", BackPrice: 3.7}}}},
+				"fixture2": {Fixture: "Bochum v Koln", Date: "2022-04-16T13:30:00Z", PriceHistory: map[int][]Price{}},
+			}
+			assert.Nil(t, s.SaveStoreToFile())
+
+			// Add a sample, change the details of one fixture and remove the other
+			fixture := s.GlobalPriceStore["59"]["fixture1"]
+			fixture.PriceHistory[1] = append(fixture.PriceHistory[1], Price{Timestamp: "2022-04-06T12:10:00Z", BackPrice: 3.75})
+			fixture.MatchStatus = Played
+			s.GlobalPriceStore["59"]["fixture1"] = fixture
+			delete(s.GlobalPriceStore["59"], "fixture2")
+			assert.Nil(t, s.SaveStoreToFile())
+
+			reloaded, err := LoadStore(open(), nil, nil)
+			assert.Nil(t, err)
+			assert.Equal(t, s.GlobalPriceStore, reloaded.GlobalPriceStore)
+
+			// A history that has been cut short is written again in full
+			fixture = reloaded.GlobalPriceStore["59"]["fixture1"]
+			fixture.PriceHistory[1] = fixture.PriceHistory[1][1:]
+			reloaded.GlobalPriceStore["59"]["fixture1"] = fixture
+			assert.Nil(t, reloaded.SaveStoreToFile())
+			again, err := LoadStore(open(), nil, &FixtureQuery{LeagueIds: []string{"59"}})
+			assert.Nil(t, err)
+			assert.Equal(t, reloaded.GlobalPriceStore, again.GlobalPriceStore)
+		})
+	}
+}
+
+func TestStore_SaveAfterReload(t *testing.T) {
+	for name, open := range testBackends(t) {
+		t.Run(name, func(t *testing.T) {
+			s, err := LoadStore(open(), nil, nil)
+			assert.Nil(t, err)
+			s.GlobalPriceStore["59"] = map[string]FixturePrices{
+				"fixture1": {Fixture: "Mainz v Dortmund", Date: "2022-04-09T13:30:00Z", HomeRunnerId: 1, PriceHistory: map[int][]Price{1: {{Timestamp: "2022-04-06T12:00:00Z", BackPrice: 3.7}}}},
+			}
+			assert.Nil(t, s.SaveStoreToFile())
+
+			// A sample taken after the store is reloaded is saved once
+			reloaded, err := LoadStore(open(), nil, nil)
+			assert.Nil(t, err)
+			fixture := reloaded.GlobalPriceStore["59"]["fixture1"]
+			fixture.PriceHistory[1] = append(fixture.PriceHistory[1], Price{Timestamp: "2022-04-06T12:10:00Z", BackPrice: 3.75})
+			reloaded.GlobalPriceStore["59"]["fixture1"] = fixture
+			assert.Nil(t, reloaded.SaveStoreToFile())
+
+			again, err := LoadStore(open(), nil, nil)
+			assert.Nil(t, err)
+			assert.Equal(t, 2, len(again.GlobalPriceStore["59"]["fixture1"].PriceHistory[1]))
+			assert.Equal(t, reloaded.GlobalPriceStore, again.GlobalPriceStore)
+		})
+	}
+}
+
+func TestSegmentedLog_AppendsOnlyChanges(t *testing.T) {
+	dir := t.TempDir()
+	backend, err := NewSegmentedLog(dir, DefaultSegmentSize)
+	assert.Nil(t, err)
+	s, err := LoadStore(backend, nil, nil)
+	assert.Nil(t, err)
+	s.GlobalPriceStore["59"] = map[string]FixturePrices{
+		"fixture1": {Fixture: "Mainz v Dortmund", HomeRunnerId: 1, AwayRunnerId: 2, PriceHistory: map[int][]Price{1: {{Timestamp: "2022-04-06T12:00:00Z"}}, 2: {{Timestamp: "2022-04-06T12:00:00Z"}}}},
+	}
+	assert.Nil(t, s.SaveStoreToFile())
+	fixture := s.GlobalPriceStore["59"]["fixture1"]
+	fixture.PriceHistory[1] = append(fixture.PriceHistory[1], Price{Timestamp: "2022-04-06T12:10:00Z"})
+	assert.Nil(t, s.SaveStoreToFile())
+	assert.Nil(t, s.SaveStoreToFile())
+	assert.Nil(t, backend.Close())
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "segment-000001.log"))
+	assert.Nil(t, err)
+	ops := []string{}
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		record := logRecord{}
+		assert.Nil(t, json.Unmarshal([]byte(line), &record))
+		ops = append(ops, record.Op)
+	}
+	assert.Equal(t, []string{opSchema, opFixture, opSamples, opSamples, opSamples}, ops)
+}
+
+func TestSegmentedLog_Segments(t *testing.T) {
+	dir := t.TempDir()
+	backend, err := NewSegmentedLog(dir, 200)
+	assert.Nil(t, err)
+	assert.Nil(t, backend.SaveFixture("59", "fixture1", FixturePrices{Fixture: "Mainz v Dortmund"}))
+	for i := 0; i < 5; i++ {
+		assert.Nil(t, backend.AppendSamples("59", "fixture1", 1, []Price{{Timestamp: "2022-04-06T12:00:00Z", BackPrice: float32(i)}}))
+	}
+	assert.Nil(t, backend.Close())
+	segments, err := filepath.Glob(filepath.Join(dir, "segment-*.log"))
+	assert.Nil(t, err)
+	assert.Greater(t, len(segments), 1)
+
+	// A record cut short by a crash is ignored and the next run writes to a new segment
+	last := segments[len(segments)-1]
+	f, err := os.OpenFile(last, os.O_APPEND|os.O_WRONLY, 0644)
+	assert.Nil(t, err)
+	_, err = f.WriteString(`{"op":"samples","league":"59","ev`)
+	assert.Nil(t, err)
+	assert.Nil(t, f.Close())
+
+	backend, err = NewSegmentedLog(dir, 200)
+	assert.Nil(t, err)
+	fixture, err := backend.LoadFixture("59", "fixture1")
+	assert.Nil(t, err)
+	assert.Equal(t, 5, len(fixture.PriceHistory[1]))
+	assert.Equal(t, float32(4), fixture.PriceHistory[1][4].BackPrice)
+	assert.Nil(t, backend.AppendSamples("59", "fixture1", 1, []Price{{Timestamp: "2022-04-06T12:10:00Z"}}))
+	assert.Nil(t, backend.Close())
+	_, err = NewSegmentedLog(dir, 200)
+	assert.Nil(t, err)
+
+	// A segment from a newer schema version is an error
+	assert.Nil(t, ioutil.WriteFile(segments[0], []byte("{\"op\":\"schema\",\"version\":99}\n"), 0644))
+	_, err = NewSegmentedLog(dir, 200)
+	assert.NotNil(t, err)
+
+	// A damaged record inside a segment is an error
+	assert.Nil(t, ioutil.WriteFile(segments[0], []byte("{\n{}\n"), 0644))
+	_, err = NewSegmentedLog(dir, 200)
+	assert.NotNil(t, err)
+}
+
+func TestOpenBackend(t *testing.T) {
+	_, err := OpenBackend("sqlite", t.TempDir())
+	assert.NotNil(t, err)
+}
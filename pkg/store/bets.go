@@ -0,0 +1,104 @@
+// Copyright 2022 Guy Barden
+// bets.go - records the bets placed against fixtures in the store
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"fmt"
+	"strings"
+)
+
+type (
+	// Bet holds an order placed on a runner in the fixture's market
+	Bet struct {
+		BetID               string  `json:"bet_id"`
+		SelectionID         int     `json:"selection_id"`
+		Side                string  `json:"side"`
+		Price               float32 `json:"price"`
+		Stake               float32 `json:"stake"`
+		PlacedDate          string  `json:"placed_date"`
+		Status              string  `json:"status"`
+		SizeMatched         float32 `json:"size_matched"`
+		AveragePriceMatched float32 `json:"average_price_matched"`
+	}
+)
+
+// FindFixture returns the league and prices of a fixture from its event id
+func (s *Store) FindFixture(eventId string) (leagueId string, fixture FixturePrices, err error) {
+	for leagueId, league := range s.GlobalPriceStore {
+		if fixture, ok := league[eventId]; ok {
+			return leagueId, fixture, nil
+		}
+	}
+	return "", FixturePrices{}, fmt.Errorf("unable to find fixture %s in the store", eventId)
+}
+
+// FindFixtureByMarket returns the league and event id of the fixture with the given market id
+func (s *Store) FindFixtureByMarket(marketId string) (leagueId string, eventId string, fixture FixturePrices, err error) {
+	for leagueId, league := range s.GlobalPriceStore {
+		for eventId, fixture := range league {
+			if fixture.MarketID == marketId {
+				return leagueId, eventId, fixture, nil
+			}
+		}
+	}
+	return "", "", FixturePrices{}, fmt.Errorf("unable to find fixture with market %s in the store", marketId)
+}
+
+// RecordBet adds a placed bet to the fixture it was placed on
+func (s *Store) RecordBet(eventId string, bet Bet) error {
+	leagueId, fixture, err := s.FindFixture(eventId)
+	if err != nil {
+		return err
+	}
+	fixture.Bets = append(fixture.Bets, bet)
+	s.GlobalPriceStore[leagueId][eventId] = fixture
+	return nil
+}
+
+// RunnerId returns the selection id of the home, away or draw runner in the fixture's market
+func (f *FixturePrices) RunnerId(runner string) (int, error) {
+	selectionId := 0
+	switch strings.ToLower(runner) {
+	case "home":
+		selectionId = f.HomeRunnerId
+	case "away":
+		selectionId = f.AwayRunnerId
+	case "draw":
+		selectionId = f.DrawRunnerId
+	default:
+		return 0, fmt.Errorf("runner must be one of home, away or draw not %s", runner)
+	}
+	if selectionId == 0 {
+		return 0, fmt.Errorf("fixture %s has no %s runner", f.Fixture, runner)
+	}
+	return selectionId, nil
+}
+
+// RunnerResult reports whether the runner won the fixture, settled is false until the result is known
+func (f *FixturePrices) RunnerResult(selectionId int) (won bool, settled bool) {
+	if f.MatchStatus != Played || f.OutCome == "" {
+		return false, false
+	}
+	switch selectionId {
+	case f.HomeRunnerId:
+		return f.OutCome == HomeWin, true
+	case f.AwayRunnerId:
+		return f.OutCome == AwayWin, true
+	case f.DrawRunnerId:
+		return f.OutCome == Draw, true
+	}
+	return false, false
+}
@@ -0,0 +1,62 @@
+// Copyright 2022 Guy Barden
+// bets_test.go - tests for recording bets against fixtures
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStore_RecordBet(t *testing.T) {
+	s := &Store{
+		GlobalPriceStore: map[string]map[string]FixturePrices{
+			"league1": {
+				"fixture1": {Fixture: "Mainz v Dortmund", HomeRunnerId: 64374},
+			},
+		},
+	}
+	bet := Bet{BetID: "31242604945", SelectionID: 64374, Side: "BACK", Price: 3.7, Stake: 10}
+	assert.Nil(t, s.RecordBet("fixture1", bet))
+	assert.Equal(t, []Bet{bet}, s.GlobalPriceStore["league1"]["fixture1"].Bets)
+	assert.NotNil(t, s.RecordBet("nofixture", bet))
+}
+
+func TestFixturePrices_RunnerId(t *testing.T) {
+	fixture := FixturePrices{Fixture: "Mainz v Dortmund", HomeRunnerId: 64374, AwayRunnerId: 44785}
+	tests := []struct {
+		name    string
+		runner  string
+		want    int
+		wantErr bool
+	}{
+		{name: "home runner", runner: "home", want: 64374},
+		{name: "away runner", runner: "Away", want: 44785},
+		{name: "no draw runner recorded", runner: "draw", wantErr: true},
+		{name: "unknown runner", runner: "mainz", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := fixture.RunnerId(tt.runner)
+			if tt.wantErr {
+				assert.NotNil(t, err)
+			} else {
+				assert.Nil(t, err)
+				assert.Equal(t, tt.want, got)
+			}
+		})
+	}
+}
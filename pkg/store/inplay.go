@@ -0,0 +1,250 @@
+// Copyright 2022 Guy Barden
+// inplay.go - marks the windows a fixture's match odds market was suspended in play and infers what caused them
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"sort"
+	"time"
+
+	"github.com/guysports/go-betfair-api/pkg/types"
+)
+
+type (
+	InPlayEvent string
+
+	// Side is the home or away team of a fixture
+	Side string
+
+	// Suspension is a window in which the match odds market was suspended in play, with the best back prices of the
+	// teams either side of it. The team is the side that scored or was shown the red card, and the end is empty while
+	// the market is still suspended
+	Suspension struct {
+		Start      string      `json:"start"`
+		End        string      `json:"end,omitempty"`
+		Event      InPlayEvent `json:"event,omitempty"`
+		Team       Side        `json:"team,omitempty"`
+		HomeBefore float32     `json:"home_before"`
+		AwayBefore float32     `json:"away_before"`
+		HomeAfter  float32     `json:"home_after,omitempty"`
+		AwayAfter  float32     `json:"away_after,omitempty"`
+	}
+
+	// Shock is an in-play suspension of a fixture
+	Shock struct {
+		LeagueId string
+		EventId  string
+		Fixture  string
+		Date     string
+		Suspension
+	}
+)
+
+const (
+	// Goal is inferred when a team's implied probability rises by at least GoalShift across a suspension
+	Goal = InPlayEvent("goal")
+	// RedCard is inferred when a team's implied probability falls by at least RedCardShift without a goal
+	RedCard = InPlayEvent("red_card")
+
+	HomeSide = Side("home")
+	AwaySide = Side("away")
+
+	// Betfair market status of a suspended market
+	MarketSuspended = "SUSPENDED"
+	MarketOpen      = "OPEN"
+
+	// InPlayWindow is how long after kickoff a fixture is polled in play, long enough for stoppages and extra time
+	InPlayWindow = 3 * time.Hour
+)
+
+var (
+	// GoalShift and RedCardShift are the changes in implied probability that mark a suspension as a goal or red card
+	GoalShift    = 0.1
+	RedCardShift = 0.04
+)
+
+// addMarketState records the state of the market book the sample was taken from
+func addMarketState(price *Price, book *types.MarketBookWrapper) {
+	price.MarketStatus = book.Status
+	price.Inplay = book.Inplay
+	price.BetDelay = book.BetDelay
+}
+
+// preMatch returns the samples taken before the market went in play
+func preMatch(history []Price) []Price {
+	for i, price := range history {
+		if price.Inplay {
+			return history[:i]
+		}
+	}
+	return history
+}
+
+// updateSuspensions marks the in-play suspensions of the fixture from its home and away histories, reporting
+// whether they changed
+func (f *FixturePrices) updateSuspensions() bool {
+	suspensions := findSuspensions(f.PriceHistory[f.HomeRunnerId], f.PriceHistory[f.AwayRunnerId])
+	if len(suspensions) == len(f.Suspensions) {
+		changed := false
+		for i := range suspensions {
+			changed = changed || suspensions[i] != f.Suspensions[i]
+		}
+		if !changed {
+			return false
+		}
+	}
+	f.Suspensions = suspensions
+	return true
+}
+
+// findSuspensions walks the in-play samples of the home runner, pairing each with the away sample taken at the same
+// time. A suspension runs from the first suspended sample to the next open one, and is compared against the last
+// open sample before it with prices for both teams
+func findSuspensions(home []Price, away []Price) []Suspension {
+	awayAt := map[string]Price{}
+	for _, price := range away {
+		awayAt[price.Timestamp] = price
+	}
+	var suspensions []Suspension
+	var open *Suspension
+	var homeBefore, awayBefore float32
+	for _, price := range home {
+		if !price.Inplay {
+			continue
+		}
+		awayPrice := awayAt[price.Timestamp]
+		switch price.MarketStatus {
+		case MarketSuspended:
+			if open == nil {
+				open = &Suspension{Start: price.Timestamp, HomeBefore: homeBefore, AwayBefore: awayBefore}
+			}
+		case MarketOpen:
+			if price.BackPrice <= 0 || awayPrice.BackPrice <= 0 {
+				continue
+			}
+			if open != nil {
+				open.End, open.HomeAfter, open.AwayAfter = price.Timestamp, price.BackPrice, awayPrice.BackPrice
+				open.Event, open.Team = inferEvent(open)
+				suspensions = append(suspensions, *open)
+				open = nil
+			}
+			homeBefore, awayBefore = price.BackPrice, awayPrice.BackPrice
+		}
+	}
+	if open != nil {
+		suspensions = append(suspensions, *open)
+	}
+	return suspensions
+}
+
+// inferEvent reads the likely cause of a suspension from how the teams' implied probabilities moved across it. The
+// team that scored shortens the most, a team shown a red card drifts
+func inferEvent(suspension *Suspension) (InPlayEvent, Side) {
+	home := ImpliedShift(suspension.HomeBefore, suspension.HomeAfter)
+	away := ImpliedShift(suspension.AwayBefore, suspension.AwayAfter)
+	switch {
+	case home >= GoalShift && home >= away:
+		return Goal, HomeSide
+	case away >= GoalShift:
+		return Goal, AwaySide
+	case home <= -RedCardShift && home <= away:
+		return RedCard, HomeSide
+	case away <= -RedCardShift:
+		return RedCard, AwaySide
+	}
+	return "", ""
+}
+
+// ImpliedShift is the change in implied probability from one price to another, 0 if either is unknown
+func ImpliedShift(before float32, after float32) float64 {
+	if before <= 0 || after <= 0 {
+		return 0
+	}
+	return 1/float64(after) - 1/float64(before)
+}
+
+// Duration is how long the market was suspended, 0 while it is still suspended
+func (s *Suspension) Duration() time.Duration {
+	start, err := time.Parse(time.RFC3339, s.Start)
+	if err != nil {
+		return 0
+	}
+	end, err := time.Parse(time.RFC3339, s.End)
+	if err != nil {
+		return 0
+	}
+	return end.Sub(start)
+}
+
+// ExtractShocks returns the in-play suspensions of every fixture in kickoff and suspension order
+func (s *Store) ExtractShocks() []Shock {
+	shocks := []Shock{}
+	for leagueId, league := range s.GlobalPriceStore {
+		for eventId, fixture := range league {
+			for _, suspension := range fixture.Suspensions {
+				shocks = append(shocks, Shock{LeagueId: leagueId, EventId: eventId, Fixture: fixture.Fixture, Date: fixture.Date, Suspension: suspension})
+			}
+		}
+	}
+	sort.SliceStable(shocks, func(i, j int) bool {
+		a, b := &shocks[i], &shocks[j]
+		switch {
+		case a.Date != b.Date:
+			return a.Date < b.Date
+		case a.EventId != b.EventId:
+			return a.EventId < b.EventId
+		}
+		return a.Start < b.Start
+	})
+	return shocks
+}
+
+// DueInPlayFixtures returns the market ids, keyed by league, of tracked fixtures that kicked off within the in-play
+// window, whose market has not closed and whose last sample is older than the interval
+func (s *Store) DueInPlayFixtures(interval time.Duration, now time.Time) map[string][]string {
+	due := map[string][]string{}
+	for leagueId, league := range s.GlobalPriceStore {
+		for _, fixture := range league {
+			if fixture.MarketID == "" || fixture.MatchStatus != Scheduled || !fixture.IsTracked() {
+				continue
+			}
+			kickoff, err := time.Parse(time.RFC3339, fixture.Date)
+			if err != nil || now.Before(kickoff) || now.Sub(kickoff) > InPlayWindow {
+				continue
+			}
+			if fixture.lastMarketStatus() == MarketClosed {
+				continue
+			}
+			lastSample, ok := fixture.lastSampleTime()
+			if ok && now.Sub(lastSample) < interval {
+				continue
+			}
+			due[leagueId] = append(due[leagueId], fixture.MarketID)
+		}
+	}
+	for leagueId := range due {
+		sort.Strings(due[leagueId])
+	}
+	return due
+}
+
+// lastMarketStatus returns the market status recorded with the home runner's latest sample
+func (f *FixturePrices) lastMarketStatus() string {
+	history := f.PriceHistory[f.HomeRunnerId]
+	if len(history) == 0 {
+		return ""
+	}
+	return history[len(history)-1].MarketStatus
+}
@@ -0,0 +1,140 @@
+// Copyright 2022 Guy Barden
+// inplay_test.go - tests for in-play tracking and suspension detection
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"guysports/go-football-trader/pkg/access"
+	"guysports/go-football-trader/pkg/fake"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// inPlaySample is a sample taken at the minute of the match with the market status and best back price
+func inPlaySample(minute int, status string, back float32) Price {
+	return Price{Timestamp: time.Date(2022, 4, 6, 16, 30+minute, 0, 0, time.UTC).Format(time.RFC3339), MarketStatus: status, Inplay: minute >= 0, BackPrice: back}
+}
+
+func Test_findSuspensions(t *testing.T) {
+	tests := []struct {
+		name string
+		home []Price
+		away []Price
+		want []Suspension
+	}{
+		{
+			name: "home goal",
+			home: []Price{inPlaySample(-5, MarketOpen, 2.5), inPlaySample(10, MarketOpen, 2.4), inPlaySample(11, MarketSuspended, 0), inPlaySample(12, MarketOpen, 1.6)},
+			away: []Price{inPlaySample(-5, MarketOpen, 3.2), inPlaySample(10, MarketOpen, 3.3), inPlaySample(11, MarketSuspended, 0), inPlaySample(12, MarketOpen, 6.4)},
+			want: []Suspension{{Start: "2022-04-06T16:41:00Z", End: "2022-04-06T16:42:00Z", Event: Goal, Team: HomeSide, HomeBefore: 2.4, AwayBefore: 3.3, HomeAfter: 1.6, AwayAfter: 6.4}},
+		},
+		{
+			name: "away goal",
+			home: []Price{inPlaySample(10, MarketOpen, 2.4), inPlaySample(11, MarketSuspended, 0), inPlaySample(12, MarketOpen, 5)},
+			away: []Price{inPlaySample(10, MarketOpen, 3.3), inPlaySample(11, MarketSuspended, 0), inPlaySample(12, MarketOpen, 1.9)},
+			want: []Suspension{{Start: "2022-04-06T16:41:00Z", End: "2022-04-06T16:42:00Z", Event: Goal, Team: AwaySide, HomeBefore: 2.4, AwayBefore: 3.3, HomeAfter: 5, AwayAfter: 1.9}},
+		},
+		{
+			name: "home red card",
+			home: []Price{inPlaySample(10, MarketOpen, 2.2), inPlaySample(11, MarketSuspended, 0), inPlaySample(12, MarketOpen, 2.9)},
+			away: []Price{inPlaySample(10, MarketOpen, 3.5), inPlaySample(11, MarketSuspended, 0), inPlaySample(12, MarketOpen, 2.8)},
+			want: []Suspension{{Start: "2022-04-06T16:41:00Z", End: "2022-04-06T16:42:00Z", Event: RedCard, Team: HomeSide, HomeBefore: 2.2, AwayBefore: 3.5, HomeAfter: 2.9, AwayAfter: 2.8}},
+		},
+		{
+			name: "suspension with little price movement",
+			home: []Price{inPlaySample(10, MarketOpen, 2.2), inPlaySample(11, MarketSuspended, 0), inPlaySample(12, MarketSuspended, 0), inPlaySample(13, MarketOpen, 2.24)},
+			away: []Price{inPlaySample(10, MarketOpen, 3.5), inPlaySample(11, MarketSuspended, 0), inPlaySample(12, MarketSuspended, 0), inPlaySample(13, MarketOpen, 3.45)},
+			want: []Suspension{{Start: "2022-04-06T16:41:00Z", End: "2022-04-06T16:43:00Z", HomeBefore: 2.2, AwayBefore: 3.5, HomeAfter: 2.24, AwayAfter: 3.45}},
+		},
+		{
+			name: "still suspended",
+			home: []Price{inPlaySample(10, MarketOpen, 2.2), inPlaySample(11, MarketSuspended, 0)},
+			away: []Price{inPlaySample(10, MarketOpen, 3.5), inPlaySample(11, MarketSuspended, 0)},
+			want: []Suspension{{Start: "2022-04-06T16:41:00Z", HomeBefore: 2.2, AwayBefore: 3.5}},
+		},
+		{
+			name: "pre-match suspensions are ignored",
+			home: []Price{inPlaySample(-10, MarketSuspended, 0), inPlaySample(-5, MarketOpen, 2.2)},
+			away: []Price{inPlaySample(-10, MarketSuspended, 0), inPlaySample(-5, MarketOpen, 3.5)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, findSuspensions(tt.home, tt.away))
+		})
+	}
+}
+
+func TestStore_DueInPlayFixtures(t *testing.T) {
+	now := time.Date(2022, 4, 6, 17, 30, 0, 0, time.UTC)
+	history := func(minutesAgo int, status string) map[int][]Price {
+		return map[int][]Price{1: {{Timestamp: now.Add(-time.Duration(minutesAgo) * time.Minute).Format(time.RFC3339), MarketStatus: status, Inplay: true}}}
+	}
+	s := &Store{GlobalPriceStore: map[string]map[string]FixturePrices{
+		"59": {
+			"due":          {MarketID: "1.1", Date: "2022-04-06T16:30:00Z", MatchStatus: Scheduled, HomeRunnerId: 1, PriceHistory: history(2, MarketOpen)},
+			"suspended":    {MarketID: "1.2", Date: "2022-04-06T16:30:00Z", MatchStatus: Scheduled, HomeRunnerId: 1, PriceHistory: history(1, MarketSuspended)},
+			"recent":       {MarketID: "1.3", Date: "2022-04-06T16:30:00Z", MatchStatus: Scheduled, HomeRunnerId: 1, PriceHistory: history(0, MarketOpen)},
+			"closed":       {MarketID: "1.4", Date: "2022-04-06T16:30:00Z", MatchStatus: Scheduled, HomeRunnerId: 1, PriceHistory: history(5, MarketClosed)},
+			"not started":  {MarketID: "1.5", Date: "2022-04-06T18:30:00Z", MatchStatus: Scheduled, HomeRunnerId: 1, PriceHistory: map[int][]Price{}},
+			"long ago":     {MarketID: "1.6", Date: "2022-04-06T12:00:00Z", MatchStatus: Scheduled, HomeRunnerId: 1, PriceHistory: history(200, MarketOpen)},
+			"played":       {MarketID: "1.7", Date: "2022-04-06T16:30:00Z", MatchStatus: Played, HomeRunnerId: 1, PriceHistory: history(2, MarketOpen)},
+			"skipped":      {MarketID: "1.8", Date: "2022-04-06T16:30:00Z", MatchStatus: Scheduled, HomeRunnerId: 1, PriceHistory: map[int][]Price{}, Admission: &Admission{Status: Skipped}},
+			"kicking off":  {MarketID: "1.9", Date: "2022-04-06T17:30:00Z", MatchStatus: Scheduled, HomeRunnerId: 1, PriceHistory: map[int][]Price{}},
+			"never priced": {Date: "2022-04-06T16:30:00Z", MatchStatus: Scheduled, PriceHistory: map[int][]Price{}},
+		},
+	}}
+	assert.Equal(t, map[string][]string{"59": {"1.1", "1.2", "1.9"}}, s.DueInPlayFixtures(30*time.Second, now))
+}
+
+func TestStore_AddLeaguePricesToStoreInPlay(t *testing.T) {
+	// The odds range admits the fixture on the away price, the goal takes both prices out of range
+	query := &access.MarketQuery{LeagueIds: []string{"league1"}, MinOdds: 2.0, MaxOdds: 3.0, InPlay: true}
+	client := &fake.FakeQuery{}
+	s := &Store{GlobalPriceStore: map[string]map[string]FixturePrices{}, QueryClient: client}
+	assert.Nil(t, s.AddLeaguePricesToStore(query))
+	client.InPlay = true
+	assert.Nil(t, s.AddMarketPricesToStore(query, "league1", []string{"1.195693926"}))
+	client.Suspended = true
+	assert.Nil(t, s.AddMarketPricesToStore(query, "league1", []string{"1.195693926"}))
+	fixture := s.GlobalPriceStore["league1"]["fixture1"]
+	assert.Equal(t, 1, len(fixture.Suspensions))
+	assert.Equal(t, "", fixture.Suspensions[0].End)
+
+	client.Suspended, client.HomeGoal = false, true
+	assert.Nil(t, s.AddMarketPricesToStore(query, "league1", []string{"1.195693926"}))
+	fixture = s.GlobalPriceStore["league1"]["fixture1"]
+	assert.Equal(t, Admitted, fixture.Admission.Status)
+	home := fixture.PriceHistory[64374]
+	assert.Equal(t, 4, len(home))
+	assert.Equal(t, []bool{false, true, true, true}, []bool{home[0].Inplay, home[1].Inplay, home[2].Inplay, home[3].Inplay})
+	assert.Equal(t, []string{MarketOpen, MarketOpen, MarketSuspended, MarketOpen}, []string{home[0].MarketStatus, home[1].MarketStatus, home[2].MarketStatus, home[3].MarketStatus})
+	assert.Equal(t, 5, home[1].BetDelay)
+	assert.Equal(t, 1, len(fixture.Suspensions))
+	assert.Equal(t, Goal, fixture.Suspensions[0].Event)
+	assert.Equal(t, HomeSide, fixture.Suspensions[0].Team)
+	assert.Equal(t, float32(3.7), fixture.Suspensions[0].HomeBefore)
+	assert.Equal(t, float32(1.8), fixture.Suspensions[0].HomeAfter)
+
+	// Trends follow the pre-match samples only
+	for _, trend := range s.ExtractTrendsFromFixtures() {
+		assert.Equal(t, 1, trend.SampleNumber, trend.Team)
+	}
+	shocks := s.ExtractShocks()
+	assert.Equal(t, 1, len(shocks))
+	assert.Equal(t, "Mainz v Dortmund", shocks[0].Fixture)
+}
@@ -0,0 +1,256 @@
+// Copyright 2022 Guy Barden
+// journal.go - write-ahead journal of the prices captured since the store was last saved
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type (
+	// Journal appends each fixture change and price sample to a file as it is captured, so a crash or error loses
+	// nothing that was fetched. Saving the store folds the journal into the snapshot and empties it
+	Journal struct {
+		Path string
+		file *os.File
+		// journaled holds the fixture details last made durable, keyed by league and event
+		journaled map[string]map[string]string
+	}
+
+	// journalRecord is either the details of a fixture, one sample at a position in a runner's history, the
+	// runner is in another market when the record names one, or one sample at a position in a market's history,
+	// the match odds market when the record names no market
+	journalRecord struct {
+		LeagueId     string         `json:"league"`
+		EventId      string         `json:"event"`
+		Fixture      *FixturePrices `json:"fixture,omitempty"`
+		SelectionId  int            `json:"selection,omitempty"`
+		Market       string         `json:"market,omitempty"`
+		Runner       int            `json:"runner,omitempty"`
+		Index        int            `json:"index,omitempty"`
+		Sample       *Price         `json:"sample,omitempty"`
+		MarketSample *MarketSample  `json:"market_sample,omitempty"`
+	}
+)
+
+const (
+	JournalExtension = ".journal"
+)
+
+// JournalPath returns the journal kept next to a store file or directory
+func JournalPath(storePath string) string {
+	return strings.TrimSuffix(storePath, filepath.Ext(storePath)) + JournalExtension
+}
+
+// NewJournal creates a journal at path, the file is opened when the first record is written
+func NewJournal(path string) *Journal {
+	return &Journal{
+		Path:      path,
+		journaled: map[string]map[string]string{},
+	}
+}
+
+// AttachJournal replays the journal over the fixtures loaded from the snapshot and records new prices to it from
+// now on. The replayed changes are written to the backend by the next save
+func (s *Store) AttachJournal(journal *Journal) error {
+	err := decodeLines(journal.Path, func(line []byte) error {
+		record := journalRecord{}
+		if err := json.Unmarshal(line, &record); err != nil {
+			return err
+		}
+		s.applyJournalRecord(&record)
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for leagueId, league := range s.GlobalPriceStore {
+		for eventId, fixture := range league {
+			journal.markJournaled(leagueId, eventId, fixtureDetails(&fixture))
+		}
+	}
+	s.Journal = journal
+	return nil
+}
+
+// applyJournalRecord replaces the details of a fixture or adds a sample. Samples already in the history, because
+// the snapshot was saved but the journal was not emptied, are skipped
+func (s *Store) applyJournalRecord(record *journalRecord) {
+	fixture, ok := s.GlobalPriceStore[record.LeagueId][record.EventId]
+	if record.Fixture != nil {
+		fixture = keepHistories(*record.Fixture, &fixture)
+		if s.GlobalPriceStore[record.LeagueId] == nil {
+			s.GlobalPriceStore[record.LeagueId] = map[string]FixturePrices{}
+		}
+		s.GlobalPriceStore[record.LeagueId][record.EventId] = fixture
+		return
+	}
+	if ok && record.MarketSample != nil {
+		history, found := fixture.marketHistory(record.Market)
+		if !found || record.Index < len(history) {
+			return
+		}
+		fixture.setMarketHistory(record.Market, append(history, *record.MarketSample))
+		s.GlobalPriceStore[record.LeagueId][record.EventId] = fixture
+		return
+	}
+	if !ok || record.Sample == nil {
+		return
+	}
+	if record.Market != "" {
+		market, ok := fixture.Markets[record.Market]
+		if !ok || record.Runner >= len(market.Runners) || record.Index < len(market.Runners[record.Runner].History) {
+			return
+		}
+		market.Runners[record.Runner].History = append(market.Runners[record.Runner].History, *record.Sample)
+		return
+	}
+	if record.Index < len(fixture.PriceHistory[record.SelectionId]) {
+		return
+	}
+	fixture.PriceHistory[record.SelectionId] = append(fixture.PriceHistory[record.SelectionId], *record.Sample)
+}
+
+// fixture adds a record of the fixture details to the pending records if they have changed since last journaled
+func (j *Journal) fixture(pending []journalRecord, leagueId string, eventId string, fixture *FixturePrices) []journalRecord {
+	if j == nil {
+		return pending
+	}
+	details := fixtureDetails(fixture)
+	if j.journaled[leagueId][eventId] == details {
+		return pending
+	}
+	record := withoutHistories(*fixture)
+	return append(pending, journalRecord{LeagueId: leagueId, EventId: eventId, Fixture: &record})
+}
+
+// sample adds a record of the sample at index in the runner's history to the pending records
+func (j *Journal) sample(pending []journalRecord, leagueId string, eventId string, selectionId int, index int, sample Price) []journalRecord {
+	if j == nil {
+		return pending
+	}
+	return append(pending, journalRecord{LeagueId: leagueId, EventId: eventId, SelectionId: selectionId, Index: index, Sample: &sample})
+}
+
+// runnerSample adds a record of the sample at index in the history of the runner at a position in another market
+func (j *Journal) runnerSample(pending []journalRecord, leagueId string, eventId string, marketType string, runner int, index int, sample Price) []journalRecord {
+	if j == nil {
+		return pending
+	}
+	return append(pending, journalRecord{LeagueId: leagueId, EventId: eventId, Market: marketType, Runner: runner, Index: index, Sample: &sample})
+}
+
+// marketSample adds a record of the market sample at index in the history of the market, match odds if the market
+// type is empty
+func (j *Journal) marketSample(pending []journalRecord, leagueId string, eventId string, marketType string, index int, sample MarketSample) []journalRecord {
+	if j == nil {
+		return pending
+	}
+	return append(pending, journalRecord{LeagueId: leagueId, EventId: eventId, Market: marketType, Index: index, MarketSample: &sample})
+}
+
+// write appends the records to the journal and syncs it to disk
+func (j *Journal) write(records []journalRecord) error {
+	if j == nil || len(records) == 0 {
+		return nil
+	}
+	if j.file == nil {
+		file, err := os.OpenFile(j.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		j.file = file
+	}
+	buffer := bytes.Buffer{}
+	for _, record := range records {
+		line, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		buffer.Write(append(line, '\n'))
+	}
+	if _, err := j.file.Write(buffer.Bytes()); err != nil {
+		return err
+	}
+	if err := j.file.Sync(); err != nil {
+		return err
+	}
+	for _, record := range records {
+		if record.Fixture != nil {
+			j.markJournaled(record.LeagueId, record.EventId, fixtureDetails(record.Fixture))
+		}
+	}
+	return nil
+}
+
+// Truncate empties the journal once its records are in the snapshot
+func (j *Journal) Truncate() error {
+	if j == nil {
+		return nil
+	}
+	if j.file != nil {
+		return j.file.Truncate(0)
+	}
+	err := os.Truncate(j.Path, 0)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// Close closes the journal file, it is reopened if more records are written
+func (j *Journal) Close() error {
+	if j == nil || j.file == nil {
+		return nil
+	}
+	err := j.file.Close()
+	j.file = nil
+	return err
+}
+
+func (j *Journal) markJournaled(leagueId string, eventId string, details string) {
+	if j.journaled[leagueId] == nil {
+		j.journaled[leagueId] = map[string]string{}
+	}
+	j.journaled[leagueId][eventId] = details
+}
+
+// decodeLines passes each line of the file to decode. A line without its closing newline at the end of the file is
+// from a write that did not complete and is ignored if it cannot be decoded
+func decodeLines(path string, decode func(line []byte) error) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	lines := bytes.Split(data, []byte("\n"))
+	for i, line := range lines {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		if err := decode(line); err != nil {
+			if i == len(lines)-1 {
+				return nil
+			}
+			return fmt.Errorf("unable to read record %d of %s: %s", i+1, path, err.Error())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+// Copyright 2022 Guy Barden
+// journal_test.go - tests for the write-ahead journal
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"encoding/json"
+	"guysports/go-football-trader/pkg/access"
+	"guysports/go-football-trader/pkg/fake"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestStore opens the store at path, failing the test if it cannot be loaded
+func newTestStore(t *testing.T, path string, qc access.QueryInterface) *Store {
+	s, err := NewStore(path, qc)
+	assert.Nil(t, err)
+	return s
+}
+
+func TestJournalPath(t *testing.T) {
+	assert.Equal(t, "store/store.journal", JournalPath("store/store.json"))
+	assert.Equal(t, "store/log.journal", JournalPath("store/log"))
+}
+
+func TestStore_Journal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.json")
+	journalPath := JournalPath(path)
+	query := &access.MarketQuery{LeagueIds: []string{"league1"}, MinOdds: 2.0, MaxOdds: 3.7}
+	client := fake.FakeQuery{}
+
+	// Two polling rounds are captured but the store is never saved
+	s := newTestStore(t, path, &client)
+	assert.Nil(t, s.AddLeaguePricesToStore(query))
+	client.AppendPrices = true
+	assert.Nil(t, s.AddLeaguePricesToStore(query))
+	assert.Nil(t, s.Journal.Close())
+	assert.Equal(t, 2, len(s.GlobalPriceStore["league1"]["fixture1"].PriceHistory[64374]))
+
+	recovered := newTestStore(t, path, &client)
+	assert.Equal(t, s.GlobalPriceStore, recovered.GlobalPriceStore)
+	journal, err := ioutil.ReadFile(journalPath)
+	assert.Nil(t, err)
+
+	// Saving folds the journal into the snapshot
+	assert.Nil(t, recovered.SaveStoreToFile())
+	assert.Nil(t, recovered.Journal.Close())
+	info, err := os.Stat(journalPath)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), info.Size())
+	assert.Equal(t, s.GlobalPriceStore, newTestStore(t, path, nil).GlobalPriceStore)
+
+	// Samples already in the snapshot are not added again if the journal was not emptied
+	assert.Nil(t, ioutil.WriteFile(journalPath, journal, 0644))
+	assert.Equal(t, s.GlobalPriceStore, newTestStore(t, path, nil).GlobalPriceStore)
+
+	// A record cut short by a crash is ignored
+	assert.Nil(t, ioutil.WriteFile(journalPath, append(journal, []byte(`{"league":"league1","event":"fix`)...), 0644))
+	assert.Equal(t, s.GlobalPriceStore, newTestStore(t, path, nil).GlobalPriceStore)
+}
+
+func TestStore_JournalOnlyChangedDetails(t *testing.T) {
+	dir := t.TempDir()
+	s := &Store{
+		GlobalPriceStore: map[string]map[string]FixturePrices{},
+		QueryClient:      &fake.FakeQuery{},
+	}
+	journal := NewJournal(filepath.Join(dir, "store.journal"))
+	assert.Nil(t, s.AttachJournal(journal))
+	query := &access.MarketQuery{LeagueIds: []string{"league1"}}
+	assert.Nil(t, s.AddLeaguePricesToStore(query))
+	assert.Nil(t, s.AddLeaguePricesToStore(query))
+	assert.Nil(t, journal.Close())
+
+	fixtures, samples, marketSamples := 0, 0, 0
+	assert.Nil(t, decodeLines(journal.Path, func(line []byte) error {
+		record := journalRecord{}
+		if err := json.Unmarshal(line, &record); err != nil {
+			return err
+		}
+		switch {
+		case record.Fixture != nil:
+			fixtures++
+		case record.MarketSample != nil:
+			marketSamples++
+		default:
+			samples++
+		}
+		return nil
+	}))
+	// The fixture is journaled once, then the home, away and draw samples and the market sample of each round
+	assert.Equal(t, 1, fixtures)
+	assert.Equal(t, 6, samples)
+	assert.Equal(t, 2, marketSamples)
+}
@@ -0,0 +1,187 @@
+// Copyright 2022 Guy Barden
+// lock.go - advisory lock on the store and atomic replacement of its files
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+type (
+	// Lock is an advisory lock file held while a process loads, changes and saves the store. Readers do not need
+	// the lock as the store files are replaced atomically
+	Lock struct {
+		Path  string
+		Owner LockOwner
+	}
+
+	// LockOwner identifies the process holding a lock
+	LockOwner struct {
+		Pid      int    `json:"pid"`
+		Hostname string `json:"hostname"`
+		Since    string `json:"since"`
+	}
+
+	// LockedError is returned when another process holds the lock
+	LockedError struct {
+		Path  string
+		Owner LockOwner
+	}
+)
+
+const (
+	LockExtension = ".lock"
+)
+
+// LockPath returns the lock file kept next to a store file or directory
+func LockPath(storePath string) string {
+	return strings.TrimSuffix(storePath, filepath.Ext(storePath)) + LockExtension
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("store is locked by process %d on %s since %s, remove %s if that process is no longer running",
+		e.Owner.Pid, e.Owner.Hostname, e.Owner.Since, e.Path)
+}
+
+// AcquireLock creates the lock file, failing with a LockedError if another process holds it. A lock left behind
+// by a process on this host that is no longer running is taken over
+func AcquireLock(path string) (*Lock, error) {
+	hostname, _ := os.Hostname()
+	lock := Lock{
+		Path:  path,
+		Owner: LockOwner{Pid: os.Getpid(), Hostname: hostname, Since: time.Now().Format(time.RFC3339)},
+	}
+	ownerBytes, err := json.Marshal(lock.Owner)
+	if err != nil {
+		return nil, err
+	}
+	for attempt := 0; attempt < 2; attempt++ {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			_, err = file.Write(ownerBytes)
+			if closeErr := file.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				_ = os.Remove(path)
+				return nil, err
+			}
+			return &lock, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		owner := LockOwner{}
+		existing, err := ioutil.ReadFile(path)
+		if err != nil {
+			// The lock was released between trying to create and read it
+			continue
+		}
+		if err := json.Unmarshal(existing, &owner); err != nil || owner.Hostname != hostname || processRunning(owner.Pid) {
+			return nil, &LockedError{Path: path, Owner: owner}
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	return nil, fmt.Errorf("unable to acquire the store lock %s", path)
+}
+
+// Release removes the lock file
+func (l *Lock) Release() error {
+	if l == nil {
+		return nil
+	}
+	return os.Remove(l.Path)
+}
+
+// processRunning reports whether a process with the id exists on this host
+func processRunning(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	err = process.Signal(syscall.Signal(0))
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// WriteFileAtomic replaces the file at path with data, so readers see either the old or the new file in full. The
+// data is written and synced to a temporary file in the same directory, which is then renamed over the file
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	temp, err := ioutil.TempFile(dir, "."+filepath.Base(path)+".tmp-")
+	if err != nil {
+		return err
+	}
+	// Removing the temporary file fails harmlessly once it has been renamed
+	defer os.Remove(temp.Name())
+	if _, err := temp.Write(data); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Sync(); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(temp.Name(), perm); err != nil {
+		return err
+	}
+	if err := os.Rename(temp.Name(), path); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// syncDir makes a rename in the directory durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	// Not every platform can sync a directory, the rename has still happened
+	_ = d.Sync()
+	return nil
+}
+
+// archiveFile keeps a copy of the file at the archive path without the file ever going missing, by linking it
+// where the filesystem allows and copying it where it does not
+func archiveFile(path string, archivePath string) error {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil
+	}
+	if err := os.Link(path, archivePath); err == nil {
+		return nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return WriteFileAtomic(archivePath, data, 0644)
+}
@@ -0,0 +1,103 @@
+// Copyright 2022 Guy Barden
+// lock_test.go - tests for the store lock and atomic writes
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAcquireLock(t *testing.T) {
+	hostname, _ := os.Hostname()
+	tests := []struct {
+		name       string
+		existing   *LockOwner
+		wantLocked bool
+	}{
+		{
+			name: "no lock held",
+		},
+		{
+			name:       "held by a running process",
+			existing:   &LockOwner{Pid: os.Getpid(), Hostname: hostname},
+			wantLocked: true,
+		},
+		{
+			name:     "left by a process that is no longer running",
+			existing: &LockOwner{Pid: 1 << 30, Hostname: hostname},
+		},
+		{
+			name:       "held by a process on another host",
+			existing:   &LockOwner{Pid: 1 << 30, Hostname: hostname + "-other"},
+			wantLocked: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := LockPath(filepath.Join(t.TempDir(), "store.json"))
+			if tt.existing != nil {
+				ownerBytes, _ := json.Marshal(tt.existing)
+				assert.Nil(t, ioutil.WriteFile(path, ownerBytes, 0644))
+			}
+			lock, err := AcquireLock(path)
+			if tt.wantLocked {
+				assert.IsType(t, &LockedError{}, err)
+				assert.Equal(t, tt.existing.Pid, err.(*LockedError).Owner.Pid)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, os.Getpid(), lock.Owner.Pid)
+
+			_, err = AcquireLock(path)
+			assert.IsType(t, &LockedError{}, err)
+			assert.Nil(t, lock.Release())
+			lock, err = AcquireLock(path)
+			assert.Nil(t, err)
+			assert.Nil(t, lock.Release())
+		})
+	}
+}
+
+func TestJSONBackend_FlushReplacesAtomically(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "store.json")
+	assert.Nil(t, ioutil.WriteFile(path, []byte(`{"59":{"fixture1":{"fixture":"Mainz v Dortmund","history":{}}}}`), 0644))
+	backend := NewJSONBackend(path)
+	assert.Nil(t, backend.DeleteFixture("59", "fixture1"))
+	assert.Nil(t, backend.Flush())
+
+	entries, err := ioutil.ReadDir(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(entries))
+	data, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"schema_version":4,"leagues":{"59":{}}}`, string(data))
+	info, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0644), info.Mode().Perm())
+
+	archives, err := ListArchives(path)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(archives))
+	data, err = ioutil.ReadFile(archives[0].Path)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"59":{"fixture1":{"fixture":"Mainz v Dortmund","history":{}}}}`, string(data))
+}
@@ -0,0 +1,148 @@
+// Copyright 2022 Guy Barden
+// markethistory.go - records the state of a whole market with each sample, its liquidity, version and overround
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"time"
+
+	"github.com/guysports/go-betfair-api/pkg/types"
+)
+
+type (
+	// MarketSample is the state of a market when its runners were sampled. The overrounds are the sum of the implied
+	// probabilities of the best back and best lay prices of the active runners, 0 when any of them has no price or the
+	// market holds handicap lines
+	MarketSample struct {
+		Timestamp      string  `json:"time_stamp"`
+		TotalMatched   float32 `json:"total_matched"`
+		TotalAvailable float32 `json:"total_available"`
+		Version        int64   `json:"version"`
+		LastMatchTime  string  `json:"last_match_time,omitempty"`
+		Delayed        bool    `json:"delayed,omitempty"`
+		Inplay         bool    `json:"inplay,omitempty"`
+		BackOverround  float32 `json:"back_overround"`
+		LayOverround   float32 `json:"lay_overround"`
+	}
+)
+
+const (
+	// RunnerActive is the Betfair status of a runner still in the market
+	RunnerActive = "ACTIVE"
+)
+
+// newMarketSample records the state of the market book, taken at the same time as its runners' prices so the two
+// samples join on the timestamp
+func newMarketSample(book *types.MarketBookWrapper, sampledAt time.Time) MarketSample {
+	sample := MarketSample{
+		Timestamp:      sampledAt.Format(time.RFC3339),
+		TotalMatched:   book.TotalMatched,
+		TotalAvailable: book.TotalAvailable,
+		Version:        book.Version,
+		LastMatchTime:  book.LastMatchTime,
+		Delayed:        book.IsMarketDataDelayed,
+		Inplay:         book.Inplay,
+	}
+	sample.BackOverround, sample.LayOverround = overround(book.Runners)
+	return sample
+}
+
+// overround sums the implied probabilities of the best back and best lay prices of the active runners. The runners of
+// an asian handicap market are priced against several handicap lines, so their sum is not an overround and is left at 0
+func overround(runners []types.Runner) (float32, float32) {
+	for _, runner := range runners {
+		if runner.Handicap != 0 {
+			return 0, 0
+		}
+	}
+	var back, lay float32
+	backComplete, layComplete := true, true
+	for _, runner := range runners {
+		if runner.Status != RunnerActive {
+			continue
+		}
+		backPrice, _ := returnBestPrice(runner.Exchange.AvailableToBack, true)
+		layPrice, _ := returnBestPrice(runner.Exchange.AvailableToLay, false)
+		if backPrice > 0 {
+			back += 1 / backPrice
+		} else {
+			backComplete = false
+		}
+		if layPrice > 0 {
+			lay += 1 / layPrice
+		} else {
+			layComplete = false
+		}
+	}
+	if !backComplete {
+		back = 0
+	}
+	if !layComplete {
+		lay = 0
+	}
+	return back, lay
+}
+
+// marketHistory returns the market history of the match odds market, or of another market when the type is set,
+// reporting whether the market is held by the fixture
+func (f *FixturePrices) marketHistory(marketType string) ([]MarketSample, bool) {
+	if marketType == "" {
+		return f.MarketHistory, true
+	}
+	market, ok := f.Markets[marketType]
+	return market.MarketHistory, ok
+}
+
+// setMarketHistory replaces the market history of the match odds market, or of another market when the type is set
+func (f *FixturePrices) setMarketHistory(marketType string, history []MarketSample) {
+	if marketType == "" {
+		f.MarketHistory = history
+		return
+	}
+	if market, ok := f.Markets[marketType]; ok {
+		market.MarketHistory = history
+		f.Markets[marketType] = market
+	}
+}
+
+// marketTypes returns the market types whose history the fixture holds, with match odds as the empty type
+func (f *FixturePrices) marketTypes() []string {
+	marketTypes := []string{""}
+	for marketType := range f.Markets {
+		marketTypes = append(marketTypes, marketType)
+	}
+	return marketTypes
+}
+
+// addMarketLiquidity gives the trends the total matched in the market at its last pre-match sample, and flags them
+// if any pre-match sample of the market was taken from delayed data
+func addMarketLiquidity(trends Trends, history []MarketSample) {
+	var latest *MarketSample
+	delayed := false
+	for i := range history {
+		if history[i].Inplay {
+			break
+		}
+		latest = &history[i]
+		delayed = delayed || latest.Delayed
+	}
+	if latest == nil {
+		return
+	}
+	for i := range trends {
+		trends[i].MarketMatched = latest.TotalMatched
+		trends[i].Delayed = delayed
+	}
+}
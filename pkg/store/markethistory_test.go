@@ -0,0 +1,164 @@
+// Copyright 2022 Guy Barden
+// markethistory_test.go - tests for recording the state of each market with its samples
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"guysports/go-football-trader/pkg/fake"
+	"path/filepath"
+	"testing"
+
+	"github.com/guysports/go-betfair-api/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_overround(t *testing.T) {
+	runner := func(status string, back []types.Odds, lay []types.Odds) types.Runner {
+		return types.Runner{Status: status, Exchange: types.ExchangePrices{AvailableToBack: back, AvailableToLay: lay}}
+	}
+	handicap := func(selectionId int, handicap float32, back float32, lay float32) types.Runner {
+		return types.Runner{SelectionID: selectionId, Handicap: handicap, Status: RunnerActive, Exchange: types.ExchangePrices{
+			AvailableToBack: []types.Odds{{Price: back, Size: 10}}, AvailableToLay: []types.Odds{{Price: lay, Size: 10}}}}
+	}
+	tests := []struct {
+		name     string
+		runners  []types.Runner
+		wantBack float32
+		wantLay  float32
+	}{
+		{
+			name: "best prices of every runner",
+			runners: []types.Runner{
+				runner(RunnerActive, []types.Odds{{Price: 1.9, Size: 10}, {Price: 2, Size: 10}}, []types.Odds{{Price: 2.5, Size: 10}, {Price: 2.02, Size: 10}}),
+				runner(RunnerActive, []types.Odds{{Price: 2, Size: 10}}, []types.Odds{{Price: 2.04, Size: 10}}),
+			},
+			wantBack: 1,
+			wantLay:  float32(1/2.02) + float32(1/2.04),
+		},
+		{
+			name: "removed runners are left out",
+			runners: []types.Runner{
+				runner(RunnerActive, []types.Odds{{Price: 2, Size: 10}}, []types.Odds{{Price: 2.02, Size: 10}}),
+				runner(RunnerActive, []types.Odds{{Price: 2, Size: 10}}, []types.Odds{{Price: 2.02, Size: 10}}),
+				runner("REMOVED", nil, nil),
+			},
+			wantBack: 1,
+			wantLay:  float32(1/2.02) + float32(1/2.02),
+		},
+		{
+			name: "a side missing a price is unknown",
+			runners: []types.Runner{
+				runner(RunnerActive, []types.Odds{{Price: 2, Size: 10}}, []types.Odds{{Price: 2.02, Size: 10}}),
+				runner(RunnerActive, []types.Odds{{Price: 2, Size: 10}}, nil),
+			},
+			wantBack: 1,
+		},
+		{
+			name: "handicap lines are not an overround",
+			runners: []types.Runner{
+				handicap(1, -0.5, 1.98, 2),
+				handicap(2, 0.5, 1.96, 1.98),
+				handicap(1, -1, 2.5, 2.52),
+				handicap(2, 1, 1.6, 1.62),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			back, lay := overround(tt.runners)
+			assert.Equal(t, tt.wantBack, back)
+			assert.Equal(t, tt.wantLay, lay)
+		})
+	}
+}
+
+func TestStore_MarketHistorySaved(t *testing.T) {
+	for name, open := range testBackends(t) {
+		t.Run(name, func(t *testing.T) {
+			client := &fake.FakeQuery{}
+			s, err := LoadStore(open(), client, nil)
+			assert.Nil(t, err)
+			assert.Nil(t, s.AddLeaguePricesToStore(testMarketsQuery()))
+			assert.Nil(t, s.SaveStoreToFile())
+
+			reloaded, err := LoadStore(open(), client, nil)
+			assert.Nil(t, err)
+			assert.Equal(t, s.GlobalPriceStore, reloaded.GlobalPriceStore)
+			assert.Nil(t, reloaded.AddLeaguePricesToStore(testMarketsQuery()))
+			assert.Nil(t, reloaded.SaveStoreToFile())
+			again, err := LoadStore(open(), client, nil)
+			assert.Nil(t, err)
+			assert.Equal(t, reloaded.GlobalPriceStore, again.GlobalPriceStore)
+
+			fixture := again.GlobalPriceStore["league1"]["fixture1"]
+			assert.Equal(t, 2, len(fixture.MarketHistory))
+			assert.Equal(t, int64(4417661231), fixture.MarketHistory[1].Version)
+			assert.True(t, fixture.MarketHistory[1].Delayed)
+			assert.Equal(t, 2, len(fixture.Markets[fake.OverUnderMarketType].MarketHistory))
+			assert.Equal(t, float32(1/2.1)+float32(1/1.9), fixture.Markets[fake.OverUnderMarketType].MarketHistory[0].BackOverround)
+			assert.Equal(t, float32(0), fixture.Markets[fake.AsianHandicapMarketType].MarketHistory[0].BackOverround)
+
+			// Each market sample joins the runner samples taken from the same book
+			for i, sample := range fixture.MarketHistory {
+				assert.Equal(t, sample.Timestamp, fixture.PriceHistory[fixture.HomeRunnerId][i].Timestamp)
+			}
+			for marketType, market := range fixture.Markets {
+				for i, sample := range market.MarketHistory {
+					assert.Equal(t, sample.Timestamp, market.Runners[0].History[i].Timestamp, marketType)
+				}
+			}
+		})
+	}
+}
+
+func TestStore_MarketHistoryJournal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.json")
+	client := &fake.FakeQuery{}
+	s := newTestStore(t, path, client)
+	assert.Nil(t, s.AddLeaguePricesToStore(testMarketsQuery()))
+	assert.Nil(t, s.SaveStoreToFile())
+	assert.Nil(t, s.AddLeaguePricesToStore(testMarketsQuery()))
+	assert.Nil(t, s.Journal.Close())
+
+	recovered := newTestStore(t, path, client)
+	assert.Equal(t, s.GlobalPriceStore, recovered.GlobalPriceStore)
+	assert.Equal(t, 2, len(recovered.GlobalPriceStore["league1"]["fixture1"].MarketHistory))
+	assert.Equal(t, 2, len(recovered.GlobalPriceStore["league1"]["fixture1"].Markets[fake.AsianHandicapMarketType].MarketHistory))
+}
+
+func TestStore_ExtractTrendsMarketLiquidity(t *testing.T) {
+	client := &fake.FakeQuery{}
+	s := &Store{GlobalPriceStore: map[string]map[string]FixturePrices{}, QueryClient: client}
+	assert.Nil(t, s.AddLeaguePricesToStore(testMarketsQuery()))
+	assert.Nil(t, s.AddLeaguePricesToStore(testMarketsQuery()))
+	// In-play samples are not the liquidity before kickoff
+	client.InPlay = true
+	fixture := s.GlobalPriceStore["league1"]["fixture1"]
+	fixture.MarketHistory[1].TotalMatched = 950000
+	assert.Nil(t, s.AddMarketPricesToStore(testMarketsQuery(), "league1", []string{"1.195693926"}))
+
+	trends := s.ExtractTrendsFromFixtures()
+	assert.NotEmpty(t, trends)
+	for _, trend := range trends {
+		if trend.MarketType == "" {
+			assert.Equal(t, float32(950000), trend.MarketMatched, trend.Team)
+			assert.True(t, trend.Delayed, trend.Team)
+			continue
+		}
+		// The fake's other markets report nothing matched and live data
+		assert.Equal(t, float32(0), trend.MarketMatched, trend.Team)
+		assert.False(t, trend.Delayed, trend.Team)
+	}
+}
@@ -0,0 +1,295 @@
+// Copyright 2022 Guy Barden
+// markets.go - tracks the other markets of a fixture, such as over/under, both teams to score, correct score and
+// asian handicap, alongside its match odds
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"fmt"
+	"guysports/go-football-trader/pkg/access"
+	"sort"
+	"time"
+)
+
+type (
+	// MarketPrices holds the price history of another market of a fixture. Runners are kept in catalogue order and
+	// identified by their position, as asian handicap runners share a selection id across their handicaps
+	MarketPrices struct {
+		MarketID   string         `json:"market_id"`
+		MarketName string         `json:"market_name"`
+		Runners    []MarketRunner `json:"runners"`
+		// MarketHistory holds the state of the market at each sample
+		MarketHistory []MarketSample `json:"market_history,omitempty"`
+	}
+
+	// MarketRunner is a runner with its price history and its Betfair status once the market is settled
+	MarketRunner struct {
+		SelectionId int     `json:"selection_id"`
+		Name        string  `json:"name"`
+		Handicap    float32 `json:"handicap,omitempty"`
+		Status      string  `json:"status,omitempty"`
+		History     []Price `json:"history"`
+	}
+
+	// marketRef locates one of the other markets in a league
+	marketRef struct {
+		eventId    string
+		marketType string
+	}
+)
+
+// Label names the runner with its handicap, if it has one
+func (r *MarketRunner) Label() string {
+	if r.Handicap == 0 {
+		return r.Name
+	}
+	return fmt.Sprintf("%s %+g", r.Name, r.Handicap)
+}
+
+// runnerIndex returns the position of the runner with the selection id and handicap, or -1
+func (m *MarketPrices) runnerIndex(selectionId int, handicap float32) int {
+	for i, runner := range m.Runners {
+		if runner.SelectionId == selectionId && runner.Handicap == handicap {
+			return i
+		}
+	}
+	return -1
+}
+
+// settled reports whether every runner of the market has its final status
+func (m *MarketPrices) settled() bool {
+	for _, runner := range m.Runners {
+		if runner.Status == "" {
+			return false
+		}
+	}
+	return len(m.Runners) > 0
+}
+
+// withoutHistories returns the details of a fixture, with no match odds history and no history in its markets
+func withoutHistories(fixture FixturePrices) FixturePrices {
+	fixture.PriceHistory = nil
+	fixture.MarketHistory = nil
+	if fixture.Markets != nil {
+		markets := map[string]MarketPrices{}
+		for marketType, market := range fixture.Markets {
+			runners := make([]MarketRunner, len(market.Runners))
+			for i, runner := range market.Runners {
+				runner.History = nil
+				runners[i] = runner
+			}
+			market.Runners = runners
+			market.MarketHistory = nil
+			markets[marketType] = market
+		}
+		fixture.Markets = markets
+	}
+	return fixture
+}
+
+// keepHistories gives the details of a fixture the histories already stored for it. A market keeps the histories
+// of its runners only while it is the same market
+func keepHistories(fixture FixturePrices, stored *FixturePrices) FixturePrices {
+	fixture = withoutHistories(fixture)
+	fixture.PriceHistory = stored.PriceHistory
+	if fixture.PriceHistory == nil {
+		fixture.PriceHistory = map[int][]Price{}
+	}
+	fixture.MarketHistory = stored.MarketHistory
+	for marketType, market := range fixture.Markets {
+		storedMarket, ok := stored.Markets[marketType]
+		if !ok || storedMarket.MarketID != market.MarketID {
+			continue
+		}
+		for i := range market.Runners {
+			if i < len(storedMarket.Runners) {
+				market.Runners[i].History = storedMarket.Runners[i].History
+			}
+		}
+		market.MarketHistory = storedMarket.MarketHistory
+		fixture.Markets[marketType] = market
+	}
+	return fixture
+}
+
+// copyHistories returns the fixture with its own copy of every history, so appends never share a slice
+func copyHistories(fixture FixturePrices) FixturePrices {
+	history := map[int][]Price{}
+	for selectionId, prices := range fixture.PriceHistory {
+		history[selectionId] = append([]Price{}, prices...)
+	}
+	copied := withoutHistories(fixture)
+	copied.PriceHistory = history
+	copied.MarketHistory = copyMarketHistory(fixture.MarketHistory)
+	for marketType, market := range copied.Markets {
+		for i := range market.Runners {
+			market.Runners[i].History = append([]Price{}, fixture.Markets[marketType].Runners[i].History...)
+		}
+		market.MarketHistory = copyMarketHistory(fixture.Markets[marketType].MarketHistory)
+		copied.Markets[marketType] = market
+	}
+	return copied
+}
+
+// copyMarketHistory returns a copy of the market samples, nil if there are none
+func copyMarketHistory(history []MarketSample) []MarketSample {
+	if history == nil {
+		return nil
+	}
+	return append([]MarketSample{}, history...)
+}
+
+// addOtherMarkets finds the markets of the other market types for the fixtures of the events, and adds any that
+// are new to the fixtures being tracked or waiting for their first prices. Markets are matched to fixtures only by
+// event id, as their runners are not named after the teams
+func (s *Store) addOtherMarkets(queryParameters *access.MarketQuery, competitionId string, eventIds []string) error {
+	for _, marketType := range queryParameters.OtherMarketTypes() {
+		markets, marketEvents, err := s.listMarketCatalogue(eventIds, marketType)
+		if err != nil {
+			return err
+		}
+		for _, market := range markets {
+			eventId := marketEvents[market.MarketId]
+			fixture, ok := s.GlobalPriceStore[competitionId][eventId]
+			if !ok || eventId == "" || (!fixture.IsTracked() && fixture.Admission.Status != Pending) {
+				continue
+			}
+			if existing, ok := fixture.Markets[marketType]; ok && existing.MarketID == market.MarketId {
+				continue
+			}
+			prices := MarketPrices{MarketID: market.MarketId, MarketName: market.MarketName, Runners: []MarketRunner{}}
+			for _, selection := range market.Selections {
+				prices.Runners = append(prices.Runners, MarketRunner{SelectionId: selection.SelectionId, Name: selection.Name, Handicap: selection.Handicap})
+			}
+			if fixture.Markets == nil {
+				fixture.Markets = map[string]MarketPrices{}
+			}
+			fixture.Markets[marketType] = prices
+			s.GlobalPriceStore[competitionId][eventId] = fixture
+		}
+	}
+	return nil
+}
+
+// otherMarketIds returns the ids of the other markets of the events in the league, sorted, with where each is kept
+func (s *Store) otherMarketIds(leagueId string, eventIds []string, include func(*MarketPrices) bool) ([]string, map[string]marketRef) {
+	marketIds := []string{}
+	refs := map[string]marketRef{}
+	for _, eventId := range eventIds {
+		for marketType, market := range s.GlobalPriceStore[leagueId][eventId].Markets {
+			if include != nil && !include(&market) {
+				continue
+			}
+			marketIds = append(marketIds, market.MarketID)
+			refs[market.MarketID] = marketRef{eventId: eventId, marketType: marketType}
+		}
+	}
+	sort.Strings(marketIds)
+	return marketIds, refs
+}
+
+// addOtherMarketBooks samples the other markets of the events in the league, adding a journal record of each
+// sample to the pending records
+func (s *Store) addOtherMarketBooks(queryParameters *access.MarketQuery, competitionId string, eventIds []string, pending []journalRecord) ([]journalRecord, error) {
+	marketIds, refs := s.otherMarketIds(competitionId, eventIds, nil)
+	if len(marketIds) == 0 {
+		return pending, nil
+	}
+	books, err := s.listMarketBooks(marketIds, priceProjection(queryParameters))
+	if err != nil {
+		return pending, err
+	}
+	for _, book := range books {
+		ref, ok := refs[book.MarketId]
+		if !ok {
+			continue
+		}
+		fixture := s.GlobalPriceStore[competitionId][ref.eventId]
+		market := fixture.Markets[ref.marketType]
+		sampledAt := time.Now()
+		for _, runner := range book.Runners {
+			i := market.runnerIndex(runner.SelectionID, runner.Handicap)
+			if i < 0 {
+				continue
+			}
+			price := getPriceFromRunner(&runner, sampledAt)
+			addMarketState(price, &book)
+			if queryParameters.LadderDepth > 0 {
+				addLadderToPrice(price, &runner, queryParameters.LadderDepth)
+			}
+			market.Runners[i].History = append(market.Runners[i].History, *price)
+			pending = s.Journal.runnerSample(pending, competitionId, ref.eventId, ref.marketType, i, len(market.Runners[i].History)-1, *price)
+		}
+		market.MarketHistory = append(market.MarketHistory, newMarketSample(&book, sampledAt))
+		fixture.Markets[ref.marketType] = market
+		pending = s.Journal.marketSample(pending, competitionId, ref.eventId, ref.marketType, len(market.MarketHistory)-1, market.MarketHistory[len(market.MarketHistory)-1])
+	}
+	return pending, nil
+}
+
+// settleMarkets records the status of each runner in the closed other markets of fixtures in the league that have
+// kicked off
+func (s *Store) settleMarkets(leagueId string, now time.Time) error {
+	eventIds := []string{}
+	for eventId, fixture := range s.GlobalPriceStore[leagueId] {
+		kickoff, err := time.Parse(time.RFC3339, fixture.Date)
+		if err != nil || now.Before(kickoff) {
+			continue
+		}
+		eventIds = append(eventIds, eventId)
+	}
+	marketIds, refs := s.otherMarketIds(leagueId, eventIds, func(market *MarketPrices) bool { return !market.settled() })
+	books, err := s.listMarketBooks(marketIds, nil)
+	if err != nil {
+		return err
+	}
+	for _, book := range books {
+		ref, ok := refs[book.MarketId]
+		if !ok || book.Status != MarketClosed {
+			continue
+		}
+		market := s.GlobalPriceStore[leagueId][ref.eventId].Markets[ref.marketType]
+		for _, runner := range book.Runners {
+			if i := market.runnerIndex(runner.SelectionID, runner.Handicap); i >= 0 {
+				market.Runners[i].Status = runner.Status
+			}
+		}
+	}
+	return nil
+}
+
+// extractMarketTrends returns a trend for each runner of the fixture's other markets that has a tradeable price
+func extractMarketTrends(fixture FixturePrices) (trends Trends) {
+	marketTypes := []string{}
+	for marketType := range fixture.Markets {
+		marketTypes = append(marketTypes, marketType)
+	}
+	sort.Strings(marketTypes)
+	for _, marketType := range marketTypes {
+		marketTrends := Trends{}
+		for _, runner := range fixture.Markets[marketType].Runners {
+			trend := trendFromPrices(fixture.Fixture, runner.Label(), runner.History)
+			if trend == nil {
+				continue
+			}
+			trend.MarketType = marketType
+			trend.RunnerStatus = runner.Status
+			marketTrends = append(marketTrends, *trend)
+		}
+		addMarketLiquidity(marketTrends, fixture.Markets[marketType].MarketHistory)
+		trends = append(trends, marketTrends...)
+	}
+	return trends
+}
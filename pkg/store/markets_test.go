@@ -0,0 +1,198 @@
+// Copyright 2022 Guy Barden
+// markets_test.go - tests for tracking the other markets of a fixture
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"guysports/go-football-trader/pkg/access"
+	"guysports/go-football-trader/pkg/fake"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testMarketsQuery() *access.MarketQuery {
+	return &access.MarketQuery{LeagueIds: []string{"league1"}, MinOdds: 2.0, MaxOdds: 3.7, MarketTypes: []string{access.MatchOddsMarket, fake.OverUnderMarketType, fake.AsianHandicapMarketType}}
+}
+
+func TestStore_AddLeaguePricesToStoreMarkets(t *testing.T) {
+	tests := []struct {
+		name          string
+		query         *access.MarketQuery
+		omitEvents    bool
+		wantAdmission AdmissionStatus
+		wantMarkets   []string
+	}{
+		{
+			name:          "admitted fixture tracks its other markets",
+			query:         testMarketsQuery(),
+			wantAdmission: Admitted,
+			wantMarkets:   []string{fake.AsianHandicapMarketType, fake.OverUnderMarketType},
+		},
+		{
+			name:          "skipped fixture keeps no other markets",
+			query:         &access.MarketQuery{LeagueIds: []string{"league1"}, MinOdds: 10, MaxOdds: 20, MarketTypes: []string{fake.OverUnderMarketType}},
+			wantAdmission: Skipped,
+			wantMarkets:   []string{},
+		},
+		{
+			name:          "markets without an event are not tracked",
+			query:         testMarketsQuery(),
+			omitEvents:    true,
+			wantAdmission: Admitted,
+			wantMarkets:   []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &fake.FakeQuery{OmitCatalogueEvents: tt.omitEvents}
+			s := &Store{GlobalPriceStore: map[string]map[string]FixturePrices{}, QueryClient: client}
+			// The first round finds the fixture by its team names if the catalogue has no events
+			if tt.omitEvents {
+				s.GlobalPriceStore["league1"] = map[string]FixturePrices{"fixture1": {Fixture: "Mainz v Dortmund", EventID: "fixture1", PriceHistory: map[int][]Price{}, Admission: &Admission{Status: Pending}}}
+			}
+			assert.Nil(t, s.AddLeaguePricesToStore(tt.query))
+			assert.Nil(t, s.AddLeaguePricesToStore(tt.query))
+
+			fixture := s.GlobalPriceStore["league1"]["fixture1"]
+			assert.Equal(t, tt.wantAdmission, fixture.Admission.Status)
+			markets := []string{}
+			for _, marketType := range tt.wantMarkets {
+				if _, ok := fixture.Markets[marketType]; ok {
+					markets = append(markets, marketType)
+				}
+			}
+			assert.Equal(t, tt.wantMarkets, markets)
+			assert.Equal(t, len(tt.wantMarkets), len(fixture.Markets))
+			if len(tt.wantMarkets) == 0 {
+				return
+			}
+
+			overUnder := fixture.Markets[fake.OverUnderMarketType]
+			assert.Equal(t, fake.OverUnderMarketId, overUnder.MarketID)
+			assert.Equal(t, "Under 2.5 Goals", overUnder.Runners[0].Label())
+			assert.Equal(t, 2, len(overUnder.Runners[0].History))
+			assert.Equal(t, float32(2.1), overUnder.Runners[0].History[0].BackPrice)
+
+			// Asian handicap runners share selection ids and are told apart by their handicap
+			handicap := fixture.Markets[fake.AsianHandicapMarketType]
+			labels := []string{}
+			for _, runner := range handicap.Runners {
+				labels = append(labels, runner.Label())
+				assert.Equal(t, 2, len(runner.History))
+			}
+			assert.Equal(t, []string{"Mainz -0.5", "Dortmund +0.5", "Mainz +0.5", "Dortmund -0.5"}, labels)
+			assert.Equal(t, float32(1.57), handicap.Runners[2].History[1].BackPrice)
+		})
+	}
+}
+
+func TestStore_MarketsSaved(t *testing.T) {
+	for name, open := range testBackends(t) {
+		t.Run(name, func(t *testing.T) {
+			client := &fake.FakeQuery{}
+			s, err := LoadStore(open(), client, nil)
+			assert.Nil(t, err)
+			assert.Nil(t, s.AddLeaguePricesToStore(testMarketsQuery()))
+			assert.Nil(t, s.SaveStoreToFile())
+
+			// Samples taken after a reload are appended once
+			reloaded, err := LoadStore(open(), client, nil)
+			assert.Nil(t, err)
+			assert.Equal(t, s.GlobalPriceStore, reloaded.GlobalPriceStore)
+			assert.Nil(t, reloaded.AddLeaguePricesToStore(testMarketsQuery()))
+			assert.Nil(t, reloaded.SaveStoreToFile())
+			again, err := LoadStore(open(), client, nil)
+			assert.Nil(t, err)
+			assert.Equal(t, reloaded.GlobalPriceStore, again.GlobalPriceStore)
+			assert.Equal(t, 2, len(again.GlobalPriceStore["league1"]["fixture1"].Markets[fake.OverUnderMarketType].Runners[1].History))
+
+			// A market replaced by one with a new id starts its history again
+			fixture := again.GlobalPriceStore["league1"]["fixture1"]
+			fixture.Markets[fake.OverUnderMarketType] = MarketPrices{MarketID: "1.2", Runners: []MarketRunner{{SelectionId: 1, Name: "Under 2.5 Goals", History: []Price{{Timestamp: "2022-04-06T12:00:00Z"}}}}}
+			assert.Nil(t, again.SaveStoreToFile())
+			replaced, err := LoadStore(open(), client, nil)
+			assert.Nil(t, err)
+			assert.Equal(t, again.GlobalPriceStore, replaced.GlobalPriceStore)
+		})
+	}
+}
+
+func TestStore_MarketsJournal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.json")
+	client := &fake.FakeQuery{}
+	s := newTestStore(t, path, client)
+	assert.Nil(t, s.AddLeaguePricesToStore(testMarketsQuery()))
+	client.AppendPrices = true
+	assert.Nil(t, s.AddLeaguePricesToStore(testMarketsQuery()))
+	assert.Nil(t, s.Journal.Close())
+
+	recovered := newTestStore(t, path, client)
+	assert.Equal(t, s.GlobalPriceStore, recovered.GlobalPriceStore)
+	assert.Equal(t, 2, len(recovered.GlobalPriceStore["league1"]["fixture1"].Markets[fake.AsianHandicapMarketType].Runners[3].History))
+
+	// Replaying the journal over the saved store adds nothing
+	assert.Nil(t, recovered.SaveStoreToFile())
+	assert.Nil(t, recovered.Journal.Close())
+	assert.Equal(t, s.GlobalPriceStore, newTestStore(t, path, nil).GlobalPriceStore)
+}
+
+func TestStore_MarketsSettledTrends(t *testing.T) {
+	client := &fake.FakeQuery{}
+	s := &Store{GlobalPriceStore: map[string]map[string]FixturePrices{}, QueryClient: client}
+	assert.Nil(t, s.AddLeaguePricesToStore(testMarketsQuery()))
+	assert.Nil(t, s.AddLeaguePricesToStore(testMarketsQuery()))
+
+	client.SettledWinner = 64374
+	settled, err := s.SettleFixtures(time.Date(2022, 4, 7, 12, 0, 0, 0, time.UTC))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, settled)
+	statuses := []string{}
+	for _, runner := range s.GlobalPriceStore["league1"]["fixture1"].Markets[fake.OverUnderMarketType].Runners {
+		statuses = append(statuses, runner.Status)
+	}
+	assert.Equal(t, []string{RunnerWinner, "LOSER"}, statuses)
+
+	trends := s.ExtractTrendsFromFixtures()
+	markets := map[string][]string{}
+	for _, trend := range trends {
+		if trend.MarketType == "" {
+			continue
+		}
+		markets[trend.MarketType] = append(markets[trend.MarketType], trend.Team)
+		assert.True(t, trend.Settled())
+		assert.Equal(t, trend.Team == "Under 2.5 Goals" || trend.Team == "Mainz -0.5", trend.RunnerWon(), trend.Team)
+	}
+	assert.ElementsMatch(t, []string{"Under 2.5 Goals", "Over 2.5 Goals"}, markets[fake.OverUnderMarketType])
+	assert.ElementsMatch(t, []string{"Mainz -0.5", "Dortmund +0.5", "Mainz +0.5", "Dortmund -0.5"}, markets[fake.AsianHandicapMarketType])
+}
+
+func TestStore_VerifyMarkets(t *testing.T) {
+	s := &Store{GlobalPriceStore: map[string]map[string]FixturePrices{
+		"59": {"fixture1": {Fixture: "Mainz v Dortmund", PriceHistory: map[int][]Price{}, Markets: map[string]MarketPrices{
+			fake.OverUnderMarketType: {MarketID: "1.2", Runners: []MarketRunner{{SelectionId: 47972, Name: "Under 2.5 Goals", History: []Price{
+				{Timestamp: "2022-04-06T12:00:00Z", BackPrice: 2.1},
+				{Timestamp: "2022-04-06T12:10:00Z", BackPrice: 2.11},
+			}}}},
+		}}},
+	}}
+	problems := s.Repair()
+	assert.Equal(t, 1, len(problems))
+	assert.Equal(t, "league 59 fixture fixture1 market OVER_UNDER_25 runner 47972 sample 1: back price 2.11 is not on the Betfair ladder", problems[0].String())
+	assert.Equal(t, 1, len(s.GlobalPriceStore["59"]["fixture1"].Markets[fake.OverUnderMarketType].Runners[0].History))
+	assert.Equal(t, []Problem{}, s.Verify())
+}
@@ -0,0 +1,135 @@
+// Copyright 2022 Guy Barden
+// poll.go - samples the leagues of a query concurrently and merges their prices into the store in league order
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"sort"
+	"sync"
+
+	"guysports/go-football-trader/pkg/access"
+)
+
+type (
+	// leagueFetch samples one league into a store holding only that league, returning the journal records of the
+	// samples taken
+	leagueFetch func(league *Store, leagueId string) ([]journalRecord, error)
+
+	// leagueResult is what a worker fetched for a league
+	leagueResult struct {
+		store   *Store
+		pending []journalRecord
+		err     error
+	}
+)
+
+// AddDuePricesToStore samples the markets due a sample, keyed by league, without rediscovering fixtures. The
+// leagues are sampled concurrently and merged in the order of their ids
+func (s *Store) AddDuePricesToStore(queryParameters *access.MarketQuery, due map[string][]string) error {
+	leagueIds := []string{}
+	for leagueId, marketIds := range due {
+		if len(marketIds) > 0 {
+			leagueIds = append(leagueIds, leagueId)
+		}
+	}
+	sort.Strings(leagueIds)
+	return s.pollLeagues(leagueIds, func(league *Store, leagueId string) ([]journalRecord, error) {
+		return league.addMarketBooksToStore(queryParameters, leagueId, due[leagueId])
+	})
+}
+
+// pollLeagues fetches each league on a pool of workers, each with a store of its own holding just the league, so
+// no two workers share a map. Once every league is fetched they are merged into the store and journaled in the
+// order given, whatever order they finished in. Every league is merged, and the first error in league order is
+// returned
+func (s *Store) pollLeagues(leagueIds []string, fetch leagueFetch) error {
+	leagueIds = uniqueLeagues(leagueIds)
+	results := make([]leagueResult, len(leagueIds))
+	for i, leagueId := range leagueIds {
+		results[i].store = s.leagueStore(leagueId)
+	}
+
+	work := make(chan int)
+	wg := sync.WaitGroup{}
+	for w := 0; w < s.workers(len(leagueIds)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				results[i].pending, results[i].err = fetch(results[i].store, leagueIds[i])
+			}
+		}()
+	}
+	for i := range leagueIds {
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+
+	var firstErr error
+	for i, leagueId := range leagueIds {
+		if league := results[i].store.GlobalPriceStore[leagueId]; league != nil {
+			s.GlobalPriceStore[leagueId] = league
+		}
+		// The samples captured before an error are still made durable
+		if err := s.Journal.write(results[i].pending); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		if results[i].err != nil && firstErr == nil {
+			firstErr = results[i].err
+		}
+	}
+	return firstErr
+}
+
+// leagueStore returns a store sharing the query client and journal that holds only the league's fixtures. The
+// journal is only read while fetching, its records are written once the leagues are merged
+func (s *Store) leagueStore(leagueId string) *Store {
+	league := &Store{
+		GlobalPriceStore: map[string]map[string]FixturePrices{},
+		QueryClient:      s.QueryClient,
+		Journal:          s.Journal,
+	}
+	if fixtures, ok := s.GlobalPriceStore[leagueId]; ok {
+		league.GlobalPriceStore[leagueId] = fixtures
+	}
+	return league
+}
+
+// workers returns how many leagues are fetched at once, one at a time unless the store is given more workers
+func (s *Store) workers(leagues int) int {
+	workers := s.Workers
+	if workers > leagues {
+		workers = leagues
+	}
+	if workers < 1 {
+		workers = 1
+	}
+	return workers
+}
+
+// uniqueLeagues drops repeated league ids, keeping the first of each
+func uniqueLeagues(leagueIds []string) []string {
+	seen := map[string]bool{}
+	unique := []string{}
+	for _, leagueId := range leagueIds {
+		if seen[leagueId] {
+			continue
+		}
+		seen[leagueId] = true
+		unique = append(unique, leagueId)
+	}
+	return unique
+}
@@ -0,0 +1,174 @@
+// Copyright 2022 Guy Barden
+// poll_test.go - tests for sampling leagues concurrently and merging them into the store
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"guysports/go-football-trader/pkg/fake"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// sampleCounts returns the number of samples of each runner and market in the store, which unlike the samples
+// themselves do not depend on when they were taken
+func sampleCounts(s *Store) map[string]int {
+	counts := map[string]int{}
+	for leagueId, league := range s.GlobalPriceStore {
+		for eventId, fixture := range league {
+			for selectionId, history := range fixture.PriceHistory {
+				counts[fmt.Sprintf("%s/%s/%d", leagueId, eventId, selectionId)] = len(history)
+			}
+			counts[fmt.Sprintf("%s/%s/market", leagueId, eventId)] = len(fixture.MarketHistory)
+			for marketType, market := range fixture.Markets {
+				for _, runner := range market.Runners {
+					counts[fmt.Sprintf("%s/%s/%s/%s", leagueId, eventId, marketType, runner.Label())] = len(runner.History)
+				}
+				counts[fmt.Sprintf("%s/%s/%s/market", leagueId, eventId, marketType)] = len(market.MarketHistory)
+			}
+		}
+	}
+	return counts
+}
+
+// journaledLeagues returns the league of each record in the journal in the order they were written
+func journaledLeagues(t *testing.T, path string) []string {
+	file, err := os.Open(path)
+	assert.Nil(t, err)
+	defer file.Close()
+	leagues := []string{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		record := journalRecord{}
+		assert.Nil(t, json.Unmarshal(scanner.Bytes(), &record))
+		leagues = append(leagues, record.LeagueId)
+	}
+	return leagues
+}
+
+func TestStore_AddLeaguePricesToStoreWorkers(t *testing.T) {
+	query := testMarketsQuery()
+	query.LeagueIds = []string{"league5", "league1", "league4", "league2", "league3", "league1"}
+	client := &fake.FakeQuery{}
+
+	serial := &Store{GlobalPriceStore: map[string]map[string]FixturePrices{}, QueryClient: client}
+	assert.Nil(t, serial.AddLeaguePricesToStore(query))
+	assert.Nil(t, serial.AddLeaguePricesToStore(query))
+
+	path := filepath.Join(t.TempDir(), "store.json")
+	concurrent := newTestStore(t, path, client)
+	concurrent.Workers = 3
+	assert.Nil(t, concurrent.AddLeaguePricesToStore(query))
+	assert.Nil(t, concurrent.AddLeaguePricesToStore(query))
+	assert.Nil(t, concurrent.Journal.Close())
+
+	assert.Equal(t, 5, len(concurrent.GlobalPriceStore))
+	assert.Equal(t, sampleCounts(serial), sampleCounts(concurrent))
+	assert.Equal(t, 2, len(concurrent.GlobalPriceStore["league3"]["fixture1"].PriceHistory[64374]))
+
+	// Each round is journaled a league at a time in the order of the query
+	order := []string{}
+	for _, leagueId := range journaledLeagues(t, JournalPath(path)) {
+		if len(order) == 0 || order[len(order)-1] != leagueId {
+			order = append(order, leagueId)
+		}
+	}
+	round := []string{"league5", "league1", "league4", "league2", "league3"}
+	assert.Equal(t, append(append([]string{}, round...), round...), order)
+
+	recovered := newTestStore(t, path, client)
+	assert.Equal(t, concurrent.GlobalPriceStore, recovered.GlobalPriceStore)
+}
+
+func TestStore_AddDuePricesToStore(t *testing.T) {
+	query := testMarketsQuery()
+	query.LeagueIds = []string{"league1", "league2", "league3"}
+	client := &fake.FakeQuery{}
+	s := &Store{GlobalPriceStore: map[string]map[string]FixturePrices{}, QueryClient: client, Workers: 2}
+	assert.Nil(t, s.AddLeaguePricesToStore(query))
+
+	due := map[string][]string{
+		"league1": {s.GlobalPriceStore["league1"]["fixture1"].MarketID},
+		"league3": {s.GlobalPriceStore["league3"]["fixture1"].MarketID},
+		"league4": {},
+	}
+	assert.Nil(t, s.AddDuePricesToStore(query, due))
+	assert.Equal(t, 2, len(s.GlobalPriceStore["league1"]["fixture1"].MarketHistory))
+	assert.Equal(t, 1, len(s.GlobalPriceStore["league2"]["fixture1"].MarketHistory))
+	assert.Equal(t, 2, len(s.GlobalPriceStore["league3"]["fixture1"].MarketHistory))
+	assert.Equal(t, 2, len(s.GlobalPriceStore["league3"]["fixture1"].Markets[fake.OverUnderMarketType].MarketHistory))
+	_, ok := s.GlobalPriceStore["league4"]
+	assert.False(t, ok)
+
+	client.InjectListMarketBookError = true
+	assert.NotNil(t, s.AddDuePricesToStore(query, due))
+	assert.Equal(t, 2, len(s.GlobalPriceStore["league1"]["fixture1"].MarketHistory))
+}
+
+func TestStore_pollLeagues(t *testing.T) {
+	s := &Store{GlobalPriceStore: map[string]map[string]FixturePrices{"league2": {"fixture1": {Fixture: "Mainz v Dortmund"}}}, Workers: 4}
+	mu := sync.Mutex{}
+	fetched := map[string]int{}
+	err := s.pollLeagues([]string{"league1", "league2", "league3", "league2", "league4"}, func(league *Store, leagueId string) ([]journalRecord, error) {
+		mu.Lock()
+		fetched[leagueId]++
+		mu.Unlock()
+		// Each worker sees only the fixtures of its own league
+		if leagueId == "league2" {
+			assert.Equal(t, map[string]map[string]FixturePrices{"league2": {"fixture1": {Fixture: "Mainz v Dortmund"}}}, league.GlobalPriceStore)
+		} else {
+			assert.Empty(t, league.GlobalPriceStore)
+		}
+		if leagueId == "league3" || leagueId == "league4" {
+			return nil, fmt.Errorf("error polling %s", leagueId)
+		}
+		league.GlobalPriceStore[leagueId] = map[string]FixturePrices{"fixture2": {Fixture: leagueId}}
+		return nil, nil
+	})
+
+	// Every league is fetched once and merged, the first error in league order is returned
+	assert.Equal(t, map[string]int{"league1": 1, "league2": 1, "league3": 1, "league4": 1}, fetched)
+	assert.EqualError(t, err, "error polling league3")
+	assert.Equal(t, map[string]map[string]FixturePrices{
+		"league1": {"fixture2": {Fixture: "league1"}},
+		"league2": {"fixture2": {Fixture: "league2"}},
+	}, s.GlobalPriceStore)
+}
+
+func TestStore_workers(t *testing.T) {
+	tests := []struct {
+		name    string
+		workers int
+		leagues int
+		want    int
+	}{
+		{name: "one at a time if not set", leagues: 5, want: 1},
+		{name: "no more workers than leagues", workers: 4, leagues: 2, want: 2},
+		{name: "workers set", workers: 4, leagues: 15, want: 4},
+		{name: "at least one worker", workers: 4, want: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Store{Workers: tt.workers}
+			assert.Equal(t, tt.want, s.workers(tt.leagues))
+		})
+	}
+}
@@ -0,0 +1,244 @@
+// Copyright 2022 Guy Barden
+// retention.go - archives of earlier store snapshots and the policy for pruning them
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type (
+	// RetentionPolicy decides which archived snapshots are kept. An archive is kept if any of the count rules keep
+	// it, with every archive kept when no count rule is set. MaxBytes then removes the oldest kept archives until
+	// their total size fits, zero values disable a rule
+	RetentionPolicy struct {
+		// KeepLast keeps the newest archives
+		KeepLast int
+		// KeepDaily keeps the newest archive of each of the most recent days that have one
+		KeepDaily int
+		// KeepWeekly keeps the newest archive of each of the most recent weeks that have one
+		KeepWeekly int
+		// MaxBytes limits the total size of the kept archives
+		MaxBytes int64
+		// Compress gzips the kept archives
+		Compress bool
+	}
+
+	// Archive is a snapshot of the store that was replaced by a later save
+	Archive struct {
+		Path       string
+		Time       time.Time
+		Size       int64
+		Compressed bool
+	}
+
+	// PruneResult lists what pruning did, or would do in a dry run
+	PruneResult struct {
+		Kept       []Archive
+		Removed    []Archive
+		Compressed []Archive
+	}
+)
+
+const (
+	GzipExtension = ".gz"
+)
+
+// splitStoreName returns the name of the store file before and after its extension, ignoring any gzip extension
+func splitStoreName(storeFile string) (stem string, ext string, compressed bool) {
+	base := filepath.Base(storeFile)
+	compressed = strings.HasSuffix(base, GzipExtension)
+	base = strings.TrimSuffix(base, GzipExtension)
+	ext = filepath.Ext(base)
+	return strings.TrimSuffix(base, ext), ext, compressed
+}
+
+// ArchivePath returns the path an earlier snapshot of the store is kept at, with the time it was replaced added to
+// its name
+func ArchivePath(storeFile string, replaced time.Time) string {
+	stem, ext, compressed := splitStoreName(storeFile)
+	name := fmt.Sprintf("%s_%d%s", stem, replaced.Unix(), ext)
+	if compressed {
+		name += GzipExtension
+	}
+	return filepath.Join(filepath.Dir(storeFile), name)
+}
+
+// ListArchives returns the archived snapshots of the store, newest first
+func ListArchives(storeFile string) ([]Archive, error) {
+	stem, ext, _ := splitStoreName(storeFile)
+	entries, err := ioutil.ReadDir(filepath.Dir(storeFile))
+	if err != nil {
+		return nil, err
+	}
+	archives := []Archive{}
+	for _, entry := range entries {
+		name := entry.Name()
+		compressed := strings.HasSuffix(name, GzipExtension)
+		name = strings.TrimSuffix(name, GzipExtension)
+		if entry.IsDir() || !strings.HasPrefix(name, stem+"_") || !strings.HasSuffix(name, ext) {
+			continue
+		}
+		seconds, err := strconv.ParseInt(strings.TrimSuffix(strings.TrimPrefix(name, stem+"_"), ext), 10, 64)
+		if err != nil {
+			continue
+		}
+		archives = append(archives, Archive{
+			Path:       filepath.Join(filepath.Dir(storeFile), entry.Name()),
+			Time:       time.Unix(seconds, 0).UTC(),
+			Size:       entry.Size(),
+			Compressed: compressed,
+		})
+	}
+	sort.SliceStable(archives, func(i, j int) bool { return archives[i].Time.After(archives[j].Time) })
+	return archives, nil
+}
+
+// Select splits the archives, which must be newest first, into those kept by the count rules and those removed
+func (p *RetentionPolicy) Select(archives []Archive) (keep []Archive, remove []Archive) {
+	if p.KeepLast <= 0 && p.KeepDaily <= 0 && p.KeepWeekly <= 0 {
+		return archives, nil
+	}
+	days, weeks := map[string]bool{}, map[string]bool{}
+	for i, archive := range archives {
+		kept := i < p.KeepLast
+		day := archive.Time.Format("2006-01-02")
+		if !days[day] && len(days) < p.KeepDaily {
+			days[day] = true
+			kept = true
+		}
+		year, number := archive.Time.ISOWeek()
+		week := fmt.Sprintf("%d-%d", year, number)
+		if !weeks[week] && len(weeks) < p.KeepWeekly {
+			weeks[week] = true
+			kept = true
+		}
+		if kept {
+			keep = append(keep, archive)
+		} else {
+			remove = append(remove, archive)
+		}
+	}
+	return keep, remove
+}
+
+// LimitSize splits the archives, which must be newest first, into the newest that fit in MaxBytes and the rest
+func (p *RetentionPolicy) LimitSize(archives []Archive) (keep []Archive, remove []Archive) {
+	if p.MaxBytes <= 0 {
+		return archives, nil
+	}
+	total := int64(0)
+	for i, archive := range archives {
+		total += archive.Size
+		if total > p.MaxBytes {
+			return archives[:i], archives[i:]
+		}
+	}
+	return archives, nil
+}
+
+// PruneArchives applies the policy to the archives of the store file. A dry run changes nothing, and as archives
+// are not compressed the size limit is checked against their current size
+func PruneArchives(storeFile string, policy RetentionPolicy, dryRun bool) (*PruneResult, error) {
+	archives, err := ListArchives(storeFile)
+	if err != nil {
+		return nil, err
+	}
+	result := PruneResult{Kept: []Archive{}, Removed: []Archive{}, Compressed: []Archive{}}
+	keep, remove := policy.Select(archives)
+	if policy.Compress {
+		for i, archive := range keep {
+			if archive.Compressed {
+				continue
+			}
+			if !dryRun {
+				if keep[i], err = compressArchive(archive); err != nil {
+					return nil, err
+				}
+			}
+			result.Compressed = append(result.Compressed, keep[i])
+		}
+	}
+	keep, oversize := policy.LimitSize(keep)
+	result.Kept = append(result.Kept, keep...)
+	result.Removed = append(append(result.Removed, remove...), oversize...)
+	if dryRun {
+		return &result, nil
+	}
+	for _, archive := range result.Removed {
+		if err := os.Remove(archive.Path); err != nil {
+			return nil, err
+		}
+	}
+	return &result, nil
+}
+
+// compressArchive replaces the archive with a gzipped copy
+func compressArchive(archive Archive) (Archive, error) {
+	source, err := os.Open(archive.Path)
+	if err != nil {
+		return archive, err
+	}
+	defer source.Close()
+	compressedPath := archive.Path + GzipExtension
+	target, err := os.Create(compressedPath)
+	if err != nil {
+		return archive, err
+	}
+	writer := gzip.NewWriter(target)
+	if _, err := io.Copy(writer, source); err != nil {
+		target.Close()
+		return archive, err
+	}
+	if err := writer.Close(); err != nil {
+		target.Close()
+		return archive, err
+	}
+	if err := target.Close(); err != nil {
+		return archive, err
+	}
+	info, err := os.Stat(compressedPath)
+	if err != nil {
+		return archive, err
+	}
+	if err := os.Remove(archive.Path); err != nil {
+		return archive, err
+	}
+	return Archive{Path: compressedPath, Time: archive.Time, Size: info.Size(), Compressed: true}, nil
+}
+
+// readStoreFile reads a store file, decompressing it if it is gzipped
+func readStoreFile(path string) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil || len(data) < 2 || data[0] != 0x1f || data[1] != 0x8b {
+		return data, err
+	}
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return ioutil.ReadAll(reader)
+}
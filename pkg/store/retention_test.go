@@ -0,0 +1,141 @@
+// Copyright 2022 Guy Barden
+// retention_test.go - tests for archive retention and pruning
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestArchivePath(t *testing.T) {
+	replaced := time.Unix(1650000000, 0)
+	assert.Equal(t, "store/store_1650000000.json", ArchivePath("store/store.json", replaced))
+	assert.Equal(t, "store/store_1650000000.json.gz", ArchivePath("store/store.json.gz", replaced))
+	assert.Equal(t, "store_1650000000.json", ArchivePath("./store.json", replaced))
+}
+
+func TestRetentionPolicy_Select(t *testing.T) {
+	// Archives every 12 hours from the 20th April 2022 going back, newest first
+	archives := []Archive{}
+	newest := time.Date(2022, 4, 20, 18, 0, 0, 0, time.UTC)
+	for i := 0; i < 30; i++ {
+		archives = append(archives, Archive{Path: fmt.Sprintf("%d", i), Time: newest.Add(time.Duration(-12*i) * time.Hour), Size: 10})
+	}
+	paths := func(archives []Archive) []string {
+		names := []string{}
+		for _, archive := range archives {
+			names = append(names, archive.Path)
+		}
+		return names
+	}
+
+	tests := []struct {
+		name       string
+		policy     RetentionPolicy
+		wantKeep   []string
+		wantRemove int
+	}{
+		{
+			name:     "no count rules keeps everything",
+			policy:   RetentionPolicy{},
+			wantKeep: paths(archives),
+		},
+		{
+			name:       "keep last",
+			policy:     RetentionPolicy{KeepLast: 3},
+			wantKeep:   []string{"0", "1", "2"},
+			wantRemove: 27,
+		},
+		{
+			name:       "keep daily takes the newest of each day",
+			policy:     RetentionPolicy{KeepDaily: 3},
+			wantKeep:   []string{"0", "2", "4"},
+			wantRemove: 27,
+		},
+		{
+			// The 18th April 2022 is a Monday
+			name:       "keep weekly takes the newest of each week",
+			policy:     RetentionPolicy{KeepWeekly: 3},
+			wantKeep:   []string{"0", "6", "20"},
+			wantRemove: 27,
+		},
+		{
+			name:       "rules combine",
+			policy:     RetentionPolicy{KeepLast: 2, KeepDaily: 2, KeepWeekly: 2},
+			wantKeep:   []string{"0", "1", "2", "6"},
+			wantRemove: 26,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keep, remove := tt.policy.Select(archives)
+			assert.Equal(t, tt.wantKeep, paths(keep))
+			assert.Equal(t, tt.wantRemove, len(remove))
+		})
+	}
+
+	keep, remove := (&RetentionPolicy{MaxBytes: 25}).LimitSize(archives[:5])
+	assert.Equal(t, []string{"0", "1"}, paths(keep))
+	assert.Equal(t, []string{"2", "3", "4"}, paths(remove))
+}
+
+func TestPruneArchives(t *testing.T) {
+	dir := t.TempDir()
+	storeFile := filepath.Join(dir, "store.json")
+	s := &Store{GlobalPriceStore: map[string]map[string]FixturePrices{"59": {"fixture1": {Fixture: "Mainz v Dortmund", PriceHistory: map[int][]Price{}}}}}
+	for i := 0; i < 4; i++ {
+		assert.Nil(t, ioutil.WriteFile(ArchivePath(storeFile, time.Unix(int64(1650000000+i*3600), 0)), []byte(`{"59":{"fixture2":{"fixture":"Bochum v Koln","history":{}}}}`), 0644))
+	}
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "paper_ledger.json"), []byte("{}"), 0644))
+	s.StorePath = storeFile
+	assert.Nil(t, s.SaveStoreToFile())
+
+	result, err := PruneArchives(storeFile, RetentionPolicy{KeepLast: 2, Compress: true}, true)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(result.Kept))
+	assert.Equal(t, 2, len(result.Removed))
+	assert.Equal(t, 2, len(result.Compressed))
+	archives, err := ListArchives(storeFile)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(archives))
+
+	result, err = PruneArchives(storeFile, RetentionPolicy{KeepLast: 2, Compress: true}, false)
+	assert.Nil(t, err)
+	archives, err = ListArchives(storeFile)
+	assert.Nil(t, err)
+	assert.Equal(t, result.Kept, archives)
+	for _, archive := range archives {
+		assert.True(t, archive.Compressed)
+		assert.Equal(t, filepath.Join(dir, fmt.Sprintf("store_%d.json.gz", archive.Time.Unix())), archive.Path)
+	}
+
+	// A gzipped store is loaded and saved gzipped
+	compressed := newTestStore(t, archives[0].Path, nil)
+	assert.Equal(t, map[string]map[string]FixturePrices{"59": {"fixture2": {Fixture: "Bochum v Koln", PriceHistory: map[int][]Price{}}}}, compressed.GlobalPriceStore)
+	compressed.StorePath = filepath.Join(dir, "copy.json.gz")
+	compressed.Backend = nil
+	compressed.GlobalPriceStore = s.GlobalPriceStore
+	assert.Nil(t, compressed.SaveStoreToFile())
+	data, err := ioutil.ReadFile(compressed.StorePath)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{0x1f, 0x8b}, data[:2])
+	assert.Equal(t, s.GlobalPriceStore, newTestStore(t, compressed.StorePath, nil).GlobalPriceStore)
+}
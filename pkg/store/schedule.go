@@ -0,0 +1,106 @@
+// Copyright 2022 Guy Barden
+// schedule.go - kickoff aware polling schedule for fixtures being tracked
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"sort"
+	"time"
+)
+
+type (
+	// PollingTier sets the interval between samples for fixtures kicking off within the given duration
+	PollingTier struct {
+		Within   time.Duration
+		Interval time.Duration
+	}
+
+	// Schedule is a set of polling tiers, the tightest tier a fixture falls into is used
+	Schedule []PollingTier
+)
+
+var (
+	// DefaultSchedule polls hourly a week out, tightening to every minute in the last hour
+	DefaultSchedule = Schedule{
+		{Within: time.Hour, Interval: time.Minute},
+		{Within: 6 * time.Hour, Interval: 5 * time.Minute},
+		{Within: 24 * time.Hour, Interval: 15 * time.Minute},
+		{Within: 72 * time.Hour, Interval: 30 * time.Minute},
+		{Within: 168 * time.Hour, Interval: time.Hour},
+	}
+)
+
+// IntervalFor returns the polling interval for a fixture kicking off after the given duration,
+// fixtures beyond the widest tier are polled at the widest tier's interval
+func (sc Schedule) IntervalFor(untilKickoff time.Duration) time.Duration {
+	if len(sc) == 0 {
+		return time.Hour
+	}
+	tiers := make(Schedule, len(sc))
+	copy(tiers, sc)
+	sort.Slice(tiers, func(i, j int) bool {
+		return tiers[i].Within < tiers[j].Within
+	})
+	for _, tier := range tiers {
+		if untilKickoff <= tier.Within {
+			return tier.Interval
+		}
+	}
+	return tiers[len(tiers)-1].Interval
+}
+
+// DueFixtures returns the market ids, keyed by league, of scheduled fixtures that have not kicked off
+// and whose last sample is older than the polling interval for their time to kickoff
+func (s *Store) DueFixtures(schedule Schedule, now time.Time) map[string][]string {
+	due := map[string][]string{}
+	for leagueId, league := range s.GlobalPriceStore {
+		for _, fixture := range league {
+			if fixture.MarketID == "" || fixture.MatchStatus != Scheduled || !fixture.IsTracked() {
+				continue
+			}
+			kickoff, err := time.Parse(time.RFC3339, fixture.Date)
+			if err != nil || !now.Before(kickoff) {
+				continue
+			}
+			lastSample, ok := fixture.lastSampleTime()
+			if ok && now.Sub(lastSample) < schedule.IntervalFor(kickoff.Sub(now)) {
+				continue
+			}
+			due[leagueId] = append(due[leagueId], fixture.MarketID)
+		}
+	}
+	for leagueId := range due {
+		sort.Strings(due[leagueId])
+	}
+	return due
+}
+
+// lastSampleTime returns the time of the most recent price recorded against any runner in the fixture
+func (f *FixturePrices) lastSampleTime() (last time.Time, ok bool) {
+	for _, prices := range f.PriceHistory {
+		if len(prices) == 0 {
+			continue
+		}
+		sampled, err := time.Parse(time.RFC3339, prices[len(prices)-1].Timestamp)
+		if err != nil {
+			continue
+		}
+		if !ok || sampled.After(last) {
+			last = sampled
+			ok = true
+		}
+	}
+	return last, ok
+}
@@ -0,0 +1,109 @@
+// Copyright 2022 Guy Barden
+// schedule_test.go - tests for the kickoff aware polling schedule
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSchedule_IntervalFor(t *testing.T) {
+	tests := []struct {
+		name         string
+		schedule     Schedule
+		untilKickoff time.Duration
+		want         time.Duration
+	}{
+		{
+			name:         "last hour polls every minute",
+			schedule:     DefaultSchedule,
+			untilKickoff: 30 * time.Minute,
+			want:         time.Minute,
+		},
+		{
+			name:         "on the day polls every fifteen minutes",
+			schedule:     DefaultSchedule,
+			untilKickoff: 12 * time.Hour,
+			want:         15 * time.Minute,
+		},
+		{
+			name:         "beyond a week uses the widest tier",
+			schedule:     DefaultSchedule,
+			untilKickoff: 300 * time.Hour,
+			want:         time.Hour,
+		},
+		{
+			name:         "unordered tiers use the tightest match",
+			schedule:     Schedule{{Within: 24 * time.Hour, Interval: time.Hour}, {Within: time.Hour, Interval: time.Second}},
+			untilKickoff: 10 * time.Minute,
+			want:         time.Second,
+		},
+		{
+			name:         "empty schedule defaults to hourly",
+			untilKickoff: 10 * time.Minute,
+			want:         time.Hour,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.schedule.IntervalFor(tt.untilKickoff))
+		})
+	}
+}
+
+func TestStore_DueFixtures(t *testing.T) {
+	now := time.Date(2022, 4, 2, 13, 0, 0, 0, time.UTC)
+	fixture := func(marketId string, kickoff time.Time, lastSample time.Time) FixturePrices {
+		return FixturePrices{
+			Date:         kickoff.Format(time.RFC3339),
+			MatchStatus:  Scheduled,
+			MarketID:     marketId,
+			HomeRunnerId: 1,
+			PriceHistory: map[int][]Price{
+				1: {{Timestamp: lastSample.Format(time.RFC3339)}},
+			},
+		}
+	}
+	s := &Store{
+		GlobalPriceStore: map[string]map[string]FixturePrices{
+			"league1": {
+				// Kicks off in 30 minutes, sampled 2 minutes ago so due
+				"fixture1": fixture("1.1", now.Add(30*time.Minute), now.Add(-2*time.Minute)),
+				// Kicks off in 3 days, sampled 10 minutes ago so not due
+				"fixture2": fixture("1.2", now.Add(72*time.Hour), now.Add(-10*time.Minute)),
+				// Already kicked off
+				"fixture3": fixture("1.3", now.Add(-time.Minute), now.Add(-time.Hour)),
+			},
+			"league2": {
+				// Never sampled
+				"fixture4": {
+					Date:         now.Add(100 * time.Hour).Format(time.RFC3339),
+					MatchStatus:  Scheduled,
+					MarketID:     "1.4",
+					PriceHistory: map[int][]Price{},
+				},
+			},
+		},
+	}
+
+	got := s.DueFixtures(DefaultSchedule, now)
+	assert.Equal(t, map[string][]string{
+		"league1": {"1.1"},
+		"league2": {"1.4"},
+	}, got)
+}
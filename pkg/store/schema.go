@@ -0,0 +1,185 @@
+// Copyright 2022 Guy Barden
+// schema.go - versions of the json store layout and the migrations between them
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+type (
+	// storeFile is the layout of the json store written by this version
+	storeFile struct {
+		SchemaVersion int                                 `json:"schema_version"`
+		Leagues       map[string]map[string]FixturePrices `json:"leagues"`
+	}
+
+	// Migration upgrades a json store from the layout of one schema version to the next
+	Migration struct {
+		Description string
+		Upgrade     func(data []byte) ([]byte, error)
+	}
+)
+
+const (
+	// CurrentSchemaVersion is the layout written to the json store, files without a version are version 1
+	CurrentSchemaVersion = 4
+
+	schemaVersionKey = "schema_version"
+	leaguesKey       = "leagues"
+)
+
+var (
+	// Migrations holds the upgrade from each schema version to the next, keyed by the version it upgrades from
+	Migrations = map[int]Migration{
+		1: {
+			Description: "move the leagues under leagues alongside the schema version",
+			Upgrade:     upgradeFromVersion1,
+		},
+		2: {
+			Description: "add the other markets tracked for each fixture",
+			Upgrade:     raiseVersion(3),
+		},
+		3: {
+			Description: "add the market history of each market",
+			Upgrade:     raiseVersion(4),
+		},
+	}
+)
+
+// upgradeFromVersion1 wraps the map of leagues that made up the whole file
+func upgradeFromVersion1(data []byte) ([]byte, error) {
+	leagues := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &leagues); err != nil {
+		return nil, err
+	}
+	return json.Marshal(map[string]interface{}{schemaVersionKey: 2, leaguesKey: leagues})
+}
+
+// raiseVersion returns an upgrade that only raises the version, for fields that older stores simply do not have.
+// The version is raised so earlier versions refuse a store with the new fields rather than dropping them when it is
+// saved
+func raiseVersion(version int) func(data []byte) ([]byte, error) {
+	return func(data []byte) ([]byte, error) {
+		fields := map[string]json.RawMessage{}
+		if err := json.Unmarshal(data, &fields); err != nil {
+			return nil, err
+		}
+		fields[schemaVersionKey] = json.RawMessage(strconv.Itoa(version))
+		return json.Marshal(fields)
+	}
+}
+
+// SchemaVersion returns the schema version of a json store
+func SchemaVersion(data []byte) (int, error) {
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return 0, err
+	}
+	versionField, ok := fields[schemaVersionKey]
+	if !ok {
+		return 1, nil
+	}
+	version := 0
+	if err := json.Unmarshal(versionField, &version); err != nil || version < 1 {
+		return 0, fmt.Errorf("schema version %s is not a version number", string(versionField))
+	}
+	return version, nil
+}
+
+// DecodeStore reads the leagues of a json store, upgrading it from older schema versions. It returns the version
+// the data was written in
+func DecodeStore(data []byte) (map[string]map[string]FixturePrices, int, error) {
+	version, err := SchemaVersion(data)
+	if err != nil {
+		return nil, 0, err
+	}
+	if version > CurrentSchemaVersion {
+		return nil, version, fmt.Errorf("schema version %d is newer than version %d read by this version of go-football-trader", version, CurrentSchemaVersion)
+	}
+	for from := version; from < CurrentSchemaVersion; from++ {
+		migration, ok := Migrations[from]
+		if !ok {
+			return nil, version, fmt.Errorf("no migration from schema version %d", from)
+		}
+		if data, err = migration.Upgrade(data); err != nil {
+			return nil, version, fmt.Errorf("unable to migrate from schema version %d: %s", from, err.Error())
+		}
+	}
+	file := storeFile{}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, version, err
+	}
+	if file.Leagues == nil {
+		file.Leagues = map[string]map[string]FixturePrices{}
+	}
+	return file.Leagues, version, nil
+}
+
+// EncodeStore writes the leagues in the current schema version, gzipped if the path ends .gz
+func EncodeStore(path string, leagues map[string]map[string]FixturePrices) ([]byte, error) {
+	data, err := json.Marshal(storeFile{SchemaVersion: CurrentSchemaVersion, Leagues: leagues})
+	if err != nil || !strings.HasSuffix(path, GzipExtension) {
+		return data, err
+	}
+	compressed := bytes.Buffer{}
+	writer := gzip.NewWriter(&compressed)
+	if _, err := writer.Write(data); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return compressed.Bytes(), nil
+}
+
+// MigrateStoreFile rewrites the json store at path in the current schema version, first copying the file unchanged
+// to a backup named after the version it was in. It returns the version the file was in and the backup path, which
+// is empty if the file was already current. A dry run only reads the file
+func MigrateStoreFile(path string, dryRun bool) (int, string, error) {
+	original, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, "", err
+	}
+	data, err := readStoreFile(path)
+	if err != nil {
+		return 0, "", err
+	}
+	leagues, version, err := DecodeStore(data)
+	if err != nil {
+		return version, "", fmt.Errorf("unable to migrate %s: %s", path, err.Error())
+	}
+	if version == CurrentSchemaVersion {
+		return version, "", nil
+	}
+	backup := fmt.Sprintf("%s.v%d.bak", path, version)
+	if dryRun {
+		return version, backup, nil
+	}
+	if err := WriteFileAtomic(backup, original, 0644); err != nil {
+		return version, "", err
+	}
+	migrated, err := EncodeStore(path, leagues)
+	if err != nil {
+		return version, "", err
+	}
+	return version, backup, WriteFileAtomic(path, migrated, 0644)
+}
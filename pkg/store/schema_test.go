@@ -0,0 +1,122 @@
+// Copyright 2022 Guy Barden
+// schema_test.go - tests for the store schema versions and migrations
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodeStore(t *testing.T) {
+	leagues := map[string]map[string]FixturePrices{
+		"59": {"fixture1": {Fixture: "Mainz v Dortmund", HomeRunnerId: 1, AwayRunnerId: 2, PriceHistory: map[int][]Price{1: {{Timestamp: "2022-04-06T12:00:00Z", BackPrice: 3.7}}}}},
+	}
+	tests := []struct {
+		name        string
+		data        string
+		wantVersion int
+		wantLeagues map[string]map[string]FixturePrices
+		wantErr     bool
+	}{
+		{
+			name:        "version 1 has no schema version",
+			data:        `{"59":{"fixture1":{"fixture":"Mainz v Dortmund","home_runner":1,"away_runner":2,"history":{"1":[{"time_stamp":"2022-04-06T12:00:00Z","back_price":3.7}]}}}}`,
+			wantVersion: 1,
+			wantLeagues: leagues,
+		},
+		{
+			name:        "version 2 has no markets",
+			data:        `{"schema_version":2,"leagues":{"59":{"fixture1":{"fixture":"Mainz v Dortmund","home_runner":1,"away_runner":2,"history":{"1":[{"time_stamp":"2022-04-06T12:00:00Z","back_price":3.7}]}}}}}`,
+			wantVersion: 2,
+			wantLeagues: leagues,
+		},
+		{
+			name:        "version 3 has no market history",
+			data:        `{"schema_version":3,"leagues":{"59":{"fixture1":{"fixture":"Mainz v Dortmund","home_runner":1,"away_runner":2,"history":{"1":[{"time_stamp":"2022-04-06T12:00:00Z","back_price":3.7}]}}}}}`,
+			wantVersion: 3,
+			wantLeagues: leagues,
+		},
+		{
+			name:        "current version",
+			data:        `{"schema_version":4,"leagues":{"59":{"fixture1":{"fixture":"Mainz v Dortmund","home_runner":1,"away_runner":2,"history":{"1":[{"time_stamp":"2022-04-06T12:00:00Z","back_price":3.7}]}}}}}`,
+			wantVersion: 4,
+			wantLeagues: leagues,
+		},
+		{
+			name:        "empty current version",
+			data:        `{"schema_version":4}`,
+			wantVersion: 4,
+			wantLeagues: map[string]map[string]FixturePrices{},
+		},
+		{
+			name:        "newer version",
+			data:        `{"schema_version":5,"leagues":{}}`,
+			wantVersion: 5,
+			wantErr:     true,
+		},
+		{
+			name:    "invalid version",
+			data:    `{"schema_version":"two"}`,
+			wantErr: true,
+		},
+		{
+			name:    "not a store",
+			data:    `[]`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotLeagues, gotVersion, err := DecodeStore([]byte(tt.data))
+			assert.Equal(t, tt.wantErr, err != nil)
+			assert.Equal(t, tt.wantVersion, gotVersion)
+			assert.Equal(t, tt.wantLeagues, gotLeagues)
+		})
+	}
+}
+
+func TestMigrateStoreFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "store.json")
+	legacy := []byte(`{"59":{"fixture1":{"fixture":"Mainz v Dortmund","history":{}}}}`)
+	assert.Nil(t, ioutil.WriteFile(path, legacy, 0644))
+
+	version, backup, err := MigrateStoreFile(path, true)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, version)
+	assert.Equal(t, path+".v1.bak", backup)
+	data, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, legacy, data)
+
+	version, backup, err = MigrateStoreFile(path, false)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, version)
+	data, err = ioutil.ReadFile(backup)
+	assert.Nil(t, err)
+	assert.Equal(t, legacy, data)
+	data, err = ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"schema_version":4,"leagues":{"59":{"fixture1":{"fixture":"Mainz v Dortmund","date":"","status":"","outcome":"","event_id":"","market_id":"","home_runner":0,"away_runner":0,"history":{}}}}}`, string(data))
+
+	version, backup, err = MigrateStoreFile(path, false)
+	assert.Nil(t, err)
+	assert.Equal(t, CurrentSchemaVersion, version)
+	assert.Equal(t, "", backup)
+}
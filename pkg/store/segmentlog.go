@@ -0,0 +1,292 @@
+// Copyright 2022 Guy Barden
+// segmentlog.go - append-only storage of the store as a directory of log segments
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+type (
+	// SegmentedLog appends each change to the store as a json record to the newest segment file in a directory,
+	// starting a new segment once it reaches the segment size. Opening the log replays every segment in order
+	SegmentedLog struct {
+		Dir         string
+		SegmentSize int64
+		fixtures    map[string]map[string]FixturePrices
+		segment     *os.File
+		writer      *bufio.Writer
+		written     int64
+		sequence    int
+	}
+
+	// logRecord is a single change to the store, each segment starts with a record of its schema version
+	logRecord struct {
+		Op            string         `json:"op"`
+		Version       int            `json:"version,omitempty"`
+		LeagueId      string         `json:"league"`
+		EventId       string         `json:"event"`
+		SelectionId   int            `json:"selection,omitempty"`
+		Market        string         `json:"market,omitempty"`
+		Runner        int            `json:"runner,omitempty"`
+		Fixture       *FixturePrices `json:"fixture,omitempty"`
+		Samples       []Price        `json:"samples,omitempty"`
+		MarketSamples []MarketSample `json:"market_samples,omitempty"`
+	}
+)
+
+const (
+	// DefaultSegmentSize starts a new segment after 64MB
+	DefaultSegmentSize = 64 << 20
+
+	segmentPrefix = "segment-"
+	segmentSuffix = ".log"
+
+	opFixture       = "fixture"
+	opSamples       = "samples"
+	opMarketSamples = "market_samples"
+	opDelete        = "delete"
+	opSchema        = "schema"
+)
+
+// NewSegmentedLog opens the log in dir, creating the directory if needed, and replays its segments
+func NewSegmentedLog(dir string, segmentSize int64) (*SegmentedLog, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	l := SegmentedLog{
+		Dir:         dir,
+		SegmentSize: segmentSize,
+		fixtures:    map[string]map[string]FixturePrices{},
+	}
+	segments, err := l.segments()
+	if err != nil {
+		return nil, err
+	}
+	for _, segment := range segments {
+		if err := l.replay(segment); err != nil {
+			return nil, err
+		}
+	}
+	return &l, nil
+}
+
+// segments returns the segment files in the order they were written
+func (l *SegmentedLog) segments() ([]string, error) {
+	entries, err := ioutil.ReadDir(l.Dir)
+	if err != nil {
+		return nil, err
+	}
+	segments := []string{}
+	for _, entry := range entries {
+		var sequence int
+		if entry.IsDir() || !strings.HasPrefix(entry.Name(), segmentPrefix) || !strings.HasSuffix(entry.Name(), segmentSuffix) {
+			continue
+		}
+		if _, err := fmt.Sscanf(entry.Name(), segmentPrefix+"%06d"+segmentSuffix, &sequence); err != nil {
+			continue
+		}
+		if sequence > l.sequence {
+			l.sequence = sequence
+		}
+		segments = append(segments, filepath.Join(l.Dir, entry.Name()))
+	}
+	sort.Strings(segments)
+	return segments, nil
+}
+
+// replay applies the records of a segment. Segments without a schema record are from schema version 1, which has
+// the same records
+func (l *SegmentedLog) replay(path string) error {
+	return decodeLines(path, func(line []byte) error {
+		record := logRecord{}
+		if err := json.Unmarshal(line, &record); err != nil {
+			return err
+		}
+		if record.Op == opSchema && record.Version > CurrentSchemaVersion {
+			return fmt.Errorf("schema version %d is newer than version %d read by this version of go-football-trader", record.Version, CurrentSchemaVersion)
+		}
+		l.apply(&record)
+		return nil
+	})
+}
+
+func (l *SegmentedLog) apply(record *logRecord) {
+	switch record.Op {
+	case opFixture:
+		if l.fixtures[record.LeagueId] == nil {
+			l.fixtures[record.LeagueId] = map[string]FixturePrices{}
+		}
+		stored := l.fixtures[record.LeagueId][record.EventId]
+		l.fixtures[record.LeagueId][record.EventId] = keepHistories(*record.Fixture, &stored)
+	case opSamples:
+		fixture, ok := l.fixtures[record.LeagueId][record.EventId]
+		if !ok {
+			return
+		}
+		if record.Market != "" {
+			market, ok := fixture.Markets[record.Market]
+			if ok && record.Runner < len(market.Runners) {
+				market.Runners[record.Runner].History = append(market.Runners[record.Runner].History, record.Samples...)
+			}
+			return
+		}
+		fixture.PriceHistory[record.SelectionId] = append(fixture.PriceHistory[record.SelectionId], record.Samples...)
+	case opMarketSamples:
+		fixture, ok := l.fixtures[record.LeagueId][record.EventId]
+		if !ok {
+			return
+		}
+		if history, ok := fixture.marketHistory(record.Market); ok {
+			fixture.setMarketHistory(record.Market, append(history, record.MarketSamples...))
+			l.fixtures[record.LeagueId][record.EventId] = fixture
+		}
+	case opDelete:
+		delete(l.fixtures[record.LeagueId], record.EventId)
+	}
+}
+
+// write appends a record to the current segment, starting a new segment when the current one is full
+func (l *SegmentedLog) write(record *logRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if l.segment == nil || (l.written > 0 && l.written+int64(len(line))+1 > l.SegmentSize) {
+		if err := l.nextSegment(); err != nil {
+			return err
+		}
+	}
+	if err := l.writeLine(line); err != nil {
+		return err
+	}
+	l.apply(record)
+	return nil
+}
+
+func (l *SegmentedLog) writeLine(line []byte) error {
+	if _, err := l.writer.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	l.written += int64(len(line)) + 1
+	return nil
+}
+
+// nextSegment closes the current segment and starts a new one. Segments from earlier runs are never reopened
+func (l *SegmentedLog) nextSegment() error {
+	if err := l.closeSegment(); err != nil {
+		return err
+	}
+	l.sequence++
+	segment, err := os.OpenFile(filepath.Join(l.Dir, fmt.Sprintf("%s%06d%s", segmentPrefix, l.sequence, segmentSuffix)), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	l.segment = segment
+	l.writer = bufio.NewWriter(segment)
+	l.written = 0
+	header, err := json.Marshal(&logRecord{Op: opSchema, Version: CurrentSchemaVersion})
+	if err != nil {
+		return err
+	}
+	return l.writeLine(header)
+}
+
+func (l *SegmentedLog) closeSegment() error {
+	if l.segment == nil {
+		return nil
+	}
+	if err := l.Flush(); err != nil {
+		return err
+	}
+	err := l.segment.Close()
+	l.segment = nil
+	return err
+}
+
+func (l *SegmentedLog) ListFixtures() ([]FixtureRef, error) {
+	return fixtureRefs(l.fixtures, nil), nil
+}
+
+func (l *SegmentedLog) QueryFixtures(query FixtureQuery) ([]FixtureRef, error) {
+	return fixtureRefs(l.fixtures, &query), nil
+}
+
+func (l *SegmentedLog) LoadFixture(leagueId string, eventId string) (FixturePrices, error) {
+	fixture, ok := l.fixtures[leagueId][eventId]
+	if !ok {
+		return FixturePrices{}, fmt.Errorf("unable to find fixture %s in league %s", eventId, leagueId)
+	}
+	// Copy the histories so appends to the log never share a slice with the store
+	return copyHistories(fixture), nil
+}
+
+func (l *SegmentedLog) SaveFixture(leagueId string, eventId string, fixture FixturePrices) error {
+	fixture = withoutHistories(fixture)
+	return l.write(&logRecord{Op: opFixture, LeagueId: leagueId, EventId: eventId, Fixture: &fixture})
+}
+
+func (l *SegmentedLog) AppendSamples(leagueId string, eventId string, selectionId int, samples []Price) error {
+	if _, ok := l.fixtures[leagueId][eventId]; !ok {
+		return fmt.Errorf("unable to find fixture %s in league %s", eventId, leagueId)
+	}
+	return l.write(&logRecord{Op: opSamples, LeagueId: leagueId, EventId: eventId, SelectionId: selectionId, Samples: append([]Price{}, samples...)})
+}
+
+func (l *SegmentedLog) AppendMarketSamples(leagueId string, eventId string, marketType string, runner int, samples []Price) error {
+	market, ok := l.fixtures[leagueId][eventId].Markets[marketType]
+	if !ok || runner >= len(market.Runners) {
+		return fmt.Errorf("unable to find runner %d of market %s in fixture %s in league %s", runner, marketType, eventId, leagueId)
+	}
+	return l.write(&logRecord{Op: opSamples, LeagueId: leagueId, EventId: eventId, Market: marketType, Runner: runner, Samples: append([]Price{}, samples...)})
+}
+
+func (l *SegmentedLog) AppendMarketHistory(leagueId string, eventId string, marketType string, samples []MarketSample) error {
+	fixture, ok := l.fixtures[leagueId][eventId]
+	if _, found := fixture.marketHistory(marketType); !ok || !found {
+		return fmt.Errorf("unable to find market %s in fixture %s in league %s", marketType, eventId, leagueId)
+	}
+	return l.write(&logRecord{Op: opMarketSamples, LeagueId: leagueId, EventId: eventId, Market: marketType, MarketSamples: append([]MarketSample{}, samples...)})
+}
+
+func (l *SegmentedLog) DeleteFixture(leagueId string, eventId string) error {
+	if _, ok := l.fixtures[leagueId][eventId]; !ok {
+		return nil
+	}
+	return l.write(&logRecord{Op: opDelete, LeagueId: leagueId, EventId: eventId})
+}
+
+// Flush writes the buffered records to the current segment and syncs it to disk
+func (l *SegmentedLog) Flush() error {
+	if l.segment == nil {
+		return nil
+	}
+	if err := l.writer.Flush(); err != nil {
+		return err
+	}
+	return l.segment.Sync()
+}
+
+// Close flushes and closes the current segment
+func (l *SegmentedLog) Close() error {
+	return l.closeSegment()
+}
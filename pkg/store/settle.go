@@ -0,0 +1,94 @@
+// Copyright 2022 Guy Barden
+// settle.go - settles fixtures in the store from the results of their closed match odds markets
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"sort"
+	"time"
+
+	"github.com/guysports/go-betfair-api/pkg/types"
+)
+
+const (
+	// Betfair market and runner status values for a settled market
+	MarketClosed = "CLOSED"
+	RunnerWinner = "WINNER"
+)
+
+// SettleFixtures looks up the closed markets of fixtures that have kicked off and records their result, and the
+// status of the runners in their other markets, returning the number of fixtures settled
+func (s *Store) SettleFixtures(now time.Time) (settled int, err error) {
+	for leagueId, league := range s.GlobalPriceStore {
+		marketIds := []string{}
+		for _, fixture := range league {
+			if fixture.MarketID == "" || fixture.MatchStatus == Played {
+				continue
+			}
+			kickoff, err := time.Parse(time.RFC3339, fixture.Date)
+			if err != nil || now.Before(kickoff) {
+				continue
+			}
+			marketIds = append(marketIds, fixture.MarketID)
+		}
+		sort.Strings(marketIds)
+
+		books, err := s.listMarketBooks(marketIds, nil)
+		if err != nil {
+			return settled, err
+		}
+		for _, book := range books {
+			if book.Status != MarketClosed {
+				continue
+			}
+			eventId, err := s.findEventFromMarketId(leagueId, book.MarketId)
+			if err != nil {
+				continue
+			}
+			event := s.GlobalPriceStore[leagueId][eventId]
+			result, ok := resultFromBook(&event, &book)
+			if !ok {
+				continue
+			}
+			event.OutCome = result
+			event.MatchStatus = Played
+			s.GlobalPriceStore[leagueId][eventId] = event
+			settled++
+		}
+		if err := s.settleMarkets(leagueId, now); err != nil {
+			return settled, err
+		}
+	}
+	return settled, nil
+}
+
+// resultFromBook reads the winning runner of a closed match odds market. Fixtures stored before the draw
+// was tracked have no draw runner, so a winner that is neither team is taken to be the draw
+func resultFromBook(event *FixturePrices, book *types.MarketBookWrapper) (Result, bool) {
+	for _, runner := range book.Runners {
+		if runner.Status != RunnerWinner {
+			continue
+		}
+		switch runner.SelectionID {
+		case event.HomeRunnerId:
+			return HomeWin, true
+		case event.AwayRunnerId:
+			return AwayWin, true
+		default:
+			return Draw, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,111 @@
+// Copyright 2022 Guy Barden
+// settle_test.go - tests for settling fixtures from closed markets
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"guysports/go-football-trader/pkg/fake"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStore_SettleFixtures(t *testing.T) {
+	now := time.Date(2022, 4, 7, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name        string
+		client      fake.FakeQuery
+		date        string
+		drawRunner  int
+		wantSettled int
+		wantStatus  Status
+		wantOutCome Result
+		wantErr     bool
+	}{
+		{
+			name:        "home win",
+			client:      fake.FakeQuery{SettledWinner: 64374},
+			date:        "2022-04-06T16:30:00.000Z",
+			wantSettled: 1,
+			wantStatus:  Played,
+			wantOutCome: HomeWin,
+		},
+		{
+			name:        "away win",
+			client:      fake.FakeQuery{SettledWinner: 44785},
+			date:        "2022-04-06T16:30:00.000Z",
+			wantSettled: 1,
+			wantStatus:  Played,
+			wantOutCome: AwayWin,
+		},
+		{
+			name:        "draw with no draw runner recorded",
+			client:      fake.FakeQuery{SettledWinner: 58805},
+			date:        "2022-04-06T16:30:00.000Z",
+			wantSettled: 1,
+			wantStatus:  Played,
+			wantOutCome: Draw,
+		},
+		{
+			name:       "market still open",
+			client:     fake.FakeQuery{},
+			date:       "2022-04-06T16:30:00.000Z",
+			wantStatus: Scheduled,
+		},
+		{
+			name:       "fixture not kicked off",
+			client:     fake.FakeQuery{SettledWinner: 64374},
+			date:       "2022-04-08T16:30:00.000Z",
+			wantStatus: Scheduled,
+		},
+		{
+			name:       "error getting market book",
+			client:     fake.FakeQuery{InjectListMarketBookError: true},
+			date:       "2022-04-06T16:30:00.000Z",
+			wantStatus: Scheduled,
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Store{
+				GlobalPriceStore: map[string]map[string]FixturePrices{
+					"league1": {
+						"fixture1": {
+							Fixture:      "Mainz v Dortmund",
+							Date:         tt.date,
+							MatchStatus:  Scheduled,
+							MarketID:     "1.195693926",
+							HomeRunnerId: 64374,
+							AwayRunnerId: 44785,
+						},
+					},
+				},
+				QueryClient: &tt.client,
+			}
+			settled, err := s.SettleFixtures(now)
+			if tt.wantErr {
+				assert.NotNil(t, err)
+			} else {
+				assert.Nil(t, err)
+			}
+			assert.Equal(t, tt.wantSettled, settled)
+			fixture := s.GlobalPriceStore["league1"]["fixture1"]
+			assert.Equal(t, tt.wantStatus, fixture.MatchStatus)
+			assert.Equal(t, tt.wantOutCome, fixture.OutCome)
+		})
+	}
+}
@@ -20,8 +20,7 @@ import (
 	"fmt"
 	"guysports/go-football-trader/pkg/access"
 	"guysports/go-football-trader/pkg/helper"
-	"io/ioutil"
-	"os"
+	"guysports/go-football-trader/pkg/ladder"
 	"sort"
 	"strings"
 	"time"
@@ -30,9 +29,10 @@ import (
 )
 
 type (
-	Result         string
-	Status         string
-	TrendDirection bool
+	Result          string
+	Status          string
+	TrendDirection  bool
+	AdmissionStatus string
 
 	// Global store of fixtures and their price histories
 	Store struct {
@@ -40,6 +40,25 @@ type (
 		GlobalPriceStore map[string]map[string]FixturePrices `json:"global_price_store"`
 		QueryClient      access.QueryInterface
 		StorePath        string
+		// Backend persists the store, a json file at the store path is used if it is not set
+		Backend Backend
+		// Journal records prices as they are captured until the store is saved, nothing is journaled if it is not set
+		Journal *Journal
+		// Workers is how many leagues are sampled at once, one at a time if it is not set
+		Workers int
+		// saved records what the backend holds for each fixture so only changes are written, keyed by league and event
+		saved map[string]map[string]savedFixture
+	}
+
+	// savedFixture is the fixture details and number of samples of each runner last written to the backend, with
+	// the id and number of samples of each runner of the other markets keyed by market type, and the number of
+	// market samples of each market keyed by market type with match odds as the empty type
+	savedFixture struct {
+		details       string
+		samples       map[int]int
+		marketIds     map[string]string
+		markets       map[string][]int
+		marketSamples map[string]int
 	}
 
 	// FixturePriceStore holds the information about the fixtures and it's prices over time
@@ -52,16 +71,47 @@ type (
 		MarketID     string          `json:"market_id"`
 		HomeRunnerId int             `json:"home_runner"`
 		AwayRunnerId int             `json:"away_runner"`
+		DrawRunnerId int             `json:"draw_runner,omitempty"`
 		PriceHistory map[int][]Price `json:"history"`
+		Admission    *Admission      `json:"admission,omitempty"`
+		Bets         []Bet           `json:"bets,omitempty"`
+		// Markets holds the other markets tracked for the fixture keyed by market type, match odds is kept above
+		Markets map[string]MarketPrices `json:"markets,omitempty"`
+		// Suspensions are the windows the match odds market was suspended in play
+		Suspensions []Suspension `json:"suspensions,omitempty"`
+		// MarketHistory holds the state of the match odds market at each sample
+		MarketHistory []MarketSample `json:"market_history,omitempty"`
+	}
+
+	// Admission records why a fixture was or was not tracked against the query odds range
+	Admission struct {
+		Status    AdmissionStatus `json:"status"`
+		Reason    string          `json:"reason"`
+		Timestamp string          `json:"time_stamp"`
 	}
 
-	// Price holds the price information for a tick
+	// Price holds the price information for a tick, the ladder fields are only recorded in ladder depth mode. The
+	// status, in play flag and bet delay of the market are recorded with each sample
 	Price struct {
-		Timestamp  string  `json:"time_stamp"`
-		BackPrice  float32 `json:"back_price"`
-		LayPrice   float32 `json:"lay_price"`
-		BackAmount float32 `json:"back_amount"`
-		LayAmount  float32 `json:"lay_amount"`
+		Timestamp       string        `json:"time_stamp"`
+		BackPrice       float32       `json:"back_price"`
+		LayPrice        float32       `json:"lay_price"`
+		BackAmount      float32       `json:"back_amount"`
+		LayAmount       float32       `json:"lay_amount"`
+		BackLadder      []LadderLevel `json:"back_ladder,omitempty"`
+		LayLadder       []LadderLevel `json:"lay_ladder,omitempty"`
+		TradedVolume    []LadderLevel `json:"traded_volume,omitempty"`
+		LastPriceTraded float32       `json:"last_price_traded,omitempty"`
+		TotalMatched    float32       `json:"total_matched,omitempty"`
+		MarketStatus    string        `json:"market_status,omitempty"`
+		Inplay          bool          `json:"inplay,omitempty"`
+		BetDelay        int           `json:"bet_delay,omitempty"`
+	}
+
+	// LadderLevel holds the amount available or traded at a price
+	LadderLevel struct {
+		Price  float32 `json:"price"`
+		Amount float32 `json:"amount"`
 	}
 
 	// Trend holds the information extracted from the global store to analyze for price movements
@@ -69,6 +119,7 @@ type (
 		Fixture                  string
 		Team                     string
 		Home                     bool
+		Draw                     bool
 		StartTime                string
 		StartPrice               float32
 		StartLayPrice            float32
@@ -79,6 +130,15 @@ type (
 		PriceChangesAgainstTrend int
 		SampleNumber             int
 		Trend                    TrendDirection
+		OutCome                  Result
+		// MarketType is the market of the runner, empty for match odds
+		MarketType string
+		// RunnerStatus is the Betfair status of a runner in another market once it is settled
+		RunnerStatus string
+		// MarketMatched is the total matched in the runner's market at its last pre-match sample
+		MarketMatched float32
+		// Delayed is set when any pre-match sample of the runner's market was taken from delayed data
+		Delayed bool
 	}
 
 	Trends []Trend
@@ -93,25 +153,65 @@ const (
 
 	TrendingUp   = TrendDirection(true)
 	TrendingDown = TrendDirection(false)
+
+	// DrawRunnerName is the runner name Betfair gives the draw selection in match odds markets
+	DrawRunnerName = "The Draw"
+
+	Pending  = AdmissionStatus("pending")
+	Admitted = AdmissionStatus("admitted")
+	Skipped  = AdmissionStatus("skipped")
+	Dropped  = AdmissionStatus("dropped")
 )
 
-// NewStore holds the state of the fixtures in the targetted leagues and their price trends
-func NewStore(path string, qc access.QueryInterface) *Store {
+// NewStore holds the state of the fixtures in the targetted leagues and their price trends, kept in a json file
+// with the prices captured since it was last saved replayed from its journal. A missing file starts a new store,
+// one that cannot be read is an error so it is never overwritten
+func NewStore(path string, qc access.QueryInterface) (*Store, error) {
+	store, err := LoadStore(NewJSONBackend(path), qc, nil)
+	if err != nil {
+		return nil, err
+	}
+	store.StorePath = path
+	if err := store.AttachJournal(NewJournal(JournalPath(path))); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// LoadStore reads the fixtures selected by the query from the backend, or every fixture if there is no query
+func LoadStore(backend Backend, qc access.QueryInterface, query *FixtureQuery) (*Store, error) {
 	store := Store{
 		GlobalPriceStore: map[string]map[string]FixturePrices{},
-		StorePath:        path,
 		QueryClient:      qc,
+		Backend:          backend,
+		saved:            map[string]map[string]savedFixture{},
+	}
+	var refs []FixtureRef
+	var err error
+	if query != nil {
+		refs, err = backend.QueryFixtures(*query)
+	} else {
+		refs, err = backend.ListFixtures()
 	}
-	// Attempt to read store, return new store if one doesn't exist
-	marshaledStore, err := ioutil.ReadFile(path)
 	if err != nil {
-		return &store
+		return &store, err
+	}
+	for _, ref := range refs {
+		fixture, err := backend.LoadFixture(ref.LeagueId, ref.EventId)
+		if err != nil {
+			return &store, err
+		}
+		if store.GlobalPriceStore[ref.LeagueId] == nil {
+			store.GlobalPriceStore[ref.LeagueId] = map[string]FixturePrices{}
+		}
+		store.GlobalPriceStore[ref.LeagueId][ref.EventId] = fixture
+		store.markSaved(ref.LeagueId, ref.EventId, &fixture)
 	}
-	// No need to check the error, if the store data cannot be unmarshaled start a new store
-	_ = json.Unmarshal(marshaledStore, &store.GlobalPriceStore)
-	return &store
+	return &store, nil
 }
 
+// AddLeaguePricesToStore finds the fixtures of each league in the query and samples their prices. The leagues are
+// sampled concurrently by the store's workers and merged in the order of the query
 func (s *Store) AddLeaguePricesToStore(queryParameters *access.MarketQuery) error {
 	// For each league build the filter to obtain the fixtures
 	currentTime := time.Now()
@@ -125,119 +225,402 @@ func (s *Store) AddLeaguePricesToStore(queryParameters *access.MarketQuery) erro
 		beforeDate = currentTime.Add(time.Duration(queryParameters.MaxDaysToFixtures) * time.Hour * 24)
 	}
 
-	// Build the filter to retrieve fixture markets for each league
-	for _, competitionId := range queryParameters.LeagueIds {
-		filter := types.MarketFilter{
-			EventTypeIds:   []string{"1"}, // Football
-			CompetitionIds: []string{competitionId},
-			MarketStartTime: &types.TimeRange{
-				From: afterDate.Format(time.RFC3339),
-				To:   beforeDate.Format(time.RFC3339),
-			},
+	return s.pollLeagues(queryParameters.LeagueIds, func(league *Store, leagueId string) ([]journalRecord, error) {
+		return league.addLeaguePrices(queryParameters, leagueId, afterDate, beforeDate)
+	})
+}
+
+// addLeaguePrices finds the fixtures of the league kicking off between the dates and samples those being tracked or
+// waiting for their first prices, returning the journal records of the samples
+func (s *Store) addLeaguePrices(queryParameters *access.MarketQuery, competitionId string, afterDate time.Time, beforeDate time.Time) ([]journalRecord, error) {
+	filter := types.MarketFilter{
+		EventTypeIds:   []string{"1"}, // Football
+		CompetitionIds: []string{competitionId},
+		MarketStartTime: &types.TimeRange{
+			From: afterDate.Format(time.RFC3339),
+			To:   beforeDate.Format(time.RFC3339),
+		},
+	}
+
+	events, err := s.QueryClient.ListEvents(&filter)
+	if err != nil {
+		return nil, err
+	}
+	// If there are no events there is nothing to sample in the competition
+	if len(events) == 0 {
+		return nil, nil
+	}
+	// Find fixtures meeting odds criteria
+	fixtureEvents := []string{}
+	for _, fixture := range events {
+		// Build a filter to get the market catalogues for each league
+		fixtureEvents = append(fixtureEvents, fixture.Event.ID)
+
+		// Create an entry in the global price store for the fixture if it doesn't exist
+		if _, ok := s.GlobalPriceStore[competitionId]; !ok {
+			// Create a league entry
+			s.GlobalPriceStore[competitionId] = map[string]FixturePrices{}
+		}
+		league := s.GlobalPriceStore[competitionId]
+		if _, ok := league[fixture.Event.ID]; !ok {
+			// The fixture is pending until its first prices are checked against the odds range
+			s.GlobalPriceStore[competitionId][fixture.Event.ID] = FixturePrices{
+				Fixture:      fixture.Event.Name,
+				Date:         fixture.Event.OpenDate,
+				MatchStatus:  Scheduled,
+				EventID:      fixture.Event.ID,
+				PriceHistory: map[int][]Price{},
+				Admission:    &Admission{Status: Pending},
+			}
 		}
+	}
 
-		events, err := s.QueryClient.ListEvents(&filter)
+	// Get the market catalogues
+	markets, eventIds, err := s.listMarketCatalogue(fixtureEvents, access.MatchOddsMarket)
+	if err != nil {
+		return nil, err
+	}
+	// For each market, get the pricing information
+	marketIds := []string{}
+	for _, market := range markets {
+		// Create PriceHistories keyed on runner selection IDs
+		eventId, err := s.findEventFromMarket(competitionId, eventIds[market.MarketId], &market)
 		if err != nil {
-			return err
+			// cannot find fixture so continue to next one
+			continue
 		}
-		// If there are no events move to the next competition
-		if len(events) == 0 {
+		event := s.GlobalPriceStore[competitionId][eventId]
+		if !event.IsTracked() && event.Admission.Status != Pending {
+			// skipped or dropped fixtures are not sampled again
 			continue
 		}
-		// Find fixtures meeting odds criteria
-		fixtureEvents := []string{}
-		for _, fixture := range events {
-			// Build a filter to get the market catalogues for each league
-			fixtureEvents = append(fixtureEvents, fixture.Event.ID)
+		event.MarketID = market.MarketId
+		event.HomeRunnerId = market.Selections[0].SelectionId
+		event.AwayRunnerId = market.Selections[1].SelectionId
+		event.DrawRunnerId = findDrawRunner(market.Selections)
+		s.GlobalPriceStore[competitionId][eventId] = event
+		marketIds = append(marketIds, market.MarketId)
+	}
+	if err := s.addOtherMarkets(queryParameters, competitionId, fixtureEvents); err != nil {
+		return nil, err
+	}
+	pending, err := s.addMarketBooksToStore(queryParameters, competitionId, marketIds)
+	if err != nil {
+		return pending, err
+	}
+
+	// Fixtures that could not be priced are not kept, they are looked at again next time
+	for eventId, event := range s.GlobalPriceStore[competitionId] {
+		if event.Admission != nil && event.Admission.Status == Pending {
+			delete(s.GlobalPriceStore[competitionId], eventId)
+		}
+	}
+	return pending, nil
+}
 
-			// Create an entry in the global price store for the fixture if it doesn't exist
-			if _, ok := s.GlobalPriceStore[competitionId]; !ok {
-				// Create a league entry
-				s.GlobalPriceStore[competitionId] = map[string]FixturePrices{}
+// AddMarketPricesToStore samples the prices of already tracked markets in a league without rediscovering fixtures
+func (s *Store) AddMarketPricesToStore(queryParameters *access.MarketQuery, competitionId string, marketIds []string) error {
+	if len(marketIds) == 0 {
+		return nil
+	}
+	pending, err := s.addMarketBooksToStore(queryParameters, competitionId, marketIds)
+	// Keep the samples already captured
+	if writeErr := s.Journal.write(pending); writeErr != nil {
+		return writeErr
+	}
+	return err
+}
+
+// IsTracked reports whether prices are being recorded for the fixture, fixtures stored before
+// odds filtering have no admission record and are always tracked
+func (f *FixturePrices) IsTracked() bool {
+	return f.Admission == nil || f.Admission.Status == Admitted
+}
+
+// priceProjection requests the best prices, or the full ladders with traded volume in ladder depth mode
+func priceProjection(queryParameters *access.MarketQuery) *types.PriceProjection {
+	projection := types.PriceProjection{PriceData: []string{"EX_BEST_OFFERS"}}
+	if queryParameters.LadderDepth > 0 {
+		projection.PriceData = []string{"EX_ALL_OFFERS", "EX_TRADED"}
+	}
+	return &projection
+}
+
+// addMarketBooksToStore samples the markets of the league, returning the journal records of the samples taken for
+// the caller to write
+func (s *Store) addMarketBooksToStore(queryParameters *access.MarketQuery, competitionId string, marketIds []string) ([]journalRecord, error) {
+	marketBook, err := s.listMarketBooks(marketIds, priceProjection(queryParameters))
+	if err != nil {
+		return nil, err
+	}
+
+	// With the market books retrieved, distill into back and lay prices for the store
+	pending := []journalRecord{}
+	sampled := []string{}
+	for _, book := range marketBook {
+		// Find the fixture in the global store
+		eventId, err := s.findEventFromMarketId(competitionId, book.MarketId)
+		if err != nil {
+			continue
+		}
+		event := s.GlobalPriceStore[competitionId][eventId]
+		// Find best back and lay prices for the tracked runners
+		prices := map[int]*Price{}
+		sampledAt := time.Now()
+		for _, runner := range book.Runners {
+			if runner.SelectionID == event.HomeRunnerId || runner.SelectionID == event.AwayRunnerId ||
+				(event.DrawRunnerId != 0 && runner.SelectionID == event.DrawRunnerId) {
+				prices[runner.SelectionID] = getPriceFromRunner(&runner, sampledAt)
+				addMarketState(prices[runner.SelectionID], &book)
+				if queryParameters.LadderDepth > 0 {
+					addLadderToPrice(prices[runner.SelectionID], &runner, queryParameters.LadderDepth)
+				}
 			}
-			league := s.GlobalPriceStore[competitionId]
-			if _, ok := league[fixture.Event.ID]; !ok {
-				s.GlobalPriceStore[competitionId][fixture.Event.ID] = FixturePrices{
-					Fixture:      fixture.Event.Name,
-					Date:         fixture.Event.OpenDate,
-					MatchStatus:  Scheduled,
-					EventID:      fixture.Event.ID,
-					PriceHistory: map[int][]Price{},
+		}
+		// Prices swing in play, so only pre-match prices decide whether a fixture is tracked
+		if !book.Inplay {
+			event.Admission = admitFixture(queryParameters, &event, prices)
+		}
+		// The other markets of a skipped fixture are never sampled, so they are not kept
+		if event.Admission != nil && event.Admission.Status == Skipped {
+			event.Markets = nil
+		}
+		s.GlobalPriceStore[competitionId][eventId] = event
+		// Fixtures still pending are not kept, so there is nothing to journal for them
+		if event.Admission == nil || event.Admission.Status != Pending {
+			pending = s.Journal.fixture(pending, competitionId, eventId, &event)
+		}
+		if !event.IsTracked() {
+			continue
+		}
+		sampled = append(sampled, eventId)
+		// Add or create the price history for back and lay
+		for selectionId, price := range prices {
+			if _, ok := event.PriceHistory[selectionId]; !ok {
+				event.PriceHistory[selectionId] = []Price{
+					*price,
 				}
+			} else {
+				event.PriceHistory[selectionId] = append(event.PriceHistory[selectionId], *price)
 			}
+			pending = s.Journal.sample(pending, competitionId, eventId, selectionId, len(event.PriceHistory[selectionId])-1, *price)
+		}
+		event.MarketHistory = append(event.MarketHistory, newMarketSample(&book, sampledAt))
+		s.GlobalPriceStore[competitionId][eventId] = event
+		pending = s.Journal.marketSample(pending, competitionId, eventId, "", len(event.MarketHistory)-1, event.MarketHistory[len(event.MarketHistory)-1])
+		if book.Inplay && event.updateSuspensions() {
+			s.GlobalPriceStore[competitionId][eventId] = event
+			pending = s.Journal.fixture(pending, competitionId, eventId, &event)
 		}
+	}
+	// The match odds samples are kept with the error if the other markets cannot be sampled, the polling round is
+	// durable once they are in the journal
+	return s.addOtherMarketBooks(queryParameters, competitionId, sampled, pending)
+}
 
-		// Get the market catalogues
-		catalogFilter := types.MarketFilter{
-			EventIds:        fixtureEvents,
-			MarketTypeCodes: []string{"MATCH_ODDS"},
+// listMarketBooks fetches the market books in batches as large as the request weight limit allows for the projection
+func (s *Store) listMarketBooks(marketIds []string, priceProjection *types.PriceProjection) ([]types.MarketBookWrapper, error) {
+	books := []types.MarketBookWrapper{}
+	batchSize := access.MarketBookBatchSize(priceProjection)
+	for start := 0; start < len(marketIds); start += batchSize {
+		end := start + batchSize
+		if end > len(marketIds) {
+			end = len(marketIds)
 		}
-		markets, err := s.QueryClient.ListMarketCatalogue(&catalogFilter, len(fixtureEvents), []string{"RUNNER_METADATA"})
-		if err != nil {
-			return err
-		}
-		// For each market, get the pricing information
-		marketIds := []string{}
-		for _, market := range markets {
-			// Create PriceHistories keyed on runner selection IDs
-			leagueId, eventId, err := s.findEventFromTeams(market.Selections[0].Name, market.Selections[1].Name)
-			if err != nil {
-				// cannot find fixture so continue to next one
-				continue
-			}
-			event := s.GlobalPriceStore[leagueId][eventId]
-			event.MarketID = market.MarketId
-			event.HomeRunnerId = market.Selections[0].SelectionId
-			event.AwayRunnerId = market.Selections[1].SelectionId
-			s.GlobalPriceStore[leagueId][eventId] = event
-			marketIds = append(marketIds, market.MarketId)
-		}
-		marketBook, err := s.QueryClient.ListMarketBook(marketIds, &types.PriceProjection{PriceData: []string{"EX_BEST_OFFERS"}}, "EXECUTABLE", "ROLLED_UP_BY_AVG_PRICE")
+		// Prices are only requested while sampling, settling needs just the status of the market and its runners
+		var orderProjection, matchProjection string
+		if priceProjection != nil {
+			orderProjection, matchProjection = "EXECUTABLE", "ROLLED_UP_BY_AVG_PRICE"
+		}
+		batch, err := s.QueryClient.ListMarketBook(marketIds[start:end], priceProjection, orderProjection, matchProjection)
 		if err != nil {
-			return err
+			return nil, err
 		}
+		books = append(books, batch...)
+	}
+	return books, nil
+}
 
-		// With the market books retrieved, distill into back and lay prices for the store
-		for _, book := range marketBook {
-			// Find the fixture in the global store
-			eventId, err := s.findEventFromMarketId(competitionId, book.MarketId)
-			if err != nil {
-				continue
+// admitFixture decides from the latest prices whether a fixture is tracked. Pending fixtures are admitted when
+// either runner's best back price is in the odds range, admitted fixtures are dropped when both drift out of
+// range unless the query asks to keep tracking them
+func admitFixture(queryParameters *access.MarketQuery, event *FixturePrices, prices map[int]*Price) *Admission {
+	admission := event.Admission
+	if admission != nil && admission.Status != Pending && admission.Status != Admitted {
+		return admission
+	}
+	if admission != nil && admission.Status == Admitted && queryParameters.KeepTracking {
+		return admission
+	}
+
+	now := time.Now().Format(time.RFC3339)
+	if !queryParameters.HasOddsFilter() {
+		if admission == nil || admission.Status == Admitted {
+			return admission
+		}
+		return &Admission{Status: Admitted, Reason: "no odds range set", Timestamp: now}
+	}
+
+	homePrice, awayPrice := prices[event.HomeRunnerId], prices[event.AwayRunnerId]
+	if homePrice == nil || awayPrice == nil {
+		return admission
+	}
+	description := fmt.Sprintf("home %.2f away %.2f", homePrice.BackPrice, awayPrice.BackPrice)
+	inRange := queryParameters.OddsInRange(homePrice.BackPrice) || queryParameters.OddsInRange(awayPrice.BackPrice)
+	switch {
+	case admission != nil && admission.Status == Pending && inRange:
+		return &Admission{Status: Admitted, Reason: fmt.Sprintf("%s within %s", description, queryParameters.OddsRange()), Timestamp: now}
+	case admission != nil && admission.Status == Pending:
+		return &Admission{Status: Skipped, Reason: fmt.Sprintf("%s outside %s", description, queryParameters.OddsRange()), Timestamp: now}
+	case !inRange:
+		return &Admission{Status: Dropped, Reason: fmt.Sprintf("%s drifted outside %s", description, queryParameters.OddsRange()), Timestamp: now}
+	}
+	return admission
+}
+
+// SaveStoreToFile writes the changes made since the store was loaded or last saved to the backend. New samples are
+// appended to the runners' histories, and a fixture whose history has been cut short is written again in full.
+// Once the backend is flushed the journal is emptied as everything in it is now in the snapshot
+func (s *Store) SaveStoreToFile() error {
+	if s.Backend == nil {
+		s.Backend = NewJSONBackend(s.StorePath)
+	}
+	// Without a record of what was saved the backend may hold anything, so every fixture is written in full
+	known := s.saved != nil
+	if !known {
+		s.saved = map[string]map[string]savedFixture{}
+	}
+
+	for leagueId, league := range s.GlobalPriceStore {
+		for eventId, fixture := range league {
+			saved, ok := s.saved[leagueId][eventId]
+			details := fixtureDetails(&fixture)
+			if !known || !ok || saved.truncated(&fixture) {
+				if err := s.Backend.DeleteFixture(leagueId, eventId); err != nil {
+					return err
+				}
+				saved = savedFixture{samples: map[int]int{}, markets: map[string][]int{}, marketSamples: map[string]int{}}
 			}
-			event := s.GlobalPriceStore[competitionId][eventId]
-			// Add or create the price history for back and lay
-			for _, runner := range book.Runners {
-				if runner.SelectionID == event.HomeRunnerId || runner.SelectionID == event.AwayRunnerId {
-					// Find best back and lay prices
-					price := getPriceFromRunner(&runner)
-					if _, ok := event.PriceHistory[runner.SelectionID]; !ok {
-						event.PriceHistory[runner.SelectionID] = []Price{
-							*price,
+			if details != saved.details {
+				if err := s.Backend.SaveFixture(leagueId, eventId, fixture); err != nil {
+					return err
+				}
+			}
+			for selectionId, history := range fixture.PriceHistory {
+				if len(history) > saved.samples[selectionId] {
+					if err := s.Backend.AppendSamples(leagueId, eventId, selectionId, history[saved.samples[selectionId]:]); err != nil {
+						return err
+					}
+				}
+			}
+			for marketType, market := range fixture.Markets {
+				counts := saved.markets[marketType]
+				for i, runner := range market.Runners {
+					count := 0
+					if i < len(counts) {
+						count = counts[i]
+					}
+					if len(runner.History) > count {
+						if err := s.Backend.AppendMarketSamples(leagueId, eventId, marketType, i, runner.History[count:]); err != nil {
+							return err
 						}
-					} else {
-						event.PriceHistory[runner.SelectionID] = append(event.PriceHistory[runner.SelectionID], *price)
 					}
 				}
 			}
+			for _, marketType := range fixture.marketTypes() {
+				history, _ := fixture.marketHistory(marketType)
+				if count := saved.marketSamples[marketType]; len(history) > count {
+					if err := s.Backend.AppendMarketHistory(leagueId, eventId, marketType, history[count:]); err != nil {
+						return err
+					}
+				}
+			}
+			s.markSaved(leagueId, eventId, &fixture)
 		}
 	}
-	return nil
-}
 
-func (s *Store) SaveStoreToFile() error {
-	storebytes, err := json.Marshal(s.GlobalPriceStore)
-	if err != nil {
+	// Fixtures removed from the store are removed from the backend
+	for leagueId, league := range s.saved {
+		for eventId := range league {
+			if _, ok := s.GlobalPriceStore[leagueId][eventId]; ok {
+				continue
+			}
+			if err := s.Backend.DeleteFixture(leagueId, eventId); err != nil {
+				return err
+			}
+			delete(league, eventId)
+		}
+	}
+	if err := s.Backend.Flush(); err != nil {
 		return err
 	}
+	return s.Journal.Truncate()
+}
 
-	now := time.Now().Unix()
-	nameComponents := strings.Split(s.StorePath, ".")
-	_ = os.Rename(s.StorePath, fmt.Sprintf("%s_%d.%s", nameComponents[0], now, nameComponents[1]))
-	err = ioutil.WriteFile(s.StorePath, storebytes, 0666)
-	if err != nil {
-		return err
+// ImportFixture adds a fixture built outside the tracker, replacing any stored fixture with the same event id in
+// full on the next save
+func (s *Store) ImportFixture(leagueId string, eventId string, fixture FixturePrices) {
+	if s.GlobalPriceStore[leagueId] == nil {
+		s.GlobalPriceStore[leagueId] = map[string]FixturePrices{}
+	}
+	s.GlobalPriceStore[leagueId][eventId] = fixture
+	if s.saved != nil {
+		delete(s.saved[leagueId], eventId)
 	}
-	return nil
+}
+
+func (s *Store) markSaved(leagueId string, eventId string, fixture *FixturePrices) {
+	if s.saved[leagueId] == nil {
+		s.saved[leagueId] = map[string]savedFixture{}
+	}
+	saved := savedFixture{details: fixtureDetails(fixture), samples: map[int]int{}, marketIds: map[string]string{}, markets: map[string][]int{}, marketSamples: map[string]int{}}
+	for selectionId, history := range fixture.PriceHistory {
+		saved.samples[selectionId] = len(history)
+	}
+	for marketType, market := range fixture.Markets {
+		saved.marketIds[marketType] = market.MarketID
+		for _, runner := range market.Runners {
+			saved.markets[marketType] = append(saved.markets[marketType], len(runner.History))
+		}
+	}
+	for _, marketType := range fixture.marketTypes() {
+		history, _ := fixture.marketHistory(marketType)
+		saved.marketSamples[marketType] = len(history)
+	}
+	s.saved[leagueId][eventId] = saved
+}
+
+// truncated reports whether any runner or market has fewer samples than were saved, or another market has been
+// replaced or removed since it was saved
+func (f *savedFixture) truncated(fixture *FixturePrices) bool {
+	for selectionId, count := range f.samples {
+		if len(fixture.PriceHistory[selectionId]) < count {
+			return true
+		}
+	}
+	for marketType, counts := range f.markets {
+		market, ok := fixture.Markets[marketType]
+		if !ok || market.MarketID != f.marketIds[marketType] || len(market.Runners) < len(counts) {
+			return true
+		}
+		for i, count := range counts {
+			if len(market.Runners[i].History) < count {
+				return true
+			}
+		}
+	}
+	for marketType, count := range f.marketSamples {
+		if history, ok := fixture.marketHistory(marketType); !ok || len(history) < count {
+			return true
+		}
+	}
+	return false
+}
+
+// fixtureDetails returns the fixture without its price history in a comparable form
+func fixtureDetails(fixture *FixturePrices) string {
+	detailBytes, _ := json.Marshal(withoutHistories(*fixture))
+	return string(detailBytes)
 }
 
 func (s *Store) ExtractTrendsFromFixtures() (trends Trends) {
@@ -247,6 +630,7 @@ func (s *Store) ExtractTrendsFromFixtures() (trends Trends) {
 			if trend != nil {
 				trends = append(trends, trend...)
 			}
+			trends = append(trends, extractMarketTrends(fixture)...)
 		}
 	}
 	sort.Sort(trends)
@@ -264,68 +648,129 @@ func (t Trends) Swap(i, j int) {
 	t[i], t[j] = t[j], t[i]
 }
 
+// Settled reports whether the result of the trend's fixture, or its runner in another market, is known
+func (t Trend) Settled() bool {
+	if t.MarketType != "" {
+		return t.RunnerStatus != ""
+	}
+	return t.OutCome != ""
+}
+
+// RunnerWon reports whether the runner the trend follows won its settled fixture or market
+func (t Trend) RunnerWon() bool {
+	switch {
+	case t.MarketType != "":
+		return t.RunnerStatus == RunnerWinner
+	case t.Draw:
+		return t.OutCome == Draw
+	case t.Home:
+		return t.OutCome == HomeWin
+	}
+	return t.OutCome == AwayWin
+}
+
 func extractTrendFromFixture(fixture FixturePrices) (trend Trends) {
 	teams := strings.Split(fixture.Fixture, " v ")
 	if len(teams) != 2 {
 		return nil
 	}
-	homeId := fixture.HomeRunnerId
-	awayId := fixture.AwayRunnerId
-	homePriceHistory := fixture.PriceHistory[homeId]
-	awayPriceHistory := fixture.PriceHistory[awayId]
 
-	// Find entry point of start & start layprice being within two ticks
-	homeIdx := findStartIndexInPrices(homePriceHistory)
-	if homeIdx == nil {
+	homeTrend := trendFromPrices(fixture.Fixture, teams[0], fixture.PriceHistory[fixture.HomeRunnerId])
+	if homeTrend == nil {
+		return nil
+	}
+	homeTrend.Home = true
+	homeTrend.OutCome = fixture.OutCome
+	trend = append(trend, *homeTrend)
+
+	awayTrend := trendFromPrices(fixture.Fixture, teams[1], fixture.PriceHistory[fixture.AwayRunnerId])
+	if awayTrend == nil {
 		return nil
 	}
-	homeTrend := Trend{
-		Fixture:         fixture.Fixture,
-		Team:            teams[0],
-		Home:            true,
-		StartTime:       homePriceHistory[*homeIdx].Timestamp,
-		StartPrice:      homePriceHistory[*homeIdx].BackPrice,
-		StartLayPrice:   homePriceHistory[*homeIdx].LayPrice,
-		CurrentPrice:    homePriceHistory[len(homePriceHistory)-1].LayPrice,
-		CurrentLayPrice: homePriceHistory[len(homePriceHistory)-1].BackPrice,
-		Delta:           helper.ConvertTo2DP(homePriceHistory[*homeIdx].BackPrice - homePriceHistory[len(homePriceHistory)-1].LayPrice),
-		SampleNumber:    len(homePriceHistory) - *homeIdx,
+	awayTrend.OutCome = fixture.OutCome
+	trend = append(trend, *awayTrend)
+
+	// Fixtures stored before the draw was tracked have no draw runner
+	if fixture.DrawRunnerId != 0 {
+		drawTrend := trendFromPrices(fixture.Fixture, DrawRunnerName, fixture.PriceHistory[fixture.DrawRunnerId])
+		if drawTrend != nil {
+			drawTrend.Draw = true
+			drawTrend.OutCome = fixture.OutCome
+			trend = append(trend, *drawTrend)
+		}
 	}
-	trend = append(trend, homeTrend)
+	addMarketLiquidity(trend, fixture.MarketHistory)
 
+	return trend
+}
+
+func trendFromPrices(fixture string, team string, priceHistory []Price) *Trend {
+	// Trends follow the pre-match price movement, in-play samples are left out
+	priceHistory = preMatch(priceHistory)
 	// Find entry point of start & start layprice being within two ticks
-	awayIdx := findStartIndexInPrices(homePriceHistory)
-	if awayIdx == nil {
+	idx := findStartIndexInPrices(priceHistory)
+	if idx == nil {
 		return nil
 	}
-	awayTrend := Trend{
-		Fixture:         fixture.Fixture,
-		Team:            teams[1],
-		Home:            false,
-		StartPrice:      awayPriceHistory[*awayIdx].BackPrice,
-		StartLayPrice:   awayPriceHistory[*awayIdx].LayPrice,
-		CurrentPrice:    awayPriceHistory[len(awayPriceHistory)-1].LayPrice,
-		CurrentLayPrice: awayPriceHistory[len(awayPriceHistory)-1].BackPrice,
-		Delta:           helper.ConvertTo2DP(awayPriceHistory[*awayIdx].BackPrice - awayPriceHistory[len(awayPriceHistory)-1].LayPrice),
-		SampleNumber:    len(awayPriceHistory) - *awayIdx,
+	last := priceHistory[len(priceHistory)-1]
+	return &Trend{
+		Fixture:         fixture,
+		Team:            team,
+		StartTime:       priceHistory[*idx].Timestamp,
+		StartPrice:      priceHistory[*idx].BackPrice,
+		StartLayPrice:   priceHistory[*idx].LayPrice,
+		CurrentPrice:    last.LayPrice,
+		CurrentLayPrice: last.BackPrice,
+		Delta:           helper.ConvertTo2DP(priceHistory[*idx].BackPrice - last.LayPrice),
+		SampleNumber:    len(priceHistory) - *idx,
 	}
-	trend = append(trend, awayTrend)
+}
 
-	return trend
+// listMarketCatalogue returns the markets of the market type in the events, with the event id of each market keyed
+// by market id if the query client can request them
+func (s *Store) listMarketCatalogue(eventIds []string, marketType string) ([]types.MarketCatalogueWrapper, map[string]string, error) {
+	catalogFilter := types.MarketFilter{
+		EventIds:        eventIds,
+		MarketTypeCodes: []string{marketType},
+	}
+	if eventClient, ok := s.QueryClient.(access.EventCatalogueInterface); ok {
+		return eventClient.ListEventMarketCatalogue(&catalogFilter, len(eventIds))
+	}
+	markets, err := s.QueryClient.ListMarketCatalogue(&catalogFilter, len(eventIds), []string{"RUNNER_METADATA"})
+	return markets, map[string]string{}, err
 }
 
-func (s *Store) findEventFromTeams(homeTeam string, awayTeam string) (leagueId string, eventId string, err error) {
-	// Look in each league
-	for leagueId, league := range s.GlobalPriceStore {
-		// Look at each fixture in the league
-		for eventId, event := range league {
-			// Check if the home and away teams are in the fixture name
-			if strings.Contains(event.Fixture, homeTeam) && strings.Contains(event.Fixture, awayTeam) {
-				return leagueId, eventId, nil
-			}
+// findEventFromMarket returns the fixture in the league that the market is in by its event id, or by the names
+// of its first two runners if the market's event is unknown or not in the league
+func (s *Store) findEventFromMarket(leagueId string, eventId string, market *types.MarketCatalogueWrapper) (string, error) {
+	if _, ok := s.GlobalPriceStore[leagueId][eventId]; ok && eventId != "" {
+		return eventId, nil
+	}
+	if len(market.Selections) < 2 {
+		return "", fmt.Errorf("unable to find fixture of market %s without runners", market.MarketId)
+	}
+	return s.findEventFromTeams(leagueId, market.Selections[0].Name, market.Selections[1].Name)
+}
+
+// findEventFromTeams returns the fixture in the league between the home and away teams, compared by their
+// normalised names
+func (s *Store) findEventFromTeams(leagueId string, homeTeam string, awayTeam string) (eventId string, err error) {
+	for eventId, event := range s.GlobalPriceStore[leagueId] {
+		if fixtureMatchesTeams(event.Fixture, homeTeam, awayTeam) {
+			return eventId, nil
+		}
+	}
+	return "", fmt.Errorf("unable to find fixture of %s v %s in league %s", homeTeam, awayTeam, leagueId)
+}
+
+// findDrawRunner returns the selection id of the draw in a match odds market, or 0 if there isn't one
+func findDrawRunner(selections []types.Selection) int {
+	for _, selection := range selections {
+		if selection.Name == DrawRunnerName {
+			return selection.SelectionId
 		}
 	}
-	return "", "", fmt.Errorf("unable to find fixture from event Id")
+	return 0
 }
 
 func (s *Store) findEventFromMarketId(leagueId string, marketId string) (eventId string, err error) {
@@ -337,10 +782,26 @@ func (s *Store) findEventFromMarketId(leagueId string, marketId string) (eventId
 	return "", fmt.Errorf("unable to find fixture from market Id")
 }
 
-func getPriceFromRunner(runner *types.Runner) *Price {
-	now := time.Now()
+// CurrentRunnerPrice returns the best back and lay prices available now for a runner in a market
+func (s *Store) CurrentRunnerPrice(marketId string, selectionId int) (*Price, error) {
+	marketBook, err := s.QueryClient.ListMarketBook([]string{marketId}, &types.PriceProjection{PriceData: []string{"EX_BEST_OFFERS"}}, "EXECUTABLE", "ROLLED_UP_BY_AVG_PRICE")
+	if err != nil {
+		return nil, err
+	}
+	for _, book := range marketBook {
+		for _, runner := range book.Runners {
+			if book.MarketId == marketId && runner.SelectionID == selectionId {
+				return getPriceFromRunner(&runner, time.Now()), nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("unable to find runner %d in market %s", selectionId, marketId)
+}
+
+// getPriceFromRunner returns the best back and lay prices of the runner sampled at the time
+func getPriceFromRunner(runner *types.Runner, sampledAt time.Time) *Price {
 	price := Price{
-		Timestamp: now.Format(time.RFC3339),
+		Timestamp: sampledAt.Format(time.RFC3339),
 	}
 
 	price.BackPrice, price.BackAmount = returnBestPrice(runner.Exchange.AvailableToBack, true)
@@ -349,6 +810,36 @@ func getPriceFromRunner(runner *types.Runner) *Price {
 	return &price
 }
 
+// addLadderToPrice records the best depth levels of each side of the ladder with the runner's traded volume
+func addLadderToPrice(price *Price, runner *types.Runner, depth int) {
+	price.BackLadder = ladderFromOdds(runner.Exchange.AvailableToBack, true, depth)
+	price.LayLadder = ladderFromOdds(runner.Exchange.AvailableToLay, false, depth)
+	price.TradedVolume = ladderFromOdds(runner.Exchange.TradedVolume, false, 0)
+	price.LastPriceTraded = runner.LastPriceTraded
+	price.TotalMatched = runner.TotalMatched
+}
+
+// ladderFromOdds orders the odds best price first and truncates to depth levels, a depth of 0 keeps every level
+func ladderFromOdds(availableOdds []types.Odds, highest bool, depth int) []LadderLevel {
+	ladder := make([]LadderLevel, 0, len(availableOdds))
+	for _, odds := range availableOdds {
+		ladder = append(ladder, LadderLevel{Price: odds.Price, Amount: odds.Size})
+	}
+	sort.Slice(ladder, func(i, j int) bool {
+		if highest {
+			return ladder[i].Price > ladder[j].Price
+		}
+		return ladder[i].Price < ladder[j].Price
+	})
+	if depth > 0 && len(ladder) > depth {
+		ladder = ladder[:depth]
+	}
+	if len(ladder) == 0 {
+		return nil
+	}
+	return ladder
+}
+
 func returnBestPrice(availableOdds []types.Odds, highest bool) (price float32, amount float32) {
 	for i, odds := range availableOdds {
 		if i == 0 {
@@ -373,11 +864,18 @@ func returnBestPrice(availableOdds []types.Odds, highest bool) (price float32, a
 
 func findStartIndexInPrices(prices []Price) (index *int) {
 	for idx, price := range prices {
-		tickOffset := helper.GetBetfairTickOffset(price.BackPrice)
-		if (price.LayPrice - price.BackPrice) <= 2*tickOffset {
+		if price.TightSpread() {
 			index = &idx
 			break
 		}
 	}
 	return index
 }
+
+// TightSpread reports whether the back and lay prices are within two ticks, so the market is liquid enough to trade
+func (p Price) TightSpread() bool {
+	if p.BackPrice <= 0 || p.LayPrice <= 0 {
+		return false
+	}
+	return ladder.TicksBetween(p.BackPrice, p.LayPrice) <= 2
+}
@@ -17,6 +17,7 @@ package store
 
 import (
 	"encoding/json"
+	"fmt"
 	"guysports/go-football-trader/pkg/access"
 	"guysports/go-football-trader/pkg/fake"
 	"io/ioutil"
@@ -154,10 +155,9 @@ func Test_getPriceFromRunner(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := getPriceFromRunner(tt.args.runner)
-			// Inject timestamp from got into want for assert
-			tt.want.Timestamp = got.Timestamp
-			assert.Equal(t, tt.want, got)
+			sampledAt := time.Date(2022, 4, 6, 12, 0, 0, 0, time.UTC)
+			tt.want.Timestamp = "2022-04-06T12:00:00Z"
+			assert.Equal(t, tt.want, getPriceFromRunner(tt.args.runner, sampledAt))
 		})
 	}
 }
@@ -236,18 +236,25 @@ func TestStore_findEventFromTeams(t *testing.T) {
 		GlobalPriceStore map[string]map[string]FixturePrices
 	}
 	type args struct {
+		leagueId string
 		homeTeam string
 		awayTeam string
 	}
 	teardownSuite := setupTestSuite(t)
 	defer teardownSuite(t)
+	youthStore := map[string]map[string]FixturePrices{
+		"10932509": {
+			"youth":  {Fixture: "Man City U23 v Chelsea U23"},
+			"senior": {Fixture: "Manchester City v Chelsea"},
+			"leeds":  {Fixture: "Leeds United v Brighton & Hove Albion"},
+		},
+	}
 	tests := []struct {
-		name         string
-		fields       fields
-		args         args
-		wantLeagueId string
-		wantEventId  string
-		wantErr      bool
+		name        string
+		fields      fields
+		args        args
+		wantEventId string
+		wantErr     bool
 	}{
 		{
 			name: "golden path finding existing teams",
@@ -255,11 +262,11 @@ func TestStore_findEventFromTeams(t *testing.T) {
 				GlobalPriceStore: testStore,
 			},
 			args: args{
+				leagueId: "league1",
 				homeTeam: "Leeds",
 				awayTeam: "Southampton",
 			},
-			wantLeagueId: "league1",
-			wantEventId:  "fixture1",
+			wantEventId: "fixture1",
 		},
 		{
 			name: "error finding event from teams",
@@ -267,23 +274,83 @@ func TestStore_findEventFromTeams(t *testing.T) {
 				GlobalPriceStore: testStore,
 			},
 			args: args{
+				leagueId: "league1",
 				homeTeam: "Brighton",
 				awayTeam: "Southampton",
 			},
 			wantErr: true,
 		},
+		{
+			name: "teams are only looked for in the league",
+			fields: fields{
+				GlobalPriceStore: testStore,
+			},
+			args: args{
+				leagueId: "league2",
+				homeTeam: "Leeds",
+				awayTeam: "Southampton",
+			},
+			wantErr: true,
+		},
+		{
+			name: "senior side never matches the youth fixture",
+			fields: fields{
+				GlobalPriceStore: youthStore,
+			},
+			args: args{
+				leagueId: "10932509",
+				homeTeam: "Man City",
+				awayTeam: "Chelsea",
+			},
+			wantEventId: "senior",
+		},
+		{
+			name: "youth side matches the youth fixture",
+			fields: fields{
+				GlobalPriceStore: youthStore,
+			},
+			args: args{
+				leagueId: "10932509",
+				homeTeam: "Man City U23",
+				awayTeam: "Chelsea U23",
+			},
+			wantEventId: "youth",
+		},
+		{
+			name: "aliases and punctuation",
+			fields: fields{
+				GlobalPriceStore: youthStore,
+			},
+			args: args{
+				leagueId: "10932509",
+				homeTeam: "Leeds",
+				awayTeam: "Brighton",
+			},
+			wantEventId: "leeds",
+		},
+		{
+			name: "team whose name is part of another",
+			fields: fields{
+				GlobalPriceStore: youthStore,
+			},
+			args: args{
+				leagueId: "10932509",
+				homeTeam: "City",
+				awayTeam: "Chelsea",
+			},
+			wantErr: true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			s := &Store{
 				GlobalPriceStore: tt.fields.GlobalPriceStore,
 			}
-			gotLeagueId, gotEventId, err := s.findEventFromTeams(tt.args.homeTeam, tt.args.awayTeam)
+			gotEventId, err := s.findEventFromTeams(tt.args.leagueId, tt.args.homeTeam, tt.args.awayTeam)
 			if tt.wantErr {
 				assert.NotNil(t, err)
 			} else {
 				assert.Equal(t, tt.wantEventId, gotEventId)
-				assert.Equal(t, tt.wantLeagueId, gotLeagueId)
 				assert.Nil(t, err)
 			}
 		})
@@ -331,26 +398,59 @@ func TestStore_AddLeaguePricesToStore(t *testing.T) {
 						MarketID:     "1.195693926",
 						HomeRunnerId: 64374,
 						AwayRunnerId: 44785,
+						DrawRunnerId: 58805,
+						Admission: &Admission{
+							Status:    Admitted,
+							Reason:    "no odds range set",
+							Timestamp: time.Now().Format(time.RFC3339),
+						},
 						PriceHistory: map[int][]Price{
+							58805: {
+								{
+									Timestamp:    time.Now().Format(time.RFC3339),
+									BackPrice:    2.56,
+									LayPrice:     2.6,
+									BackAmount:   70.4,
+									LayAmount:    171.1,
+									MarketStatus: "OPEN",
+									BetDelay:     5,
+								},
+							},
 							44785: {
 								{
-									Timestamp:  time.Now().Format(time.RFC3339),
-									BackPrice:  2.86,
-									LayPrice:   2.9,
-									BackAmount: 537.2,
-									LayAmount:  194.9,
+									Timestamp:    time.Now().Format(time.RFC3339),
+									BackPrice:    2.86,
+									LayPrice:     2.9,
+									BackAmount:   537.2,
+									LayAmount:    194.9,
+									MarketStatus: "OPEN",
+									BetDelay:     5,
 								},
 							},
 							64374: {
 								{
-									Timestamp:  time.Now().Format(time.RFC3339),
-									BackPrice:  3.7,
-									LayPrice:   3.75,
-									BackAmount: 777.45,
-									LayAmount:  718.45,
+									Timestamp:    time.Now().Format(time.RFC3339),
+									BackPrice:    3.7,
+									LayPrice:     3.75,
+									BackAmount:   777.45,
+									LayAmount:    718.45,
+									MarketStatus: "OPEN",
+									BetDelay:     5,
 								},
 							},
 						},
+						MarketHistory: []MarketSample{
+							{
+								Timestamp:      time.Now().Format(time.RFC3339),
+								TotalMatched:   946450.74,
+								TotalAvailable: 173585.43,
+								Version:        4417661231,
+								LastMatchTime:  "2022-03-16T18:34:06.924Z",
+								Delayed:        true,
+								BackOverround:  1.0105456,
+								LayOverround:   0.9961096,
+							},
+						},
 					},
 				},
 			},
@@ -424,19 +524,43 @@ func TestStore_AddLeaguePricesToStore(t *testing.T) {
 				client.AppendPrices = true
 				prices := tt.wantStore["league1"]["fixture1"]
 				prices.PriceHistory[44785] = append(prices.PriceHistory[44785], Price{
-					Timestamp:  time.Now().Format(time.RFC3339),
-					BackPrice:  2.84,
-					LayPrice:   2.92,
-					BackAmount: 537.2,
-					LayAmount:  194.9,
+					Timestamp:    time.Now().Format(time.RFC3339),
+					BackPrice:    2.84,
+					LayPrice:     2.92,
+					BackAmount:   537.2,
+					LayAmount:    194.9,
+					MarketStatus: "OPEN",
+					BetDelay:     5,
+				})
+				prices.PriceHistory[58805] = append(prices.PriceHistory[58805], Price{
+					Timestamp:    time.Now().Format(time.RFC3339),
+					BackPrice:    2.56,
+					LayPrice:     2.6,
+					BackAmount:   70.4,
+					LayAmount:    171.1,
+					MarketStatus: "OPEN",
+					BetDelay:     5,
 				})
 				prices.PriceHistory[64374] = append(prices.PriceHistory[64374], Price{
-					Timestamp:  time.Now().Format(time.RFC3339),
-					BackPrice:  3.75,
-					LayPrice:   3.8,
-					BackAmount: 777.45,
-					LayAmount:  718.45,
+					Timestamp:    time.Now().Format(time.RFC3339),
+					BackPrice:    3.75,
+					LayPrice:     3.8,
+					BackAmount:   777.45,
+					LayAmount:    718.45,
+					MarketStatus: "OPEN",
+					BetDelay:     5,
 				})
+				prices.MarketHistory = append(prices.MarketHistory, MarketSample{
+					Timestamp:      time.Now().Format(time.RFC3339),
+					TotalMatched:   946450.74,
+					TotalAvailable: 173585.43,
+					Version:        4417661231,
+					LastMatchTime:  "2022-03-16T18:34:06.924Z",
+					Delayed:        true,
+					BackOverround:  1.0094044,
+					LayOverround:   0.990239,
+				})
+				tt.wantStore["league1"]["fixture1"] = prices
 				err = s.AddLeaguePricesToStore(tt.args.queryParameters)
 				assert.Nil(t, err)
 				assert.Equal(t, tt.wantStore, s.GlobalPriceStore)
@@ -445,6 +569,49 @@ func TestStore_AddLeaguePricesToStore(t *testing.T) {
 	}
 }
 
+func TestStore_AddLeaguePricesToStoreCatalogueEvents(t *testing.T) {
+	tests := []struct {
+		name                string
+		omitCatalogueEvents bool
+		fixtureName         string
+		wantMarketId        string
+	}{
+		{
+			name:         "market mapped to its event by id",
+			fixtureName:  "FSV Mainz 05 v Borussia Dortmund",
+			wantMarketId: "1.195693926",
+		},
+		{
+			name:                "market without an event matched by team names",
+			omitCatalogueEvents: true,
+			fixtureName:         "Mainz v Borussia Dortmund",
+			wantMarketId:        "1.195693926",
+		},
+		{
+			name:                "market without an event and team names that do not match",
+			omitCatalogueEvents: true,
+			fixtureName:         "FSV Mainz 05 v Borussia Dortmund",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Store{
+				GlobalPriceStore: map[string]map[string]FixturePrices{
+					"league1": {"fixture1": {Fixture: tt.fixtureName, EventID: "fixture1", PriceHistory: map[int][]Price{}, Admission: &Admission{Status: Admitted}}},
+				},
+				QueryClient: &fake.FakeQuery{OmitCatalogueEvents: tt.omitCatalogueEvents},
+			}
+			err := s.AddLeaguePricesToStore(&access.MarketQuery{LeagueIds: []string{"league1"}})
+			assert.Nil(t, err)
+			fixture := s.GlobalPriceStore["league1"]["fixture1"]
+			assert.Equal(t, tt.wantMarketId, fixture.MarketID)
+			if tt.wantMarketId != "" {
+				assert.Equal(t, []int{64374, 44785, 58805}, []int{fixture.HomeRunnerId, fixture.AwayRunnerId, fixture.DrawRunnerId})
+			}
+		})
+	}
+}
+
 func Test_extractTrendFromFixture(t *testing.T) {
 	type args struct {
 		fixture FixturePrices
@@ -465,24 +632,84 @@ func Test_extractTrendFromFixture(t *testing.T) {
 			},
 			wantTrend: []Trend{
 				{
-					Fixture:       "Leeds v Southampton",
-					Team:          "Leeds",
-					Home:          true,
-					StartPrice:    2.5,
-					StartLayPrice: 2.58,
-					CurrentPrice:  2.44,
-					Delta:         0.06,
-					SampleNumber:  3,
+					Fixture:         "Leeds v Southampton",
+					Team:            "Leeds",
+					Home:            true,
+					StartTime:       "2022-03-23T14:21:01Z",
+					StartPrice:      2.44,
+					StartLayPrice:   2.46,
+					CurrentPrice:    2.44,
+					CurrentLayPrice: 2.42,
+					Delta:           0,
+					SampleNumber:    2,
 				},
 				{
-					Fixture:       "Leeds v Southampton",
-					Team:          "Southampton",
-					Home:          false,
-					StartPrice:    2.8,
-					StartLayPrice: 2.84,
-					CurrentPrice:  2.92,
-					Delta:         -0.12,
-					SampleNumber:  3,
+					Fixture:         "Leeds v Southampton",
+					Team:            "Southampton",
+					Home:            false,
+					StartTime:       "2022-03-23T13:58:53Z",
+					StartPrice:      2.8,
+					StartLayPrice:   2.84,
+					CurrentPrice:    2.92,
+					CurrentLayPrice: 2.9,
+					Delta:           -0.12,
+					SampleNumber:    3,
+				},
+			},
+		},
+		{
+			name: "Get trend for fixture with the draw",
+			args: args{
+				fixture: FixturePrices{
+					Fixture:      "Mainz v Dortmund",
+					HomeRunnerId: 1,
+					AwayRunnerId: 2,
+					DrawRunnerId: 3,
+					PriceHistory: map[int][]Price{
+						1: {{Timestamp: "2022-03-23T13:58:53Z", BackPrice: 3.7, LayPrice: 3.75}},
+						2: {{Timestamp: "2022-03-23T13:58:53Z", BackPrice: 2.88, LayPrice: 2.9}},
+						3: {
+							{Timestamp: "2022-03-23T13:58:53Z", BackPrice: 2.56, LayPrice: 2.6},
+							{Timestamp: "2022-03-23T14:21:01Z", BackPrice: 2.5, LayPrice: 2.52},
+						},
+					},
+				},
+			},
+			wantTrend: []Trend{
+				{
+					Fixture:         "Mainz v Dortmund",
+					Team:            "Mainz",
+					Home:            true,
+					StartTime:       "2022-03-23T13:58:53Z",
+					StartPrice:      3.7,
+					StartLayPrice:   3.75,
+					CurrentPrice:    3.75,
+					CurrentLayPrice: 3.7,
+					Delta:           -0.05,
+					SampleNumber:    1,
+				},
+				{
+					Fixture:         "Mainz v Dortmund",
+					Team:            "Dortmund",
+					StartTime:       "2022-03-23T13:58:53Z",
+					StartPrice:      2.88,
+					StartLayPrice:   2.9,
+					CurrentPrice:    2.9,
+					CurrentLayPrice: 2.88,
+					Delta:           -0.02,
+					SampleNumber:    1,
+				},
+				{
+					Fixture:         "Mainz v Dortmund",
+					Team:            "The Draw",
+					Draw:            true,
+					StartTime:       "2022-03-23T13:58:53Z",
+					StartPrice:      2.56,
+					StartLayPrice:   2.6,
+					CurrentPrice:    2.52,
+					CurrentLayPrice: 2.5,
+					Delta:           0.04,
+					SampleNumber:    2,
 				},
 			},
 		},
@@ -494,3 +721,208 @@ func Test_extractTrendFromFixture(t *testing.T) {
 		})
 	}
 }
+
+func TestStore_AddLeaguePricesToStoreOddsFilter(t *testing.T) {
+	tests := []struct {
+		name          string
+		query         access.MarketQuery
+		wantStatus    AdmissionStatus
+		wantReason    string
+		wantSamples   int
+		wantAfterMove AdmissionStatus
+	}{
+		{
+			name:          "admitted when a runner is in range",
+			query:         access.MarketQuery{LeagueIds: []string{"league1"}, MinOdds: 2.0, MaxOdds: 3.7},
+			wantStatus:    Admitted,
+			wantReason:    "home 3.70 away 2.86 within 2.00 to 3.70",
+			wantSamples:   2,
+			wantAfterMove: Admitted,
+		},
+		{
+			name:          "skipped when no runner is in range",
+			query:         access.MarketQuery{LeagueIds: []string{"league1"}, MinOdds: 4.0},
+			wantStatus:    Skipped,
+			wantReason:    "home 3.70 away 2.86 outside 4.00 and above",
+			wantAfterMove: Skipped,
+		},
+		{
+			name:          "dropped when prices drift out of range",
+			query:         access.MarketQuery{LeagueIds: []string{"league1"}, MinOdds: 2.85, MaxOdds: 3.72},
+			wantStatus:    Admitted,
+			wantReason:    "home 3.70 away 2.86 within 2.85 to 3.72",
+			wantSamples:   1,
+			wantAfterMove: Dropped,
+		},
+		{
+			name:          "kept when prices drift out of range and keep tracking is set",
+			query:         access.MarketQuery{LeagueIds: []string{"league1"}, MinOdds: 2.85, MaxOdds: 3.72, KeepTracking: true},
+			wantStatus:    Admitted,
+			wantReason:    "home 3.70 away 2.86 within 2.85 to 3.72",
+			wantSamples:   2,
+			wantAfterMove: Admitted,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := fake.FakeQuery{}
+			s := &Store{
+				GlobalPriceStore: map[string]map[string]FixturePrices{},
+				QueryClient:      &client,
+			}
+			assert.Nil(t, s.AddLeaguePricesToStore(&tt.query))
+			fixture := s.GlobalPriceStore["league1"]["fixture1"]
+			assert.Equal(t, tt.wantStatus, fixture.Admission.Status)
+			assert.Equal(t, tt.wantReason, fixture.Admission.Reason)
+
+			client.AppendPrices = true
+			assert.Nil(t, s.AddLeaguePricesToStore(&tt.query))
+			fixture = s.GlobalPriceStore["league1"]["fixture1"]
+			assert.Equal(t, tt.wantAfterMove, fixture.Admission.Status)
+			assert.Equal(t, tt.wantSamples, len(fixture.PriceHistory[64374]))
+		})
+	}
+}
+
+func Test_ladderFromOdds(t *testing.T) {
+	testOdds := []types.Odds{
+		{Price: 2.12, Size: 1321.33},
+		{Price: 1.98, Size: 672.21},
+		{Price: 2.16, Size: 1458.00},
+	}
+	tests := []struct {
+		name    string
+		odds    []types.Odds
+		highest bool
+		depth   int
+		want    []LadderLevel
+	}{
+		{
+			name:    "back ladder best price first truncated to depth",
+			odds:    testOdds,
+			highest: true,
+			depth:   2,
+			want:    []LadderLevel{{Price: 2.16, Amount: 1458.00}, {Price: 2.12, Amount: 1321.33}},
+		},
+		{
+			name:  "lay ladder keeps every level with no depth",
+			odds:  testOdds,
+			depth: 0,
+			want:  []LadderLevel{{Price: 1.98, Amount: 672.21}, {Price: 2.12, Amount: 1321.33}, {Price: 2.16, Amount: 1458.00}},
+		},
+		{
+			name:  "empty ladder",
+			depth: 3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, ladderFromOdds(tt.odds, tt.highest, tt.depth))
+		})
+	}
+}
+
+func TestStore_AddLeaguePricesToStoreLadderDepth(t *testing.T) {
+	s := &Store{
+		GlobalPriceStore: map[string]map[string]FixturePrices{},
+		QueryClient:      &fake.FakeQuery{},
+	}
+	err := s.AddLeaguePricesToStore(&access.MarketQuery{LeagueIds: []string{"league1"}, LadderDepth: 2})
+	assert.Nil(t, err)
+
+	prices := s.GlobalPriceStore["league1"]["fixture1"].PriceHistory[64374]
+	assert.Equal(t, 1, len(prices))
+	// Best price fields are still recorded for older analysis
+	assert.Equal(t, float32(3.7), prices[0].BackPrice)
+	assert.Equal(t, float32(3.75), prices[0].LayPrice)
+	assert.Equal(t, []LadderLevel{{Price: 3.7, Amount: 777.45}, {Price: 3.65, Amount: 1164.38}}, prices[0].BackLadder)
+	assert.Equal(t, []LadderLevel{{Price: 3.75, Amount: 718.45}, {Price: 3.8, Amount: 1145.15}}, prices[0].LayLadder)
+	assert.Equal(t, []LadderLevel{{Price: 3.7, Amount: 1250.12}, {Price: 3.75, Amount: 842.6}}, prices[0].TradedVolume)
+	assert.Equal(t, float32(3.75), prices[0].LastPriceTraded)
+	assert.Equal(t, float32(2092.72), prices[0].TotalMatched)
+}
+
+// batchQuery records the markets asked for in each market book request
+type batchQuery struct {
+	fake.FakeQuery
+	batches [][]string
+}
+
+func (b *batchQuery) ListMarketBook(marketIds []string, priceProjection *types.PriceProjection, orderProjection string, matchProjection string) ([]types.MarketBookWrapper, error) {
+	b.batches = append(b.batches, marketIds)
+	return b.FakeQuery.ListMarketBook(marketIds, priceProjection, orderProjection, matchProjection)
+}
+
+func TestStore_listMarketBooks(t *testing.T) {
+	tests := []struct {
+		name        string
+		markets     int
+		projection  *types.PriceProjection
+		wantBatches []int
+	}{
+		{name: "best offers", markets: 45, projection: &types.PriceProjection{PriceData: []string{"EX_BEST_OFFERS"}}, wantBatches: []int{40, 5}},
+		{name: "ladders and traded", markets: 13, projection: &types.PriceProjection{PriceData: []string{"EX_ALL_OFFERS", "EX_TRADED"}}, wantBatches: []int{6, 6, 1}},
+		{name: "no markets", projection: &types.PriceProjection{PriceData: []string{"EX_BEST_OFFERS"}}, wantBatches: []int{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &batchQuery{}
+			s := &Store{GlobalPriceStore: map[string]map[string]FixturePrices{}, QueryClient: client}
+			marketIds := []string{}
+			for i := 0; i < tt.markets; i++ {
+				marketIds = append(marketIds, fmt.Sprintf("1.%d", i))
+			}
+			_, err := s.listMarketBooks(marketIds, tt.projection)
+			assert.Nil(t, err)
+			batches := []int{}
+			for _, batch := range client.batches {
+				batches = append(batches, len(batch))
+			}
+			assert.Equal(t, tt.wantBatches, batches)
+		})
+	}
+}
+
+func TestStore_CurrentRunnerPrice(t *testing.T) {
+	tests := []struct {
+		name        string
+		client      fake.FakeQuery
+		marketId    string
+		selectionId int
+		want        *Price
+		wantErr     bool
+	}{
+		{
+			name:        "best prices for the runner",
+			marketId:    "1.195693926",
+			selectionId: 44785,
+			want:        &Price{BackPrice: 2.86, LayPrice: 2.9, BackAmount: 537.2, LayAmount: 194.9},
+		},
+		{
+			name:        "runner not in the market",
+			marketId:    "1.195693926",
+			selectionId: 1,
+			wantErr:     true,
+		},
+		{
+			name:        "error getting market book",
+			client:      fake.FakeQuery{InjectListMarketBookError: true},
+			marketId:    "1.195693926",
+			selectionId: 44785,
+			wantErr:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Store{QueryClient: &tt.client}
+			got, err := s.CurrentRunnerPrice(tt.marketId, tt.selectionId)
+			if tt.wantErr {
+				assert.NotNil(t, err)
+			} else {
+				assert.Nil(t, err)
+				tt.want.Timestamp = got.Timestamp
+				assert.Equal(t, tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright 2022 Guy Barden
+// teams.go - matches the team names of market runners to the fixtures they play in
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"strings"
+)
+
+// TeamAliases maps the normalised short or alternative names of teams to one name, so a runner named one way
+// matches a fixture named the other
+var TeamAliases = map[string]string{
+	"man city":                 "manchester city",
+	"man utd":                  "manchester united",
+	"man united":               "manchester united",
+	"spurs":                    "tottenham",
+	"tottenham hotspur":        "tottenham",
+	"wolves":                   "wolverhampton",
+	"wolverhampton wanderers":  "wolverhampton",
+	"nottm forest":             "nottingham forest",
+	"sheff utd":                "sheffield united",
+	"sheff wed":                "sheffield wednesday",
+	"west ham united":          "west ham",
+	"brighton and hove albion": "brighton",
+	"newcastle united":         "newcastle",
+	"leeds united":             "leeds",
+	"leicester city":           "leicester",
+	"internazionale":           "inter",
+	"inter milan":              "inter",
+	"bayern munich":            "bayern munchen",
+	"borussia dortmund":        "dortmund",
+	"borussia monchengladbach": "monchengladbach",
+	"b monchengladbach":        "monchengladbach",
+	"gladbach":                 "monchengladbach",
+	"paris saint germain":      "paris st g",
+	"psg":                      "paris st g",
+}
+
+// clubAffixes are dropped from team names as they are used inconsistently
+var clubAffixes = map[string]bool{"fc": true, "afc": true, "cf": true}
+
+// NormaliseTeamName reduces a team name to lower case words without punctuation or club affixes, and replaces an
+// alias with its team's name. Qualifiers such as U23 or Women are kept so those teams never match the senior side
+func NormaliseTeamName(name string) string {
+	name = strings.ToLower(name)
+	name = strings.NewReplacer("&", " and ", "-", " ", ".", "", "'", "").Replace(name)
+	words := []string{}
+	for _, word := range strings.Fields(name) {
+		if !clubAffixes[word] {
+			words = append(words, word)
+		}
+	}
+	name = strings.Join(words, " ")
+	if alias, ok := TeamAliases[name]; ok {
+		return alias
+	}
+	return name
+}
+
+// splitFixture returns the home and away teams of a fixture named "Home v Away"
+func splitFixture(fixture string) (home string, away string, ok bool) {
+	teams := strings.Split(fixture, " v ")
+	if len(teams) != 2 {
+		return "", "", false
+	}
+	return teams[0], teams[1], true
+}
+
+// fixtureMatchesTeams reports whether the fixture is between the home and away teams
+func fixtureMatchesTeams(fixture string, homeTeam string, awayTeam string) bool {
+	home, away, ok := splitFixture(fixture)
+	if !ok {
+		return false
+	}
+	return NormaliseTeamName(home) == NormaliseTeamName(homeTeam) && NormaliseTeamName(away) == NormaliseTeamName(awayTeam)
+}
@@ -0,0 +1,274 @@
+// Copyright 2022 Guy Barden
+// verify.go - checks the structure of the stored price histories and salvages damaged stores
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"guysports/go-football-trader/pkg/access"
+	"guysports/go-football-trader/pkg/ladder"
+	"sort"
+	"time"
+)
+
+type (
+	// Problem is a fault found in a fixture, the selection and sample index are set when it is in a runner's history
+	// and the market type when the runner is in another market
+	Problem struct {
+		LeagueId    string
+		EventId     string
+		Market      string
+		SelectionId int
+		Index       int
+		Message     string
+	}
+)
+
+func (p Problem) String() string {
+	if p.SelectionId == 0 {
+		return fmt.Sprintf("league %s fixture %s: %s", p.LeagueId, p.EventId, p.Message)
+	}
+	if p.Market != "" {
+		return fmt.Sprintf("league %s fixture %s market %s runner %d sample %d: %s", p.LeagueId, p.EventId, p.Market, p.SelectionId, p.Index, p.Message)
+	}
+	return fmt.Sprintf("league %s fixture %s runner %d sample %d: %s", p.LeagueId, p.EventId, p.SelectionId, p.Index, p.Message)
+}
+
+// Verify checks every fixture has its runner ids, only holds histories for its runners, and that each history has
+// increasing timestamps and prices on the Betfair ladder, as do the histories of the runners in its other markets
+func (s *Store) Verify() []Problem {
+	return s.checkFixtures(false)
+}
+
+// Repair removes what Verify finds wrong and returns the problems it removed. Fixtures with a history but no runner
+// ids are removed, as are histories of runners not in their fixture and samples that are out of order or off the
+// ladder
+func (s *Store) Repair() []Problem {
+	return s.checkFixtures(true)
+}
+
+func (s *Store) checkFixtures(repair bool) []Problem {
+	problems := []Problem{}
+	for leagueId, league := range s.GlobalPriceStore {
+		for eventId, fixture := range league {
+			fixtureProblems, keep := checkFixture(leagueId, eventId, &fixture, repair)
+			problems = append(problems, fixtureProblems...)
+			if repair && !keep {
+				delete(league, eventId)
+			} else if repair {
+				league[eventId] = fixture
+			}
+		}
+	}
+	sort.SliceStable(problems, func(i, j int) bool {
+		a, b := problems[i], problems[j]
+		if a.LeagueId != b.LeagueId {
+			return a.LeagueId < b.LeagueId
+		}
+		if a.EventId != b.EventId {
+			return a.EventId < b.EventId
+		}
+		if a.Market != b.Market {
+			return a.Market < b.Market
+		}
+		if a.SelectionId != b.SelectionId {
+			return a.SelectionId < b.SelectionId
+		}
+		return a.Index < b.Index
+	})
+	return problems
+}
+
+// checkFixture returns the problems in the fixture, removing the faulty histories and samples when repairing. It
+// reports false if the whole fixture has to be removed
+func checkFixture(leagueId string, eventId string, fixture *FixturePrices, repair bool) ([]Problem, bool) {
+	problems := []Problem{}
+	if len(fixture.PriceHistory) > 0 && (fixture.HomeRunnerId == 0 || fixture.AwayRunnerId == 0) {
+		problems = append(problems, Problem{LeagueId: leagueId, EventId: eventId, Message: "price history recorded without home and away runner ids"})
+		return problems, false
+	}
+	for selectionId, history := range fixture.PriceHistory {
+		if selectionId != fixture.HomeRunnerId && selectionId != fixture.AwayRunnerId && (fixture.DrawRunnerId == 0 || selectionId != fixture.DrawRunnerId) {
+			problems = append(problems, Problem{LeagueId: leagueId, EventId: eventId, SelectionId: selectionId, Message: "history of a runner not in the fixture"})
+			if repair {
+				delete(fixture.PriceHistory, selectionId)
+			}
+			continue
+		}
+		kept, historyProblems := checkHistory(Problem{LeagueId: leagueId, EventId: eventId, SelectionId: selectionId}, history)
+		problems = append(problems, historyProblems...)
+		if repair {
+			fixture.PriceHistory[selectionId] = kept
+		}
+	}
+	for marketType, market := range fixture.Markets {
+		for i, runner := range market.Runners {
+			kept, historyProblems := checkHistory(Problem{LeagueId: leagueId, EventId: eventId, Market: marketType, SelectionId: runner.SelectionId}, runner.History)
+			problems = append(problems, historyProblems...)
+			if repair {
+				market.Runners[i].History = kept
+			}
+		}
+	}
+	return problems, true
+}
+
+// checkHistory returns the samples of a history that have nothing wrong with them, and a problem like at for each
+// sample that does
+func checkHistory(at Problem, history []Price) ([]Price, []Problem) {
+	problems := []Problem{}
+	kept := make([]Price, 0, len(history))
+	var previous time.Time
+	for index, sample := range history {
+		message := checkSample(&sample, previous)
+		if message != "" {
+			problem := at
+			problem.Index, problem.Message = index, message
+			problems = append(problems, problem)
+			continue
+		}
+		previous, _ = time.Parse(time.RFC3339, sample.Timestamp)
+		kept = append(kept, sample)
+	}
+	return kept, problems
+}
+
+// checkSample describes what is wrong with a sample taken after previous, or returns an empty string
+func checkSample(sample *Price, previous time.Time) string {
+	taken, err := time.Parse(time.RFC3339, sample.Timestamp)
+	if err != nil {
+		return fmt.Sprintf("timestamp %q is not RFC3339", sample.Timestamp)
+	}
+	if taken.Before(previous) {
+		return fmt.Sprintf("timestamp %s is before the previous sample at %s", sample.Timestamp, previous.Format(time.RFC3339))
+	}
+	// A price of zero means nothing was available on that side
+	if sample.BackPrice != 0 && !ladder.IsValid(sample.BackPrice) {
+		return fmt.Sprintf("back price %.2f is not on the Betfair ladder", sample.BackPrice)
+	}
+	if sample.LayPrice != 0 && !ladder.IsValid(sample.LayPrice) {
+		return fmt.Sprintf("lay price %.2f is not on the Betfair ladder", sample.LayPrice)
+	}
+	return ""
+}
+
+// SalvageStore loads the json store at path, keeping every fixture that can be decoded before the file becomes
+// unreadable. Prices captured in the journal are replayed over what is salvaged. The error describes what was lost,
+// it is nil if the whole file was read
+func SalvageStore(path string, qc access.QueryInterface) (*Store, error) {
+	backend := NewJSONBackend(path)
+	backend.fixtures = map[string]map[string]FixturePrices{}
+	store := Store{
+		GlobalPriceStore: map[string]map[string]FixturePrices{},
+		QueryClient:      qc,
+		StorePath:        path,
+		Backend:          backend,
+	}
+	data, err := readStoreFile(path)
+	var salvageErr error
+	if err == nil {
+		salvageErr = salvageFixtures(data, store.GlobalPriceStore)
+	} else {
+		salvageErr = err
+	}
+	if err := store.AttachJournal(NewJournal(JournalPath(path))); err != nil && salvageErr == nil {
+		salvageErr = err
+	}
+	return &store, salvageErr
+}
+
+// salvageFixtures decodes the leagues of the store one fixture at a time until the data cannot be decoded. Files
+// without a schema version hold the leagues at the top level
+func salvageFixtures(data []byte, fixtures map[string]map[string]FixturePrices) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	stopped := func(err error) error {
+		return fmt.Errorf("fixtures after byte %d could not be read: %s", decoder.InputOffset(), err.Error())
+	}
+	if err := expectDelim(decoder, '{'); err != nil {
+		return stopped(err)
+	}
+	for decoder.More() {
+		key, err := decoder.Token()
+		if err != nil {
+			return stopped(err)
+		}
+		switch key {
+		case schemaVersionKey:
+			var version int
+			err = decoder.Decode(&version)
+		case leaguesKey:
+			err = salvageLeagues(decoder, fixtures)
+		default:
+			err = salvageLeague(decoder, key.(string), fixtures)
+		}
+		if err != nil {
+			return stopped(err)
+		}
+	}
+	return nil
+}
+
+func salvageLeagues(decoder *json.Decoder, fixtures map[string]map[string]FixturePrices) error {
+	if err := expectDelim(decoder, '{'); err != nil {
+		return err
+	}
+	for decoder.More() {
+		leagueId, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		if err := salvageLeague(decoder, leagueId.(string), fixtures); err != nil {
+			return err
+		}
+	}
+	return expectDelim(decoder, '}')
+}
+
+func salvageLeague(decoder *json.Decoder, leagueId string, fixtures map[string]map[string]FixturePrices) error {
+	if err := expectDelim(decoder, '{'); err != nil {
+		return err
+	}
+	for decoder.More() {
+		eventId, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		fixture := FixturePrices{}
+		if err := decoder.Decode(&fixture); err != nil {
+			return err
+		}
+		if fixtures[leagueId] == nil {
+			fixtures[leagueId] = map[string]FixturePrices{}
+		}
+		if fixture.PriceHistory == nil {
+			fixture.PriceHistory = map[int][]Price{}
+		}
+		fixtures[leagueId][eventId.(string)] = fixture
+	}
+	return expectDelim(decoder, '}')
+}
+
+func expectDelim(decoder *json.Decoder, delim json.Delim) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	if token != delim {
+		return fmt.Errorf("expected %s but found %v", delim, token)
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+// Copyright 2022 Guy Barden
+// verify_test.go - tests for verifying, repairing and salvaging the store
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStore_Verify(t *testing.T) {
+	good := []Price{
+		{Timestamp: "2022-04-06T12:00:00Z", BackPrice: 3.7, LayPrice: 3.75},
+		{Timestamp: "2022-04-06T12:10:00Z", BackPrice: 3.65, LayPrice: 0},
+	}
+	tests := []struct {
+		name         string
+		fixture      FixturePrices
+		wantProblems []string
+		wantHistory  map[int][]Price
+		wantRemoved  bool
+	}{
+		{
+			name:        "valid fixture",
+			fixture:     FixturePrices{HomeRunnerId: 1, AwayRunnerId: 2, DrawRunnerId: 3, PriceHistory: map[int][]Price{1: good, 2: good, 3: good}},
+			wantHistory: map[int][]Price{1: good, 2: good, 3: good},
+		},
+		{
+			name:         "history without runner ids",
+			fixture:      FixturePrices{PriceHistory: map[int][]Price{1: good}},
+			wantProblems: []string{"league 59 fixture fixture1: price history recorded without home and away runner ids"},
+			wantRemoved:  true,
+		},
+		{
+			name:         "history of another runner",
+			fixture:      FixturePrices{HomeRunnerId: 1, AwayRunnerId: 2, PriceHistory: map[int][]Price{1: good, 4: good}},
+			wantProblems: []string{"league 59 fixture fixture1 runner 4 sample 0: history of a runner not in the fixture"},
+			wantHistory:  map[int][]Price{1: good},
+		},
+		{
+			name: "bad samples",
+			fixture: FixturePrices{HomeRunnerId: 1, AwayRunnerId: 2, PriceHistory: map[int][]Price{
+				1: {good[0], {Timestamp: "yesterday"}, {Timestamp: "2022-04-06T11:00:00Z", BackPrice: 3.7}, good[1]},
+				2: {{Timestamp: "2022-04-06T12:00:00Z", BackPrice: 3.72}, {Timestamp: "2022-04-06T12:00:00Z", LayPrice: 1001}, good[1]},
+			}},
+			wantProblems: []string{
+				`league 59 fixture fixture1 runner 1 sample 1: timestamp "yesterday" is not RFC3339`,
+				"league 59 fixture fixture1 runner 1 sample 2: timestamp 2022-04-06T11:00:00Z is before the previous sample at 2022-04-06T12:00:00Z",
+				"league 59 fixture fixture1 runner 2 sample 0: back price 3.72 is not on the Betfair ladder",
+				"league 59 fixture fixture1 runner 2 sample 1: lay price 1001.00 is not on the Betfair ladder",
+			},
+			wantHistory: map[int][]Price{1: good, 2: good[1:]},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Store{GlobalPriceStore: map[string]map[string]FixturePrices{"59": {"fixture1": tt.fixture}}}
+			problems := []string{}
+			for _, problem := range s.Verify() {
+				problems = append(problems, problem.String())
+			}
+			if tt.wantProblems == nil {
+				tt.wantProblems = []string{}
+			}
+			assert.Equal(t, tt.wantProblems, problems)
+
+			assert.Equal(t, len(tt.wantProblems), len(s.Repair()))
+			assert.Equal(t, []Problem{}, s.Verify())
+			fixture, ok := s.GlobalPriceStore["59"]["fixture1"]
+			assert.Equal(t, !tt.wantRemoved, ok)
+			if !tt.wantRemoved {
+				assert.Equal(t, tt.wantHistory, fixture.PriceHistory)
+			}
+		})
+	}
+}
+
+func TestNewStore_Damaged(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.json")
+	fixtures := map[string]map[string]FixturePrices{
+		"59": {"fixture1": {Fixture: "Mainz v Dortmund", HomeRunnerId: 1, AwayRunnerId: 2, PriceHistory: map[int][]Price{1: {{Timestamp: "2022-04-06T12:00:00Z", BackPrice: 3.7}}}}},
+		"81": {"fixture2": {Fixture: "Roma v Lazio", HomeRunnerId: 3, AwayRunnerId: 4, PriceHistory: map[int][]Price{}}},
+	}
+	data, err := json.Marshal(fixtures)
+	assert.Nil(t, err)
+	// Cut the file short inside the second league
+	assert.Nil(t, ioutil.WriteFile(path, data[:len(data)-20], 0644))
+
+	_, err = NewStore(path, nil)
+	assert.NotNil(t, err)
+
+	s, err := SalvageStore(path, nil)
+	assert.NotNil(t, err)
+	assert.Equal(t, map[string]map[string]FixturePrices{"59": fixtures["59"]}, s.GlobalPriceStore)
+	assert.Nil(t, s.SaveStoreToFile())
+	assert.Equal(t, s.GlobalPriceStore, newTestStore(t, path, nil).GlobalPriceStore)
+	archives, err := ListArchives(path)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(archives))
+
+	// A store that can be read in full salvages without error
+	s, err = SalvageStore(path, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]map[string]FixturePrices{"59": fixtures["59"]}, s.GlobalPriceStore)
+}